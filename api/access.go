@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/csv"
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type accessReport struct {
+	//Totals add up the windows per prefix, busiest first
+	Totals  []storage.AccessCounts `json:"totals"`
+	Windows []storage.AccessWindow `json:"windows"`
+}
+
+//HandleAccess reports reads, writes and misses per key prefix for the last
+//?windows intervals (all of them by default). With ?format=csv the windows are
+//downloaded as a CSV file with a row per window and prefix.
+func (srv *Server) HandleAccess() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		n := 0
+		if v := q.Get("windows"); v != "" {
+			var err error
+			if n, err = strconv.Atoi(v); err != nil || n < 0 {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid windows"))
+				return
+			}
+		}
+		windows := srv.storage.Access(n)
+		if windows == nil {
+			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("access stats are disabled"))
+			return
+		}
+
+		switch q.Get("format") {
+		case "", "json":
+			utils.Respond(w, r, http.StatusOK, accessReport{Totals: storage.SumAccess(windows), Windows: windows})
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="access.csv"`)
+			cw := csv.NewWriter(w)
+			cw.Write([]string{"start", "prefix", "reads", "writes", "misses"})
+			for _, win := range windows {
+				start := win.Start.UTC().Format(time.RFC3339)
+				for _, c := range win.Prefixes {
+					cw.Write([]string{
+						start,
+						c.Prefix,
+						strconv.FormatUint(c.Reads, 10),
+						strconv.FormatUint(c.Writes, 10),
+						strconv.FormatUint(c.Misses, 10),
+					})
+				}
+			}
+			cw.Flush()
+		default:
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("format must be json or csv"))
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+type memoryStats struct {
+	HeapAlloc    uint64 `json:"heap_alloc"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	TotalAlloc   uint64 `json:"total_alloc"`
+	Mallocs      uint64 `json:"mallocs"`
+	Frees        uint64 `json:"frees"`
+	NumGC        uint32 `json:"num_gc"`
+	PauseTotalNs uint64 `json:"pause_total_ns"`
+}
+
+func readMemoryStats() memoryStats {
+	ms := runtime.MemStats{}
+	runtime.ReadMemStats(&ms)
+	return memoryStats{
+		HeapAlloc:    ms.HeapAlloc,
+		HeapObjects:  ms.HeapObjects,
+		TotalAlloc:   ms.TotalAlloc,
+		Mallocs:      ms.Mallocs,
+		Frees:        ms.Frees,
+		NumGC:        ms.NumGC,
+		PauseTotalNs: ms.PauseTotalNs,
+	}
+}
+
+func (srv *Server) HandleStats() http.HandlerFunc {
+	type response struct {
+		storage.Stats
+		Memory      memoryStats               `json:"memory"`
+		Compression *storage.CompressionStats `json:"compression,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response{Stats: srv.storage.Stats(), Memory: readMemoryStats()}
+		if srv.config.compresses() {
+			st := srv.storage.Compression()
+			resp.Compression = &st
+		}
+		utils.Respond(w, r, http.StatusOK, resp)
+	}
+}
+
+//HandleInfo describes the running server and how its db was loaded.
+func (srv *Server) HandleInfo() http.HandlerFunc {
+	type response struct {
+		StartedAt time.Time        `json:"started_at"`
+		Uptime    string           `json:"uptime"`
+		DBFile    string           `json:"db_file,omitempty"`
+		Recovery  storage.Recovery `json:"recovery"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response{
+			StartedAt: srv.startedAt,
+			Uptime:    time.Since(srv.startedAt).Round(time.Second).String(),
+			Recovery:  srv.recovery,
+		}
+		if srv.config != nil {
+			resp.DBFile = srv.config.DBFileName
+		}
+		utils.Respond(w, r, http.StatusOK, resp)
+	}
+}
+
+//HandleFlush deletes all items. The map is presized again unless reserve=false
+//or reserve_on_flush is disabled in config.
+//parseDryRun reads ?dry_run, with which destructive operations only report
+//what they would affect, and ?sample, how many affected keys they list.
+func parseDryRun(r *http.Request) (bool, int, error) {
+	q := r.URL.Query()
+	if q.Get("dry_run") != "true" {
+		return false, 0, nil
+	}
+	sample := storage.DefaultDryRunSample
+	if v := q.Get("sample"); v != "" {
+		var err error
+		if sample, err = strconv.Atoi(v); err != nil || sample < 0 || sample > maxListLimit {
+			return true, 0, fmt.Errorf("sample must be within [0, %d]", maxListLimit)
+		}
+	}
+	return true, sample, nil
+}
+
+//HandleFlush deletes all items, or with ?dry_run=true reports them.
+func (srv *Server) HandleFlush() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun, sample, err := parseDryRun(r)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if dryRun {
+			utils.Respond(w, r, http.StatusOK, srv.storage.FlushDryRun(sample))
+			return
+		}
+		reserve := srv.config == nil || srv.config.ReserveOnFlush
+		switch r.URL.Query().Get("reserve") {
+		case "true":
+			reserve = true
+		case "false":
+			reserve = false
+		}
+		srv.storage.Flush(reserve)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+//HandleCompact rebuilds the items map and indexes into right-sized structures
+//after mass deletions, copying ?batch items (default storage.DefaultCompactBatch)
+//per hold of the lock, and reports the memory estimates before and after.
+//With ?dry_run=true it only reports the estimates.
+func (srv *Server) HandleCompact() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("dry_run") == "true" {
+			utils.Respond(w, r, http.StatusOK, srv.storage.CompactDryRun())
+			return
+		}
+		batch := 0
+		if v := r.URL.Query().Get("batch"); v != "" {
+			var err error
+			if batch, err = strconv.Atoi(v); err != nil || batch <= 0 {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("invalid batch: %s", v))
+				return
+			}
+		}
+		st, err := srv.storage.Compact(r.Context(), batch)
+		if errors.Is(err, storage.ErrCompacting) || errors.Is(err, storage.ErrCompactionAborted) {
+			utils.ErrorMessage(w, r, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, st)
+	}
+}
+
+//maxExpiryBuckets bounds the response of HandleExpirations.
+const maxExpiryBuckets = 1440
+
+//HandleExpirations counts the items expiring within ?within (default 10m) in
+//buckets of ?bucket (default 1m), listing their keys with keys=true.
+func (srv *Server) HandleExpirations() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		within, width := 10*time.Minute, time.Minute
+		var err error
+		if v := q.Get("within"); v != "" {
+			if within, err = time.ParseDuration(v); err != nil || within <= 0 {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid within"))
+				return
+			}
+		}
+		if v := q.Get("bucket"); v != "" {
+			if width, err = time.ParseDuration(v); err != nil || width <= 0 {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid bucket"))
+				return
+			}
+		}
+		if within/width > maxExpiryBuckets {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("more than %d buckets", maxExpiryBuckets))
+			return
+		}
+
+		utils.Respond(w, r, http.StatusOK, srv.storage.Expirations(within, width, q.Get("keys") == "true"))
+	}
+}
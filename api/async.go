@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+//Overflow policies of the async write queue: reject answers 503, drop_oldest
+//discards the oldest queued write and block waits for room.
+const (
+	OverflowReject     = "reject"
+	OverflowDropOldest = "drop_oldest"
+	OverflowBlock      = "block"
+)
+
+const defaultAsyncQueueSize = 10000
+
+//asyncWrite is a write or, with del, a delete accepted with ?async=true.
+//A write with flushed set only signals that the writes before it are applied.
+type asyncWrite struct {
+	key     string
+	value   interface{}
+	opts    writeOptions
+	del     bool
+	flushed chan struct{}
+}
+
+//asyncQueue applies writes accepted with ?async=true in the background, in the
+//order they were accepted. Their outcome is only visible in the counters, which
+//are accessed atomically.
+type asyncQueue struct {
+	writes   chan asyncWrite
+	overflow string
+	applied  uint64
+	failed   uint64
+	dropped  uint64
+	rejected uint64
+	//late holds the flush markers taken off the queue by drop_oldest, which
+	//are closed once the writer is done with its current write
+	mu   sync.Mutex
+	late []chan struct{}
+}
+
+func checkOverflow(overflow string) error {
+	switch overflow {
+	case "", OverflowReject, OverflowDropOldest, OverflowBlock:
+		return nil
+	}
+	return fmt.Errorf("unknown async_overflow %q", overflow)
+}
+
+//asyncWrites returns the queue of the server, starting it on first use.
+func (srv *Server) asyncWrites() *asyncQueue {
+	srv.asyncOnce.Do(func() {
+		size, overflow := defaultAsyncQueueSize, OverflowReject
+		if srv.config != nil {
+			if srv.config.AsyncQueueSize > 0 {
+				size = srv.config.AsyncQueueSize
+			}
+			if srv.config.AsyncOverflow != "" {
+				overflow = srv.config.AsyncOverflow
+			}
+		}
+		srv.async = &asyncQueue{writes: make(chan asyncWrite, size), overflow: overflow}
+		go srv.applyAsync(srv.async)
+	})
+	return srv.async
+}
+
+func (srv *Server) applyAsync(q *asyncQueue) {
+	for aw := range q.writes {
+		srv.applyWrite(q, aw)
+		q.mu.Lock()
+		for _, done := range q.late {
+			close(done)
+		}
+		q.late = nil
+		q.mu.Unlock()
+	}
+}
+
+func (srv *Server) applyWrite(q *asyncQueue, aw asyncWrite) {
+	switch {
+	case aw.flushed != nil:
+		close(aw.flushed)
+	case aw.del:
+		//the key may have been locked since the delete was accepted
+		if _, err := srv.storage.Guard(srv.lockGuard(aw.opts.lockToken)).Delete(aw.key); err != nil {
+			atomic.AddUint64(&q.failed, 1)
+			return
+		}
+		atomic.AddUint64(&q.applied, 1)
+	default:
+		if _, err := srv.write(aw.key, aw.value, aw.opts); err != nil {
+			atomic.AddUint64(&q.failed, 1)
+			return
+		}
+		atomic.AddUint64(&q.applied, 1)
+	}
+}
+
+//enqueue hands aw to the background writer according to the overflow policy
+//and reports whether it was accepted.
+func (q *asyncQueue) enqueue(ctx context.Context, aw asyncWrite) bool {
+	select {
+	case q.writes <- aw:
+		return true
+	default:
+	}
+	switch q.overflow {
+	case OverflowBlock:
+		select {
+		case q.writes <- aw:
+			return true
+		case <-ctx.Done():
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case old := <-q.writes:
+				//the writes before a flush marker are taken off the queue
+				//already, so it is only held until the current one is applied;
+				//the write queued next wakes the writer up for it
+				if old.flushed != nil {
+					q.mu.Lock()
+					q.late = append(q.late, old.flushed)
+					q.mu.Unlock()
+				} else {
+					atomic.AddUint64(&q.dropped, 1)
+				}
+			default:
+			}
+			select {
+			case q.writes <- aw:
+				return true
+			default:
+			}
+		}
+	}
+	atomic.AddUint64(&q.rejected, 1)
+	return false
+}
+
+//flush waits until the writes accepted so far are applied.
+func (q *asyncQueue) flush() {
+	done := make(chan struct{})
+	q.writes <- asyncWrite{flushed: done}
+	<-done
+}
+
+func isAsync(r *http.Request) bool {
+	return r.URL.Query().Get("async") == "true"
+}
+
+//acceptAsync queues aw and responds with 202, with 423 if its key is locked or
+//with 503 if the queue is full.
+func (srv *Server) acceptAsync(w http.ResponseWriter, r *http.Request, aw asyncWrite) {
+	aw.opts.lockToken = r.Header.Get(LockTokenHeader)
+	if srv.keysLocked(w, r, aw.key) {
+		return
+	}
+	if !srv.asyncWrites().enqueue(r.Context(), aw) {
+		w.Header().Set("Retry-After", "1")
+		utils.ErrorMessage(w, r, http.StatusServiceUnavailable, errors.New("async write queue is full"))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
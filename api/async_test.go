@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"testing"
+	"time"
+)
+
+//newTestQueue returns a queue of size whose writer isn't started yet.
+func newTestQueue(size int, overflow string) (*Server, *asyncQueue) {
+	srv := NewServer(storage.New(storage.DefaultExpiration, 0, 0))
+	return srv, &asyncQueue{writes: make(chan asyncWrite, size), overflow: overflow}
+}
+
+func TestAsyncQueue_Reject(t *testing.T) {
+	srv, q := newTestQueue(1, OverflowReject)
+	ctx := context.Background()
+	if !q.enqueue(ctx, asyncWrite{key: "a", value: "1"}) {
+		t.Fatal("write was rejected by an empty queue")
+	}
+	if q.enqueue(ctx, asyncWrite{key: "b", value: "2"}) {
+		t.Error("write was accepted by a full queue")
+	}
+	go srv.applyAsync(q)
+	q.flush()
+	if _, found := srv.storage.Get("b"); found || q.rejected != 1 || q.applied != 1 {
+		t.Errorf("unexpected counters: applied %d, rejected %d", q.applied, q.rejected)
+	}
+}
+
+func TestAsyncQueue_DropOldest(t *testing.T) {
+	srv, q := newTestQueue(2, OverflowDropOldest)
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c"} {
+		if !q.enqueue(ctx, asyncWrite{key: key, value: key}) {
+			t.Fatalf("%s was rejected", key)
+		}
+	}
+	go srv.applyAsync(q)
+	q.flush()
+	if _, found := srv.storage.Get("a"); found {
+		t.Error("oldest write was not dropped")
+	}
+	if _, found := srv.storage.Get("c"); !found {
+		t.Error("newest write was not applied")
+	}
+	if q.dropped != 1 || q.applied != 2 {
+		t.Errorf("unexpected counters: applied %d, dropped %d", q.applied, q.dropped)
+	}
+}
+
+func TestAsyncQueue_DropOldestKeepsFlush(t *testing.T) {
+	srv, q := newTestQueue(2, OverflowDropOldest)
+	ctx := context.Background()
+	q.enqueue(ctx, asyncWrite{key: "a", value: "1"})
+	flushed := make(chan struct{})
+	go func() {
+		q.flush()
+		close(flushed)
+	}()
+	for len(q.writes) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	//drops a, then takes the flush marker off the queue
+	q.enqueue(ctx, asyncWrite{key: "b", value: "2"})
+	q.enqueue(ctx, asyncWrite{key: "c", value: "3"})
+
+	go srv.applyAsync(q)
+	select {
+	case <-flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("flush never returned after its marker was dropped")
+	}
+	if q.dropped != 1 {
+		t.Errorf("unexpected dropped: %d", q.dropped)
+	}
+}
+
+func TestAsyncQueue_Block(t *testing.T) {
+	srv, q := newTestQueue(1, OverflowBlock)
+	q.enqueue(context.Background(), asyncWrite{key: "a", value: "1"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if q.enqueue(ctx, asyncWrite{key: "b", value: "2"}) {
+		t.Error("write was accepted by a full queue")
+	}
+
+	go srv.applyAsync(q)
+	if !q.enqueue(context.Background(), asyncWrite{key: "c", value: "3"}) {
+		t.Error("write was rejected after the queue drained")
+	}
+	q.flush()
+	if _, found := srv.storage.Get("c"); !found || q.rejected != 1 {
+		t.Errorf("unexpected result: found %v, rejected %d", found, q.rejected)
+	}
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"io"
+	"net/http"
+	"time"
+)
+
+//batchCommitSize bounds how many decoded operations are held in memory.
+const batchCommitSize = 1000
+
+type batchOp struct {
+	Op    string          `json:"op"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+	TTL   string          `json:"ttl"`
+}
+
+//rawValue stores JSON strings as plain strings, like values set through the path,
+//and any other JSON value as its text.
+func rawValue(raw json.RawMessage) interface{} {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+//HandleBatch reads a stream of JSON operations like {"op":"set","key":"k","value":"v","ttl":"1m"}
+//or {"op":"delete","key":"k"} and commits them in groups of batchCommitSize.
+//Each group is applied atomically; on error, groups committed before it stay applied.
+func (srv *Server) HandleBatch() http.HandlerFunc {
+	type response struct {
+		Applied int      `json:"applied"`
+		Error   string   `json:"error,omitempty"`
+		Details []string `json:"details,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		dec := json.NewDecoder(r.Body)
+		batch := srv.guarded(r).Batch()
+		applied := 0
+		token := r.Header.Get(LockTokenHeader)
+
+		fail := func(code int, err error) {
+			utils.Respond(w, r, code, response{Applied: applied, Error: err.Error()})
+		}
+		//keys are checked for locks before they are staged already, but they may
+		//be locked before the commit. Errors are reported like by writeError,
+		//with the operations applied before
+		commit := func() bool {
+			n := batch.Len()
+			err := batch.Commit()
+			var nf *storage.NamespaceFullError
+			var ve *storage.ValidationError
+			switch {
+			case errors.Is(err, errKeyLocked):
+				setRetryAfter(w, err)
+				fail(http.StatusLocked, err)
+			case errors.As(err, &nf):
+				srv.quotaExceeded(w, nf.Key)
+				fail(http.StatusInsufficientStorage, err)
+			case errors.As(err, &ve):
+				utils.Respond(w, r, http.StatusUnprocessableEntity, response{applied, err.Error(), ve.Errors})
+			case err != nil:
+				fail(http.StatusUnprocessableEntity, err)
+			default:
+				applied += n
+			}
+			return err == nil
+		}
+
+		for i := 0; ; i++ {
+			op := batchOp{}
+			err := dec.Decode(&op)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fail(http.StatusBadRequest, fmt.Errorf("operation %d: %v", i, err))
+				return
+			}
+			if op.Key, err = srv.checkKey(op.Key); err != nil {
+				fail(http.StatusBadRequest, fmt.Errorf("operation %d: %v", i, err))
+				return
+			}
+			if err = srv.keyLocks.verify(token, op.Key); err != nil {
+				setRetryAfter(w, err)
+				fail(http.StatusLocked, fmt.Errorf("operation %d: %v", i, err))
+				return
+			}
+
+			switch op.Op {
+			case "set":
+				ttl := storage.DefaultExpiration
+				if op.TTL == "-1" {
+					ttl = storage.NoExpiration
+				} else if op.TTL != "" {
+					if ttl, err = time.ParseDuration(op.TTL); err != nil || ttl <= 0 {
+						fail(http.StatusBadRequest, fmt.Errorf("operation %d: invalid ttl", i))
+						return
+					}
+				}
+				batch.Set(op.Key, rawValue(op.Value), ttl)
+			case "delete":
+				batch.Delete(op.Key)
+			default:
+				fail(http.StatusBadRequest, fmt.Errorf("operation %d: unknown op %q", i, op.Op))
+				return
+			}
+
+			if batch.Len() == batchCommitSize && !commit() {
+				return
+			}
+		}
+		if !commit() {
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, response{Applied: applied})
+	}
+}
+
+//HandleDeleteVersions reads [{"key":"k","version":3}] and deletes each key only
+//if it still has the version it was read with (the ETag of GET), so cleanup jobs
+//never delete values modified in the meantime. Results are reported per key.
+func (srv *Server) HandleDeleteVersions() http.HandlerFunc {
+	type guard struct {
+		Key     string `json:"key"`
+		Version uint64 `json:"version"`
+	}
+	type result struct {
+		Key     string `json:"key"`
+		Deleted bool   `json:"deleted"`
+		Error   string `json:"error,omitempty"`
+	}
+	type response struct {
+		Deleted int      `json:"deleted"`
+		Results []result `json:"results"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req []guard
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid request body"))
+			return
+		}
+		guards := make([]storage.DeleteGuard, len(req))
+		for i, g := range req {
+			var err error
+			if g.Key, err = srv.checkKey(g.Key); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("guard %d: %v", i, err))
+				return
+			}
+			if g.Version == 0 {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("guard %d: version is required", i))
+				return
+			}
+			guards[i] = storage.DeleteGuard{Key: g.Key, Version: g.Version}
+			if srv.keysLocked(w, r, g.Key) {
+				return
+			}
+		}
+
+		resp := response{Results: make([]result, len(guards))}
+		for i, err := range srv.guarded(r).DeleteVersions(guards) {
+			resp.Results[i] = result{Key: guards[i].Key, Deleted: err == nil}
+			if err != nil {
+				resp.Results[i].Error = err.Error()
+			} else {
+				resp.Deleted++
+			}
+		}
+		utils.Respond(w, r, http.StatusOK, resp)
+	}
+}
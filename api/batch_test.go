@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatch_Errors(t *testing.T) {
+	db := storage.New(storage.DefaultExpiration, 0, 0)
+	db.SetNamespaceOptions("ns", storage.NamespaceOptions{MaxItems: 1})
+	sc, err := storage.ParseSchema([]byte(`{"type": "object", "required": ["id"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetSchema("doc", sc)
+	srv := NewServer(db)
+	srv.config = &Config{}
+	srv.configureRouter()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	type response struct {
+		Applied int      `json:"applied"`
+		Error   string   `json:"error"`
+		Details []string `json:"details"`
+	}
+	post := func(ops ...string) (*http.Response, response) {
+		t.Helper()
+		resp, err := http.Post(ts.URL+"/batch", "application/json", strings.NewReader(strings.Join(ops, "\n")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		res := response{}
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return resp, res
+	}
+
+	//the first group is committed, the second one doesn't fit into the namespace
+	ops := make([]string, batchCommitSize, batchCommitSize+2)
+	for i := range ops {
+		ops[i] = fmt.Sprintf(`{"op":"set","key":"k%d","value":"v"}`, i)
+	}
+	ops = append(ops, `{"op":"set","key":"ns:1","value":"v"}`, `{"op":"set","key":"ns:2","value":"v"}`)
+	resp, res := post(ops...)
+	if resp.StatusCode != http.StatusInsufficientStorage || res.Applied != batchCommitSize {
+		t.Errorf("overflowing batch returned %d, %+v", resp.StatusCode, res)
+	}
+	if ns := resp.Header.Get("X-Quota-Namespace"); ns != "ns" || resp.Header.Get("X-Quota-Max-Items") != "1" {
+		t.Errorf("quota of namespace %q was described", ns)
+	}
+	if _, found := db.Get("ns:1"); found {
+		t.Error("group overflowing the namespace was applied")
+	}
+
+	//values violating the schema are rejected with the violations
+	resp, res = post(`{"op":"set","key":"doc:1","value":{"name":"x"}}`)
+	if resp.StatusCode != http.StatusUnprocessableEntity || res.Applied != 0 || len(res.Details) == 0 {
+		t.Errorf("invalid value returned %d, %+v", resp.StatusCode, res)
+	}
+	if resp, res = post(`{"op":"set","key":"doc:1","value":{"id":1}}`); resp.StatusCode != http.StatusOK || res.Applied != 1 {
+		t.Errorf("valid value returned %d, %+v", resp.StatusCode, res)
+	}
+}
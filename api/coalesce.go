@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"sync"
+	"sync/atomic"
+)
+
+//itemResponse is the JSON response of GET /items/{key}.
+type itemResponse struct {
+	Value   interface{} `json:"value"`
+	Version uint64      `json:"version"`
+}
+
+type getCall struct {
+	done chan struct{}
+	item storage.Item
+	//body is the encoded response, nil if the value is served as it was written
+	body []byte
+	err  error
+	//canceled is set if err is the one of the ctx of the request doing the lookup
+	canceled bool
+}
+
+//coalescing lets concurrent GETs of the same key share one storage lookup and
+//its encoded response. shared counts the requests which got the response of
+//another one and is accessed atomically.
+type coalescing struct {
+	watch  sync.Once
+	mu     sync.Mutex
+	calls  map[string]*getCall
+	shared uint64
+}
+
+//changed keeps requests arriving after a write of key from sharing a lookup
+//which may have missed it, so a GET sees every write acknowledged before it.
+//It is called with the storage write lock held, before the write is acknowledged.
+func (g *coalescing) changed(key string, _ *storage.Item) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+}
+
+//getEncoded looks up key like GetOrLoad and encodes its response, or waits for
+//a request of the same key already doing so.
+func (srv *Server) getEncoded(ctx context.Context, key string) (storage.Item, []byte, error) {
+	g := &srv.gets
+	//not under g.mu: the storage calls changed with its lock held
+	g.watch.Do(func() {
+		srv.storage.NotifyChanged("*", g.changed)
+	})
+	for {
+		g.mu.Lock()
+		call, ok := g.calls[key]
+		if !ok {
+			break
+		}
+		g.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return storage.Item{}, nil, ctx.Err()
+		}
+		//the request doing the lookup went away, not the item
+		if !call.canceled {
+			atomic.AddUint64(&g.shared, 1)
+			return call.item, call.body, call.err
+		}
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*getCall)
+	}
+	call := &getCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.item, call.err = srv.storage.GetOrLoad(ctx, key)
+	if call.err == nil {
+		call.body = srv.responses.encode(key, call.item)
+	}
+	call.canceled = call.err != nil && call.err == ctx.Err()
+
+	g.mu.Lock()
+	//a write may have replaced it with a newer lookup
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+	close(call.done)
+	return call.item, call.body, call.err
+}
+
+//encodeItem returns the JSON response of item, or nil for values written with
+//a content type and values which can't be encoded.
+func encodeItem(item storage.Item) []byte {
+	switch item.Object.(type) {
+	case storage.ChunkedValue:
+		return nil
+	case string:
+		if item.ContentType != "" {
+			return nil
+		}
+	}
+	body, err := json.Marshal(itemResponse{item.Object, item.Version})
+	if err != nil {
+		return nil
+	}
+	//same as the output of utils.Respond
+	return append(body, '\n')
+}
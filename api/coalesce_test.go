@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"testing"
+	"time"
+)
+
+//blockingLoader returns a loader which signals each call on started and
+//returns value once release is closed.
+func blockingLoader(value string) (storage.Loader, chan struct{}, chan struct{}) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	return func(ctx context.Context, key string) (interface{}, error) {
+		started <- struct{}{}
+		<-release
+		return value, nil
+	}, started, release
+}
+
+func TestGetEncoded_ReadYourWrites(t *testing.T) {
+	db := storage.New(storage.DefaultExpiration, 0, 0)
+	srv := NewServer(db)
+	loader, started, release := blockingLoader("loaded")
+	db.SetLoader(loader, 0)
+
+	leader := make(chan error)
+	go func() {
+		_, _, err := srv.getEncoded(context.Background(), "k")
+		leader <- err
+	}()
+	<-started
+
+	//a GET after an acknowledged write doesn't join the lookup which started before it
+	db.Set("k", "written", 0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	item, _, err := srv.getEncoded(ctx, "k")
+	if err != nil || item.Object != "written" {
+		t.Errorf("GET after a write returned %v, %v", item.Object, err)
+	}
+	close(release)
+	if err := <-leader; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetEncoded_LeaderCanceled(t *testing.T) {
+	db := storage.New(storage.DefaultExpiration, 0, 0)
+	srv := NewServer(db)
+	loader, started, release := blockingLoader("loaded")
+	db.SetLoader(loader, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error)
+	go func() {
+		_, _, err := srv.getEncoded(ctx, "k")
+		leader <- err
+	}()
+	<-started
+
+	type result struct {
+		item storage.Item
+		err  error
+	}
+	waiter := make(chan result)
+	go func() {
+		item, _, err := srv.getEncoded(context.Background(), "k")
+		waiter <- result{item, err}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	//the request going away fails only itself, the waiter does the lookup again
+	cancel()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled request returned %v", err)
+	}
+	close(release)
+	select {
+	case res := <-waiter:
+		if res.err != nil || res.item.Object != "loaded" {
+			t.Errorf("waiter returned %v, %v", res.item.Object, res.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter didn't return")
+	}
+}
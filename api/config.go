@@ -1,15 +1,270 @@
 package api
 
+import (
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
 type Config struct {
-	BindAddr   string `toml:"bind_addr"`
-	DBSize     int    `toml:"db_size"`
-	DBFileName string `toml:"file_name"`
+	BindAddr        string  `toml:"bind_addr"`
+	DBSize          int     `toml:"db_size"`
+	DBFileName      string  `toml:"file_name"`
+	FullTextSearch  bool    `toml:"full_text_search"`
+	MaxKeyLength    int     `toml:"max_key_length"`
+	AllowBinaryKeys bool    `toml:"allow_binary_keys"`
+	ShrinkThreshold float64 `toml:"shrink_threshold"`
+	ReserveOnFlush  bool    `toml:"reserve_on_flush"`
+	//CoarseClock is a resolution like "1ms"; empty means precise time is used
+	CoarseClock string `toml:"coarse_clock"`
+	//values written in a request body larger than ChunkThreshold bytes are stored in ChunkSize segments
+	ChunkThreshold int   `toml:"chunk_threshold"`
+	ChunkSize      int   `toml:"chunk_size"`
+	MaxValueSize   int64 `toml:"max_value_size"`
+	//string values larger than CompressThreshold bytes are kept DEFLATE-compressed
+	//in memory; 0 disables it except for namespaces with compress = true
+	CompressThreshold int `toml:"compress_threshold"`
+	//OverflowDir keeps items evicted from full namespaces in files of the directory
+	//until they expire, and reads fetch them back; empty disables it
+	OverflowDir string `toml:"overflow_dir"`
+	//ItemChecksums stores a checksum with every written value; reads and loads
+	//drop values which don't match it and count them as corrupted
+	ItemChecksums bool `toml:"item_checksums"`
+	//NodeID is put in the IDs of /ids/next; servers sharing ID namespaces need different ones
+	NodeID int `toml:"node_id"`
+	//SnapshotMode is "copy" or "block", RestoreMode is "swap" or "block", see storage.SetConsistency
+	SnapshotMode string `toml:"snapshot_mode"`
+	RestoreMode  string `toml:"restore_mode"`
+	//OnCorrupt is "fail", "empty" or "partial", see storage.RecoveryMode
+	OnCorrupt string `toml:"on_corrupt"`
+	//Upstream is the address of a kvstorage-srv whose values are cached on a miss
+	//for UpstreamTTL (a duration like "5m"; empty means the default expiration)
+	Upstream    string `toml:"upstream"`
+	UpstreamTTL string `toml:"upstream_ttl"`
+	//UpstreamMaxStale serves values which expired at most that long ago (a duration)
+	//while the upstream fails; up to UpstreamStaleSize of them are kept after expiring
+	UpstreamMaxStale  string `toml:"upstream_max_stale"`
+	UpstreamStaleSize int    `toml:"upstream_stale_size"`
+	//Shadow forwards every change asynchronously to another kvstorage-srv
+	//("http://host:port") or Redis ("redis://host:port/db"), e.g. to validate a
+	//migration; up to ShadowQueueSize changes wait to be forwarded.
+	//ShadowPercent (1-100, all if 0) of the keys are shadowed, and their reads
+	//are compared with the target's if ShadowCompareReads is set
+	Shadow             string `toml:"shadow"`
+	ShadowQueueSize    int    `toml:"shadow_queue_size"`
+	ShadowPercent      int    `toml:"shadow_percent"`
+	ShadowCompareReads bool   `toml:"shadow_compare_reads"`
+	//DefaultExpiration and CleanupInterval are durations like "5m"
+	DefaultExpiration string `toml:"default_expiration"`
+	CleanupInterval   string `toml:"cleanup_interval"`
+	//JanitorWarnAfter logs a warning about janitor runs taking longer; empty disables it
+	JanitorWarnAfter string `toml:"janitor_warn_after"`
+	//TraceLockWaits exposes how long operations wait for the storage lock in /metrics
+	TraceLockWaits bool `toml:"trace_lock_waits"`
+	//KeyspaceMetrics exposes items, memory, hit ratio and expirations per namespace in /metrics
+	KeyspaceMetrics bool `toml:"keyspace_metrics"`
+	//AccessStatsInterval enables /admin/access, counting reads, writes and misses
+	//per key prefix of AccessStatsDepth segments (default 1) in windows of the
+	//interval (a duration like "1m"), of which AccessStatsWindows are kept (default 60)
+	AccessStatsInterval string `toml:"access_stats_interval"`
+	AccessStatsWindows  int    `toml:"access_stats_windows"`
+	AccessStatsDepth    int    `toml:"access_stats_depth"`
+	//ForecastInterval enables /admin/forecast, sampling the items of the storage
+	//and of namespaces with max_items every interval; the last ForecastSamples
+	//samples (default 60) give the growth rate
+	ForecastInterval string `toml:"forecast_interval"`
+	ForecastSamples  int    `toml:"forecast_samples"`
+	//KeyNotifications lets writes pass ?notify=<url>, which is posted an "expired"
+	//or "evicted" event when the item is removed; it lets clients make the server
+	//post to any address, so it is disabled by default
+	KeyNotifications bool `toml:"key_notifications"`
+	//QuotaAlertURL receives a "quota_exceeded" event when writes to a namespace
+	//are first rejected because it reached max_items
+	QuotaAlertURL string `toml:"quota_alert_url"`
+	//FaultInjection enables /admin/faults which can add latency, drop requests
+	//and fail persistence; never enable it in production
+	FaultInjection bool `toml:"fault_injection"`
+	//HistoryChecks enables /admin/history which records operations and checks
+	//that they are linearizable; meant for CI and benchmarks
+	HistoryChecks bool `toml:"history_checks"`
+	//Namespaces override expiration settings and limit the size of namespaces
+	Namespaces map[string]NamespaceConfig `toml:"namespaces"`
+	//WriteHooks check and transform JSON documents written to a namespace
+	WriteHooks []WriteHookConfig `toml:"write_hooks"`
+	//ExpiryHooks are registered on start, more can be added through /admin/expiry-hooks
+	ExpiryHooks []ExpiryHook `toml:"expiry_hooks"`
+	//Save rules like "300 10" save the db when at least 10 writes happened
+	//and 300 seconds passed since the last save
+	Save []string `toml:"save"`
+	//DeltaInterval is how often keys changed since the last save are written to
+	//a delta file next to the db file (a duration like "10s"; empty disables it).
+	//After MaxDeltas deltas a full snapshot is saved instead (0 means no limit)
+	DeltaInterval string `toml:"delta_interval"`
+	MaxDeltas     int    `toml:"max_deltas"`
+	//SaveOnShutdown saves the db on SIGINT and SIGTERM; ShutdownSaveTimeout limits
+	//how long the final save may take (empty means no limit)
+	SaveOnShutdown      bool   `toml:"save_on_shutdown"`
+	ShutdownSaveTimeout string `toml:"shutdown_save_timeout"`
+	//PersistenceWarnAfter is how long saving may fail before writes get a Warning header
+	PersistenceWarnAfter string `toml:"persistence_warn_after"`
+	//Values are encrypted in db files with the first key read from the file or the
+	//environment variable, as "id:base64key" separated by commas or newlines;
+	//the other keys only decrypt files written before a rotation
+	EncryptionKeysFile string `toml:"encryption_keys_file"`
+	EncryptionKeysEnv  string `toml:"encryption_keys_env"`
+	//Requests beyond MaxConcurrentRequests get a 503 and connections beyond
+	//MaxConnections are closed right away; 0 means no limit
+	MaxConcurrentRequests int `toml:"max_concurrent_requests"`
+	MaxConnections        int `toml:"max_connections"`
+	//Writes with ?async=true wait in a queue of AsyncQueueSize (default 10000);
+	//AsyncOverflow is "reject", "drop_oldest" or "block" when it is full
+	AsyncQueueSize int    `toml:"async_queue_size"`
+	AsyncOverflow  string `toml:"async_overflow"`
+	//ResponseCacheSize is how many encoded GET responses of hot keys are kept; 0 disables it
+	ResponseCacheSize int `toml:"response_cache_size"`
+	//RouteTimeouts limit how long requests of a route (its path template like
+	//"/items/") may take, as durations like "5s"
+	RouteTimeouts map[string]string `toml:"route_timeouts"`
+	//Log describes the destination, format, level and rotation of the log
+	Log LogConfig `toml:"log"`
+	//Listeners replace bind_addr when given, serving the API on several addresses
+	Listeners []ListenerConfig `toml:"listeners"`
+	//Force starts even if another instance holds the lock of the db file,
+	//it is set by the --force flag
+	Force bool `toml:"-"`
+	//Stores are independent storages served under their own path prefix
+	Stores []StoreConfig `toml:"stores"`
+}
+
+//StoreConfig describes a storage mounted under Prefix. Options which are not set
+//are taken from the top level config, except for the file name which is required.
+type StoreConfig struct {
+	Prefix            string `toml:"prefix"`
+	DBFileName        string `toml:"file_name"`
+	DBSize            int    `toml:"db_size"`
+	DefaultExpiration string `toml:"default_expiration"`
+	CleanupInterval   string `toml:"cleanup_interval"`
+}
+
+//NamespaceConfig holds durations like "30m"; empty values keep the storage settings.
+type NamespaceConfig struct {
+	DefaultExpiration string `toml:"default_expiration"`
+	CleanupInterval   string `toml:"cleanup_interval"`
+	MaxItems          int    `toml:"max_items"`
+	//Evict makes writes to a full namespace evict volatile, then normal items
+	Evict bool `toml:"evict"`
+	//Canonicalize normalizes keys with "lower", "trim" and "nfc", see storage.KeyCanon
+	Canonicalize []string `toml:"canonicalize"`
+	//Compress overrides compress_threshold for the namespace: true compresses
+	//values above it (or storage.DefaultCompressThreshold), false never does
+	Compress *bool `toml:"compress"`
+}
+
+//WriteHookConfig holds the expressions of a storage.WriteHook, e.g.
+//reject = "$.qty <= 0" and set = ["$.updated_at = now()"].
+type WriteHookConfig struct {
+	Namespace string   `toml:"namespace"`
+	Reject    string   `toml:"reject"`
+	Message   string   `toml:"message"`
+	Set       []string `toml:"set"`
+}
+
+func (hc WriteHookConfig) hook() (storage.WriteHook, error) {
+	h := storage.WriteHook{Message: hc.Message}
+	if hc.Reject != "" {
+		var err error
+		if h.Reject, err = storage.ParseExpr(hc.Reject); err != nil {
+			return h, err
+		}
+		if h.Message == "" {
+			h.Message = "rejected by " + hc.Reject
+		}
+	}
+	for _, s := range hc.Set {
+		a, err := storage.ParseAssignment(s)
+		if err != nil {
+			return h, err
+		}
+		h.Set = append(h.Set, a)
+	}
+	return h, nil
+}
+
+func (nc NamespaceConfig) options() (storage.NamespaceOptions, error) {
+	opts := storage.NamespaceOptions{MaxItems: nc.MaxItems, Evict: nc.Evict}
+	var err error
+	if opts.Canon, err = storage.ParseKeyCanon(nc.Canonicalize); err != nil {
+		return opts, err
+	}
+	if nc.Compress != nil {
+		opts.Compression = storage.CompressOff
+		if *nc.Compress {
+			opts.Compression = storage.CompressOn
+		}
+	}
+	if nc.DefaultExpiration != "" {
+		if opts.DefaultExpiration, err = time.ParseDuration(nc.DefaultExpiration); err != nil {
+			return opts, err
+		}
+	}
+	if nc.CleanupInterval != "" {
+		if opts.CleanupInterval, err = time.ParseDuration(nc.CleanupInterval); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+//compresses reports whether any values are compressed, so that their stats are worth scanning for.
+func (c *Config) compresses() bool {
+	if c == nil {
+		return false
+	}
+	if c.CompressThreshold > 0 {
+		return true
+	}
+	for _, nc := range c.Namespaces {
+		if nc.Compress != nil && *nc.Compress {
+			return true
+		}
+	}
+	return false
+}
+
+//forStore returns the config of the store described by sc.
+func (c *Config) forStore(sc StoreConfig) *Config {
+	cfg := *c
+	cfg.Stores = nil
+	cfg.DBFileName = sc.DBFileName
+	if sc.DBSize != 0 {
+		cfg.DBSize = sc.DBSize
+	}
+	if sc.DefaultExpiration != "" {
+		cfg.DefaultExpiration = sc.DefaultExpiration
+	}
+	if sc.CleanupInterval != "" {
+		cfg.CleanupInterval = sc.CleanupInterval
+	}
+	//stores must not share the files of their evicted items
+	if cfg.OverflowDir != "" {
+		cfg.OverflowDir = filepath.Join(cfg.OverflowDir, strings.Trim(sc.Prefix, "/"))
+	}
+	return &cfg
 }
 
 func NewConfig() *Config {
 	return &Config{
-		BindAddr:   ":8080",
-		DBSize:     0,
-		DBFileName: "db.dat",
+		BindAddr:       ":8080",
+		DBSize:         0,
+		DBFileName:     "db.dat",
+		MaxKeyLength:   storage.DefaultMaxKeyLength,
+		ReserveOnFlush: true,
+		SaveOnShutdown: true,
+		//same as the values used before they became configurable
+		DefaultExpiration:    "5m",
+		CleanupInterval:      "10m",
+		JanitorWarnAfter:     "1s",
+		PersistenceWarnAfter: "1m",
 	}
 }
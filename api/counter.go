@@ -0,0 +1,135 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const defaultRetention = 60
+
+func parseWindow(s string) (time.Duration, error) {
+	switch s {
+	case "", "minute":
+		return time.Minute, nil
+	case "hour":
+		return time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+//parseTimeRange reads RFC3339 "from" and "to" parameters, defaulting to everything up to now.
+func parseTimeRange(q url.Values) (time.Time, time.Time, error) {
+	from := time.Unix(0, 0)
+	to := time.Now()
+	var err error
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, errors.New("invalid from")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, errors.New("invalid to")
+		}
+	}
+	return from, to, nil
+}
+
+//HandleIncr adds ?by (default 1) to an integer value. A missing key is created
+//from ?initial (default 0).
+func (srv *Server) HandleIncr() http.HandlerFunc {
+	type response struct {
+		Value int64 `json:"value"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+		q := r.URL.Query()
+
+		by, initial := int64(1), int64(0)
+		var err error
+		if v := q.Get("by"); v != "" {
+			if by, err = strconv.ParseInt(v, 10, 64); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid by"))
+				return
+			}
+		}
+		if v := q.Get("initial"); v != "" {
+			if initial, err = strconv.ParseInt(v, 10, 64); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid initial"))
+				return
+			}
+		}
+
+		n, err := srv.guarded(r).Incr(key, by, initial)
+		if err != nil {
+			srv.writeError(w, r, key, http.StatusUnprocessableEntity, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, response{n})
+	}
+}
+
+func (srv *Server) HandleIncrWindow() http.HandlerFunc {
+	type response struct {
+		Value int64 `json:"value"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+		q := r.URL.Query()
+
+		window, err := parseWindow(q.Get("window"))
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid window"))
+			return
+		}
+		retention := defaultRetention
+		if v := q.Get("retention"); v != "" {
+			if retention, err = strconv.Atoi(v); err != nil || retention <= 0 || retention > storage.MaxWindowRetention {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("retention must be between 1 and %d", storage.MaxWindowRetention))
+				return
+			}
+		}
+		delta := int64(1)
+		if v := q.Get("delta"); v != "" {
+			if delta, err = strconv.ParseInt(v, 10, 64); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid delta"))
+				return
+			}
+		}
+
+		n, err := srv.guarded(r).IncrWindow(key, window, retention, delta)
+		if err != nil {
+			srv.writeError(w, r, key, http.StatusConflict, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, response{n})
+	}
+}
+
+func (srv *Server) HandleWindowRange() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+
+		from, to, err := parseTimeRange(r.URL.Query())
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		buckets, err := srv.storage.WindowRange(key, from, to)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusNotFound, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, buckets)
+	}
+}
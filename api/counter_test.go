@@ -0,0 +1,38 @@
+package api
+
+import (
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIncrWindow_Errors(t *testing.T) {
+	db := storage.New(storage.DefaultExpiration, 0, 0)
+	db.SetNamespaceOptions("full", storage.NamespaceOptions{MaxItems: 1})
+	db.Set("full:1", "v", storage.NoExpiration)
+	db.Set("str", "v", storage.NoExpiration)
+	srv := NewServer(db)
+	srv.config = &Config{}
+	srv.configureRouter()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	for _, c := range []struct {
+		key  string
+		code int
+	}{
+		{"full:2", http.StatusInsufficientStorage},
+		{"str", http.StatusConflict},
+		{"hits", http.StatusOK},
+	} {
+		resp, err := http.Post(ts.URL+"/counters/"+c.key+"/incr?window=minute", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.code {
+			t.Errorf("increment of %s returned %d, want %d", c.key, resp.StatusCode, c.code)
+		}
+	}
+}
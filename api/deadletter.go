@@ -0,0 +1,151 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"time"
+)
+
+//deadLetterNamespace holds events whose delivery failed after all retries.
+//They are items like any other, so they are persisted with the db and
+//max_items of the namespace limits how many are kept.
+const deadLetterNamespace = "_deadletter"
+
+type deadLetter struct {
+	ID       string       `json:"id"`
+	URL      string       `json:"url"`
+	Event    webhookEvent `json:"event"`
+	Error    string       `json:"error"`
+	FailedAt time.Time    `json:"failed_at"`
+	//Replays counts failed replays
+	Replays int `json:"replays"`
+}
+
+func deadLetterKey(id string) string {
+	return deadLetterNamespace + storage.NamespaceSeparator + id
+}
+
+//deliverOrDeadLetter delivers event and stores it as a dead letter if that fails.
+func (srv *Server) deliverOrDeadLetter(url string, event webhookEvent) {
+	err := deliver(url, event)
+	if err == nil {
+		return
+	}
+	log.Printf("delivery of %s event for %s: %v", event.Type, event.Key, err)
+
+	b := make([]byte, 4)
+	rand.Read(b)
+	//ids sort by the time of the failure
+	dl := deadLetter{
+		ID:       fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(b)),
+		URL:      url,
+		Event:    event,
+		Error:    err.Error(),
+		FailedAt: time.Now(),
+	}
+	if err = srv.putDeadLetter(dl); err != nil {
+		log.Printf("WARNING: dropped %s event for %s: %v", event.Type, event.Key, err)
+	}
+}
+
+func (srv *Server) putDeadLetter(dl deadLetter) error {
+	raw, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	_, err = srv.storage.Write(deadLetterKey(dl.ID), string(raw), storage.WriteOptions{TTL: storage.NoExpiration})
+	return err
+}
+
+func decodeDeadLetter(v interface{}) (deadLetter, bool) {
+	dl := deadLetter{}
+	raw, _ := v.(string)
+	return dl, json.Unmarshal([]byte(raw), &dl) == nil
+}
+
+//deadLetters returns the dead letters ordered by the time they failed.
+func (srv *Server) deadLetters() []deadLetter {
+	sn := srv.storage.Snapshot(storage.NamespaceFilter(deadLetterNamespace))
+	list := make([]deadLetter, 0, sn.Len())
+	for _, key := range sn.Keys() {
+		item, _ := sn.Get(key)
+		if dl, ok := decodeDeadLetter(item.Object); ok {
+			list = append(list, dl)
+		}
+	}
+	return list
+}
+
+//replay delivers dl again once, deleting it on success and recording the failure otherwise.
+func (srv *Server) replay(dl deadLetter) error {
+	if err := deliver(dl.URL, dl.Event); err != nil {
+		dl.Error = err.Error()
+		dl.Replays++
+		srv.putDeadLetter(dl)
+		return err
+	}
+	srv.storage.Delete(deadLetterKey(dl.ID))
+	return nil
+}
+
+//HandleDeadLetters lists undelivered events, oldest first.
+func (srv *Server) HandleDeadLetters() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		utils.Respond(w, r, http.StatusOK, srv.deadLetters())
+	}
+}
+
+//HandleReplayDeadLetter delivers a dead letter again; it is removed once delivered.
+func (srv *Server) HandleReplayDeadLetter() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v, found := srv.storage.Get(deadLetterKey(mux.Vars(r)["id"]))
+		dl, ok := decodeDeadLetter(v)
+		if !found || !ok {
+			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("no such dead letter"))
+			return
+		}
+		if err := srv.replay(dl); err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadGateway, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+//HandleReplayDeadLetters delivers all dead letters again, oldest first.
+func (srv *Server) HandleReplayDeadLetters() http.HandlerFunc {
+	type response struct {
+		Delivered int `json:"delivered"`
+		Failed    int `json:"failed"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response{}
+		for _, dl := range srv.deadLetters() {
+			if srv.replay(dl) != nil {
+				resp.Failed++
+			} else {
+				resp.Delivered++
+			}
+		}
+		utils.Respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (srv *Server) HandleDeleteDeadLetter() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !srv.storage.Delete(deadLetterKey(mux.Vars(r)["id"])) {
+			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("no such dead letter"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
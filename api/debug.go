@@ -0,0 +1,28 @@
+package api
+
+import (
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+)
+
+//HandleDebugKey describes everything known about a key, like DEBUG OBJECT,
+//without counting as a read of it. It adds the key lock to storage.DebugKey.
+func (srv *Server) HandleDebugKey() http.HandlerFunc {
+	type response struct {
+		storage.KeyDebug
+		LockedUntil *time.Time `json:"locked_until,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+
+		resp := response{KeyDebug: srv.storage.DebugKey(key)}
+		if lock, locked := srv.keyLocks.check(key, ""); locked {
+			resp.LockedUntil = &lock.expires
+		}
+		utils.Respond(w, r, http.StatusOK, resp)
+	}
+}
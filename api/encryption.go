@@ -0,0 +1,82 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"net/http"
+	"os"
+	"strings"
+)
+
+//encryptionKeys reads KEKs from encryption_keys_file or, if it is not set, from
+//the environment variable named by encryption_keys_env. No source means no encryption.
+func encryptionKeys(config *Config) ([]storage.KEK, error) {
+	switch {
+	case config.EncryptionKeysFile != "":
+		raw, err := os.ReadFile(config.EncryptionKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		return storage.ParseKEKs(strings.ReplaceAll(string(raw), "\n", ","))
+	case config.EncryptionKeysEnv != "":
+		return storage.ParseKEKs(os.Getenv(config.EncryptionKeysEnv))
+	}
+	return nil, nil
+}
+
+//servers returns the server with all of its mounted stores.
+func (srv *Server) servers() []*Server {
+	return append([]*Server{srv}, srv.stores...)
+}
+
+//HandleEncryption returns the id of the key the db files are encrypted with.
+func (srv *Server) HandleEncryption() http.HandlerFunc {
+	type response struct {
+		Key string `json:"key,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		utils.Respond(w, r, http.StatusOK, response{Key: srv.storage.EncryptionKey()})
+	}
+}
+
+//HandleRotateEncryption reloads the keys and rewrites the db files of all stores
+//under a new data key encrypted with the current KEK. Keys which were rotated out
+//can be removed once it succeeded.
+func (srv *Server) HandleRotateEncryption() http.HandlerFunc {
+	type response struct {
+		Key   string   `json:"key,omitempty"`
+		Files []string `json:"files"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if srv.config == nil {
+			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("encryption is not configured"))
+			return
+		}
+		keys, err := encryptionKeys(srv.config)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusInternalServerError, fmt.Errorf("loading encryption keys: %w", err))
+			return
+		}
+
+		resp := response{Files: []string{}}
+		for _, s := range srv.servers() {
+			if err = s.storage.SetEncryption(keys); err != nil {
+				utils.ErrorMessage(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		for _, s := range srv.servers() {
+			if err = s.saveFile(s.config.DBFileName); err != nil {
+				utils.ErrorMessage(w, r, http.StatusInternalServerError, fmt.Errorf("rewriting %s: %w", s.config.DBFileName, err))
+				return
+			}
+			resp.Files = append(resp.Files, s.config.DBFileName)
+		}
+		resp.Key = srv.storage.EncryptionKey()
+		utils.Respond(w, r, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errInjectedFault = errors.New("injected persistence failure")
+
+//faults are injected into requests for resilience testing when fault_injection
+//is enabled in config; they are changed at runtime through /admin/faults.
+type faults struct {
+	mu       sync.RWMutex
+	settings faultSettings
+}
+
+type faultSettings struct {
+	//Latency is a duration like "100ms" added to every request
+	Latency string `json:"latency,omitempty"`
+	//DropRate is the fraction of requests whose connection is closed without a response
+	DropRate float64 `json:"drop_rate"`
+	//FailPersistence makes saving the db fail
+	FailPersistence bool `json:"fail_persistence"`
+
+	latency time.Duration
+}
+
+func (f *faults) get() faultSettings {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.settings
+}
+
+//injectFaults delays and drops requests except those changing the faults.
+func (srv *Server) injectFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/faults") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		fs := srv.faults.get()
+		if fs.latency > 0 {
+			time.Sleep(fs.latency)
+		}
+		if fs.DropRate > 0 && rand.Float64() < fs.DropRate {
+			panic(http.ErrAbortHandler)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (srv *Server) HandleGetFaults() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		utils.Respond(w, r, http.StatusOK, srv.faults.get())
+	}
+}
+
+//HandleSetFaults replaces all injected faults; an empty object disables them.
+func (srv *Server) HandleSetFaults() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fs := faultSettings{}
+		if err := json.NewDecoder(r.Body).Decode(&fs); err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if fs.Latency != "" {
+			d, err := time.ParseDuration(fs.Latency)
+			if err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+				return
+			}
+			fs.latency = d
+		}
+		if fs.DropRate < 0 || fs.DropRate > 1 {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("drop_rate must be between 0 and 1"))
+			return
+		}
+
+		srv.faults.mu.Lock()
+		srv.faults.settings = fs
+		srv.faults.mu.Unlock()
+		utils.Respond(w, r, http.StatusOK, fs)
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"io"
+	"net/http"
+	"time"
+)
+
+//runGrowthSamples samples the number of items every interval for forecasts.
+func (srv *Server) runGrowthSamples(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		srv.storage.SampleGrowth()
+	}
+}
+
+//HandleForecast estimates from the items sampled every forecast_interval when
+//namespaces with max_items will be full at their current growth.
+func (srv *Server) HandleForecast() http.HandlerFunc {
+	type response struct {
+		Storage    storage.Forecast   `json:"storage"`
+		Namespaces []storage.Forecast `json:"namespaces"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		f := srv.storage.Forecast()
+		if f == nil {
+			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("forecasts are disabled"))
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, response{Storage: f[0], Namespaces: f[1:]})
+	}
+}
+
+//writeForecastMetrics exposes the seconds left until namespaces are full, so
+//capacity alerts can fire on a threshold; namespaces which don't fill up at
+//their current growth are left out.
+func (srv *Server) writeForecastMetrics(w io.Writer) {
+	f := srv.storage.Forecast()
+	if len(f) < 2 {
+		return
+	}
+	fmt.Fprint(w, "# HELP kvstorage_namespace_full_seconds Seconds until the namespace reaches max_items at its current growth.\n# TYPE kvstorage_namespace_full_seconds gauge\n")
+	for _, nf := range f[1:] {
+		if nf.FullAt != nil {
+			fmt.Fprintf(w, "kvstorage_namespace_full_seconds{namespace=%q} %g\n", nf.Namespace, time.Until(*nf.FullAt).Seconds())
+		}
+	}
+}
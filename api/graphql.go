@@ -0,0 +1,397 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+//graphqlSchema is what /graphql serves. It isn't used for validation, fields
+//are checked when they are resolved.
+const graphqlSchema = `type Query {
+  item(key: String!): Item
+  items(prefix: String, namespace: String, after: String, limit: Int = 100): [Item!]!
+}
+
+type Mutation {
+  set(key: String!, value: String!, ttl: String): Item!
+  delete(key: String!): Boolean!
+}
+
+type Item {
+  key: String!
+  value: JSON
+  version: Int!
+  ttl: Float
+  expiresAt: String
+  sliding: Boolean!
+  contentType: String
+  class: String!
+}`
+
+//defaultListLimit and maxListLimit bound the pages of key listings.
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type graphqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+type graphqlResponse struct {
+	Data   interface{}    `json:"data,omitempty"`
+	Errors []graphqlError `json:"errors,omitempty"`
+}
+
+//gqlObject is a result object, which keeps its fields in the order they were selected.
+type gqlObject []gqlEntry
+
+type gqlEntry struct {
+	key   string
+	value interface{}
+}
+
+func (o gqlObject) MarshalJSON() ([]byte, error) {
+	buf := bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, e := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(e.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(e.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+var gqlRootTypes = map[string]string{"query": "Query", "mutation": "Mutation"}
+
+//gqlExec holds the state of executing one operation.
+type gqlExec struct {
+	srv    *Server
+	r      *http.Request
+	vars   map[string]interface{}
+	errors []graphqlError
+}
+
+//HandleGraphQL serves reads selecting only the fields they need and set/delete
+//mutations, see graphqlSchema. Queries are taken from POST bodies like
+//{"query": ..., "variables": ...} or the query parameter of a GET, which can't run mutations.
+func (srv *Server) HandleGraphQL() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := graphqlRequest{}
+		if r.Method == http.MethodGet {
+			q := r.URL.Query()
+			req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+			if v := q.Get("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+					utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("invalid variables: %v", err))
+					return
+				}
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid request body"))
+			return
+		}
+
+		op, err := selectOperation(req)
+		if err != nil {
+			utils.Respond(w, r, http.StatusBadRequest, graphqlResponse{Errors: []graphqlError{{Message: err.Error()}}})
+			return
+		}
+		if op.kind == "mutation" && r.Method == http.MethodGet {
+			utils.Respond(w, r, http.StatusMethodNotAllowed, graphqlResponse{Errors: []graphqlError{{Message: "mutations require POST"}}})
+			return
+		}
+		ex := &gqlExec{srv: srv, r: r, vars: make(map[string]interface{})}
+		for _, def := range op.vars {
+			if v, ok := req.Variables[def.name]; ok {
+				ex.vars[def.name] = v
+			} else if def.hasDefault {
+				ex.vars[def.name] = def.def
+			}
+		}
+		data := ex.root(op)
+		utils.Respond(w, r, http.StatusOK, graphqlResponse{Data: data, Errors: ex.errors})
+	}
+}
+
+//HandleGraphQLSchema returns the schema of /graphql in the schema language.
+func (srv *Server) HandleGraphQLSchema() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, graphqlSchema)
+	}
+}
+
+//selectOperation parses the query and picks the operation to run.
+func selectOperation(req graphqlRequest) (gqlOperation, error) {
+	ops, err := parseGraphQL(req.Query)
+	if err != nil {
+		return gqlOperation{}, err
+	}
+	if req.OperationName == "" {
+		if len(ops) > 1 {
+			return gqlOperation{}, errors.New("operationName is required for documents with several operations")
+		}
+		return ops[0], nil
+	}
+	for _, op := range ops {
+		if op.name == req.OperationName {
+			return op, nil
+		}
+	}
+	return gqlOperation{}, fmt.Errorf("no operation %s", req.OperationName)
+}
+
+func (ex *gqlExec) fail(path []interface{}, err error) {
+	ex.errors = append(ex.errors, graphqlError{Message: err.Error(), Path: append([]interface{}{}, path...)})
+}
+
+//root resolves the fields of the operation in order, so mutations apply in order.
+func (ex *gqlExec) root(op gqlOperation) gqlObject {
+	res := make(gqlObject, 0, len(op.selections))
+	for _, f := range op.selections {
+		path := []interface{}{f.responseKey()}
+		var value interface{}
+		var err error
+		switch {
+		case f.name == "__typename":
+			value = gqlRootTypes[op.kind]
+		case op.kind == "query" && f.name == "item":
+			value, err = ex.item(f, path)
+		case op.kind == "query" && f.name == "items":
+			value, err = ex.items(f, path)
+		case op.kind == "mutation" && f.name == "set":
+			value, err = ex.set(f, path)
+		case op.kind == "mutation" && f.name == "delete":
+			value, err = ex.delete(f)
+		default:
+			err = fmt.Errorf("no field %s on %s", f.name, gqlRootTypes[op.kind])
+		}
+		if err != nil {
+			ex.fail(path, err)
+			value = nil
+		}
+		res = append(res, gqlEntry{f.responseKey(), value})
+	}
+	return res
+}
+
+//arg returns the argument name of f with variables substituted.
+func (ex *gqlExec) arg(f gqlField, name string) (interface{}, bool) {
+	v, ok := f.args[name]
+	if ref, isVar := v.(gqlVar); isVar {
+		v, ok = ex.vars[string(ref)]
+	}
+	return v, ok && v != nil
+}
+
+func (ex *gqlExec) stringArg(f gqlField, name string, required bool) (string, error) {
+	v, ok := ex.arg(f, name)
+	if !ok {
+		if required {
+			return "", fmt.Errorf("argument %s is required", name)
+		}
+		return "", nil
+	}
+	s, isString := v.(string)
+	if !isString {
+		return "", fmt.Errorf("argument %s must be a string", name)
+	}
+	return s, nil
+}
+
+func (ex *gqlExec) intArg(f gqlField, name string, def int) (int, error) {
+	v, ok := ex.arg(f, name)
+	if !ok {
+		return def, nil
+	}
+	switch n := v.(type) {
+	case int64:
+		return int(n), nil
+	case float64:
+		//variables are decoded from JSON
+		if n == float64(int(n)) {
+			return int(n), nil
+		}
+	}
+	return 0, fmt.Errorf("argument %s must be an integer", name)
+}
+
+func (ex *gqlExec) item(f gqlField, path []interface{}) (interface{}, error) {
+	key, err := ex.stringArg(f, "key", true)
+	if err != nil {
+		return nil, err
+	}
+	key = ex.srv.storage.CanonicalKey(key)
+	item, found := ex.srv.storage.GetItem(key)
+	if !found {
+		return nil, nil
+	}
+	return ex.itemFields(f, path, key, item)
+}
+
+func (ex *gqlExec) items(f gqlField, path []interface{}) (interface{}, error) {
+	prefix, err := ex.stringArg(f, "prefix", false)
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := ex.stringArg(f, "namespace", false)
+	if err != nil {
+		return nil, err
+	}
+	after, err := ex.stringArg(f, "after", false)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := ex.intArg(f, "limit", defaultListLimit)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 1 || limit > maxListLimit {
+		return nil, fmt.Errorf("limit must be within [1, %d]", maxListLimit)
+	}
+
+	filter := storage.PrefixFilter(prefix)
+	if namespace != "" {
+		ns := storage.NamespaceFilter(namespace)
+		filter = func(key string) bool {
+			return ns(key) && strings.HasPrefix(key, prefix)
+		}
+	}
+	sn, err := ex.srv.storage.SnapshotContext(ex.r.Context(), filter)
+	if err != nil {
+		return nil, err
+	}
+	keys := sn.Keys()
+	start := sort.SearchStrings(keys, after)
+	if start < len(keys) && keys[start] == after {
+		start++
+	}
+	if keys = keys[start:]; len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	list := make([]interface{}, 0, len(keys))
+	for i, key := range keys {
+		item, _ := sn.Get(key)
+		obj, err := ex.itemFields(f, append(path, i), key, item)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, obj)
+	}
+	return list, nil
+}
+
+func (ex *gqlExec) set(f gqlField, path []interface{}) (interface{}, error) {
+	key, err := ex.stringArg(f, "key", true)
+	if err != nil {
+		return nil, err
+	}
+	value, err := ex.stringArg(f, "value", true)
+	if err != nil {
+		return nil, err
+	}
+	ttlArg, err := ex.stringArg(f, "ttl", false)
+	if err != nil {
+		return nil, err
+	}
+	if key, err = ex.srv.checkKey(key); err != nil {
+		return nil, err
+	}
+	opts := writeOptions{ttl: storage.DefaultExpiration, lockToken: ex.r.Header.Get(LockTokenHeader)}
+	if ttlArg == "-1" {
+		opts.ttl = storage.NoExpiration
+	} else if ttlArg != "" {
+		if opts.ttl, err = time.ParseDuration(ttlArg); err != nil || opts.ttl <= 0 {
+			return nil, errors.New("invalid ttl")
+		}
+	}
+	if _, err = ex.srv.write(key, value, opts); err != nil {
+		return nil, err
+	}
+	item, found := ex.srv.storage.GetItem(key)
+	if !found {
+		//expired or deleted right away
+		return nil, nil
+	}
+	return ex.itemFields(f, path, key, item)
+}
+
+func (ex *gqlExec) delete(f gqlField) (interface{}, error) {
+	key, err := ex.stringArg(f, "key", true)
+	if err != nil {
+		return nil, err
+	}
+	key = ex.srv.storage.CanonicalKey(key)
+	return ex.srv.guarded(ex.r).Delete(key)
+}
+
+//itemFields resolves the selection of f on an item.
+func (ex *gqlExec) itemFields(f gqlField, path []interface{}, key string, item storage.Item) (gqlObject, error) {
+	if len(f.selections) == 0 {
+		return nil, fmt.Errorf("field %s of type Item must have a selection of subfields", f.name)
+	}
+	obj := make(gqlObject, 0, len(f.selections))
+	for _, sel := range f.selections {
+		var value interface{}
+		switch sel.name {
+		case "__typename":
+			value = "Item"
+		case "key":
+			value = key
+		case "value":
+			if _, chunked := item.Object.(storage.ChunkedValue); chunked {
+				ex.fail(append(path, sel.responseKey()), errors.New("chunked values can only be read through /items/{key}"))
+				break
+			}
+			value = item.Object
+		case "version":
+			value = item.Version
+		case "ttl":
+			if at := item.ExpiresAt(); !at.IsZero() {
+				value = time.Until(at).Seconds()
+			}
+		case "expiresAt":
+			if at := item.ExpiresAt(); !at.IsZero() {
+				value = at.UTC().Format(time.RFC3339Nano)
+			}
+		case "sliding":
+			value = item.Sliding > 0
+		case "contentType":
+			if item.ContentType != "" {
+				value = item.ContentType
+			}
+		case "class":
+			value = item.Class.String()
+		default:
+			return nil, fmt.Errorf("no field %s on Item", sel.name)
+		}
+		obj = append(obj, gqlEntry{sel.responseKey(), value})
+	}
+	return obj, nil
+}
@@ -0,0 +1,378 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//The parser covers the part of GraphQL the /graphql endpoint needs: query and
+//mutation operations with variables, fields with aliases, arguments and
+//selection sets. Fragments and directives are rejected.
+
+type gqlOperation struct {
+	kind       string
+	name       string
+	vars       []gqlVarDef
+	selections []gqlField
+}
+
+type gqlVarDef struct {
+	name       string
+	def        interface{}
+	hasDefault bool
+}
+
+type gqlField struct {
+	alias      string
+	name       string
+	args       map[string]interface{}
+	selections []gqlField
+}
+
+//responseKey is the name of the field in the result.
+func (f *gqlField) responseKey() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+//gqlVar and gqlEnum are argument values which aren't literals.
+type gqlVar string
+type gqlEnum string
+
+type gqlParser struct {
+	src string
+	pos int
+}
+
+func parseGraphQL(src string) ([]gqlOperation, error) {
+	p := &gqlParser{src: src}
+	var ops []gqlOperation
+	for p.skip(); p.pos < len(p.src); p.skip() {
+		op, err := p.operation()
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("document has no operations")
+	}
+	return ops, nil
+}
+
+func (p *gqlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("syntax error at %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+//skip moves past whitespace, commas and comments.
+func (p *gqlParser) skip() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *gqlParser) peek() byte {
+	p.skip()
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *gqlParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+func (p *gqlParser) name() (string, error) {
+	p.skip()
+	start := p.pos
+	for p.pos < len(p.src) && isNameByte(p.src[p.pos], p.pos == start) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a name")
+	}
+	return p.src[start:p.pos], nil
+}
+
+func (p *gqlParser) operation() (gqlOperation, error) {
+	op := gqlOperation{kind: "query"}
+	if p.peek() != '{' {
+		kind, err := p.name()
+		if err != nil {
+			return op, err
+		}
+		switch kind {
+		case "query", "mutation":
+			op.kind = kind
+		case "fragment":
+			return op, p.errorf("fragments aren't supported")
+		default:
+			return op, p.errorf("unsupported operation %s", kind)
+		}
+		if c := p.peek(); isNameByte(c, true) {
+			if op.name, err = p.name(); err != nil {
+				return op, err
+			}
+		}
+		if p.peek() == '(' {
+			if op.vars, err = p.varDefs(); err != nil {
+				return op, err
+			}
+		}
+		if p.peek() == '@' {
+			return op, p.errorf("directives aren't supported")
+		}
+	}
+	var err error
+	op.selections, err = p.selectionSet()
+	return op, err
+}
+
+func (p *gqlParser) varDefs() ([]gqlVarDef, error) {
+	p.pos++
+	var defs []gqlVarDef
+	for p.peek() != ')' {
+		if err := p.expect('$'); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(':'); err != nil {
+			return nil, err
+		}
+		if err = p.varType(); err != nil {
+			return nil, err
+		}
+		def := gqlVarDef{name: name}
+		if p.peek() == '=' {
+			p.pos++
+			if def.def, err = p.value(); err != nil {
+				return nil, err
+			}
+			def.hasDefault = true
+		}
+		defs = append(defs, def)
+	}
+	p.pos++
+	return defs, nil
+}
+
+//varType skips a type like [String!]!; arguments are checked when they are used.
+func (p *gqlParser) varType() error {
+	if p.peek() == '[' {
+		p.pos++
+		if err := p.varType(); err != nil {
+			return err
+		}
+		if err := p.expect(']'); err != nil {
+			return err
+		}
+	} else if _, err := p.name(); err != nil {
+		return err
+	}
+	if p.peek() == '!' {
+		p.pos++
+	}
+	return nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlField, error) {
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	var fields []gqlField
+	for p.peek() != '}' {
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unclosed selection set")
+		}
+		if strings.HasPrefix(p.src[p.pos:], "...") {
+			return nil, p.errorf("fragments aren't supported")
+		}
+		f, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	p.pos++
+	if len(fields) == 0 {
+		return nil, p.errorf("empty selection set")
+	}
+	return fields, nil
+}
+
+func (p *gqlParser) field() (gqlField, error) {
+	f := gqlField{}
+	var err error
+	if f.name, err = p.name(); err != nil {
+		return f, err
+	}
+	if p.peek() == ':' {
+		p.pos++
+		f.alias = f.name
+		if f.name, err = p.name(); err != nil {
+			return f, err
+		}
+	}
+	if p.peek() == '(' {
+		p.pos++
+		f.args = make(map[string]interface{})
+		for p.peek() != ')' {
+			name, err := p.name()
+			if err != nil {
+				return f, err
+			}
+			if err = p.expect(':'); err != nil {
+				return f, err
+			}
+			if f.args[name], err = p.value(); err != nil {
+				return f, err
+			}
+		}
+		p.pos++
+	}
+	if p.peek() == '@' {
+		return f, p.errorf("directives aren't supported")
+	}
+	if p.peek() == '{' {
+		f.selections, err = p.selectionSet()
+	}
+	return f, err
+}
+
+func (p *gqlParser) value() (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '$':
+		p.pos++
+		name, err := p.name()
+		return gqlVar(name), err
+	case c == '"':
+		return p.string()
+	case c == '[':
+		p.pos++
+		list := []interface{}{}
+		for p.peek() != ']' {
+			if p.pos >= len(p.src) {
+				return nil, p.errorf("unclosed list")
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		p.pos++
+		return list, nil
+	case c == '{':
+		p.pos++
+		obj := map[string]interface{}{}
+		for p.peek() != '}' {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(':'); err != nil {
+				return nil, err
+			}
+			if obj[name], err = p.value(); err != nil {
+				return nil, err
+			}
+		}
+		p.pos++
+		return obj, nil
+	case c == '-' || c >= '0' && c <= '9':
+		return p.number()
+	case isNameByte(c, true):
+		name, _ := p.name()
+		switch name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return gqlEnum(name), nil
+	}
+	return nil, p.errorf("expected a value")
+}
+
+func (p *gqlParser) string() (string, error) {
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		end := strings.Index(p.src[p.pos+3:], `"""`)
+		if end < 0 {
+			return "", p.errorf("unclosed block string")
+		}
+		s := p.src[p.pos+3 : p.pos+3+end]
+		p.pos += end + 6
+		return s, nil
+	}
+	start := p.pos
+	for p.pos++; p.pos < len(p.src) && p.src[p.pos] != '"'; p.pos++ {
+		if p.src[p.pos] == '\\' {
+			p.pos++
+		} else if p.src[p.pos] == '\n' {
+			break
+		}
+	}
+	if p.pos >= len(p.src) || p.src[p.pos] != '"' {
+		return "", p.errorf("unclosed string")
+	}
+	p.pos++
+	//escapes are those of JSON
+	var s string
+	if err := json.Unmarshal([]byte(p.src[start:p.pos]), &s); err != nil {
+		return "", p.errorf("invalid string: %v", err)
+	}
+	return s, nil
+}
+
+func (p *gqlParser) number() (interface{}, error) {
+	start := p.pos
+	float := false
+	for ; p.pos < len(p.src); p.pos++ {
+		c := p.src[p.pos]
+		if c == '.' || c == 'e' || c == 'E' {
+			float = true
+		} else if !(c >= '0' && c <= '9' || c == '-' || c == '+') {
+			break
+		}
+	}
+	text := p.src[start:p.pos]
+	if float {
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", text)
+		}
+		return f, nil
+	}
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return nil, p.errorf("invalid number %s", text)
+	}
+	return n, nil
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGraphQL(t *testing.T) {
+	ops, err := parseGraphQL(`
+		# a comment
+		query Get($key: String!, $limit: Int = 2, $tags: [String!]) {
+			one: item(key: $key) { key, value }
+			items(prefix: "a\n", limit: -3, f: 1.5, on: true, off: false, none: null, e: ASC, l: [1 "x"], o: {a: 1}) {
+				key
+			}
+		}
+		mutation { set(key: "k", value: """raw "value" here""") { version } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 2 {
+		t.Fatalf("expected 2 operations, got %d", len(ops))
+	}
+
+	q := ops[0]
+	if q.kind != "query" || q.name != "Get" {
+		t.Errorf("unexpected operation %s %s", q.kind, q.name)
+	}
+	wantVars := []gqlVarDef{{name: "key"}, {name: "limit", def: int64(2), hasDefault: true}, {name: "tags"}}
+	if !reflect.DeepEqual(q.vars, wantVars) {
+		t.Errorf("unexpected variables %+v", q.vars)
+	}
+	if len(q.selections) != 2 {
+		t.Fatalf("expected 2 fields, got %d", len(q.selections))
+	}
+	one := q.selections[0]
+	if one.alias != "one" || one.name != "item" || one.responseKey() != "one" || one.args["key"] != gqlVar("key") {
+		t.Errorf("unexpected field %+v", one)
+	}
+	if len(one.selections) != 2 || one.selections[0].name != "key" || one.selections[1].name != "value" {
+		t.Errorf("unexpected selection %+v", one.selections)
+	}
+	wantArgs := map[string]interface{}{
+		"prefix": "a\n", "limit": int64(-3), "f": 1.5, "on": true, "off": false, "none": nil,
+		"e": gqlEnum("ASC"), "l": []interface{}{int64(1), "x"}, "o": map[string]interface{}{"a": int64(1)},
+	}
+	if items := q.selections[1]; items.responseKey() != "items" || !reflect.DeepEqual(items.args, wantArgs) {
+		t.Errorf("unexpected arguments %#v", items.args)
+	}
+
+	m := ops[1]
+	if m.kind != "mutation" || m.name != "" || m.selections[0].args["value"] != `raw "value" here` {
+		t.Errorf("unexpected mutation %+v", m)
+	}
+
+	//the shorthand is a query
+	if ops, err = parseGraphQL(`{ item(key: "k") { key } }`); err != nil || ops[0].kind != "query" {
+		t.Errorf("shorthand query returned %+v, %v", ops, err)
+	}
+}
+
+func TestParseGraphQL_Errors(t *testing.T) {
+	for _, src := range []string{
+		``,
+		`# only a comment`,
+		`{`,
+		`{ }`,
+		`{ item(key: "k") { key }`,
+		`subscription { item }`,
+		`fragment F on Item { key }`,
+		`{ ...F }`,
+		`query @cached { item }`,
+		`{ item @include(if: true) }`,
+		`{ item(key: "k) }`,
+		`{ item(key: ) }`,
+		`{ item(key "k") }`,
+		`{ items(limit: 1x) { key } }`,
+		`{ items(limit: 99999999999999999999) { key } }`,
+		`query ($key String) { item }`,
+		`query (key: String) { item }`,
+		`{ items(l: [1, 2) }`,
+	} {
+		if _, err := parseGraphQL(src); err == nil {
+			t.Errorf("%q was parsed", src)
+		}
+	}
+}
+
+func TestSelectOperation(t *testing.T) {
+	query := `query A { item(key: "a") { key } } query B { item(key: "b") { key } }`
+	if _, err := selectOperation(graphqlRequest{Query: query}); err == nil {
+		t.Error("operation was picked without operationName")
+	}
+	if op, err := selectOperation(graphqlRequest{Query: query, OperationName: "B"}); err != nil || op.name != "B" {
+		t.Errorf("picked %+v, %v", op, err)
+	}
+	if _, err := selectOperation(graphqlRequest{Query: query, OperationName: "C"}); err == nil {
+		t.Error("missing operation was picked")
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	db := storage.New(storage.DefaultExpiration, 0, 0)
+	srv := NewServer(db)
+	srv.config = &Config{}
+	srv.configureRouter()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	for _, key := range []string{"a1", "a2", "a3", "b1"} {
+		db.Set(key, "value of "+key, storage.NoExpiration)
+	}
+
+	post := func(query string, vars map[string]interface{}) (int, string) {
+		t.Helper()
+		body, _ := json.Marshal(graphqlRequest{Query: query, Variables: vars})
+		resp, err := http.Post(ts.URL+"/graphql", "application/json", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		res := json.RawMessage{}
+		json.NewDecoder(resp.Body).Decode(&res)
+		return resp.StatusCode, string(res)
+	}
+
+	cases := []struct {
+		name  string
+		query string
+		vars  map[string]interface{}
+		want  string
+	}{
+		{
+			"selected fields in order",
+			`{ item(key: "a1") { value key __typename } }`, nil,
+			`{"data":{"item":{"value":"value of a1","key":"a1","__typename":"Item"}}}`,
+		},
+		{
+			"aliases",
+			`{ x: item(key: "a1") { k: key } y: item(key: "b1") { key } missing: item(key: "c") { key } }`, nil,
+			`{"data":{"x":{"k":"a1"},"y":{"key":"b1"},"missing":null}}`,
+		},
+		{
+			"variables",
+			`query ($key: String!) { item(key: $key) { key } }`, map[string]interface{}{"key": "a2"},
+			`{"data":{"item":{"key":"a2"}}}`,
+		},
+		{
+			"variable defaults",
+			`query ($limit: Int = 2) { items(prefix: "a", limit: $limit) { key } }`, nil,
+			`{"data":{"items":[{"key":"a1"},{"key":"a2"}]}}`,
+		},
+		{
+			"variables override defaults",
+			`query ($limit: Int = 2) { items(prefix: "a", limit: $limit) { key } }`, map[string]interface{}{"limit": 1},
+			`{"data":{"items":[{"key":"a1"}]}}`,
+		},
+		{
+			"paging",
+			`{ items(prefix: "a", after: "a1", limit: 5) { key } }`, nil,
+			`{"data":{"items":[{"key":"a2"},{"key":"a3"}]}}`,
+		},
+		{
+			"past the last page",
+			`{ items(after: "b1") { key } }`, nil,
+			`{"data":{"items":[]}}`,
+		},
+		{
+			"missing required argument",
+			`{ item { key } }`, nil,
+			`{"data":{"item":null},"errors":[{"message":"argument key is required","path":["item"]}]}`,
+		},
+		{
+			"missing variable",
+			`query ($key: String!) { item(key: $key) { key } }`, nil,
+			`{"data":{"item":null},"errors":[{"message":"argument key is required","path":["item"]}]}`,
+		},
+		{
+			"invalid limit",
+			`{ items(limit: 0) { key } }`, nil,
+			`{"data":{"items":null},"errors":[{"message":"limit must be within [1, 1000]","path":["items"]}]}`,
+		},
+		{
+			"unknown field",
+			`{ item(key: "a1") { key size } other { key } }`, nil,
+			`{"data":{"item":null,"other":null},"errors":[{"message":"no field size on Item","path":["item"]},{"message":"no field other on Query","path":["other"]}]}`,
+		},
+		{
+			"missing subfields",
+			`{ item(key: "a1") }`, nil,
+			`{"data":{"item":null},"errors":[{"message":"field item of type Item must have a selection of subfields","path":["item"]}]}`,
+		},
+		{
+			"mutations apply in order",
+			`mutation { first: set(key: "m", value: "1") { value } second: set(key: "m", value: "2", ttl: "1h") { value sliding class } gone: delete(key: "b1") again: delete(key: "b1") }`, nil,
+			`{"data":{"first":{"value":"1"},"second":{"value":"2","sliding":false,"class":"normal"},"gone":true,"again":false}}`,
+		},
+		{
+			"mutation fields on a query",
+			`{ set(key: "m", value: "1") { key } }`, nil,
+			`{"data":{"set":null},"errors":[{"message":"no field set on Query","path":["set"]}]}`,
+		},
+		{
+			"invalid ttl",
+			`mutation { set(key: "m", value: "1", ttl: "soon") { key } }`, nil,
+			`{"data":{"set":null},"errors":[{"message":"invalid ttl","path":["set"]}]}`,
+		},
+	}
+	for _, c := range cases {
+		status, got := post(c.query, c.vars)
+		if status != http.StatusOK || got != c.want {
+			t.Errorf("%s: got %d %s, want %s", c.name, status, got, c.want)
+		}
+	}
+	if _, found := db.Get("b1"); found {
+		t.Error("deleted b1 was found")
+	}
+
+	if status, _ := post(`{ item(key: "a1") { key }`, nil); status != http.StatusBadRequest {
+		t.Errorf("syntax error returned %d", status)
+	}
+
+	get := func(query string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/graphql?" + url.Values{"query": {query}}.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		res := json.RawMessage{}
+		json.NewDecoder(resp.Body).Decode(&res)
+		return resp.StatusCode, string(res)
+	}
+	if status, got := get(`{ item(key: "a3") { key } }`); status != http.StatusOK || got != `{"data":{"item":{"key":"a3"}}}` {
+		t.Errorf("GET query returned %d %s", status, got)
+	}
+	if status, _ := get(`mutation { delete(key: "a3") }`); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET mutation returned %d", status)
+	}
+	if _, found := db.Get("a3"); !found {
+		t.Error("GET mutation was applied")
+	}
+}
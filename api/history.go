@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"net/http"
+)
+
+type historySettings struct {
+	Enabled bool `json:"enabled"`
+}
+
+type historyReport struct {
+	Truncated bool                           `json:"truncated"`
+	Ops       []storage.HistoryOp            `json:"ops,omitempty"`
+	Result    *storage.LinearizabilityResult `json:"result,omitempty"`
+}
+
+//HandleSetHistory starts recording a new history of reads, writes and deletes
+//with {"enabled": true}, or stops it.
+func (srv *Server) HandleSetHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hs := historySettings{}
+		if err := json.NewDecoder(r.Body).Decode(&hs); err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		srv.storage.RecordHistory(hs.Enabled)
+		utils.Respond(w, r, http.StatusOK, hs)
+	}
+}
+
+//HandleHistory returns the recorded operations, to be checked elsewhere.
+func (srv *Server) HandleHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ops, truncated := srv.storage.History()
+		utils.Respond(w, r, http.StatusOK, historyReport{Truncated: truncated, Ops: ops})
+	}
+}
+
+//HandleCheckHistory checks that the recorded operations are linearizable. The
+//check is exponential in the number of concurrent operations of a key, so keep
+//histories of benchmarks short.
+func (srv *Server) HandleCheckHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ops, truncated := srv.storage.History()
+		res := storage.CheckLinearizable(ops)
+		utils.Respond(w, r, http.StatusOK, historyReport{Truncated: truncated, Result: &res})
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//defaultIDNamespace is used by /ids/next without ?namespace.
+const defaultIDNamespace = "default"
+
+//HandleNextID returns a new ID of ?namespace, see storage.NextID. The ID is
+//also returned as a string, since JSON numbers lose precision above 2^53 in
+//most clients.
+func (srv *Server) HandleNextID() http.HandlerFunc {
+	type response struct {
+		ID        uint64    `json:"id"`
+		IDString  string    `json:"id_str"`
+		Namespace string    `json:"namespace"`
+		Time      time.Time `json:"time"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.URL.Query().Get("namespace")
+		if namespace == "" {
+			namespace = defaultIDNamespace
+		}
+		id := srv.storage.NextID(namespace)
+		utils.Respond(w, r, http.StatusOK, response{
+			ID:        id,
+			IDString:  strconv.FormatUint(id, 10),
+			Namespace: namespace,
+			Time:      storage.IDTime(id).UTC(),
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+func (srv *Server) HandleCreateIndex() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		if err := srv.storage.CreateIndex(vars["namespace"], vars["field"]); err != nil {
+			utils.ErrorMessage(w, r, http.StatusConflict, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (srv *Server) HandleDropIndex() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		if !srv.storage.DropIndex(vars["namespace"], vars["field"]) {
+			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("no such index"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (srv *Server) HandleIndexes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns := mux.Vars(r)["namespace"]
+		utils.Respond(w, r, http.StatusOK, srv.storage.Indexes(ns))
+	}
+}
+
+func (srv *Server) HandleQuery() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns := mux.Vars(r)["namespace"]
+		q := r.URL.Query()
+
+		keys, err := srv.storage.Query(ns, q.Get("field"), q.Get("value"))
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, keys)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"net/http"
+	"time"
+)
+
+//parseDurationParam reads the positive duration parameter name, 0 if it is missing.
+func parseDurationParam(r *http.Request, name string) (time.Duration, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, errors.New("invalid " + name)
+	}
+	return d, nil
+}
+
+func (srv *Server) HandleJanitor() http.HandlerFunc {
+	type response struct {
+		Paused bool                 `json:"paused"`
+		Stats  storage.JanitorStats `json:"stats"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		utils.Respond(w, r, http.StatusOK, response{srv.storage.JanitorPaused(), srv.storage.Stats().Janitor})
+	}
+}
+
+//HandlePauseJanitor stops scheduled janitor runs for ?for, or until resumed,
+//e.g. during latency sensitive windows.
+func (srv *Server) HandlePauseJanitor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, err := parseDurationParam(r, "for")
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		srv.storage.PauseJanitor(d)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (srv *Server) HandleResumeJanitor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		srv.storage.ResumeJanitor()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+//HandleRunJanitor removes expired items now, spending at most ?budget holding the lock.
+func (srv *Server) HandleRunJanitor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		budget, err := parseDurationParam(r, "budget")
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, srv.storage.RunJanitor(budget))
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+//requestVersion returns the version expected by the client from If-Match header
+//or version query parameter, 0 if the client didn't provide one.
+func requestVersion(r *http.Request) (uint64, error) {
+	v := strings.Trim(r.Header.Get("If-Match"), `"`)
+	if v == "" {
+		v = r.URL.Query().Get("version")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	version, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid version")
+	}
+	return version, nil
+}
+
+func (srv *Server) HandleJSONGet() http.HandlerFunc {
+	type response struct {
+		Value interface{} `json:"value"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+		path := r.URL.Query().Get("path")
+		if path == "" {
+			path = "$"
+		}
+
+		v, err := srv.storage.JSONGet(key, path)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusNotFound, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, response{v})
+	}
+}
+
+func (srv *Server) HandleJSONSet() http.HandlerFunc {
+	type request struct {
+		Path  string      `json:"path"`
+		Value interface{} `json:"value"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+
+		req := request{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid request body"))
+			return
+		}
+		if req.Path == "" {
+			req.Path = "$"
+		}
+
+		if err := srv.guarded(r).JSONSet(key, req.Path, req.Value); err != nil {
+			srv.writeError(w, r, key, http.StatusUnprocessableEntity, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (srv *Server) HandlePatch() http.HandlerFunc {
+	type response struct {
+		Value   interface{} `json:"value"`
+		Version uint64      `json:"version"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+
+		version, err := requestVersion(r)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		var patch func(doc interface{}) (interface{}, error)
+		contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch contentType {
+		case mergePatchType:
+			var p interface{}
+			if err = json.NewDecoder(r.Body).Decode(&p); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid merge patch"))
+				return
+			}
+			patch = func(doc interface{}) (interface{}, error) {
+				return storage.MergePatch(doc, p), nil
+			}
+		case jsonPatchType:
+			var ops []storage.PatchOp
+			if err = json.NewDecoder(r.Body).Decode(&ops); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid json patch"))
+				return
+			}
+			patch = func(doc interface{}) (interface{}, error) {
+				return storage.ApplyJSONPatch(doc, ops)
+			}
+		default:
+			utils.ErrorMessage(w, r, http.StatusUnsupportedMediaType, errors.New("content type must be "+mergePatchType+" or "+jsonPatchType))
+			return
+		}
+
+		doc, newVersion, err := srv.guarded(r).PatchJSON(key, version, patch)
+		switch err {
+		case nil:
+		case storage.ErrNotFound:
+			utils.ErrorMessage(w, r, http.StatusNotFound, err)
+			return
+		case storage.ErrVersionMismatch:
+			utils.ErrorMessage(w, r, http.StatusPreconditionFailed, err)
+			return
+		default:
+			srv.writeError(w, r, key, http.StatusUnprocessableEntity, err)
+			return
+		}
+		w.Header().Set("ETag", strconv.Quote(strconv.FormatUint(newVersion, 10)))
+		utils.Respond(w, r, http.StatusOK, response{doc, newVersion})
+	}
+}
@@ -0,0 +1,241 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//LockTokenHeader carries the token of a key lock on writes of the locked key.
+const LockTokenHeader = "X-Lock-Token"
+
+//maxLockTTL bounds ?ttl of a key lock, so a lost token doesn't lock a key for long.
+const maxLockTTL = 5 * time.Minute
+
+const defaultLockTTL = 5 * time.Second
+
+//minLockSweep is how many locks acquire keeps before sweeping expired ones.
+const minLockSweep = 64
+
+var errKeyLocked = errors.New("key is locked")
+
+type keyLock struct {
+	token   string
+	expires time.Time
+}
+
+//lockedError is returned for writes of a key locked with another token.
+type lockedError struct {
+	key  string
+	lock keyLock
+}
+
+func (e *lockedError) Error() string {
+	return fmt.Sprintf("key %s is locked", e.key)
+}
+
+func (e *lockedError) Unwrap() error {
+	return errKeyLocked
+}
+
+//keyLocks holds the keys locked with POST /items/{key}/lock. They only live in
+//memory: after a restart every key is unlocked. Expired locks are swept once
+//their number doubled since the last sweep.
+type keyLocks struct {
+	mu      sync.Mutex
+	locks   map[string]keyLock
+	sweepAt int
+}
+
+//acquire locks key with a new token, or extends the lock if token holds it.
+func (l *keyLocks) acquire(key, token string, ttl time.Duration) (keyLock, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.locks == nil {
+		l.locks = make(map[string]keyLock)
+	}
+	if len(l.locks) >= l.sweepAt {
+		for k, lock := range l.locks {
+			if !now.Before(lock.expires) {
+				delete(l.locks, k)
+			}
+		}
+		if l.sweepAt = 2 * len(l.locks); l.sweepAt < minLockSweep {
+			l.sweepAt = minLockSweep
+		}
+	}
+	cur, locked := l.locks[key]
+	if locked && !now.Before(cur.expires) {
+		cur, locked = keyLock{}, false
+	}
+	if locked && cur.token != token {
+		return cur, errKeyLocked
+	}
+	if !locked {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return keyLock{}, err
+		}
+		cur.token = base64.RawURLEncoding.EncodeToString(b)
+	}
+	cur.expires = now.Add(ttl)
+	l.locks[key] = cur
+	return cur, nil
+}
+
+//release unlocks key if token holds it and reports whether it was locked.
+func (l *keyLocks) release(key, token string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cur, locked := l.locks[key]
+	if !locked || !time.Now().Before(cur.expires) {
+		delete(l.locks, key)
+		return false, nil
+	}
+	if cur.token != token {
+		return true, errKeyLocked
+	}
+	delete(l.locks, key)
+	return true, nil
+}
+
+//check returns the lock of key unless it is unlocked or token holds it.
+func (l *keyLocks) check(key, token string) (keyLock, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cur, locked := l.locks[key]
+	if !locked || cur.token == token {
+		return keyLock{}, false
+	}
+	if !time.Now().Before(cur.expires) {
+		delete(l.locks, key)
+		return keyLock{}, false
+	}
+	return cur, true
+}
+
+type lockTokenKey struct{}
+
+//withLockToken passes the lock token of a request to writes which don't get the
+//request, like the calls of /rpc.
+func withLockToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, lockTokenKey{}, token)
+}
+
+func lockToken(ctx context.Context) string {
+	token, _ := ctx.Value(lockTokenKey{}).(string)
+	return token
+}
+
+//verify fails with a *lockedError if one of keys is locked with another
+//token than token. Writes verify their keys under the write lock of the
+//storage, see lockGuard.
+func (l *keyLocks) verify(token string, keys ...string) error {
+	for _, key := range keys {
+		if lock, locked := l.check(key, token); locked {
+			return &lockedError{key, lock}
+		}
+	}
+	return nil
+}
+
+//lockGuard rejects writes of keys locked with another token than token. The
+//storage calls it under its write lock, so a write can't pass the check and
+//land after the key was locked.
+func (srv *Server) lockGuard(token string) storage.KeyGuard {
+	return func(key string) error {
+		return srv.keyLocks.verify(token, key)
+	}
+}
+
+//guarded returns the storage failing writes of keys which are locked, unless r
+//carries the token of the lock in X-Lock-Token.
+func (srv *Server) guarded(r *http.Request) storage.Guarded {
+	return srv.storage.Guard(srv.lockGuard(r.Header.Get(LockTokenHeader)))
+}
+
+//keysLocked responds with 423 Locked and returns true if one of keys is
+//locked, unless the request carries the token of the lock in X-Lock-Token.
+//It only rejects requests early; writes are checked by lockGuard.
+func (srv *Server) keysLocked(w http.ResponseWriter, r *http.Request, keys ...string) bool {
+	err := srv.keyLocks.verify(r.Header.Get(LockTokenHeader), keys...)
+	if err != nil {
+		lockedResponse(w, r, err)
+	}
+	return err != nil
+}
+
+//lockedResponse responds to a write failing with a *lockedError.
+func lockedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	setRetryAfter(w, err)
+	utils.ErrorMessage(w, r, http.StatusLocked, err)
+}
+
+//setRetryAfter tells when the lock of err expires, if err is a *lockedError.
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var le *lockedError
+	if errors.As(err, &le) {
+		retry := int(time.Until(le.lock.expires)/time.Second) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+	}
+}
+
+//HandleLock locks the key for ?ttl (5s by default) and returns the token which
+//writes of the key need until it is unlocked or the lock expires. With the token
+//in X-Lock-Token, the lock is extended.
+func (srv *Server) HandleLock() http.HandlerFunc {
+	type response struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+		ttl := defaultLockTTL
+		if v := r.URL.Query().Get("ttl"); v != "" {
+			var err error
+			if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 || ttl > maxLockTTL {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("ttl must be a duration up to %v", maxLockTTL))
+				return
+			}
+		}
+
+		lock, err := srv.keyLocks.acquire(key, r.Header.Get(LockTokenHeader), ttl)
+		if errors.Is(err, errKeyLocked) {
+			lockedResponse(w, r, &lockedError{key, lock})
+			return
+		}
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, response{lock.token, lock.expires.UTC()})
+	}
+}
+
+//HandleUnlock releases the lock whose token is in X-Lock-Token.
+func (srv *Server) HandleUnlock() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+
+		locked, err := srv.keyLocks.release(key, r.Header.Get(LockTokenHeader))
+		switch {
+		case err != nil:
+			utils.ErrorMessage(w, r, http.StatusConflict, errors.New("key is locked with another token"))
+		case !locked:
+			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("key is not locked"))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
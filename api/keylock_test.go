@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyLocks(t *testing.T) {
+	l := keyLocks{}
+	lock, err := l.acquire("k", "", time.Minute)
+	if err != nil || lock.token == "" {
+		t.Fatalf("lock was not acquired: %v", err)
+	}
+	if _, err = l.acquire("k", "other", time.Minute); !errors.Is(err, errKeyLocked) {
+		t.Errorf("lock was acquired twice: %v", err)
+	}
+	extended, err := l.acquire("k", lock.token, time.Hour)
+	if err != nil || extended.token != lock.token || !extended.expires.After(lock.expires) {
+		t.Errorf("lock was not extended: %+v, %v", extended, err)
+	}
+	if err = l.verify("", "free", "k"); !errors.Is(err, errKeyLocked) {
+		t.Errorf("write without the token was allowed: %v", err)
+	}
+	if err = l.verify(lock.token, "free", "k"); err != nil {
+		t.Errorf("write with the token was rejected: %v", err)
+	}
+
+	if _, err = l.release("k", "other"); !errors.Is(err, errKeyLocked) {
+		t.Errorf("lock was released with another token: %v", err)
+	}
+	if locked, err := l.release("k", lock.token); !locked || err != nil {
+		t.Errorf("lock was not released: %v", err)
+	}
+	if locked, _ := l.release("k", lock.token); locked {
+		t.Error("lock was released twice")
+	}
+
+	//expired locks neither hold keys nor pile up
+	if _, err = l.acquire("k", "", time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if err = l.verify("", "k"); err != nil {
+		t.Errorf("expired lock holds the key: %v", err)
+	}
+	if _, err = l.acquire("k", "", time.Minute); err != nil {
+		t.Errorf("expired lock was not replaced: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		l.acquire("tmp"+strconv.Itoa(i), "", time.Nanosecond)
+	}
+	if len(l.locks) > 2*minLockSweep {
+		t.Errorf("%d expired locks were kept", len(l.locks))
+	}
+}
+
+func TestKeyLocks_Writes(t *testing.T) {
+	db := storage.New(storage.DefaultExpiration, 0, 0)
+	srv := NewServer(db)
+	srv.config = &Config{}
+	srv.configureRouter()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	db.Set("other", "v", storage.NoExpiration)
+
+	do := func(method, path, token, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set(LockTokenHeader, token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	req, _ := http.NewRequest("POST", ts.URL+"/items/k/lock?ttl=3s", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("lock failed: %v", err)
+	}
+	lock := struct {
+		Token string `json:"token"`
+	}{}
+	json.NewDecoder(resp.Body).Decode(&lock)
+	resp.Body.Close()
+
+	locked := []struct {
+		method, path, body string
+	}{
+		{"PUT", "/items/k/v", ""},
+		{"PUT", "/items/k/v?async=true", ""},
+		{"DELETE", "/items/k", ""},
+		{"POST", "/items/k/incr", ""},
+		{"POST", "/items/other/rename?to=k", ""},
+		{"POST", "/items/other/copy?to=k", ""},
+		{"POST", "/batch", `{"op":"set","key":"k","value":"v"}`},
+		{"POST", "/rotate", `{"keys":["other","k"]}`},
+		{"POST", "/scheduled", `{"op":"delete","key":"k","in":"1s"}`},
+		{"POST", "/queues/k", "m"},
+		{"POST", "/queues/k/receive", ""},
+		{"PATCH", "/items/k/json", `{"path":"$.a","value":1}`},
+		{"POST", "/counters/k/incr?window=1m", ""},
+		{"POST", "/streams/k", `{"f":"v"}`},
+		{"POST", "/streams/k/trim?maxlen=1", ""},
+		{"POST", "/timeseries/k?value=1", ""},
+		{"POST", "/items/delete", `[{"key":"k","version":1}]`},
+	}
+	for _, c := range locked {
+		resp := do(c.method, c.path, "", c.body)
+		if resp.StatusCode != http.StatusLocked {
+			t.Errorf("%s %s without the token: %d", c.method, c.path, resp.StatusCode)
+			continue
+		}
+		if retry, _ := strconv.Atoi(resp.Header.Get("Retry-After")); retry < 1 || retry > 3 {
+			t.Errorf("%s %s: unexpected Retry-After %q", c.method, c.path, resp.Header.Get("Retry-After"))
+		}
+	}
+
+	rpc := `{"jsonrpc":"2.0","method":"set","params":{"key":"k","value":"v"},"id":1}`
+	req, _ = http.NewRequest("POST", ts.URL+"/rpc", strings.NewReader(rpc))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), strconv.Itoa(rpcLocked)) {
+		t.Errorf("rpc set without the token: %s", body)
+	}
+
+	if resp := do("PUT", "/items/k/v", lock.Token, ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("write with the token: %d", resp.StatusCode)
+	}
+	if resp := do("POST", "/batch", lock.Token, `{"op":"set","key":"k","value":"w"}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("batch with the token: %d", resp.StatusCode)
+	}
+	if resp := do("POST", "/items/k/lock?ttl=1m", lock.Token, ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("lock was not extended: %d", resp.StatusCode)
+	}
+	if resp := do("POST", "/items/k/lock", "", ""); resp.StatusCode != http.StatusLocked {
+		t.Errorf("locked key was locked again: %d", resp.StatusCode)
+	}
+
+	if resp := do("DELETE", "/items/k/lock", "other", ""); resp.StatusCode != http.StatusConflict {
+		t.Errorf("unlock with another token: %d", resp.StatusCode)
+	}
+	if resp := do("DELETE", "/items/k/lock", lock.Token, ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("unlock: %d", resp.StatusCode)
+	}
+	if resp := do("PUT", "/items/k/v", "", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("write after unlock: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+//limits shed load beyond MaxConcurrentRequests and MaxConnections instead of
+//letting requests queue up on the storage lock. Counters are accessed atomically.
+type limits struct {
+	requests     chan struct{}
+	connections  chan struct{}
+	shedRequests uint64
+	shedConns    uint64
+}
+
+func newLimits(maxRequests, maxConnections int) *limits {
+	l := &limits{}
+	if maxRequests > 0 {
+		l.requests = make(chan struct{}, maxRequests)
+	}
+	if maxConnections > 0 {
+		l.connections = make(chan struct{}, maxConnections)
+	}
+	return l
+}
+
+//limitRequests responds with 503 right away while the maximum number of
+//requests is being served.
+func (srv *Server) limitRequests(next http.Handler) http.Handler {
+	l := srv.limits
+	if l == nil || l.requests == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case l.requests <- struct{}{}:
+			defer func() { <-l.requests }()
+			next.ServeHTTP(w, r)
+		default:
+			atomic.AddUint64(&l.shedRequests, 1)
+			w.Header().Set("Retry-After", "1")
+			utils.ErrorMessage(w, r, http.StatusServiceUnavailable, errors.New("too many concurrent requests"))
+		}
+	})
+}
+
+//limitListener closes connections accepted while the maximum number of
+//connections is open, before any TLS handshake.
+type limitListener struct {
+	net.Listener
+	limits *limits
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case l.limits.connections <- struct{}{}:
+			return &limitConn{Conn: c, sem: l.limits.connections}, nil
+		default:
+			atomic.AddUint64(&l.limits.shedConns, 1)
+			c.Close()
+		}
+	}
+}
+
+type limitConn struct {
+	net.Conn
+	sem  chan struct{}
+	once sync.Once
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { <-c.sem })
+	return err
+}
+
+//limitConnections wraps ln if the number of connections is limited.
+func (srv *Server) limitConnections(ln net.Listener) net.Listener {
+	if srv.limits == nil || srv.limits.connections == nil {
+		return ln
+	}
+	return &limitListener{Listener: ln, limits: srv.limits}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+//ListenerConfig describes an address the API is served on.
+type ListenerConfig struct {
+	//Type is "http" (default), "https", "unix" or "mqtt" for the MQTT bridge,
+	//which doesn't serve the HTTP API and ignores APIs
+	Type string `toml:"type"`
+	//Addr is host:port, or the socket path of unix listeners
+	Addr     string `toml:"addr"`
+	CertFile string `toml:"cert_file"`
+	KeyFile  string `toml:"key_file"`
+	//APIs limits the listener to paths starting with these segments,
+	//e.g. ["items", "admin"]; empty serves everything
+	APIs []string `toml:"apis"`
+}
+
+//listen opens the listener, applying wrap to the raw one, e.g. to limit connections.
+func (lc ListenerConfig) listen(wrap func(net.Listener) net.Listener) (net.Listener, error) {
+	switch lc.Type {
+	case "", "http", "mqtt":
+		ln, err := net.Listen("tcp", lc.Addr)
+		if err != nil {
+			return nil, err
+		}
+		return wrap(ln), nil
+	case "https":
+		cert, err := tls.LoadX509KeyPair(lc.CertFile, lc.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		ln, err := net.Listen("tcp", lc.Addr)
+		if err != nil {
+			return nil, err
+		}
+		return tls.NewListener(wrap(ln), &tls.Config{Certificates: []tls.Certificate{cert}}), nil
+	case "unix":
+		//a socket left by a previous run would make listening fail
+		if fi, err := os.Stat(lc.Addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(lc.Addr)
+		}
+		ln, err := net.Listen("unix", lc.Addr)
+		if err != nil {
+			return nil, err
+		}
+		return wrap(ln), nil
+	case "resp", "grpc":
+		return nil, fmt.Errorf("%s listeners are not supported by this server", lc.Type)
+	}
+	return nil, fmt.Errorf("unknown listener type %s", lc.Type)
+}
+
+//filterAPIs serves only paths whose first segment is one of apis.
+func filterAPIs(next http.Handler, apis []string) http.Handler {
+	if len(apis) == 0 {
+		return next
+	}
+	allowed := make(map[string]bool, len(apis))
+	for _, api := range apis {
+		allowed[strings.Trim(api, "/")] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		segment := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+		if !allowed[segment] {
+			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("not found"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//serve starts all listeners and returns when one of them fails.
+//Listeners are opened before serving, so a bad config fails the start.
+func (srv *Server) serve(listeners []ListenerConfig) error {
+	lns := make([]net.Listener, 0, len(listeners))
+	for _, lc := range listeners {
+		ln, err := lc.listen(srv.limitConnections)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return fmt.Errorf("listener %s: %w", lc.Addr, err)
+		}
+		lns = append(lns, ln)
+	}
+
+	errs := make(chan error, len(lns))
+	for i, ln := range lns {
+		if listeners[i].Type == "mqtt" {
+			go func(ln net.Listener) {
+				errs <- srv.serveMQTT(ln)
+			}(ln)
+			continue
+		}
+		handler := srv.limitRequests(filterAPIs(srv, listeners[i].APIs))
+		go func(ln net.Listener) {
+			errs <- http.Serve(ln, handler)
+		}(ln)
+	}
+	return <-errs
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+//dbLock keeps a second instance from using the same db file, which would overwrite
+//the snapshots of the first one. It holds an advisory lock on <file>.lock, which
+//records who holds it. Where the OS supports it, the lock goes away with the
+//process, so a crashed instance doesn't block the next start.
+type dbLock struct {
+	f    *os.File
+	path string
+}
+
+//lockDB locks filename or fails naming the instance holding it. With force
+//the lock is taken over, e.g. if a crashed instance left it behind.
+func lockDB(filename string, force bool) (*dbLock, error) {
+	path := filename + ".lock"
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(f); err != nil {
+		holder, _ := io.ReadAll(f)
+		desc := strings.TrimSpace(string(holder))
+		if desc == "" {
+			desc = "unknown process"
+		}
+		if !force {
+			f.Close()
+			return nil, fmt.Errorf("%s is in use by another instance (%s), stop it or start with --force if it's gone", filename, desc)
+		}
+		log.Printf("WARNING: taking over %s from %s because of --force", path, desc)
+	}
+
+	host, _ := os.Hostname()
+	holder := fmt.Sprintf("pid %d on %s since %s\n", os.Getpid(), host, time.Now().Format(time.RFC3339))
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(holder), 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &dbLock{f: f, path: path}, nil
+}
+
+//release removes the lock file on a clean shutdown. A nil lock does nothing.
+func (l *dbLock) release() {
+	if l == nil {
+		return
+	}
+	os.Remove(l.path)
+	l.f.Close()
+}
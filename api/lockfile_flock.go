@@ -0,0 +1,13 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package api
+
+import (
+	"os"
+	"syscall"
+)
+
+//lockFile takes an exclusive flock of f, which the OS releases when the process exits.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
@@ -0,0 +1,21 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package api
+
+import (
+	"errors"
+	"os"
+)
+
+//lockFile treats a lock file which records a holder as locked, since there is no
+//flock here. A crash leaves it behind, so the next start needs --force.
+func lockFile(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > 0 {
+		return errors.New("lock file isn't empty")
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//LogConfig describes where the log goes. Output is "stderr" (the default), "stdout",
+//"file" or "syslog"; Format is "text" or "json"; messages below Level ("debug",
+//"info", "warn" or "error") are dropped.
+//Files are rotated when they grow beyond MaxSizeMB or are older than RotateEvery
+//(a duration like "24h"), keeping MaxBackups rotated files (0 keeps all).
+//SyslogAddr is like "udp://host:514"; empty means the local syslog daemon.
+type LogConfig struct {
+	Output      string `toml:"output"`
+	Format      string `toml:"format"`
+	Level       string `toml:"level"`
+	File        string `toml:"file"`
+	MaxSizeMB   int    `toml:"max_size_mb"`
+	RotateEvery string `toml:"rotate_every"`
+	MaxBackups  int    `toml:"max_backups"`
+	SyslogAddr  string `toml:"syslog_addr"`
+	SyslogTag   string `toml:"syslog_tag"`
+}
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+//logLevelPrefixes mark the level of messages passed to log.Printf, e.g.
+//"WARNING: janitor run took 2s"; messages without one are info.
+var logLevelPrefixes = []string{"DEBUG: ", "", "WARNING: ", "ERROR: "}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+func parseLogLevel(s string) (logLevel, error) {
+	if s == "" {
+		return levelInfo, nil
+	}
+	for i, name := range logLevelNames {
+		if s == name {
+			return logLevel(i), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+//logSink receives formatted log lines with their level.
+type logSink interface {
+	writeLog(level logLevel, line []byte) error
+}
+
+type writerSink struct {
+	io.Writer
+}
+
+func (s writerSink) writeLog(_ logLevel, line []byte) error {
+	_, err := s.Write(line)
+	return err
+}
+
+//logWriter is the output of the standard logger. It filters messages by their
+//level and formats them for the sink.
+type logWriter struct {
+	min  logLevel
+	json bool
+	//stamp adds the time to text lines, syslog has its own
+	stamp bool
+	sink  logSink
+}
+
+func (lw *logWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	level := levelInfo
+	for l, prefix := range logLevelPrefixes {
+		if prefix != "" && strings.HasPrefix(msg, prefix) {
+			level = logLevel(l)
+			break
+		}
+	}
+	if level < lw.min {
+		return len(p), nil
+	}
+
+	now := time.Now()
+	var line []byte
+	if lw.json {
+		var err error
+		line, err = json.Marshal(struct {
+			Time  time.Time `json:"time"`
+			Level string    `json:"level"`
+			Msg   string    `json:"msg"`
+		}{now, level.String(), strings.TrimPrefix(msg, logLevelPrefixes[level])})
+		if err != nil {
+			return 0, err
+		}
+		line = append(line, '\n')
+	} else if lw.stamp {
+		line = []byte(now.Format("2006/01/02 15:04:05 ") + msg + "\n")
+	} else {
+		line = []byte(msg + "\n")
+	}
+	if err := lw.sink.writeLog(level, line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+//setupLogging directs the standard logger as described by c.
+func setupLogging(c LogConfig) error {
+	min, err := parseLogLevel(c.Level)
+	if err != nil {
+		return err
+	}
+	lw := &logWriter{min: min, stamp: true}
+	switch c.Format {
+	case "", "text":
+	case "json":
+		lw.json = true
+	default:
+		return fmt.Errorf("unknown log format %q", c.Format)
+	}
+
+	switch c.Output {
+	case "", "stderr":
+		lw.sink = writerSink{os.Stderr}
+	case "stdout":
+		lw.sink = writerSink{os.Stdout}
+	case "file":
+		if c.File == "" {
+			return fmt.Errorf("log output file requires file")
+		}
+		f := &rotatingFile{path: c.File, maxSize: int64(c.MaxSizeMB) << 20, maxBackups: c.MaxBackups}
+		if c.RotateEvery != "" {
+			if f.maxAge, err = time.ParseDuration(c.RotateEvery); err != nil {
+				return fmt.Errorf("rotate_every: %w", err)
+			}
+		}
+		if err = f.open(); err != nil {
+			return err
+		}
+		lw.sink = writerSink{f}
+	case "syslog":
+		if lw.sink, err = newSyslogSink(c.SyslogAddr, c.SyslogTag); err != nil {
+			return err
+		}
+		lw.stamp = false
+	default:
+		return fmt.Errorf("unknown log output %q", c.Output)
+	}
+
+	log.SetFlags(0)
+	log.SetOutput(lw)
+	return nil
+}
+
+//rotatingFile is a log file which is renamed to path.<time> and replaced by a new
+//one when it grows beyond maxSize or gets older than maxAge (0 disables either).
+//Only the newest maxBackups rotated files are kept unless it is 0.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size, rf.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	full := rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize
+	old := rf.maxAge > 0 && time.Since(rf.opened) >= rf.maxAge
+	if full || old {
+		if err := rf.rotate(); err != nil {
+			//keep logging to the current file rather than losing messages
+			fmt.Fprintf(os.Stderr, "rotating %s: %v\n", rf.path, err)
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+//rotate must be called with the lock held.
+func (rf *rotatingFile) rotate() error {
+	backup := rf.path + "." + time.Now().Format("20060102T150405.000000000")
+	if err := os.Rename(rf.path, backup); err != nil {
+		return err
+	}
+	f := rf.f
+	if err := rf.open(); err != nil {
+		return err
+	}
+	f.Close()
+	return rf.removeBackups()
+}
+
+//removeBackups deletes the oldest rotated files beyond maxBackups.
+func (rf *rotatingFile) removeBackups() error {
+	if rf.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return err
+	}
+	//the time suffix sorts chronologically
+	sort.Strings(backups)
+	for len(backups) > rf.maxBackups {
+		if err = os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package api
+
+import "errors"
+
+func newSyslogSink(addr, tag string) (logSink, error) {
+	return nil, errors.New("syslog isn't supported on this platform")
+}
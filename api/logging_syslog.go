@@ -0,0 +1,40 @@
+//go:build !windows && !plan9
+
+package api
+
+import (
+	"log/syslog"
+	"strings"
+)
+
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink(addr, tag string) (logSink, error) {
+	if tag == "" {
+		tag = "kvstorage-srv"
+	}
+	var network string
+	if i := strings.Index(addr, "://"); i != -1 {
+		network, addr = addr[:i], addr[i+3:]
+	}
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return syslogSink{w}, nil
+}
+
+func (s syslogSink) writeLog(level logLevel, line []byte) error {
+	msg := string(line)
+	switch level {
+	case levelDebug:
+		return s.w.Debug(msg)
+	case levelWarn:
+		return s.w.Warning(msg)
+	case levelError:
+		return s.w.Err(msg)
+	}
+	return s.w.Info(msg)
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+//writeMetric writes a metric in the Prometheus text exposition format.
+func writeMetric(w io.Writer, name, typ, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, typ, name, value)
+}
+
+//HandleMetrics exposes storage, janitor and lock wait statistics in the Prometheus text format.
+func (srv *Server) HandleMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st := srv.storage.Stats()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		writeMetric(w, "kvstorage_items", "gauge", "Items including expired ones which haven't been removed yet.", float64(st.Items))
+		writeMetric(w, "kvstorage_items_expired", "gauge", "Expired items which haven't been removed yet.", float64(st.Expired))
+		writeMetric(w, "kvstorage_janitor_runs_total", "counter", "Janitor runs.", float64(st.Janitor.Runs))
+		writeMetric(w, "kvstorage_janitor_scanned_total", "counter", "Items scanned by the janitor.", float64(st.Janitor.Scanned))
+		writeMetric(w, "kvstorage_janitor_reclaimed_total", "counter", "Expired items removed by the janitor.", float64(st.Janitor.Reclaimed))
+		writeMetric(w, "kvstorage_janitor_duration_seconds_total", "counter", "Time spent in janitor runs.", st.Janitor.Duration.Seconds())
+		writeMetric(w, "kvstorage_janitor_lock_wait_seconds_total", "counter", "Time janitor runs waited for the storage lock.", st.Janitor.LockWait.Seconds())
+		if last := st.Janitor.Last; last != nil {
+			writeMetric(w, "kvstorage_janitor_last_duration_seconds", "gauge", "Duration of the last janitor run.", last.Duration.Seconds())
+		}
+
+		reasons := make([]string, 0, len(st.Evictions))
+		for reason := range st.Evictions {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		fmt.Fprint(w, "# HELP kvstorage_evictions_total Removed items by reason.\n# TYPE kvstorage_evictions_total counter\n")
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "kvstorage_evictions_total{reason=%q} %d\n", reason, st.Evictions[reason])
+		}
+
+		if l := srv.limits; l != nil {
+			writeMetric(w, "kvstorage_shed_requests_total", "counter", "Requests rejected beyond max_concurrent_requests.", float64(atomic.LoadUint64(&l.shedRequests)))
+			writeMetric(w, "kvstorage_shed_connections_total", "counter", "Connections closed beyond max_connections.", float64(atomic.LoadUint64(&l.shedConns)))
+		}
+
+		writeMetric(w, "kvstorage_mqtt_connections", "gauge", "Open connections of the MQTT bridge.", float64(atomic.LoadInt64(&srv.mqtt.connections)))
+		writeMetric(w, "kvstorage_mqtt_published_total", "counter", "Messages published to the MQTT bridge.", float64(atomic.LoadUint64(&srv.mqtt.published)))
+		writeMetric(w, "kvstorage_mqtt_delivered_total", "counter", "Change events delivered to MQTT subscribers.", float64(atomic.LoadUint64(&srv.mqtt.delivered)))
+		writeMetric(w, "kvstorage_mqtt_dropped_events_total", "counter", "Change events dropped because an MQTT subscriber lagged behind.", float64(atomic.LoadUint64(&srv.mqtt.droppedEvents)))
+		if sh := srv.shadow; sh != nil {
+			writeMetric(w, "kvstorage_shadow_queue_depth", "gauge", "Changes waiting to be forwarded to the shadow target.", float64(len(sh.ops)))
+			writeMetric(w, "kvstorage_shadow_forwarded_total", "counter", "Changes forwarded to the shadow target.", float64(atomic.LoadUint64(&sh.forwarded)))
+			writeMetric(w, "kvstorage_shadow_errors_total", "counter", "Changes the shadow target failed to apply.", float64(atomic.LoadUint64(&sh.failed)))
+			writeMetric(w, "kvstorage_shadow_dropped_total", "counter", "Changes dropped because the shadow queue was full.", float64(atomic.LoadUint64(&sh.dropped)))
+			writeMetric(w, "kvstorage_shadow_lag_seconds", "gauge", "How long the last forwarded change waited to be forwarded.", time.Duration(atomic.LoadInt64(&sh.lag)).Seconds())
+			writeMetric(w, "kvstorage_shadow_compared_total", "counter", "Reads compared with the shadow target.", float64(atomic.LoadUint64(&sh.compared)))
+			writeMetric(w, "kvstorage_shadow_mismatches_total", "counter", "Compared reads which had another value on the shadow target.", float64(atomic.LoadUint64(&sh.mismatched)))
+		}
+		writeMetric(w, "kvstorage_coalesced_gets_total", "counter", "GET requests which shared the lookup of a concurrent request of the same key.", float64(atomic.LoadUint64(&srv.gets.shared)))
+
+		if srv.responses != nil {
+			entries, hits, misses := srv.responses.stats()
+			writeMetric(w, "kvstorage_response_cache_entries", "gauge", "Encoded GET responses in the response cache.", float64(entries))
+			writeMetric(w, "kvstorage_response_cache_hits_total", "counter", "GET responses served from the response cache.", float64(hits))
+			writeMetric(w, "kvstorage_response_cache_misses_total", "counter", "GET responses which had to be encoded.", float64(misses))
+			ratio := 0.0
+			if hits+misses > 0 {
+				ratio = float64(hits) / float64(hits+misses)
+			}
+			writeMetric(w, "kvstorage_response_cache_hit_ratio", "gauge", "Share of GET responses served from the response cache.", ratio)
+		}
+
+		q := srv.asyncWrites()
+		writeMetric(w, "kvstorage_async_queue_depth", "gauge", "Writes with async=true waiting to be applied.", float64(len(q.writes)))
+		writeMetric(w, "kvstorage_async_queue_capacity", "gauge", "Size of the async write queue.", float64(cap(q.writes)))
+		fmt.Fprint(w, "# HELP kvstorage_async_writes_total Writes with async=true by outcome.\n# TYPE kvstorage_async_writes_total counter\n")
+		for _, c := range []struct {
+			result string
+			n      *uint64
+		}{{"applied", &q.applied}, {"failed", &q.failed}, {"dropped", &q.dropped}, {"rejected", &q.rejected}} {
+			fmt.Fprintf(w, "kvstorage_async_writes_total{result=%q} %d\n", c.result, atomic.LoadUint64(c.n))
+		}
+
+		writeKeyspaceMetrics(w, srv.storage.Keyspace())
+		if o, ok := srv.storage.Overflow(); ok {
+			writeMetric(w, "kvstorage_overflow_items", "gauge", "Evicted items kept in the overflow directory.", float64(o.Items))
+			writeMetric(w, "kvstorage_overflow_spilled_total", "counter", "Evicted items written to the overflow directory.", float64(o.Spilled))
+			writeMetric(w, "kvstorage_overflow_fetched_total", "counter", "Reads served from the overflow directory.", float64(o.Fetched))
+			writeMetric(w, "kvstorage_overflow_errors_total", "counter", "Items which couldn't be written to or read from the overflow directory.", float64(o.Errors))
+		}
+		if srv.config.compresses() {
+			c := srv.storage.Compression()
+			writeMetric(w, "kvstorage_compressed_items", "gauge", "Items whose value is kept compressed.", float64(c.Items))
+			writeMetric(w, "kvstorage_compressed_value_bytes", "gauge", "Size of the compressed values before compression.", float64(c.Bytes))
+			writeMetric(w, "kvstorage_compressed_bytes", "gauge", "Memory taken by the compressed values.", float64(c.CompressedBytes))
+			writeMetric(w, "kvstorage_compression_ratio", "gauge", "How many times smaller the compressed values are.", c.Ratio())
+		}
+		writeMetric(w, "kvstorage_corrupt_items_total", "counter", "Items which failed their checksum on read or load.", float64(srv.storage.Corruptions()))
+		srv.writeQuotaMetrics(w)
+		srv.writeForecastMetrics(w)
+
+		waits := srv.storage.LockWaits()
+		if len(waits) == 0 {
+			return
+		}
+		ops := make([]string, 0, len(waits))
+		for op := range waits {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+		fmt.Fprint(w, "# HELP kvstorage_lock_wait_seconds Time operations waited for the storage lock.\n# TYPE kvstorage_lock_wait_seconds histogram\n")
+		for _, op := range ops {
+			h := waits[op]
+			var cumulative uint64
+			for i, le := range storage.LockWaitBuckets {
+				cumulative += h.Counts[i]
+				fmt.Fprintf(w, "kvstorage_lock_wait_seconds_bucket{op=%q,le=\"%g\"} %d\n", op, le.Seconds(), cumulative)
+			}
+			fmt.Fprintf(w, "kvstorage_lock_wait_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", op, h.Count)
+			fmt.Fprintf(w, "kvstorage_lock_wait_seconds_sum{op=%q} %g\n", op, h.Sum.Seconds())
+			fmt.Fprintf(w, "kvstorage_lock_wait_seconds_count{op=%q} %d\n", op, h.Count)
+		}
+	}
+}
+
+//writeKeyspaceMetrics writes a series per namespace for each of the stats.
+func writeKeyspaceMetrics(w io.Writer, keyspace []storage.NamespaceStats) {
+	if len(keyspace) == 0 {
+		return
+	}
+	for _, m := range []struct {
+		name, typ, help string
+		value           func(storage.NamespaceStats) float64
+	}{
+		{"kvstorage_namespace_items", "gauge", "Items of the namespace including expired ones which haven't been removed yet.",
+			func(st storage.NamespaceStats) float64 { return float64(st.Items) }},
+		{"kvstorage_namespace_items_expired", "gauge", "Expired items of the namespace which haven't been removed yet.",
+			func(st storage.NamespaceStats) float64 { return float64(st.Expired) }},
+		{"kvstorage_namespace_memory_bytes", "gauge", "Estimated memory taken by the keys and values of the namespace.",
+			func(st storage.NamespaceStats) float64 { return float64(st.Bytes) }},
+		{"kvstorage_namespace_hits_total", "counter", "Lookups which found a live item of the namespace.",
+			func(st storage.NamespaceStats) float64 { return float64(st.Hits) }},
+		{"kvstorage_namespace_misses_total", "counter", "Lookups of keys of the namespace which found no live item.",
+			func(st storage.NamespaceStats) float64 { return float64(st.Misses) }},
+		{"kvstorage_namespace_hit_ratio", "gauge", "Share of lookups of the namespace which found a live item.",
+			func(st storage.NamespaceStats) float64 { return st.HitRatio() }},
+		{"kvstorage_namespace_expirations_total", "counter", "Expired items of the namespace removed by the janitor; rate() gives expirations per second.",
+			func(st storage.NamespaceStats) float64 { return float64(st.Expirations) }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, st := range keyspace {
+			fmt.Fprintf(w, "%s{namespace=%q} %g\n", m.name, st.Namespace, m.value(st))
+		}
+	}
+}
+
+//logSlowJanitorRuns logs a warning about janitor runs of db which took longer than after.
+func logSlowJanitorRuns(db string, after time.Duration) func(storage.JanitorRun) {
+	return func(run storage.JanitorRun) {
+		if run.Duration <= after {
+			return
+		}
+		log.Printf("WARNING: janitor run on %s took %s (%s waiting for the lock), scanned %d and reclaimed %d items",
+			db, run.Duration, run.LockWait, run.Scanned, run.Reclaimed)
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"net/http"
+	"strings"
+)
+
+//maxMGetKeys bounds the keys of a single multi-get.
+const maxMGetKeys = 1000
+
+//HandleMGetNamespaces reads [{"namespace":"users","key":"42"}, ...] and returns
+//the value of every key, in the order requested, from a single pass over the
+//storage, so dashboards aggregating several namespaces need one round trip.
+//A key without namespace is read as it is.
+func (srv *Server) HandleMGetNamespaces() http.HandlerFunc {
+	type ref struct {
+		Namespace string `json:"namespace"`
+		Key       string `json:"key"`
+	}
+	type result struct {
+		Namespace string      `json:"namespace"`
+		Key       string      `json:"key"`
+		Found     bool        `json:"found"`
+		Value     interface{} `json:"value,omitempty"`
+		Version   uint64      `json:"version,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var refs []ref
+		if err := json.NewDecoder(r.Body).Decode(&refs); err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid request body"))
+			return
+		}
+		if len(refs) > maxMGetKeys {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("at most %d keys can be read at once", maxMGetKeys))
+			return
+		}
+		keys := make([]string, len(refs))
+		for i, ref := range refs {
+			if strings.Contains(ref.Namespace, storage.NamespaceSeparator) {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("key %d: invalid namespace", i))
+				return
+			}
+			key := ref.Key
+			if ref.Namespace != "" {
+				key = ref.Namespace + storage.NamespaceSeparator + key
+			}
+			var err error
+			if keys[i], err = srv.checkKey(key); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("key %d: %v", i, err))
+				return
+			}
+		}
+
+		items, found := srv.storage.GetMany(keys)
+		results := make([]result, len(refs))
+		for i, ref := range refs {
+			results[i] = result{Namespace: ref.Namespace, Key: ref.Key, Found: found[i]}
+			if found[i] {
+				results[i].Value = items[i].Object
+				results[i].Version = items[i].Version
+			}
+		}
+		utils.Respond(w, r, http.StatusOK, results)
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+)
+
+//decodeVars unescapes route variables, since the router matches on the encoded
+//path to keep "/" and "%" inside keys, and canonicalizes and validates the key
+//if the route has one.
+func (srv *Server) decodeVars(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		decoded := make(map[string]string, len(vars))
+		for k, v := range vars {
+			d, err := url.PathUnescape(v)
+			if err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid escaping of "+k))
+				return
+			}
+			decoded[k] = d
+		}
+		if key, ok := decoded["key"]; ok {
+			var err error
+			if decoded["key"], err = srv.checkKey(key); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+				return
+			}
+		}
+		next.ServeHTTP(w, mux.SetURLVars(r, decoded))
+	})
+}
+
+//checkKey returns the canonical form of key for its namespace if it is valid.
+func (srv *Server) checkKey(key string) (string, error) {
+	key = srv.storage.CanonicalKey(key)
+	return key, srv.keyPolicy.Validate(key)
+}
+
+//routeTimeout gives requests the deadline configured for their route in
+//route_timeouts. Handlers scanning the storage stop once it passes, see aborted.
+func (srv *Server) routeTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil || len(srv.routeTimeouts) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tpl, _ := route.GetPathTemplate()
+		timeout, ok := srv.routeTimeouts[tpl]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+//aborted responds with 503 if err is the request running out of its time budget.
+func aborted(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		utils.ErrorMessage(w, r, http.StatusServiceUnavailable, errors.New("request took longer than its route timeout"))
+		return
+	}
+	utils.ErrorMessage(w, r, http.StatusInternalServerError, err)
+}
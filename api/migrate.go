@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//migrateReportEvery is how often (in items) progress of a migration is reported.
+const migrateReportEvery = 1000
+
+type migrateProgress struct {
+	Migrated int    `json:"migrated"`
+	Total    int    `json:"total"`
+	Done     bool   `json:"done,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+//keyFilter selects keys by the ?prefix or ?namespace query parameter, so that
+//a single tenant can be backed up or moved. Without either every key is selected.
+func keyFilter(r *http.Request) storage.KeyFilter {
+	q := r.URL.Query()
+	if ns, ok := q["namespace"]; ok {
+		return storage.NamespaceFilter(ns[0])
+	}
+	if prefix := q.Get("prefix"); prefix != "" {
+		return storage.PrefixFilter(prefix)
+	}
+	return nil
+}
+
+//HandleExport streams live items with their expiration as gob records from a
+//point-in-time snapshot, whose version and time are sent as headers.
+func (srv *Server) HandleExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sn, err := srv.storage.SnapshotContext(r.Context(), keyFilter(r))
+		if err != nil {
+			aborted(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("X-Snapshot-Version", strconv.FormatUint(sn.Version, 10))
+		w.Header().Set("X-Snapshot-At", sn.At.Format(time.RFC3339Nano))
+		//the status is sent already, so a timeout can only cut the stream short
+		sn.Export(w, func(int, int) error {
+			return r.Context().Err()
+		})
+	}
+}
+
+//HandleSnapshot streams a point-in-time snapshot in the db file format with a
+//checksum trailer, so that a new instance or a backup can be bootstrapped
+//without access to the server's filesystem.
+func (srv *Server) HandleSnapshot() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		//the status is sent already, a failure only shows as a bad checksum
+		srv.storage.SaveChecksummed(w)
+	}
+}
+
+//HandleImport stores records produced by HandleExport.
+func (srv *Server) HandleImport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n, err := srv.storage.Import(r.Body, keyFilter(r), nil)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("imported %d items: %w", n, err))
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, map[string]int{"imported": n})
+	}
+}
+
+//HandleMigrate copies the keyspace, or the part selected by ?prefix or ?namespace,
+//to the instance given by ?target, sending at most ?rate items per second.
+//Progress is streamed as JSON lines.
+func (srv *Server) HandleMigrate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		target := strings.TrimRight(q.Get("target"), "/")
+		if target == "" {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("target is required"))
+			return
+		}
+		rate := 0
+		if v := q.Get("rate"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("invalid rate: %s", v))
+				return
+			}
+			rate = n
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+		report := func(p migrateProgress) {
+			enc.Encode(p)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		filter := keyFilter(r)
+		wait := utils.Throttle(rate)
+		pr, pw := io.Pipe()
+		progress := migrateProgress{}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, err := srv.storage.Export(pw, filter, func(n, total int) error {
+				progress.Migrated, progress.Total = n, total
+				if n%migrateReportEvery == 0 {
+					report(progress)
+				}
+				wait()
+				return r.Context().Err()
+			})
+			pw.CloseWithError(err)
+		}()
+
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, target+"/admin/import", pr)
+		if err == nil {
+			var resp *http.Response
+			if resp, err = (&http.Client{}).Do(req); err == nil {
+				if resp.StatusCode != http.StatusOK {
+					err = fmt.Errorf("%s/admin/import: %s", target, resp.Status)
+				}
+				resp.Body.Close()
+			}
+		}
+		pr.CloseWithError(errors.New("migration aborted"))
+		<-done
+
+		progress.Done = true
+		if err != nil {
+			progress.Error = err.Error()
+		}
+		report(progress)
+	}
+}
+
+//HandleImportRDB stores string keys from an uploaded Redis RDB dump.
+func (srv *Server) HandleImportRDB() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := srv.storage.ImportRDB(r.Body)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, stats)
+	}
+}
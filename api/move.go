@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+)
+
+//moveFailed reports an error of a rename or copy to key.
+func (srv *Server) moveFailed(w http.ResponseWriter, r *http.Request, key string, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		utils.ErrorMessage(w, r, http.StatusNotFound, err)
+	case errors.Is(err, storage.ErrExists):
+		utils.ErrorMessage(w, r, http.StatusConflict, err)
+	default:
+		srv.writeError(w, r, key, http.StatusUnprocessableEntity, err)
+	}
+}
+
+//HandleRename atomically moves the item to ?to. An existing item there is only
+//replaced with overwrite=true, which allows staging a value and then promoting it.
+func (srv *Server) HandleRename() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+		q := r.URL.Query()
+		if q.Get("to") == "" {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("to is required"))
+			return
+		}
+		to, err := srv.checkKey(q.Get("to"))
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("to: %v", err))
+			return
+		}
+
+		version, err := srv.guarded(r).Rename(key, to, q.Get("overwrite") == "true")
+		if err != nil {
+			srv.moveFailed(w, r, to, err)
+			return
+		}
+		written(w, version)
+	}
+}
+
+//HandleCopy copies the item to ?to, replacing any item there. The copy keeps the
+//expiration of the original unless ?ttl is given (-1 for no expiration).
+func (srv *Server) HandleCopy() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+		q := r.URL.Query()
+		if q.Get("to") == "" {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("to is required"))
+			return
+		}
+		to, err := srv.checkKey(q.Get("to"))
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("to: %v", err))
+			return
+		}
+		ttl := storage.DefaultExpiration
+		if v := q.Get("ttl"); v == "-1" {
+			ttl = storage.NoExpiration
+		} else if v != "" {
+			if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid ttl"))
+				return
+			}
+		}
+
+		version, err := srv.guarded(r).Copy(key, to, ttl)
+		if err != nil {
+			srv.moveFailed(w, r, to, err)
+			return
+		}
+		written(w, version)
+	}
+}
+
+//HandleRotate reads {"keys": ["new", "current", "previous"], "wrap": false} and
+//atomically moves the item of every key to the next one, see storage.Rotate.
+func (srv *Server) HandleRotate() http.HandlerFunc {
+	type request struct {
+		Keys []string `json:"keys"`
+		Wrap bool     `json:"wrap"`
+	}
+	type result struct {
+		Key     string `json:"key"`
+		Version uint64 `json:"version,omitempty"`
+		Deleted bool   `json:"deleted,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := request{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid request body"))
+			return
+		}
+		for i, key := range req.Keys {
+			var err error
+			if req.Keys[i], err = srv.checkKey(key); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("key %d: %v", i, err))
+				return
+			}
+		}
+
+		versions, err := srv.guarded(r).Rotate(req.Keys, req.Wrap)
+		if err != nil {
+			srv.writeError(w, r, "", http.StatusUnprocessableEntity, err)
+			return
+		}
+		results := make([]result, len(versions))
+		for i, v := range versions {
+			results[i] = result{Key: req.Keys[i], Version: v, Deleted: v == 0}
+		}
+		utils.Respond(w, r, http.StatusOK, results)
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRotate_Errors(t *testing.T) {
+	db := storage.New(storage.DefaultExpiration, 0, 0)
+	db.SetNamespaceOptions("full", storage.NamespaceOptions{MaxItems: 1})
+	db.SetSchema("strict", &storage.Schema{Type: "object"})
+	db.Set("full:1", "v", storage.NoExpiration)
+	db.Set("a", "v", storage.NoExpiration)
+	srv := NewServer(db)
+	srv.config = &Config{}
+	srv.configureRouter()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	rotate := func(body string) (*http.Response, map[string]interface{}) {
+		t.Helper()
+		resp, err := http.Post(ts.URL+"/rotate", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		res := map[string]interface{}{}
+		json.NewDecoder(resp.Body).Decode(&res)
+		return resp, res
+	}
+
+	//the namespace of the key which didn't fit is described
+	resp, _ := rotate(`{"keys": ["a", "full:2"]}`)
+	if resp.StatusCode != http.StatusInsufficientStorage || resp.Header.Get("X-Quota-Namespace") != "full" {
+		t.Errorf("rotation into a full namespace returned %d, namespace %q", resp.StatusCode, resp.Header.Get("X-Quota-Namespace"))
+	}
+	resp, res := rotate(`{"keys": ["a", "strict:1"]}`)
+	if resp.StatusCode != http.StatusUnprocessableEntity || res["details"] == nil {
+		t.Errorf("rotation of an invalid value returned %d, %v", resp.StatusCode, res)
+	}
+	if resp, res = rotate(`{"keys": ["a", "a"]}`); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("rotation of a duplicate key returned %d, %v", resp.StatusCode, res)
+	}
+	if v, _ := db.Get("a"); v != "v" {
+		t.Error("failed rotations changed keys")
+	}
+}
@@ -0,0 +1,385 @@
+package api
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//The MQTT bridge speaks the part of MQTT 3.1.1 devices need to use the store:
+//publishing to kv/set/{key} writes the payload as the value of key, publishing
+//to kv/delete/{key} deletes it, and subscribing to kv/updates/{pattern} delivers
+//a JSON event to kv/updates/{key} for every change of a key matching pattern
+//(see storage.MatchPattern; the MQTT wildcards + and # match like *).
+//Publishes are accepted with QoS 0 and 1, events are delivered with QoS 0.
+//Retained messages, wills, sessions and QoS 2 aren't supported.
+
+const (
+	mqttConnect     = 1
+	mqttConnack     = 2
+	mqttPublish     = 3
+	mqttPuback      = 4
+	mqttSubscribe   = 8
+	mqttSuback      = 9
+	mqttUnsubscribe = 10
+	mqttUnsuback    = 11
+	mqttPingreq     = 12
+	mqttPingresp    = 13
+	mqttDisconnect  = 14
+)
+
+const (
+	mqttSetPrefix     = "kv/set/"
+	mqttDeletePrefix  = "kv/delete/"
+	mqttUpdatesPrefix = "kv/updates/"
+)
+
+//mqttMaxPacket bounds the packets clients may send.
+const mqttMaxPacket = 1 << 20
+
+//mqttEventBuffer is how many events a slow subscriber may lag behind before
+//further ones are dropped.
+const mqttEventBuffer = 256
+
+//mqttStats counts bridge activity for the metrics and is accessed atomically.
+type mqttStats struct {
+	connections   int64
+	published     uint64
+	delivered     uint64
+	droppedEvents uint64
+}
+
+type mqttEvent struct {
+	Key     string      `json:"key"`
+	Value   interface{} `json:"value,omitempty"`
+	Version uint64      `json:"version,omitempty"`
+	Deleted bool        `json:"deleted,omitempty"`
+}
+
+type mqttConn struct {
+	srv    *Server
+	conn   net.Conn
+	r      *bufio.Reader
+	wmu    sync.Mutex
+	id     string
+	events chan mqttEvent
+	//subs maps topic filters to the cancel functions of their notifications
+	subs map[string]func()
+}
+
+//serveMQTT runs the bridge on ln until it is closed.
+func (srv *Server) serveMQTT(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		c := &mqttConn{
+			srv:    srv,
+			conn:   conn,
+			r:      bufio.NewReader(conn),
+			events: make(chan mqttEvent, mqttEventBuffer),
+			subs:   make(map[string]func()),
+		}
+		go c.serve()
+	}
+}
+
+func (c *mqttConn) serve() {
+	atomic.AddInt64(&c.srv.mqtt.connections, 1)
+	done := make(chan struct{})
+	defer func() {
+		for _, cancel := range c.subs {
+			cancel()
+		}
+		close(done)
+		c.conn.Close()
+		atomic.AddInt64(&c.srv.mqtt.connections, -1)
+	}()
+
+	keepAlive, err := c.connect()
+	if err != nil {
+		if err != io.EOF {
+			log.Printf("WARNING: mqtt %s: %v", c.conn.RemoteAddr(), err)
+		}
+		return
+	}
+	go c.deliver(done)
+
+	for {
+		if keepAlive > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		}
+		typ, flags, body, err := readMQTTPacket(c.r)
+		if err == nil {
+			err = c.handle(typ, flags, body)
+		}
+		if err == errMQTTDisconnect || err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Printf("WARNING: mqtt client %s: %v", c.id, err)
+			return
+		}
+	}
+}
+
+var errMQTTDisconnect = errors.New("disconnect")
+
+//connect handles the CONNECT packet and returns the keep alive of the client.
+func (c *mqttConn) connect() (time.Duration, error) {
+	c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	typ, _, body, err := readMQTTPacket(c.r)
+	if err != nil {
+		return 0, err
+	}
+	if typ != mqttConnect {
+		return 0, errors.New("first packet isn't CONNECT")
+	}
+	protocol, body, err := readMQTTString(body)
+	if err != nil || len(body) < 4 {
+		return 0, errors.New("malformed CONNECT")
+	}
+	if protocol != "MQTT" || body[0] != 4 {
+		//unacceptable protocol version
+		c.write(mqttConnack<<4, []byte{0, 1})
+		return 0, fmt.Errorf("unsupported protocol %s level %d", protocol, body[0])
+	}
+	keepAlive := time.Duration(binary.BigEndian.Uint16(body[2:4])) * time.Second
+	if c.id, _, err = readMQTTString(body[4:]); err != nil {
+		return 0, errors.New("malformed CONNECT")
+	}
+	if c.id == "" {
+		c.id = c.conn.RemoteAddr().String()
+	}
+	c.conn.SetReadDeadline(time.Time{})
+	return keepAlive, c.write(mqttConnack<<4, []byte{0, 0})
+}
+
+func (c *mqttConn) handle(typ, flags byte, body []byte) error {
+	switch typ {
+	case mqttPublish:
+		return c.publish(flags, body)
+	case mqttSubscribe:
+		return c.subscribe(body)
+	case mqttUnsubscribe:
+		return c.unsubscribe(body)
+	case mqttPingreq:
+		return c.write(mqttPingresp<<4, nil)
+	case mqttDisconnect:
+		return errMQTTDisconnect
+	}
+	return fmt.Errorf("unsupported packet type %d", typ)
+}
+
+func (c *mqttConn) publish(flags byte, body []byte) error {
+	qos := flags >> 1 & 3
+	if qos > 1 {
+		return errors.New("QoS 2 isn't supported")
+	}
+	topic, body, err := readMQTTString(body)
+	if err != nil {
+		return errors.New("malformed PUBLISH")
+	}
+	var packetID []byte
+	if qos == 1 {
+		if len(body) < 2 {
+			return errors.New("malformed PUBLISH")
+		}
+		packetID, body = body[:2], body[2:]
+	}
+
+	atomic.AddUint64(&c.srv.mqtt.published, 1)
+	//MQTT has no way to report a failed publish, so failures are only logged
+	if err := c.apply(topic, body); err != nil {
+		log.Printf("WARNING: mqtt client %s: publish to %s: %v", c.id, topic, err)
+	}
+	if qos == 1 {
+		return c.write(mqttPuback<<4, packetID)
+	}
+	return nil
+}
+
+//apply performs the operation of a publish to topic.
+func (c *mqttConn) apply(topic string, payload []byte) error {
+	var key string
+	switch {
+	case strings.HasPrefix(topic, mqttSetPrefix):
+		key = strings.TrimPrefix(topic, mqttSetPrefix)
+	case strings.HasPrefix(topic, mqttDeletePrefix):
+		key = strings.TrimPrefix(topic, mqttDeletePrefix)
+	default:
+		return errors.New("unknown topic")
+	}
+	key, err := c.srv.checkKey(key)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(topic, mqttDeletePrefix) {
+		//MQTT clients have no lock tokens, so they can't write locked keys
+		_, err = c.srv.storage.Guard(c.srv.lockGuard("")).Delete(key)
+		return err
+	}
+	_, err = c.srv.write(key, string(payload), writeOptions{ttl: storage.DefaultExpiration})
+	return err
+}
+
+func (c *mqttConn) subscribe(body []byte) error {
+	if len(body) < 2 {
+		return errors.New("malformed SUBSCRIBE")
+	}
+	ack := append([]byte{}, body[:2]...)
+	for body = body[2:]; len(body) > 0; {
+		var filter string
+		var err error
+		if filter, body, err = readMQTTString(body); err != nil || len(body) < 1 {
+			return errors.New("malformed SUBSCRIBE")
+		}
+		body = body[1:]
+		if !strings.HasPrefix(filter, mqttUpdatesPrefix) {
+			ack = append(ack, 0x80)
+			continue
+		}
+		if _, ok := c.subs[filter]; !ok {
+			pattern := strings.NewReplacer("#", "*", "+", "*").Replace(strings.TrimPrefix(filter, mqttUpdatesPrefix))
+			c.subs[filter] = c.srv.storage.NotifyChanged(pattern, c.notify)
+		}
+		ack = append(ack, 0)
+	}
+	return c.write(mqttSuback<<4, ack)
+}
+
+func (c *mqttConn) unsubscribe(body []byte) error {
+	if len(body) < 2 {
+		return errors.New("malformed UNSUBSCRIBE")
+	}
+	packetID := body[:2]
+	for body = body[2:]; len(body) > 0; {
+		var filter string
+		var err error
+		if filter, body, err = readMQTTString(body); err != nil {
+			return errors.New("malformed UNSUBSCRIBE")
+		}
+		if cancel, ok := c.subs[filter]; ok {
+			cancel()
+			delete(c.subs, filter)
+		}
+	}
+	return c.write(mqttUnsuback<<4, packetID)
+}
+
+//notify is called by the storage with its lock held, so it never blocks.
+func (c *mqttConn) notify(key string, item *storage.Item) {
+	event := mqttEvent{Key: key, Deleted: item == nil}
+	if item != nil {
+		if _, chunked := item.Object.(storage.ChunkedValue); !chunked {
+			event.Value = item.Object
+		}
+		event.Version = item.Version
+	}
+	select {
+	case c.events <- event:
+	default:
+		atomic.AddUint64(&c.srv.mqtt.droppedEvents, 1)
+	}
+}
+
+//deliver publishes events to the client until done is closed.
+func (c *mqttConn) deliver(done chan struct{}) {
+	for {
+		select {
+		case event := <-c.events:
+			payload, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			body := appendMQTTString(nil, mqttUpdatesPrefix+event.Key)
+			if c.write(mqttPublish<<4, append(body, payload...)) != nil {
+				return
+			}
+			atomic.AddUint64(&c.srv.mqtt.delivered, 1)
+		case <-done:
+			return
+		}
+	}
+}
+
+func (c *mqttConn) write(header byte, body []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	packet := []byte{header}
+	for n := len(body); ; {
+		b := byte(n % 128)
+		if n /= 128; n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := c.conn.Write(append(packet, body...))
+	return err
+}
+
+//readMQTTPacket reads a packet and returns its type, flags and everything after the fixed header.
+func readMQTTPacket(r *bufio.Reader) (byte, byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	length, mult := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		length += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		if mult *= 128; i == 3 {
+			return 0, 0, nil, errors.New("malformed remaining length")
+		}
+	}
+	if length > mqttMaxPacket {
+		return 0, 0, nil, fmt.Errorf("packet of %d bytes is too large", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return header >> 4, header & 0x0f, body, nil
+}
+
+func readMQTTString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadMQTTPacket(t *testing.T) {
+	cases := []struct {
+		name   string
+		packet []byte
+		body   string
+		err    string
+	}{
+		{"short length", []byte{mqttPingreq << 4, 0}, "", ""},
+		{"long length", append([]byte{mqttPublish<<4 | 2, 0x80, 0x01}, bytes.Repeat([]byte("x"), 128)...), strings.Repeat("x", 128), ""},
+		{"malformed length", []byte{mqttPublish << 4, 0xff, 0xff, 0xff, 0xff, 0x01}, "", "malformed remaining length"},
+		//the body isn't allocated before the length is checked
+		{"oversized", []byte{mqttPublish << 4, 0x80, 0x80, 0x80, 0x01}, "", "packet of 2097152 bytes is too large"},
+		{"truncated body", []byte{mqttPublish << 4, 5, 'a'}, "", io.ErrUnexpectedEOF.Error()},
+		{"truncated length", []byte{mqttPublish << 4, 0x80}, "", io.EOF.Error()},
+	}
+	for _, c := range cases {
+		typ, flags, body, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(c.packet)))
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("%s: unexpected error %v", c.name, err)
+			}
+			continue
+		}
+		if err != nil || typ != c.packet[0]>>4 || flags != c.packet[0]&0x0f || string(body) != c.body {
+			t.Errorf("%s: read type %d, flags %d, %d bytes, %v", c.name, typ, flags, len(body), err)
+		}
+	}
+}
+
+//mqttClient is the client side of a bridge connection in tests.
+type mqttClient struct {
+	t *testing.T
+	c *mqttConn
+}
+
+func dialMQTT(t *testing.T, addr string) *mqttClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return &mqttClient{t, &mqttConn{conn: conn, r: bufio.NewReader(conn)}}
+}
+
+func (c *mqttClient) send(header byte, body []byte) {
+	c.t.Helper()
+	if err := c.c.write(header, body); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+//expect reads the next packet, which must be of type typ.
+func (c *mqttClient) expect(typ byte) []byte {
+	c.t.Helper()
+	got, _, body, err := readMQTTPacket(c.c.r)
+	if err != nil {
+		c.t.Fatalf("reading packet of type %d: %v", typ, err)
+	}
+	if got != typ {
+		c.t.Fatalf("received packet of type %d, want %d", got, typ)
+	}
+	return body
+}
+
+func (c *mqttClient) connect() {
+	c.t.Helper()
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4, 2, 0, 60)
+	c.send(mqttConnect<<4, appendMQTTString(body, "test"))
+	if ack := c.expect(mqttConnack); !bytes.Equal(ack, []byte{0, 0}) {
+		c.t.Fatalf("connection was refused: %v", ack)
+	}
+}
+
+func startMQTT(t *testing.T) (*storage.Storage, net.Listener) {
+	db := storage.New(storage.DefaultExpiration, 0, 0)
+	srv := NewServer(db)
+	srv.config = &Config{}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.serveMQTT(ln)
+	return db, ln
+}
+
+func TestMQTT(t *testing.T) {
+	db, ln := startMQTT(t)
+	defer ln.Close()
+	c := dialMQTT(t, ln.Addr().String())
+	defer c.c.conn.Close()
+	c.connect()
+
+	//filters outside kv/updates/ are refused
+	sub := []byte{0, 1}
+	sub = append(appendMQTTString(sub, "kv/updates/dev+"), 0)
+	sub = append(appendMQTTString(sub, "other/#"), 0)
+	c.send(mqttSubscribe<<4|2, sub)
+	if ack := c.expect(mqttSuback); !bytes.Equal(ack, []byte{0, 1, 0, 0x80}) {
+		t.Fatalf("unexpected SUBACK %v", ack)
+	}
+
+	//QoS 1 publishes are acknowledged with their packet id, and the change is
+	//delivered to the subscriber; the two are written by different goroutines
+	c.send(mqttPublish<<4|2, append(appendMQTTString(nil, "kv/set/dev1"), 0x12, 0x34, 'o', 'n'))
+	var ack []byte
+	var event mqttEvent
+	for i := 0; i < 2; i++ {
+		typ, _, body, err := readMQTTPacket(c.c.r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch typ {
+		case mqttPuback:
+			ack = body
+		case mqttPublish:
+			topic, payload, err := readMQTTString(body)
+			if err != nil || topic != "kv/updates/dev1" {
+				t.Fatalf("event was published to %q: %v", topic, err)
+			}
+			if err := json.Unmarshal(payload, &event); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			t.Fatalf("unexpected packet of type %d", typ)
+		}
+	}
+	if !bytes.Equal(ack, []byte{0x12, 0x34}) {
+		t.Errorf("unexpected PUBACK %v", ack)
+	}
+	if v, found := db.Get("dev1"); !found || v != "on" {
+		t.Errorf("published value is %v", v)
+	}
+	if event.Key != "dev1" || event.Value != "on" || event.Version == 0 || event.Deleted {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	//keys not matching the filter aren't delivered
+	db.Set("other", "x", storage.NoExpiration)
+	db.Delete("dev1")
+	topic, payload, _ := readMQTTString(c.expect(mqttPublish))
+	if err := json.Unmarshal(payload, &event); err != nil || topic != "kv/updates/dev1" || !event.Deleted {
+		t.Errorf("unexpected event on %s: %s", topic, payload)
+	}
+
+	//nothing is delivered after unsubscribing; PINGRESP follows the QoS 0 publish
+	c.send(mqttUnsubscribe<<4|2, appendMQTTString([]byte{0, 2}, "kv/updates/dev+"))
+	if ack := c.expect(mqttUnsuback); !bytes.Equal(ack, []byte{0, 2}) {
+		t.Errorf("unexpected UNSUBACK %v", ack)
+	}
+	c.send(mqttPublish<<4, append(appendMQTTString(nil, "kv/set/dev2"), "off"...))
+	c.send(mqttPingreq<<4, nil)
+	c.expect(mqttPingresp)
+	if v, found := db.Get("dev2"); !found || v != "off" {
+		t.Errorf("published value is %v", v)
+	}
+
+	c.send(mqttDisconnect<<4, nil)
+	if _, _, _, err := readMQTTPacket(c.c.r); err != io.EOF {
+		t.Errorf("connection wasn't closed after DISCONNECT: %v", err)
+	}
+}
+
+func TestMQTT_MalformedPackets(t *testing.T) {
+	_, ln := startMQTT(t)
+	defer ln.Close()
+	for name, packet := range map[string][]byte{
+		"malformed length": {mqttPublish << 4, 0xff, 0xff, 0xff, 0xff, 0x01},
+		"oversized":        {mqttPublish << 4, 0xff, 0xff, 0xff, 0x7f},
+		"QoS 2":            append([]byte{mqttPublish<<4 | 4, 5}, appendMQTTString(nil, "kv/set/k")[:5]...),
+		"malformed topic":  {mqttPublish << 4, 2, 0, 9},
+	} {
+		c := dialMQTT(t, ln.Addr().String())
+		c.connect()
+		if _, err := c.c.conn.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+		//the connection is closed without an answer
+		if _, _, _, err := readMQTTPacket(c.c.r); err != io.EOF {
+			t.Errorf("%s: connection wasn't closed: %v", name, err)
+		}
+		c.c.conn.Close()
+	}
+
+	//the first packet must be a CONNECT of MQTT 3.1.1
+	c := dialMQTT(t, ln.Addr().String())
+	defer c.c.conn.Close()
+	body := append(appendMQTTString(nil, "MQTT"), 3, 2, 0, 60)
+	c.send(mqttConnect<<4, appendMQTTString(body, "old"))
+	if ack := c.expect(mqttConnack); !bytes.Equal(ack, []byte{0, 1}) {
+		t.Errorf("unsupported protocol level was answered with %v", ack)
+	}
+}
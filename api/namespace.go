@@ -0,0 +1,25 @@
+package api
+
+import (
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+)
+
+//HandleNamespaceSliding enables sliding expiration with the given ttl for
+//a namespace, ttl=0 disables it.
+func (srv *Server) HandleNamespaceSliding() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns := mux.Vars(r)["namespace"]
+
+		ttl, err := time.ParseDuration(r.URL.Query().Get("ttl"))
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid ttl"))
+			return
+		}
+		srv.storage.SetNamespaceSliding(ns, ttl)
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//acceptable reports whether the Accept header accept allows contentType.
+//A missing header accepts anything.
+func acceptable(accept, contentType string) bool {
+	if accept == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	for _, part := range strings.Split(accept, ",") {
+		want, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		if want == "*/*" || want == mediaType ||
+			strings.HasSuffix(want, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(want, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+//serveValue writes a value stored in contentType as it is, or 406 if the client
+//doesn't accept contentType.
+func serveValue(w http.ResponseWriter, r *http.Request, contentType string, content io.ReadSeeker) {
+	if !acceptable(r.Header.Get("Accept"), contentType) {
+		utils.ErrorMessage(w, r, http.StatusNotAcceptable, fmt.Errorf("value is stored as %s", contentType))
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, "", time.Time{}, content)
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//saveRulesInterval is how often save rules are checked.
+const saveRulesInterval = time.Second
+
+//persistence tracks results of saving the db.
+type persistence struct {
+	mu           sync.Mutex
+	lastAttempt  time.Time
+	lastDuration time.Duration
+	lastError    error
+	//failingSince is the time of the first failed save after the last successful one
+	failingSince time.Time
+}
+
+func (p *persistence) record(start time.Time, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastAttempt = start
+	p.lastDuration = time.Since(start)
+	p.lastError = err
+	if err == nil {
+		p.failingSince = time.Time{}
+	} else if p.failingSince.IsZero() {
+		p.failingSince = start
+	}
+}
+
+//failing returns the time persistence has been failing since, or a zero time.
+func (p *persistence) failing() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.failingSince
+}
+
+//saveFile saves the db unless a persistence failure is injected.
+func (srv *Server) saveFile(filename string) error {
+	start := time.Now()
+	var err error
+	if srv.faults != nil && srv.faults.get().FailPersistence {
+		err = errInjectedFault
+	} else {
+		err = srv.storage.SaveFile(filename)
+	}
+	srv.persistence.record(start, err)
+	return err
+}
+
+//runSaveRules saves the db whenever one of rules is satisfied.
+func (srv *Server) runSaveRules(rules []storage.SaveRule) {
+	ticker := time.NewTicker(saveRulesInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !srv.storage.NeedsSave(rules) {
+			continue
+		}
+		if err := srv.saveFile(srv.config.DBFileName); err != nil {
+			log.Printf("ERROR: saving %s: %v", srv.config.DBFileName, err)
+		}
+	}
+}
+
+//runDeltas writes a delta of the keys changed since the last save every interval,
+//or a full snapshot if there is none yet or maxDeltas deltas were written on top
+//of it (0 means no limit).
+func (srv *Server) runDeltas(interval time.Duration, maxDeltas int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	filename := srv.config.DBFileName
+	for range ticker.C {
+		if srv.storage.Changed() == 0 {
+			continue
+		}
+		err := errDeltaLimit
+		if maxDeltas == 0 || srv.storage.Deltas() < maxDeltas {
+			err = srv.saveDelta(filename)
+		}
+		if errors.Is(err, storage.ErrNoBaseSnapshot) || errors.Is(err, errDeltaLimit) {
+			err = srv.saveFile(filename)
+		}
+		if err != nil {
+			log.Printf("ERROR: saving %s: %v", filename, err)
+		}
+	}
+}
+
+var errDeltaLimit = errors.New("too many deltas")
+
+//saveDelta saves a delta of the db unless a persistence failure is injected.
+func (srv *Server) saveDelta(filename string) error {
+	start := time.Now()
+	var err error
+	if srv.faults != nil && srv.faults.get().FailPersistence {
+		err = errInjectedFault
+	} else {
+		_, err = srv.storage.SaveDelta(filename)
+	}
+	srv.persistence.record(start, err)
+	return err
+}
+
+//persistenceWarning adds a Warning header to writes while saving the db
+//has been failing for longer than persistence_warn_after.
+func (srv *Server) persistenceWarning(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			since := srv.persistence.failing()
+			if !since.IsZero() && time.Since(since) > srv.warnAfter {
+				w.Header().Set("Warning", fmt.Sprintf(`199 kvstorage-srv "persistence failing since %s"`,
+					since.UTC().Format(time.RFC3339)))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (srv *Server) HandlePersistence() http.HandlerFunc {
+	type response struct {
+		Dirty        uint64     `json:"dirty"`
+		LastSave     time.Time  `json:"last_save"`
+		Deltas       int        `json:"deltas"`
+		Changed      int        `json:"changed"`
+		LastAttempt  *time.Time `json:"last_attempt,omitempty"`
+		LastDuration string     `json:"last_duration,omitempty"`
+		LastError    string     `json:"last_error,omitempty"`
+		FailingSince *time.Time `json:"failing_since,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := response{
+			Dirty:    srv.storage.Dirty(),
+			LastSave: srv.storage.LastSave(),
+			Deltas:   srv.storage.Deltas(),
+			Changed:  srv.storage.Changed(),
+		}
+		p := &srv.persistence
+		p.mu.Lock()
+		if !p.lastAttempt.IsZero() {
+			attempt := p.lastAttempt
+			resp.LastAttempt = &attempt
+			resp.LastDuration = p.lastDuration.String()
+		}
+		if p.lastError != nil {
+			resp.LastError = p.lastError.Error()
+		}
+		if !p.failingSince.IsZero() {
+			since := p.failingSince
+			resp.FailingSince = &since
+		}
+		p.mu.Unlock()
+		utils.Respond(w, r, http.StatusOK, resp)
+	}
+}
+
+//Exit codes of a shutdown, so that orchestration can tell whether the final save happened.
+const (
+	ExitSaved       = 0
+	ExitSaveFailed  = 3
+	ExitSaveTimeout = 4
+)
+
+//shutdown saves filename and the db files of mounted stores unless save_on_shutdown
+//is disabled, and returns the exit code. If the save takes longer than
+//shutdown_save_timeout, the previous snapshots are kept since files are replaced atomically.
+func (srv *Server) shutdown(filename string) int {
+	if srv.config != nil && !srv.config.SaveOnShutdown {
+		log.Printf("exiting without saving, save_on_shutdown is disabled")
+		return ExitSaved
+	}
+	var timeout <-chan time.Time
+	if srv.config != nil && srv.config.ShutdownSaveTimeout != "" {
+		budget, err := time.ParseDuration(srv.config.ShutdownSaveTimeout)
+		if err == nil {
+			timeout = time.After(budget)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		//writes accepted with async=true are acknowledged already, so they must be saved
+		srv.asyncWrites().flush()
+		err := srv.saveFile(filename)
+		for _, store := range srv.stores {
+			store.asyncWrites().flush()
+			if serr := store.saveFile(store.config.DBFileName); err == nil {
+				err = serr
+			}
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Printf("ERROR: final save failed: %v", err)
+			return ExitSaveFailed
+		}
+		return ExitSaved
+	case <-timeout:
+		log.Printf("final save didn't finish in %s, keeping the previous snapshot", srv.config.ShutdownSaveTimeout)
+		return ExitSaveTimeout
+	}
+}
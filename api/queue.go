@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//maxQueueReceive bounds ?max of a receive.
+const maxQueueReceive = 100
+
+//HandleQPush appends the request body to the queue as a message.
+func (srv *Server) HandleQPush() http.HandlerFunc {
+	type response struct {
+		ID uint64 `json:"id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+
+		if srv.config != nil && srv.config.MaxValueSize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, srv.config.MaxValueSize)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		id, err := srv.guarded(r).QPush(key, string(body))
+		if err != nil {
+			srv.writeError(w, r, key, http.StatusConflict, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, response{id})
+	}
+}
+
+//HandleQReceive returns up to ?max (1 by default) messages, which are received
+//again after ?visibility (30s by default) unless they are acked with their receipt.
+func (srv *Server) HandleQReceive() http.HandlerFunc {
+	type message struct {
+		ID       uint64 `json:"id"`
+		Body     string `json:"body"`
+		Receipt  string `json:"receipt"`
+		Receives int    `json:"receives"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+		q := r.URL.Query()
+
+		var err error
+		max := 1
+		if v := q.Get("max"); v != "" {
+			if max, err = strconv.Atoi(v); err != nil || max <= 0 || max > maxQueueReceive {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("max must be between 1 and 100"))
+				return
+			}
+		}
+		var visibility time.Duration
+		if v := q.Get("visibility"); v != "" {
+			if visibility, err = time.ParseDuration(v); err != nil || visibility <= 0 {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid visibility"))
+				return
+			}
+		}
+
+		msgs, err := srv.guarded(r).QReceive(key, max, visibility)
+		if err != nil {
+			srv.writeError(w, r, key, http.StatusConflict, err)
+			return
+		}
+		resp := make([]message, len(msgs))
+		for i, m := range msgs {
+			resp[i] = message{ID: m.ID, Body: m.Body, Receipt: m.Receipt(), Receives: m.Receives}
+		}
+		utils.Respond(w, r, http.StatusOK, resp)
+	}
+}
+
+//HandleQAck reads {"receipt":"..."} and deletes the received message.
+func (srv *Server) HandleQAck() http.HandlerFunc {
+	type request struct {
+		Receipt string `json:"receipt"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+
+		req := request{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Receipt == "" {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("body must be {\"receipt\": ...}"))
+			return
+		}
+
+		err := srv.guarded(r).QAck(key, req.Receipt)
+		switch {
+		case errors.Is(err, errKeyLocked):
+			lockedResponse(w, r, err)
+		case errors.Is(err, storage.ErrNotFound):
+			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("no such message"))
+		case errors.Is(err, storage.ErrStaleReceipt):
+			utils.ErrorMessage(w, r, http.StatusConflict, err)
+		case err != nil:
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+func (srv *Server) HandleQStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+
+		st, err := srv.storage.QStats(key)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusConflict, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, st)
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+//quotas counts writes rejected because their namespace reached max_items, and
+//remembers the namespaces whose first rejection was alerted.
+type quotas struct {
+	mu       sync.Mutex
+	rejected map[string]uint64
+	alerted  map[string]bool
+}
+
+//reject counts a rejection in namespace and reports whether it is the first one.
+func (q *quotas) reject(namespace string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.rejected == nil {
+		q.rejected = make(map[string]uint64)
+		q.alerted = make(map[string]bool)
+	}
+	q.rejected[namespace]++
+	if q.alerted[namespace] {
+		return false
+	}
+	q.alerted[namespace] = true
+	return true
+}
+
+//namespaceFull responds with 507 to a write of key rejected by the item limit
+//of its namespace, see quotaExceeded.
+func (srv *Server) namespaceFull(w http.ResponseWriter, r *http.Request, key string, err error) {
+	srv.quotaExceeded(w, key)
+	utils.ErrorMessage(w, r, http.StatusInsufficientStorage, err)
+}
+
+//quotaExceeded describes the usage of the namespace of key in X-Quota-Namespace,
+//X-Quota-Items and X-Quota-Max-Items, and posts the first rejection of each
+//namespace as a "quota_exceeded" event to quota_alert_url.
+func (srv *Server) quotaExceeded(w http.ResponseWriter, key string) {
+	name := storage.Namespace(key)
+	items, maxItems, _ := srv.storage.NamespaceQuota(name)
+	h := w.Header()
+	h.Set("X-Quota-Namespace", name)
+	h.Set("X-Quota-Items", strconv.Itoa(items))
+	h.Set("X-Quota-Max-Items", strconv.Itoa(maxItems))
+
+	if srv.quotas.reject(name) && srv.config != nil && srv.config.QuotaAlertURL != "" {
+		go srv.deliverOrDeadLetter(srv.config.QuotaAlertURL, webhookEvent{
+			Type: "quota_exceeded",
+			Key:  key,
+			Value: map[string]interface{}{
+				"namespace": name,
+				"items":     items,
+				"max_items": maxItems,
+			},
+		})
+	}
+}
+
+func (srv *Server) writeQuotaMetrics(w io.Writer) {
+	q := &srv.quotas
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.rejected) == 0 {
+		return
+	}
+	names := make([]string, 0, len(q.rejected))
+	for name := range q.rejected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprint(w, "# HELP kvstorage_quota_rejections_total Writes rejected because their namespace reached max_items.\n# TYPE kvstorage_quota_rejections_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "kvstorage_quota_rejections_total{namespace=%q} %d\n", name, q.rejected[name])
+	}
+}
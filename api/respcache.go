@@ -0,0 +1,92 @@
+package api
+
+import (
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"sync"
+)
+
+//maxCachedResponse keeps large values out of the response cache, encoding them
+//costs little compared to sending them.
+const maxCachedResponse = 64 << 10
+
+//responseCacheSample is how many entries are compared to find the least used one
+//when the cache is full.
+const responseCacheSample = 5
+
+type cachedResponse struct {
+	version uint64
+	body    []byte
+	hits    uint64
+}
+
+//responseCache keeps encoded GET responses of keys so that the value isn't encoded
+//on every read. Entries are valid for the version of the item they were encoded
+//from, so a write invalidates them.
+type responseCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*cachedResponse
+	hits    uint64
+	misses  uint64
+}
+
+func newResponseCache(size int) *responseCache {
+	return &responseCache{size: size, entries: make(map[string]*cachedResponse, size)}
+}
+
+//encode returns the encoded response of item read from key, see encodeItem.
+//A nil cache encodes every time.
+func (c *responseCache) encode(key string, item storage.Item) []byte {
+	if c == nil {
+		return encodeItem(item)
+	}
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok && e.version == item.Version {
+		e.hits++
+		c.hits++
+		c.mu.Unlock()
+		return e.body
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	body := encodeItem(item)
+	if body == nil || len(body) > maxCachedResponse {
+		return body
+	}
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		//keep the hits of the key, it's as hot as before the write
+		e.version, e.body = item.Version, body
+	} else {
+		if len(c.entries) >= c.size {
+			c.evict()
+		}
+		c.entries[key] = &cachedResponse{version: item.Version, body: body}
+	}
+	c.mu.Unlock()
+	return body
+}
+
+//evict removes the least used of a few random entries. Must be called with the lock held.
+func (c *responseCache) evict() {
+	var victim string
+	var min uint64
+	n := 0
+	for key, e := range c.entries {
+		if n == 0 || e.hits < min {
+			victim, min = key, e.hits
+		}
+		if n++; n == responseCacheSample {
+			break
+		}
+	}
+	delete(c.entries, victim)
+}
+
+//stats returns the number of entries, hits and misses.
+func (c *responseCache) stats() (entries int, hits, misses uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.hits, c.misses
+}
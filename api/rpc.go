@@ -0,0 +1,309 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"net/http"
+	"time"
+)
+
+//JSON-RPC 2.0 error codes; the ones from -32000 on are ours.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcStorageError   = -32000
+	rpcNotFound       = -32001
+	rpcConflict       = -32002
+	rpcLocked         = -32003
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcMethod func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+//rpcMethods maps the methods of /rpc to their implementation. Params are
+//always an object of named arguments.
+func (srv *Server) rpcMethods() map[string]rpcMethod {
+	return map[string]rpcMethod{
+		"get":    srv.rpcGet,
+		"set":    srv.rpcSet,
+		"delete": srv.rpcDelete,
+		"incr":   srv.rpcIncr,
+		"rename": srv.rpcRename,
+		"keys":   srv.rpcKeys,
+	}
+}
+
+//HandleRPC serves JSON-RPC 2.0 calls and batches of them. Keys are plain JSON
+//strings, so they need no path escaping. Notifications (calls without an id)
+//are executed but not answered; a request with only notifications gets 204.
+func (srv *Server) HandleRPC() http.HandlerFunc {
+	methods := srv.rpcMethods()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := withLockToken(r.Context(), r.Header.Get(LockTokenHeader))
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeRPC(w, rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: rpcParseError, Message: "parse error"}, ID: json.RawMessage("null")})
+			return
+		}
+
+		trimmed := bytes.TrimSpace(body)
+		if len(trimmed) == 0 || trimmed[0] != '[' {
+			if resp, answer := srv.callRPC(ctx, methods, trimmed); answer {
+				writeRPC(w, resp)
+			} else {
+				w.WriteHeader(http.StatusNoContent)
+			}
+			return
+		}
+
+		var batch []json.RawMessage
+		if err := json.Unmarshal(trimmed, &batch); err != nil || len(batch) == 0 {
+			writeRPC(w, rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: rpcInvalidRequest, Message: "invalid request"}, ID: json.RawMessage("null")})
+			return
+		}
+		responses := make([]rpcResponse, 0, len(batch))
+		for _, call := range batch {
+			if resp, answer := srv.callRPC(ctx, methods, call); answer {
+				responses = append(responses, resp)
+			}
+		}
+		if len(responses) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeRPC(w, responses)
+	}
+}
+
+//writeRPC sends a response; JSON-RPC errors are reported in the body with 200.
+func writeRPC(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+//callRPC runs a single call and reports whether it must be answered.
+func (srv *Server) callRPC(ctx context.Context, methods map[string]rpcMethod, raw json.RawMessage) (rpcResponse, bool) {
+	resp := rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null")}
+	req := rpcRequest{}
+	if err := json.Unmarshal(raw, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = &rpcError{Code: rpcInvalidRequest, Message: "invalid request"}
+		return resp, true
+	}
+	notification := req.ID == nil
+	if !notification {
+		resp.ID = req.ID
+	}
+
+	method, ok := methods[req.Method]
+	if !ok {
+		resp.Error = &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method}
+		return resp, !notification
+	}
+	result, err := method(ctx, req.Params)
+	if err != nil {
+		resp.Error = toRPCError(err)
+	} else {
+		resp.Result = result
+	}
+	return resp, !notification
+}
+
+//toRPCError maps storage errors like the REST handlers map them to status codes.
+func toRPCError(err error) *rpcError {
+	var re *rpcError
+	var ve *storage.ValidationError
+	switch {
+	case errors.As(err, &re):
+		return re
+	case errors.As(err, &ve):
+		return &rpcError{Code: rpcStorageError, Message: err.Error(), Data: ve.Errors}
+	case errors.Is(err, storage.ErrNotFound):
+		return &rpcError{Code: rpcNotFound, Message: err.Error()}
+	case errors.Is(err, storage.ErrExists), errors.Is(err, storage.ErrVersionMismatch):
+		return &rpcError{Code: rpcConflict, Message: err.Error()}
+	case errors.Is(err, errKeyLocked):
+		return &rpcError{Code: rpcLocked, Message: err.Error()}
+	}
+	return &rpcError{Code: rpcStorageError, Message: err.Error()}
+}
+
+//rpcParams decodes params into v, rejecting unknown ones, and canonicalizes and validates keys.
+func (srv *Server) rpcParams(params json.RawMessage, v interface{}, keys ...*string) error {
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: "invalid params: " + err.Error()}
+	}
+	for _, key := range keys {
+		var err error
+		if *key, err = srv.checkKey(*key); err != nil {
+			return &rpcError{Code: rpcInvalidParams, Message: "invalid params: " + err.Error()}
+		}
+	}
+	return nil
+}
+
+func (srv *Server) rpcGet(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	p := struct {
+		Key string `json:"key"`
+	}{}
+	if err := srv.rpcParams(params, &p, &p.Key); err != nil {
+		return nil, err
+	}
+	item, err := srv.storage.GetOrLoad(ctx, p.Key)
+	var stale *storage.StaleError
+	if err != nil && !errors.As(err, &stale) {
+		return nil, err
+	}
+	if _, chunked := item.Object.(storage.ChunkedValue); chunked {
+		return nil, errors.New("chunked values can only be read through /items/{key}")
+	}
+	return itemResponse{item.Object, item.Version}, nil
+}
+
+//rpcSet stores value, a JSON string as a string like PUT /items/{key}/{value}
+//and any other JSON value as its text like /batch. ttl is a duration or "-1".
+func (srv *Server) rpcSet(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	p := struct {
+		Key       string          `json:"key"`
+		Value     json.RawMessage `json:"value"`
+		TTL       string          `json:"ttl"`
+		IfVersion uint64          `json:"if_version"`
+		IfAbsent  bool            `json:"if_absent"`
+	}{}
+	if err := srv.rpcParams(params, &p, &p.Key); err != nil {
+		return nil, err
+	}
+	if p.Value == nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid params: value is required"}
+	}
+	opts := writeOptions{ttl: storage.DefaultExpiration, ifVersion: p.IfVersion, ifAbsent: p.IfAbsent, lockToken: lockToken(ctx)}
+	if p.TTL == "-1" {
+		opts.ttl = storage.NoExpiration
+	} else if p.TTL != "" {
+		var err error
+		if opts.ttl, err = time.ParseDuration(p.TTL); err != nil || opts.ttl <= 0 {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid params: invalid ttl"}
+		}
+	}
+	version, err := srv.write(p.Key, rawValue(p.Value), opts)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]uint64{"version": version}, nil
+}
+
+func (srv *Server) rpcDelete(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	p := struct {
+		Key string `json:"key"`
+	}{}
+	if err := srv.rpcParams(params, &p, &p.Key); err != nil {
+		return nil, err
+	}
+	deleted, err := srv.storage.Guard(srv.lockGuard(lockToken(ctx))).Delete(p.Key)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]bool{"deleted": deleted}, nil
+}
+
+func (srv *Server) rpcIncr(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	p := struct {
+		Key     string `json:"key"`
+		By      *int64 `json:"by"`
+		Initial int64  `json:"initial"`
+	}{}
+	if err := srv.rpcParams(params, &p, &p.Key); err != nil {
+		return nil, err
+	}
+	by := int64(1)
+	if p.By != nil {
+		by = *p.By
+	}
+	n, err := srv.storage.Guard(srv.lockGuard(lockToken(ctx))).Incr(p.Key, by, p.Initial)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]int64{"value": n}, nil
+}
+
+func (srv *Server) rpcRename(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	p := struct {
+		Key       string `json:"key"`
+		To        string `json:"to"`
+		Overwrite bool   `json:"overwrite"`
+	}{}
+	if err := srv.rpcParams(params, &p, &p.Key, &p.To); err != nil {
+		return nil, err
+	}
+	version, err := srv.storage.Guard(srv.lockGuard(lockToken(ctx))).Rename(p.Key, p.To, p.Overwrite)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]uint64{"version": version}, nil
+}
+
+//rpcKeys lists up to limit (default 100, at most 1000) live keys with prefix
+//in order, starting after the key after.
+func (srv *Server) rpcKeys(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	p := struct {
+		Prefix string `json:"prefix"`
+		After  string `json:"after"`
+		Limit  int    `json:"limit"`
+	}{}
+	if err := srv.rpcParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Limit == 0 {
+		p.Limit = defaultListLimit
+	}
+	if p.Limit < 0 || p.Limit > maxListLimit {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid params: limit must be within [1, 1000]"}
+	}
+	sn, err := srv.storage.SnapshotContext(ctx, storage.PrefixFilter(p.Prefix))
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, key := range sn.Keys() {
+		if len(keys) == p.Limit {
+			break
+		}
+		if key > p.After {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//rpcReply is an rpcResponse with the result left undecoded.
+type rpcReply struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *rpcError       `json:"error"`
+	ID      json.RawMessage `json:"id"`
+}
+
+//newRPCServer returns a server and a function posting a body with the lock token to its /rpc.
+func newRPCServer(t *testing.T) (*Server, func(token, body string) (int, []byte)) {
+	srv := NewServer(storage.New(storage.DefaultExpiration, 0, 0))
+	srv.config = &Config{}
+	srv.configureRouter()
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return srv, func(token, body string) (int, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", ts.URL+"/rpc", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set(LockTokenHeader, token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, b
+	}
+}
+
+func TestRPC_Calls(t *testing.T) {
+	srv, post := newRPCServer(t)
+	db := srv.storage
+	db.Set("n", int64(1), storage.NoExpiration)
+
+	cases := []struct {
+		call   string
+		result string
+		code   int
+	}{
+		{`{"jsonrpc":"2.0","method":"set","params":{"key":"a","value":"x"},"id":1}`, `{"version":`, 0},
+		{`{"jsonrpc":"2.0","method":"get","params":{"key":"a"},"id":"s"}`, `{"value":"x","version":`, 0},
+		{`{"jsonrpc":"2.0","method":"set","params":{"key":"b","value":{"y":1},"ttl":"-1"},"id":1}`, `{"version":`, 0},
+		{`{"jsonrpc":"2.0","method":"get","params":{"key":"b"},"id":1}`, `{"value":"{\"y\":1}"`, 0},
+		{`{"jsonrpc":"2.0","method":"incr","params":{"key":"n","by":2},"id":1}`, `{"value":3}`, 0},
+		{`{"jsonrpc":"2.0","method":"rename","params":{"key":"a","to":"c"},"id":1}`, `{"version":`, 0},
+		{`{"jsonrpc":"2.0","method":"keys","params":{"after":"b"},"id":1}`, `["c","n"]`, 0},
+		{`{"jsonrpc":"2.0","method":"delete","params":{"key":"c"},"id":1}`, `{"deleted":true}`, 0},
+		{`{"jsonrpc":"2.0","method":"delete","params":{"key":"c"},"id":1}`, `{"deleted":false}`, 0},
+
+		{`{"jsonrpc":"2.0","method":"get","params":{"key":"c"},"id":1}`, "", rpcNotFound},
+		{`{"jsonrpc":"2.0","method":"set","params":{"key":"b","value":"z","if_absent":true},"id":1}`, "", rpcConflict},
+		{`{"jsonrpc":"2.0","method":"set","params":{"key":"b","value":"z","if_version":999999},"id":1}`, "", rpcConflict},
+		{`{"jsonrpc":"2.0","method":"set","params":{"key":"b"},"id":1}`, "", rpcInvalidParams},
+		{`{"jsonrpc":"2.0","method":"set","params":{"key":"b","value":"z","ttl":"soon"},"id":1}`, "", rpcInvalidParams},
+		{`{"jsonrpc":"2.0","method":"get","params":{"key":"b","other":1},"id":1}`, "", rpcInvalidParams},
+		{`{"jsonrpc":"2.0","method":"get","params":["b"],"id":1}`, "", rpcInvalidParams},
+		{`{"jsonrpc":"2.0","method":"get","params":{"key":""},"id":1}`, "", rpcInvalidParams},
+		{`{"jsonrpc":"2.0","method":"keys","params":{"limit":1001},"id":1}`, "", rpcInvalidParams},
+		{`{"jsonrpc":"2.0","method":"incr","params":{"key":"b"},"id":1}`, "", rpcStorageError},
+		{`{"jsonrpc":"2.0","method":"flush","id":1}`, "", rpcMethodNotFound},
+		{`{"jsonrpc":"1.0","method":"get","params":{"key":"b"},"id":1}`, "", rpcInvalidRequest},
+		{`{"jsonrpc":"2.0","id":1}`, "", rpcInvalidRequest},
+		{`"get"`, "", rpcInvalidRequest},
+		{`{"jsonrpc":"2.0","method":"get",`, "", rpcParseError},
+	}
+	for _, c := range cases {
+		status, body := post("", c.call)
+		reply := rpcReply{}
+		if err := json.Unmarshal(body, &reply); err != nil || status != http.StatusOK || reply.JSONRPC != "2.0" {
+			t.Errorf("%s: got %d %s", c.call, status, body)
+			continue
+		}
+		if c.code != 0 {
+			if reply.Error == nil || reply.Error.Code != c.code || reply.Result != nil {
+				t.Errorf("%s: expected error %d, got %s", c.call, c.code, body)
+			}
+			continue
+		}
+		if reply.Error != nil || !strings.HasPrefix(string(reply.Result), c.result) {
+			t.Errorf("%s: expected result %s, got %s", c.call, c.result, body)
+		}
+	}
+
+	//the id is echoed as is, and null when it can't be read
+	_, body := post("", `{"jsonrpc":"2.0","method":"get","params":{"key":"b"},"id":"req-7"}`)
+	if reply := (rpcReply{}); json.Unmarshal(body, &reply) != nil || string(reply.ID) != `"req-7"` {
+		t.Errorf("unexpected id: %s", body)
+	}
+	_, body = post("", `{"jsonrpc":"2.0","method":"get"`)
+	if reply := (rpcReply{}); json.Unmarshal(body, &reply) != nil || string(reply.ID) != "null" {
+		t.Errorf("unexpected id: %s", body)
+	}
+}
+
+func TestRPC_Notifications(t *testing.T) {
+	srv, post := newRPCServer(t)
+	db := srv.storage
+	status, body := post("", `{"jsonrpc":"2.0","method":"set","params":{"key":"a","value":"x"}}`)
+	if status != http.StatusNoContent || len(body) != 0 {
+		t.Errorf("notification was answered: %d %s", status, body)
+	}
+	if v, found := db.Get("a"); !found || v != "x" {
+		t.Errorf("notification wasn't executed: %v", v)
+	}
+	//failed notifications aren't answered either
+	if status, body = post("", `{"jsonrpc":"2.0","method":"flush"}`); status != http.StatusNoContent {
+		t.Errorf("failed notification was answered: %d %s", status, body)
+	}
+}
+
+func TestRPC_Batch(t *testing.T) {
+	srv, post := newRPCServer(t)
+	db := srv.storage
+	status, body := post("", `[
+		{"jsonrpc":"2.0","method":"set","params":{"key":"a","value":"x"},"id":1},
+		{"jsonrpc":"2.0","method":"set","params":{"key":"b","value":"y"}},
+		{"jsonrpc":"2.0","method":"get","params":{"key":"b"},"id":2},
+		{"jsonrpc":"2.0","method":"nope","id":3},
+		42,
+		{"jsonrpc":"2.0","method":"get","params":{"key":"c"},"id":4}
+	]`)
+	replies := []rpcReply{}
+	if err := json.Unmarshal(body, &replies); err != nil || status != http.StatusOK {
+		t.Fatalf("got %d %s", status, body)
+	}
+	want := []struct {
+		id   string
+		code int
+	}{{"1", 0}, {"2", 0}, {"3", rpcMethodNotFound}, {"null", rpcInvalidRequest}, {"4", rpcNotFound}}
+	if len(replies) != len(want) {
+		t.Fatalf("expected %d replies, got %s", len(want), body)
+	}
+	for i, w := range want {
+		r := replies[i]
+		code := 0
+		if r.Error != nil {
+			code = r.Error.Code
+		}
+		if string(r.ID) != w.id || code != w.code {
+			t.Errorf("reply %d: expected id %s and code %d, got %s", i, w.id, w.code, body)
+		}
+	}
+	if !strings.Contains(string(replies[1].Result), `"value":"y"`) {
+		t.Errorf("batch calls weren't applied in order: %s", replies[1].Result)
+	}
+	if _, found := db.Get("b"); !found {
+		t.Error("notification in a batch wasn't executed")
+	}
+
+	if status, _ = post("", `[{"jsonrpc":"2.0","method":"delete","params":{"key":"a"}}]`); status != http.StatusNoContent {
+		t.Errorf("batch of notifications returned %d", status)
+	}
+	for _, call := range []string{`[]`, `[1,`} {
+		status, body = post("", call)
+		reply := rpcReply{}
+		json.Unmarshal(body, &reply)
+		code := 0
+		if reply.Error != nil {
+			code = reply.Error.Code
+		}
+		if status != http.StatusOK || code != rpcInvalidRequest && code != rpcParseError {
+			t.Errorf("%s: got %d %s", call, status, body)
+		}
+	}
+}
+
+func TestRPC_Locked(t *testing.T) {
+	srv, post := newRPCServer(t)
+	srv.storage.Set("other", "v", storage.NoExpiration)
+	lock, err := srv.keyLocks.acquire("k", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := []string{
+		`{"jsonrpc":"2.0","method":"set","params":{"key":"k","value":"x"},"id":1}`,
+		`{"jsonrpc":"2.0","method":"delete","params":{"key":"k"},"id":1}`,
+		`{"jsonrpc":"2.0","method":"incr","params":{"key":"k"},"id":1}`,
+		`{"jsonrpc":"2.0","method":"rename","params":{"key":"other","to":"k","overwrite":true},"id":1}`,
+	}
+	for _, call := range calls {
+		_, body := post("", call)
+		reply := rpcReply{}
+		if json.Unmarshal(body, &reply) != nil || reply.Error == nil || reply.Error.Code != rpcLocked {
+			t.Errorf("%s without the token: %s", call, body)
+		}
+		_, body = post(lock.token, call)
+		if reply = (rpcReply{}); json.Unmarshal(body, &reply) != nil || reply.Error != nil {
+			t.Errorf("%s with the token: %s", call, body)
+		}
+	}
+}
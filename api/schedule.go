@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"time"
+)
+
+//schedulerInterval is how often due scheduled operations are executed.
+const schedulerInterval = time.Second
+
+func logScheduledRun(run storage.ScheduledRun) {
+	if run.Err != nil {
+		log.Printf("WARNING: scheduled %s of %s failed: %v", run.Op.Op, run.Op.Key, run.Err)
+	}
+}
+
+//HandleSchedule schedules {"op":"set","key":"k","value":"v","ttl":"1m"} or
+//{"op":"delete","key":"k"} for the RFC3339 time "at" or after the duration "in".
+func (srv *Server) HandleSchedule() http.HandlerFunc {
+	type request struct {
+		Op    string          `json:"op"`
+		Key   string          `json:"key"`
+		Value json.RawMessage `json:"value"`
+		TTL   string          `json:"ttl"`
+		At    time.Time       `json:"at"`
+		In    string          `json:"in"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := request{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid request body"))
+			return
+		}
+		var err error
+		if req.Key, err = srv.checkKey(req.Key); err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if srv.keysLocked(w, r, req.Key) {
+			return
+		}
+
+		op := storage.ScheduledOp{Op: req.Op, Key: req.Key, At: req.At, TTL: storage.DefaultExpiration}
+		if req.In != "" {
+			in, err := time.ParseDuration(req.In)
+			if err != nil || in < 0 || !req.At.IsZero() {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid in"))
+				return
+			}
+			op.At = time.Now().Add(in)
+		}
+		if op.At.IsZero() {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("at or in is required"))
+			return
+		}
+		if req.Op == "set" {
+			if req.Value == nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("value is required"))
+				return
+			}
+			op.Value = rawValue(req.Value)
+			if req.TTL == "-1" {
+				op.TTL = storage.NoExpiration
+			} else if req.TTL != "" {
+				var err error
+				if op.TTL, err = time.ParseDuration(req.TTL); err != nil || op.TTL <= 0 {
+					utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid ttl"))
+					return
+				}
+			}
+		}
+
+		op, err = srv.storage.Schedule(op)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusCreated, op)
+	}
+}
+
+func (srv *Server) HandleScheduled() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		utils.Respond(w, r, http.StatusOK, srv.storage.Scheduled())
+	}
+}
+
+func (srv *Server) HandleCancelScheduled() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !srv.storage.CancelScheduled(mux.Vars(r)["id"]) {
+			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("no such scheduled operation"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+)
+
+//writeError responds with 422 and the list of violations for validation errors,
+//507 for full namespaces (see namespaceFull), 423 for locked keys and with code
+//for everything else.
+func (srv *Server) writeError(w http.ResponseWriter, r *http.Request, key string, code int, err error) {
+	if errors.Is(err, errKeyLocked) {
+		lockedResponse(w, r, err)
+		return
+	}
+	if errors.Is(err, storage.ErrNamespaceFull) {
+		//writes of several keys name the one which didn't fit
+		var nf *storage.NamespaceFullError
+		if errors.As(err, &nf) {
+			key = nf.Key
+		}
+		srv.namespaceFull(w, r, key, err)
+		return
+	}
+	var ve *storage.ValidationError
+	if errors.As(err, &ve) {
+		utils.Respond(w, r, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":   err.Error(),
+			"details": ve.Errors,
+		})
+		return
+	}
+	utils.ErrorMessage(w, r, code, err)
+}
+
+func (srv *Server) HandleSetSchema() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns := mux.Vars(r)["namespace"]
+
+		raw, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		sc, err := storage.ParseSchema(raw)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		srv.storage.SetSchema(ns, sc)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (srv *Server) HandleGetSchema() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns := mux.Vars(r)["namespace"]
+
+		sc, ok := srv.storage.Schema(ns)
+		if !ok {
+			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("namespace has no schema"))
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, sc)
+	}
+}
+
+func (srv *Server) HandleDeleteSchema() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns := mux.Vars(r)["namespace"]
+
+		srv.storage.SetSchema(ns, nil)
+		w.WriteHeader(http.StatusOK)
+	}
+}
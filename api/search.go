@@ -0,0 +1,31 @@
+package api
+
+import (
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"net/http"
+	"strconv"
+)
+
+func (srv *Server) HandleSearch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		limit := 0
+		if v := q.Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid limit"))
+				return
+			}
+		}
+
+		res, err := srv.storage.Search(q.Get("q"), limit)
+		if err == storage.ErrSearchDisabled {
+			utils.ErrorMessage(w, r, http.StatusNotImplemented, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, res)
+	}
+}
@@ -1,46 +1,309 @@
 package api
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/client"
 	"github.com/bulbetski/kvstorage-srv/storage"
 	"github.com/bulbetski/kvstorage-srv/utils"
 	"github.com/gorilla/mux"
+	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
 type Server struct {
-	router  *mux.Router
-	storage *storage.Storage
-	config  *Config
+	router    *mux.Router
+	storage   *storage.Storage
+	config    *Config
+	keyPolicy storage.KeyPolicy
+	//stores are mounted under their own prefixes, see Config.Stores
+	stores []*Server
+	//faults is nil unless fault injection is enabled in config
+	faults      *faults
+	expiryHooks expiryHooks
+	persistence persistence
+	//warnAfter is how long saving may fail before writes get a warning
+	warnAfter time.Duration
+	startedAt time.Time
+	//recovery describes how the db file was loaded on start
+	recovery storage.Recovery
+	//dbLock keeps other instances from using the db file, see lockDB
+	dbLock *dbLock
+	//routeTimeouts are the deadlines of requests by route path template
+	routeTimeouts map[string]time.Duration
+	//limits is nil unless requests or connections are limited in config
+	limits *limits
+	//async is started by the first write with ?async=true, see asyncWrites
+	async     *asyncQueue
+	asyncOnce sync.Once
+	gets      coalescing
+	//responses is nil unless response_cache_size is set
+	responses *responseCache
+	mqtt      mqttStats
+	//shadow is nil unless changes are forwarded to a shadow target
+	shadow *shadow
+	quotas quotas
+	//keyLocks are the keys locked with POST /items/{key}/lock
+	keyLocks keyLocks
 }
 
-func NewServer(storage *storage.Storage) *Server {
+func NewServer(s *storage.Storage) *Server {
 	return &Server{
-		router:  mux.NewRouter(),
-		storage: storage,
+		router:    mux.NewRouter().UseEncodedPath(),
+		storage:   s,
+		keyPolicy: storage.DefaultKeyPolicy,
+		startedAt: time.Now(),
 	}
 }
 
+//Handler returns the API of s with the default settings, e.g. for httptest servers.
+func Handler(s *storage.Storage) http.Handler {
+	srv := NewServer(s)
+	srv.configureRouter()
+	return srv
+}
+
 func Start(config *Config) error {
-	db := storage.New(5*time.Minute, 10*time.Minute, config.DBSize)
-	if _, err := os.Stat(config.DBFileName); err == nil {
-		if err = db.LoadFile(config.DBFileName); err != nil {
+	if err := setupLogging(config.Log); err != nil {
+		return fmt.Errorf("log: %w", err)
+	}
+	srv, err := configure(config)
+	if err != nil {
+		return err
+	}
+	for _, sc := range config.Stores {
+		if err = srv.mount(config, sc); err != nil {
 			return err
 		}
 	}
+	srv.PersistDB(config.DBFileName)
+
+	if config.MaxConcurrentRequests > 0 || config.MaxConnections > 0 {
+		srv.limits = newLimits(config.MaxConcurrentRequests, config.MaxConnections)
+	}
+	listeners := config.Listeners
+	if len(listeners) == 0 {
+		listeners = []ListenerConfig{{Addr: config.BindAddr}}
+	}
+	return srv.serve(listeners)
+}
+
+//configure creates a storage with its server as described by config.
+func configure(config *Config) (*Server, error) {
+	defaultExpiration, err := time.ParseDuration(config.DefaultExpiration)
+	if err != nil {
+		return nil, err
+	}
+	cleanupInterval, err := time.ParseDuration(config.CleanupInterval)
+	if err != nil {
+		return nil, err
+	}
+	db := storage.New(defaultExpiration, cleanupInterval, config.DBSize)
+	snapshotMode, err := storage.ParseSnapshotMode(config.SnapshotMode)
+	if err != nil {
+		return nil, err
+	}
+	restoreMode, err := storage.ParseRestoreMode(config.RestoreMode)
+	if err != nil {
+		return nil, err
+	}
+	db.SetConsistency(snapshotMode, restoreMode)
+	keys, err := encryptionKeys(config)
+	if err != nil {
+		return nil, fmt.Errorf("loading encryption keys: %w", err)
+	}
+	if err = db.SetEncryption(keys); err != nil {
+		return nil, err
+	}
+	//before loading, so loaded items keep their checksums
+	db.SetItemChecksums(config.ItemChecksums)
+	recoveryMode, err := storage.ParseRecoveryMode(config.OnCorrupt)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := lockDB(config.DBFileName, config.Force)
+	if err != nil {
+		return nil, err
+	}
+	recovery, err := db.LoadFileRecover(config.DBFileName, recoveryMode)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w (set on_corrupt to start anyway)", config.DBFileName, err)
+	}
+	if recovery.Corrupt {
+		log.Printf("WARNING: %s is corrupt (%s), %s with %d items", config.DBFileName, recovery.Error, recovery.Action, recovery.Items)
+	}
+
+	if config.FullTextSearch {
+		db.EnableSearch()
+	}
+	db.SetShrinkThreshold(config.ShrinkThreshold)
+	db.SetCompression(config.CompressThreshold)
+	if err = db.SetNodeID(config.NodeID); err != nil {
+		return nil, err
+	}
+	if err = db.SetOverflow(config.OverflowDir); err != nil {
+		return nil, fmt.Errorf("overflow: %w", err)
+	}
+	db.TraceLockWaits(config.TraceLockWaits)
+	db.TrackKeyspace(config.KeyspaceMetrics)
+	if config.AccessStatsInterval != "" {
+		interval, err := time.ParseDuration(config.AccessStatsInterval)
+		if err != nil {
+			return nil, err
+		}
+		db.TrackAccess(interval, config.AccessStatsWindows, config.AccessStatsDepth)
+	}
+	if config.CoarseClock != "" {
+		resolution, err := time.ParseDuration(config.CoarseClock)
+		if err != nil {
+			return nil, err
+		}
+		db.UseCoarseClock(resolution)
+	}
+	if config.JanitorWarnAfter != "" {
+		after, err := time.ParseDuration(config.JanitorWarnAfter)
+		if err != nil {
+			return nil, err
+		}
+		db.OnJanitorRun(logSlowJanitorRuns(config.DBFileName, after))
+	}
+	for name, nc := range config.Namespaces {
+		opts, err := nc.options()
+		if err != nil {
+			return nil, fmt.Errorf("namespace %s: %w", name, err)
+		}
+		db.SetNamespaceOptions(name, opts)
+	}
+	hooks := make(map[string][]storage.WriteHook)
+	for _, hc := range config.WriteHooks {
+		h, err := hc.hook()
+		if err != nil {
+			return nil, fmt.Errorf("write hook of %s: %w", hc.Namespace, err)
+		}
+		hooks[hc.Namespace] = append(hooks[hc.Namespace], h)
+	}
+	for ns, hs := range hooks {
+		db.SetWriteHooks(ns, hs)
+	}
+	if config.Upstream != "" {
+		var ttl time.Duration
+		if config.UpstreamTTL != "" {
+			if ttl, err = time.ParseDuration(config.UpstreamTTL); err != nil {
+				return nil, err
+			}
+		}
+		db.SetLoader(upstreamLoader(client.New(config.Upstream)), ttl)
+		if config.UpstreamMaxStale != "" {
+			maxStale, err := time.ParseDuration(config.UpstreamMaxStale)
+			if err != nil {
+				return nil, err
+			}
+			db.SetServeStale(maxStale, config.UpstreamStaleSize)
+		}
+	}
 
 	srv := NewServer(db)
+	srv.recovery = recovery
+	srv.dbLock = lock
 	//config property is needed to save and load db from client requests (don't know where to put filePath property)
 	srv.config = config
+	srv.keyPolicy = storage.KeyPolicy{
+		MaxLength:   config.MaxKeyLength,
+		AllowBinary: config.AllowBinaryKeys,
+	}
+
+	//scheduled writes carry no lock token, so they wait for locked keys
+	db.SetScheduleGuard(func(op storage.ScheduledOp) error {
+		return srv.keyLocks.verify("", op.Key)
+	})
+	db.StartScheduler(schedulerInterval, logScheduledRun)
+	if config.Shadow != "" {
+		if err = srv.startShadow(config.Shadow, config.ShadowQueueSize, config.ShadowPercent, config.ShadowCompareReads); err != nil {
+			return nil, err
+		}
+	}
+
+	srv.routeTimeouts = make(map[string]time.Duration, len(config.RouteTimeouts))
+	for route, v := range config.RouteTimeouts {
+		if srv.routeTimeouts[route], err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("timeout of %s: %w", route, err)
+		}
+	}
+
+	if err = checkOverflow(config.AsyncOverflow); err != nil {
+		return nil, err
+	}
+	if config.ResponseCacheSize > 0 {
+		srv.responses = newResponseCache(config.ResponseCacheSize)
+	}
+
+	for _, h := range config.ExpiryHooks {
+		if _, err := srv.addExpiryHook(h); err != nil {
+			return nil, fmt.Errorf("expiry hook %s: %w", h.Pattern, err)
+		}
+	}
+
+	if srv.warnAfter, err = time.ParseDuration(config.PersistenceWarnAfter); err != nil {
+		return nil, err
+	}
+	if config.ShutdownSaveTimeout != "" {
+		if _, err = time.ParseDuration(config.ShutdownSaveTimeout); err != nil {
+			return nil, fmt.Errorf("shutdown_save_timeout: %w", err)
+		}
+	}
+	if len(config.Save) > 0 {
+		rules := make([]storage.SaveRule, 0, len(config.Save))
+		for _, line := range config.Save {
+			rule, err := storage.ParseSaveRule(line)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+		}
+		go srv.runSaveRules(rules)
+	}
+	if config.ForecastInterval != "" {
+		interval, err := time.ParseDuration(config.ForecastInterval)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("forecast_interval: invalid duration %q", config.ForecastInterval)
+		}
+		db.TrackGrowth(config.ForecastSamples)
+		go srv.runGrowthSamples(interval)
+	}
+	if config.DeltaInterval != "" {
+		interval, err := time.ParseDuration(config.DeltaInterval)
+		if err != nil {
+			return nil, fmt.Errorf("delta_interval: %w", err)
+		}
+		db.EnableDeltas()
+		go srv.runDeltas(interval, config.MaxDeltas)
+	}
 
 	srv.configureRouter()
-	srv.PersistDB(config.DBFileName)
+	return srv, nil
+}
 
-	return http.ListenAndServe(config.BindAddr, srv)
+//mount serves an independent storage described by sc under its prefix.
+func (srv *Server) mount(config *Config, sc StoreConfig) error {
+	prefix := strings.TrimRight(sc.Prefix, "/")
+	if !strings.HasPrefix(prefix, "/") || sc.DBFileName == "" {
+		return fmt.Errorf("store %q: prefix starting with / and file_name are required", sc.Prefix)
+	}
+	sub, err := configure(config.forStore(sc))
+	if err != nil {
+		return fmt.Errorf("store %s: %w", prefix, err)
+	}
+	srv.router.PathPrefix(prefix + "/").Handler(http.StripPrefix(prefix, sub))
+	srv.stores = append(srv.stores, sub)
+	return nil
 }
 
 func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -48,27 +311,120 @@ func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (srv *Server) configureRouter() {
+	srv.router.Use(srv.decodeVars)
+	srv.router.Use(srv.routeTimeout)
+	srv.router.Use(srv.persistenceWarning)
+	if srv.config != nil && srv.config.FaultInjection {
+		srv.faults = &faults{}
+		srv.router.Use(srv.injectFaults)
+		srv.router.HandleFunc("/admin/faults", srv.HandleGetFaults()).Methods("GET")
+		srv.router.HandleFunc("/admin/faults", srv.HandleSetFaults()).Methods("PUT")
+	}
+	if srv.config != nil && srv.config.HistoryChecks {
+		srv.router.HandleFunc("/admin/history", srv.HandleHistory()).Methods("GET")
+		srv.router.HandleFunc("/admin/history", srv.HandleSetHistory()).Methods("PUT")
+		srv.router.HandleFunc("/admin/history/check", srv.HandleCheckHistory()).Methods("GET")
+	}
 	srv.router.HandleFunc("/items/{key}/{value}", srv.HandleSet()).Methods("PUT")
+	srv.router.HandleFunc("/items/{key}", srv.HandleSetBody()).Methods("PUT")
 	srv.router.HandleFunc("/items/{key}", srv.HandleGet()).Methods("GET")
+	srv.router.HandleFunc("/items/{key}/json", srv.HandleJSONGet()).Methods("GET")
+	srv.router.HandleFunc("/items/{key}/json", srv.HandleJSONSet()).Methods("PATCH")
+	srv.router.HandleFunc("/items/{key}", srv.HandlePatch()).Methods("PATCH")
+	srv.router.HandleFunc("/items/{key}/incr", srv.HandleIncr()).Methods("POST")
+	srv.router.HandleFunc("/items/{key}/rename", srv.HandleRename()).Methods("POST")
+	srv.router.HandleFunc("/items/{key}/copy", srv.HandleCopy()).Methods("POST")
+	srv.router.HandleFunc("/items/{key}/lock", srv.HandleLock()).Methods("POST")
+	srv.router.HandleFunc("/items/{key}/lock", srv.HandleUnlock()).Methods("DELETE")
 	srv.router.HandleFunc("/items/", srv.HandleItems()).Methods("GET")
+	srv.router.HandleFunc("/items/delete", srv.HandleDeleteVersions()).Methods("POST")
 	srv.router.HandleFunc("/items/{key}", srv.HandleDelete()).Methods("DELETE")
 	srv.router.HandleFunc("/saveItems", srv.HandleSave()).Methods("GET")
 	srv.router.HandleFunc("/loadItems", srv.HandleLoad()).Methods("GET")
+	srv.router.HandleFunc("/counters/{key}/incr", srv.HandleIncrWindow()).Methods("POST")
+	srv.router.HandleFunc("/counters/{key}", srv.HandleWindowRange()).Methods("GET")
+	srv.router.HandleFunc("/streams/{key}", srv.HandleXAdd()).Methods("POST")
+	srv.router.HandleFunc("/streams/{key}", srv.HandleXRange()).Methods("GET")
+	srv.router.HandleFunc("/streams/{key}/len", srv.HandleXLen()).Methods("GET")
+	srv.router.HandleFunc("/streams/{key}/trim", srv.HandleXTrim()).Methods("POST")
+	srv.router.HandleFunc("/queues/{key}", srv.HandleQPush()).Methods("POST")
+	srv.router.HandleFunc("/queues/{key}", srv.HandleQStats()).Methods("GET")
+	srv.router.HandleFunc("/queues/{key}/receive", srv.HandleQReceive()).Methods("POST")
+	srv.router.HandleFunc("/queues/{key}/ack", srv.HandleQAck()).Methods("POST")
+	srv.router.HandleFunc("/timeseries/{key}", srv.HandleTSAdd()).Methods("POST")
+	srv.router.HandleFunc("/timeseries/{key}", srv.HandleTSRange()).Methods("GET")
+	srv.router.HandleFunc("/ns/{namespace}/schema", srv.HandleSetSchema()).Methods("PUT")
+	srv.router.HandleFunc("/ns/{namespace}/schema", srv.HandleGetSchema()).Methods("GET")
+	srv.router.HandleFunc("/ns/{namespace}/schema", srv.HandleDeleteSchema()).Methods("DELETE")
+	srv.router.HandleFunc("/ns/{namespace}/indexes", srv.HandleIndexes()).Methods("GET")
+	srv.router.HandleFunc("/ns/{namespace}/indexes/{field}", srv.HandleCreateIndex()).Methods("PUT")
+	srv.router.HandleFunc("/ns/{namespace}/indexes/{field}", srv.HandleDropIndex()).Methods("DELETE")
+	srv.router.HandleFunc("/ns/{namespace}/query", srv.HandleQuery()).Methods("GET")
+	srv.router.HandleFunc("/ns/{namespace}/sliding", srv.HandleNamespaceSliding()).Methods("PUT")
+	srv.router.HandleFunc("/sessions", srv.HandleCreateSession()).Methods("POST")
+	srv.router.HandleFunc("/sessions/{token}", srv.HandleGetSession()).Methods("GET")
+	srv.router.HandleFunc("/sessions/{token}", srv.HandleRevokeSession()).Methods("DELETE")
+	srv.router.HandleFunc("/scheduled", srv.HandleSchedule()).Methods("POST")
+	srv.router.HandleFunc("/scheduled", srv.HandleScheduled()).Methods("GET")
+	srv.router.HandleFunc("/scheduled/{id}", srv.HandleCancelScheduled()).Methods("DELETE")
+	srv.router.HandleFunc("/search", srv.HandleSearch()).Methods("GET")
+	srv.router.HandleFunc("/batch", srv.HandleBatch()).Methods("POST")
+	srv.router.HandleFunc("/mget-ns", srv.HandleMGetNamespaces()).Methods("POST")
+	srv.router.HandleFunc("/rotate", srv.HandleRotate()).Methods("POST")
+	srv.router.HandleFunc("/ids/next", srv.HandleNextID()).Methods("GET")
+	srv.router.HandleFunc("/graphql", srv.HandleGraphQL()).Methods("GET", "POST")
+	srv.router.HandleFunc("/graphql/schema", srv.HandleGraphQLSchema()).Methods("GET")
+	srv.router.HandleFunc("/rpc", srv.HandleRPC()).Methods("POST")
+	srv.router.HandleFunc("/admin/stats", srv.HandleStats()).Methods("GET")
+	srv.router.HandleFunc("/metrics", srv.HandleMetrics()).Methods("GET")
+	srv.router.HandleFunc("/admin/info", srv.HandleInfo()).Methods("GET")
+	srv.router.HandleFunc("/admin/expirations", srv.HandleExpirations()).Methods("GET")
+	srv.router.HandleFunc("/admin/access", srv.HandleAccess()).Methods("GET")
+	srv.router.HandleFunc("/admin/forecast", srv.HandleForecast()).Methods("GET")
+	srv.router.HandleFunc("/admin/debug/key/{key}", srv.HandleDebugKey()).Methods("GET")
+	srv.router.HandleFunc("/admin/janitor", srv.HandleJanitor()).Methods("GET")
+	srv.router.HandleFunc("/admin/janitor/pause", srv.HandlePauseJanitor()).Methods("POST")
+	srv.router.HandleFunc("/admin/janitor/resume", srv.HandleResumeJanitor()).Methods("POST")
+	srv.router.HandleFunc("/admin/janitor/run", srv.HandleRunJanitor()).Methods("POST")
+	srv.router.HandleFunc("/admin/flush", srv.HandleFlush()).Methods("POST")
+	srv.router.HandleFunc("/admin/compact", srv.HandleCompact()).Methods("POST")
+	srv.router.HandleFunc("/admin/shadow", srv.HandleShadow()).Methods("GET")
+	srv.router.HandleFunc("/admin/persistence", srv.HandlePersistence()).Methods("GET")
+	srv.router.HandleFunc("/admin/export", srv.HandleExport()).Methods("GET")
+	srv.router.HandleFunc("/admin/snapshot", srv.HandleSnapshot()).Methods("GET")
+	srv.router.HandleFunc("/admin/import", srv.HandleImport()).Methods("POST")
+	srv.router.HandleFunc("/admin/encryption", srv.HandleEncryption()).Methods("GET")
+	srv.router.HandleFunc("/admin/encryption/rotate", srv.HandleRotateEncryption()).Methods("POST")
+	srv.router.HandleFunc("/admin/import/rdb", srv.HandleImportRDB()).Methods("POST")
+	srv.router.HandleFunc("/admin/migrate", srv.HandleMigrate()).Methods("POST")
+	srv.router.HandleFunc("/admin/warmup", srv.HandleWarmup()).Methods("POST")
+	srv.router.HandleFunc("/admin/expiry-hooks", srv.HandleExpiryHooks()).Methods("GET")
+	srv.router.HandleFunc("/admin/expiry-hooks", srv.HandleAddExpiryHook()).Methods("POST")
+	srv.router.HandleFunc("/admin/expiry-hooks/{id}", srv.HandleDeleteExpiryHook()).Methods("DELETE")
+	srv.router.HandleFunc("/admin/dead-letters", srv.HandleDeadLetters()).Methods("GET")
+	srv.router.HandleFunc("/admin/dead-letters/replay", srv.HandleReplayDeadLetters()).Methods("POST")
+	srv.router.HandleFunc("/admin/dead-letters/{id}/replay", srv.HandleReplayDeadLetter()).Methods("POST")
+	srv.router.HandleFunc("/admin/dead-letters/{id}", srv.HandleDeleteDeadLetter()).Methods("DELETE")
 }
 
+//PersistDB exits on SIGINT or SIGTERM after the final save described by the config,
+//see shutdown for the exit codes.
 func (srv *Server) PersistDB(filename string) {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-sigs
-		srv.storage.SaveFile(filename)
-		os.Exit(0)
+		code := srv.shutdown(filename)
+		srv.dbLock.release()
+		for _, store := range srv.stores {
+			store.dbLock.release()
+		}
+		os.Exit(code)
 	}()
 }
 
 //TODO:
 // check if value from path maps correctly
-// add expiration time parameter to path
 
 func (srv *Server) HandleSet() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -76,26 +432,133 @@ func (srv *Server) HandleSet() http.HandlerFunc {
 		key := vars["key"]
 		value := vars["value"]
 
-		srv.storage.Set(key, value, storage.DefaultExpiration)
-		w.WriteHeader(http.StatusOK)
+		opts, err := srv.parseWriteOptions(r)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if isAsync(r) {
+			srv.acceptAsync(w, r, asyncWrite{key: key, value: value, opts: opts})
+			return
+		}
+		version, err := srv.write(key, value, opts)
+		if err != nil {
+			srv.writeFailed(w, r, key, err)
+			return
+		}
+		written(w, version)
 	}
 }
 
-func (srv *Server) HandleGet() http.HandlerFunc {
-	type response struct {
-		Value interface{} `json:"value"`
-	}
+//maxGetWait bounds ?wait of GET /items/{key}.
+const maxGetWait = 5 * time.Minute
 
+//HandleGet shares lookups and encoded responses between concurrent requests
+//of the same key, see getEncoded, and takes responses of hot keys from the
+//response cache if enabled.
+//
+//With ?wait=30s it long-polls: it waits up to that long for the key to exist,
+//or with ?version=N for it to hold another version, and answers 304 Not
+//Modified if it still holds version N.
+func (srv *Server) HandleGet() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		key := vars["key"]
+		//as converts the value to int, float, bool or string
+		as := r.URL.Query().Get("as")
+		switch as {
+		case "", "int", "float", "bool", "string":
+		default:
+			utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("invalid as: %s", as))
+			return
+		}
+		//raw returns every value without the JSON envelope, like values written with a content type
+		raw := r.URL.Query().Get("raw") == "true"
+		var version uint64
+		if v := r.URL.Query().Get("version"); v != "" {
+			var err error
+			if version, err = strconv.ParseUint(v, 10, 64); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid version"))
+				return
+			}
+		}
+		if v := r.URL.Query().Get("wait"); v != "" {
+			wait, err := time.ParseDuration(v)
+			if err != nil || wait <= 0 || wait > maxGetWait {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("wait must be a duration up to %v", maxGetWait))
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), wait)
+			srv.storage.WaitItem(ctx, key, version)
+			cancel()
+		}
 
-		val, found := srv.storage.Get(key)
-		if !found {
+		start := time.Now()
+		item, body, err := srv.getEncoded(r.Context(), key)
+		if sh := srv.shadow; sh != nil {
+			switch {
+			case err == nil:
+				sh.mirrorRead(key, &item, time.Since(start))
+			case errors.Is(err, storage.ErrNotFound):
+				sh.mirrorRead(key, nil, time.Since(start))
+			}
+		}
+		if errors.Is(err, storage.ErrNotFound) {
 			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("no such key"))
 			return
 		}
-		utils.Respond(w, r, http.StatusOK, response{val})
+		//the upstream failed, but the last known value is recent enough
+		var stale *storage.StaleError
+		if errors.As(err, &stale) {
+			setStaleWarning(w)
+			err = nil
+		}
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadGateway, err)
+			return
+		}
+		w.Header().Set("ETag", strconv.Quote(strconv.FormatUint(item.Version, 10)))
+		if version != 0 && item.Version == version {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if as != "" {
+			v, err := storage.Coerce(item.Object, as)
+			if err != nil {
+				utils.ErrorMessage(w, r, http.StatusUnprocessableEntity, err)
+				return
+			}
+			utils.Respond(w, r, http.StatusOK, itemResponse{v, item.Version})
+			return
+		}
+		if body != nil && !raw {
+			utils.RespondEncoded(w, r, http.StatusOK, body)
+			return
+		}
+		//values written with a content type are returned as they were written
+		switch val := item.Object.(type) {
+		case storage.ChunkedValue:
+			contentType := item.ContentType
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			serveValue(w, r, contentType, val.Reader())
+			return
+		case string:
+			if item.ContentType != "" {
+				serveValue(w, r, item.ContentType, strings.NewReader(val))
+				return
+			}
+			if raw {
+				serveValue(w, r, "text/plain; charset=utf-8", strings.NewReader(val))
+				return
+			}
+		}
+		if raw {
+			utils.Respond(w, r, http.StatusOK, item.Object)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, itemResponse{item.Object, item.Version})
 	}
 }
 
@@ -104,7 +567,15 @@ func (srv *Server) HandleDelete() http.HandlerFunc {
 		vars := mux.Vars(r)
 		key := vars["key"]
 
-		deleted := srv.storage.Delete(key)
+		if isAsync(r) {
+			srv.acceptAsync(w, r, asyncWrite{key: key, del: true})
+			return
+		}
+		deleted, err := srv.guarded(r).Delete(key)
+		if err != nil {
+			lockedResponse(w, r, err)
+			return
+		}
 		if !deleted {
 			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("no such key"))
 			return
@@ -115,14 +586,18 @@ func (srv *Server) HandleDelete() http.HandlerFunc {
 
 func (srv *Server) HandleItems() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		m := srv.storage.Items()
+		m, err := srv.storage.ItemsContext(r.Context())
+		if err != nil {
+			aborted(w, r, err)
+			return
+		}
 		utils.Respond(w, r, http.StatusOK, m)
 	}
 }
 
 func (srv *Server) HandleSave() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := srv.storage.SaveFile(srv.config.DBFileName); err != nil {
+		if err := srv.saveFile(srv.config.DBFileName); err != nil {
 			utils.ErrorMessage(w, r, http.StatusInternalServerError, errors.New("couldn't save db"))
 			return
 		}
@@ -133,8 +608,27 @@ func (srv *Server) HandleSave() http.HandlerFunc {
 
 func (srv *Server) HandleLoad() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun, sample, err := parseDryRun(r)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
 		if _, err := os.Stat(srv.config.DBFileName); err == nil {
-			if err = srv.storage.LoadFile(srv.config.DBFileName); err != nil {
+			if dryRun {
+				d, err := srv.storage.LoadFileDryRun(srv.config.DBFileName, keyFilter(r), sample)
+				if err != nil {
+					utils.ErrorMessage(w, r, http.StatusInternalServerError, errors.New("couldn't read db"))
+					return
+				}
+				utils.Respond(w, r, http.StatusOK, d)
+				return
+			}
+			if filter := keyFilter(r); filter != nil {
+				_, err = srv.storage.LoadFileFilter(srv.config.DBFileName, filter)
+			} else {
+				err = srv.storage.LoadFile(srv.config.DBFileName)
+			}
+			if err != nil {
 				utils.ErrorMessage(w, r, http.StatusInternalServerError, errors.New("couldn't load db"))
 				return
 			}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+)
+
+type sessionResponse struct {
+	Token     string          `json:"token"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	ExpiresAt time.Time       `json:"expires_at"`
+	Sliding   bool            `json:"sliding"`
+}
+
+//newSessionResponse returns the data of the session as JSON, which is how
+//HandleCreateSession stores it.
+func newSessionResponse(s storage.Session) sessionResponse {
+	resp := sessionResponse{Token: s.Token, ExpiresAt: s.ExpiresAt, Sliding: s.Sliding}
+	if data, ok := s.Data.(string); ok && data != "" {
+		resp.Data = json.RawMessage(data)
+	}
+	return resp
+}
+
+//HandleCreateSession stores the JSON data of the body under a new random token.
+//ttl is a duration like "30m", storage.DefaultSessionTTL if it is empty.
+func (srv *Server) HandleCreateSession() http.HandlerFunc {
+	type request struct {
+		Data    json.RawMessage `json:"data"`
+		TTL     string          `json:"ttl"`
+		Sliding bool            `json:"sliding"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := request{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid request body"))
+			return
+		}
+		var ttl time.Duration
+		if req.TTL != "" {
+			var err error
+			if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid ttl"))
+				return
+			}
+		}
+
+		s, err := srv.storage.CreateSession(string(req.Data), ttl, req.Sliding)
+		if err != nil {
+			srv.writeError(w, r, storage.SessionNamespace+storage.NamespaceSeparator, http.StatusUnprocessableEntity, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusCreated, newSessionResponse(s))
+	}
+}
+
+//HandleGetSession returns the session of a valid token and slides its expiration
+//unless ?slide=false, which allows checking a session without extending it.
+func (srv *Server) HandleGetSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := mux.Vars(r)["token"]
+
+		s, ok := srv.storage.Session(token, r.URL.Query().Get("slide") != "false")
+		if !ok {
+			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("no such session"))
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, newSessionResponse(s))
+	}
+}
+
+func (srv *Server) HandleRevokeSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := mux.Vars(r)["token"]
+
+		if !srv.storage.RevokeSession(token) {
+			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("no such session"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
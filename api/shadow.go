@@ -0,0 +1,503 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/client"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"hash/crc32"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Shadowing forwards every change of the storage to a secondary target, so that
+//a new server can be validated with production traffic before a cutover. The
+//target is another kvstorage-srv ("http://host:port") or Redis
+//("redis://[:password@]host:port[/db]"). Changes are queued and forwarded one
+//at a time in order; when the queue is full they are dropped and counted.
+//Values which aren't strings are sent as their JSON text.
+//
+//To try the target with part of the traffic, only the keys whose hash falls
+//in a percentage are shadowed; a key is always shadowed or never, so the
+//target holds a consistent subset of the keys. Reads of shadowed keys can be
+//mirrored too: the value served is compared with the target's and mismatches
+//and the latency of both are reported. Comparisons go through the queue after
+//the changes read before them, but a change made while a read is being served
+//can still show up as a mismatch, so a few of them are expected under writes.
+
+const (
+	defaultShadowQueueSize = 10000
+	shadowTimeout          = 5 * time.Second
+	//maxShadowMismatches is how many of the last mismatches are reported
+	maxShadowMismatches = 20
+	//maxMismatchValue is how much of the values of a mismatch is reported
+	maxMismatchValue = 256
+)
+
+var errShadowNotFound = errors.New("no such key")
+
+//shadowTarget.get fails with errShadowNotFound for missing keys.
+type shadowTarget interface {
+	set(ctx context.Context, key, value string, ttl time.Duration) error
+	delete(ctx context.Context, key string) error
+	get(ctx context.Context, key string) (string, error)
+}
+
+//shadowOp is a change, or a read to compare if compare is set: item is then
+//the value served, nil if the key wasn't found, and latency how long it took.
+type shadowOp struct {
+	key     string
+	item    *storage.Item
+	at      time.Time
+	compare bool
+	latency time.Duration
+}
+
+//ShadowMismatch is a read whose value differs on the shadow target.
+type ShadowMismatch struct {
+	Key         string    `json:"key"`
+	Value       *string   `json:"value"`
+	ShadowValue *string   `json:"shadow_value"`
+	At          time.Time `json:"at"`
+}
+
+//shadow holds the queue and the counters, which are accessed atomically.
+type shadow struct {
+	target string
+	to     shadowTarget
+	ops    chan shadowOp
+	//percent of the keys are shadowed, see sampled
+	percent      int
+	compareReads bool
+	forwarded    uint64
+	failed       uint64
+	dropped      uint64
+	//lag is how long the last forwarded change waited, in nanoseconds
+	lag int64
+	//compared reads took primaryTime and shadowTime in total, in nanoseconds
+	compared    uint64
+	mismatched  uint64
+	skipped     uint64
+	primaryTime int64
+	shadowTime  int64
+	mu          sync.Mutex
+	lastErr     string
+	mismatches  []ShadowMismatch
+}
+
+//ShadowStats are served by /admin/shadow.
+type ShadowStats struct {
+	Target     string `json:"target"`
+	QueueDepth int    `json:"queue_depth"`
+	Forwarded  uint64 `json:"forwarded"`
+	Failed     uint64 `json:"failed"`
+	Dropped    uint64 `json:"dropped"`
+	Lag        string `json:"lag"`
+	LastError  string `json:"last_error,omitempty"`
+	Percent    int    `json:"percent"`
+	//Compared reads, of which Mismatched had another value on the target, and
+	//their mean latency here and on the target; Skipped reads weren't compared
+	//because the queue was busy
+	Compared         uint64           `json:"compared"`
+	Mismatched       uint64           `json:"mismatched"`
+	Skipped          uint64           `json:"skipped"`
+	PrimaryLatency   string           `json:"primary_latency,omitempty"`
+	ShadowLatency    string           `json:"shadow_latency,omitempty"`
+	RecentMismatches []ShadowMismatch `json:"recent_mismatches,omitempty"`
+}
+
+func newShadowTarget(target string) (shadowTarget, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shadow target: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return kvShadow{client.New(target)}, nil
+	case "redis":
+		t := &redisShadow{addr: u.Host}
+		if p, ok := u.User.Password(); ok {
+			t.password = p
+		}
+		if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+			if t.db, err = strconv.Atoi(db); err != nil {
+				return nil, fmt.Errorf("invalid redis db %s", db)
+			}
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("unsupported shadow target %s", target)
+}
+
+//startShadow forwards the changes of percent (all if 0) of the keys of the
+//storage to target from now on, and compares their reads if compareReads is set.
+func (srv *Server) startShadow(target string, queueSize, percent int, compareReads bool) error {
+	to, err := newShadowTarget(target)
+	if err != nil {
+		return err
+	}
+	if queueSize <= 0 {
+		queueSize = defaultShadowQueueSize
+	}
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("invalid shadow percent %d", percent)
+	}
+	if percent == 0 {
+		percent = 100
+	}
+	//the target isn't reported with its password
+	if u, err := url.Parse(target); err == nil {
+		target = u.Redacted()
+	}
+	sh := &shadow{target: target, to: to, ops: make(chan shadowOp, queueSize), percent: percent, compareReads: compareReads}
+	//called with the storage lock held, so it never blocks
+	srv.storage.NotifyChanged("*", func(key string, item *storage.Item) {
+		if !sh.sampled(key) {
+			return
+		}
+		select {
+		case sh.ops <- shadowOp{key: key, item: item, at: time.Now()}:
+		default:
+			atomic.AddUint64(&sh.dropped, 1)
+		}
+	})
+	srv.shadow = sh
+	go sh.run()
+	return nil
+}
+
+//sampled tells whether key is shadowed.
+func (sh *shadow) sampled(key string) bool {
+	return sh.percent >= 100 || int(crc32.ChecksumIEEE([]byte(key))%100) < sh.percent
+}
+
+//mirrorRead queues the comparison of a read of key which served item, nil if
+//the key wasn't found, in latency. Comparisons only take the first half of
+//the queue, so that reads never crowd out the changes, and are skipped rather
+//than waited for.
+func (sh *shadow) mirrorRead(key string, item *storage.Item, latency time.Duration) {
+	if !sh.compareReads || !sh.sampled(key) {
+		return
+	}
+	if len(sh.ops) >= cap(sh.ops)/2 {
+		atomic.AddUint64(&sh.skipped, 1)
+		return
+	}
+	select {
+	case sh.ops <- shadowOp{key: key, item: item, at: time.Now(), compare: true, latency: latency}:
+	default:
+		atomic.AddUint64(&sh.skipped, 1)
+	}
+}
+
+func (sh *shadow) run() {
+	for op := range sh.ops {
+		ctx, cancel := context.WithTimeout(context.Background(), shadowTimeout)
+		if op.compare {
+			err := sh.compare(ctx, op)
+			cancel()
+			if err != nil {
+				sh.mu.Lock()
+				sh.lastErr = err.Error()
+				sh.mu.Unlock()
+			}
+			continue
+		}
+		err := sh.forward(ctx, op)
+		cancel()
+		atomic.StoreInt64(&sh.lag, int64(time.Since(op.at)))
+		if err != nil {
+			if atomic.AddUint64(&sh.failed, 1) == 1 {
+				log.Printf("WARNING: shadowing to %s failed: %v", sh.target, err)
+			}
+			sh.mu.Lock()
+			sh.lastErr = err.Error()
+			sh.mu.Unlock()
+			continue
+		}
+		atomic.AddUint64(&sh.forwarded, 1)
+	}
+}
+
+func (sh *shadow) forward(ctx context.Context, op shadowOp) error {
+	if op.item == nil {
+		return sh.to.delete(ctx, op.key)
+	}
+	ttl := storage.NoExpiration
+	if at := op.item.ExpiresAt(); !at.IsZero() {
+		if ttl = time.Until(at); ttl <= 0 {
+			//expired while queued
+			return nil
+		}
+	}
+	value, err := shadowValue(op.item)
+	if err != nil {
+		return err
+	}
+	return sh.to.set(ctx, op.key, value, ttl)
+}
+
+//shadowValue is the value of item as it is sent to the target.
+func shadowValue(item *storage.Item) (string, error) {
+	switch v := item.Object.(type) {
+	case string:
+		return v, nil
+	case storage.ChunkedValue:
+		b, err := io.ReadAll(v.Reader())
+		return string(b), err
+	}
+	b, err := json.Marshal(item.Object)
+	return string(b), err
+}
+
+//compare reads op.key from the target and records a mismatch if its value
+//differs from the one served.
+func (sh *shadow) compare(ctx context.Context, op shadowOp) error {
+	var want *string
+	if op.item != nil {
+		v, err := shadowValue(op.item)
+		if err != nil {
+			return err
+		}
+		want = &v
+	}
+	start := time.Now()
+	v, err := sh.to.get(ctx, op.key)
+	latency := time.Since(start)
+	var got *string
+	switch {
+	case err == nil:
+		got = &v
+	case !errors.Is(err, errShadowNotFound):
+		return err
+	}
+
+	atomic.AddUint64(&sh.compared, 1)
+	atomic.AddInt64(&sh.primaryTime, int64(op.latency))
+	atomic.AddInt64(&sh.shadowTime, int64(latency))
+	if (want == nil) == (got == nil) && (want == nil || *want == *got) {
+		return nil
+	}
+	if atomic.AddUint64(&sh.mismatched, 1) == 1 {
+		log.Printf("WARNING: shadow target %s has another value of %s", sh.target, op.key)
+	}
+	m := ShadowMismatch{Key: op.key, Value: truncateMismatch(want), ShadowValue: truncateMismatch(got), At: op.at}
+	sh.mu.Lock()
+	sh.mismatches = append(sh.mismatches, m)
+	if len(sh.mismatches) > maxShadowMismatches {
+		sh.mismatches = append(sh.mismatches[:0], sh.mismatches[1:]...)
+	}
+	sh.mu.Unlock()
+	return nil
+}
+
+func truncateMismatch(v *string) *string {
+	if v == nil || len(*v) <= maxMismatchValue {
+		return v
+	}
+	s := (*v)[:maxMismatchValue] + "..."
+	return &s
+}
+
+func (sh *shadow) stats() ShadowStats {
+	sh.mu.Lock()
+	lastErr := sh.lastErr
+	mismatches := append([]ShadowMismatch(nil), sh.mismatches...)
+	sh.mu.Unlock()
+	st := ShadowStats{
+		Target:           sh.target,
+		QueueDepth:       len(sh.ops),
+		Forwarded:        atomic.LoadUint64(&sh.forwarded),
+		Failed:           atomic.LoadUint64(&sh.failed),
+		Dropped:          atomic.LoadUint64(&sh.dropped),
+		Lag:              time.Duration(atomic.LoadInt64(&sh.lag)).String(),
+		LastError:        lastErr,
+		Percent:          sh.percent,
+		Compared:         atomic.LoadUint64(&sh.compared),
+		Mismatched:       atomic.LoadUint64(&sh.mismatched),
+		Skipped:          atomic.LoadUint64(&sh.skipped),
+		RecentMismatches: mismatches,
+	}
+	if st.Compared > 0 {
+		st.PrimaryLatency = (time.Duration(atomic.LoadInt64(&sh.primaryTime)) / time.Duration(st.Compared)).String()
+		st.ShadowLatency = (time.Duration(atomic.LoadInt64(&sh.shadowTime)) / time.Duration(st.Compared)).String()
+	}
+	return st
+}
+
+//HandleShadow reports how forwarding changes to the shadow target goes.
+func (srv *Server) HandleShadow() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if srv.shadow == nil {
+			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("shadowing is disabled"))
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, srv.shadow.stats())
+	}
+}
+
+//kvShadow forwards to another kvstorage-srv.
+type kvShadow struct {
+	c *client.Client
+}
+
+func (t kvShadow) set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return t.c.Set(ctx, key, value, ttl)
+}
+
+func (t kvShadow) delete(ctx context.Context, key string) error {
+	err := t.c.Delete(ctx, key)
+	if errors.Is(err, client.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+//get returns string values as is and the JSON text of the others, like they
+//were sent.
+func (t kvShadow) get(ctx context.Context, key string) (string, error) {
+	v, err := t.c.Get(ctx, key)
+	if errors.Is(err, client.ErrNotFound) {
+		return "", errShadowNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	var s string
+	if json.Unmarshal(v.Raw, &s) == nil {
+		return s, nil
+	}
+	return string(v.Raw), nil
+}
+
+//redisShadow forwards to Redis over a single connection, which is dialed
+//again after an error.
+type redisShadow struct {
+	addr     string
+	password string
+	db       int
+	conn     net.Conn
+	r        *bufio.Reader
+}
+
+func (t *redisShadow) set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if ttl > 0 {
+		ms := ttl.Milliseconds()
+		if ms < 1 {
+			ms = 1
+		}
+		_, err := t.do(ctx, "SET", key, value, "PX", strconv.FormatInt(ms, 10))
+		return err
+	}
+	_, err := t.do(ctx, "SET", key, value)
+	return err
+}
+
+func (t *redisShadow) delete(ctx context.Context, key string) error {
+	_, err := t.do(ctx, "DEL", key)
+	return err
+}
+
+func (t *redisShadow) get(ctx context.Context, key string) (string, error) {
+	return t.do(ctx, "GET", key)
+}
+
+func (t *redisShadow) do(ctx context.Context, args ...string) (string, error) {
+	if t.conn == nil {
+		if err := t.dial(ctx); err != nil {
+			return "", err
+		}
+	}
+	reply, err := t.command(ctx, args...)
+	var re redisError
+	if err != nil && !errors.As(err, &re) && !errors.Is(err, errShadowNotFound) {
+		t.conn.Close()
+		t.conn = nil
+	}
+	return reply, err
+}
+
+func (t *redisShadow) dial(ctx context.Context) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return err
+	}
+	t.conn, t.r = conn, bufio.NewReader(conn)
+	if t.password != "" {
+		_, err = t.command(ctx, "AUTH", t.password)
+	}
+	if err == nil && t.db != 0 {
+		_, err = t.command(ctx, "SELECT", strconv.Itoa(t.db))
+	}
+	if err != nil {
+		conn.Close()
+		t.conn = nil
+	}
+	return err
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+//command sends args as a RESP array and reads the reply, which is returned if
+//it is a bulk string; a nil bulk string fails with errShadowNotFound.
+func (t *redisShadow) command(ctx context.Context, args ...string) (string, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(shadowTimeout)
+	}
+	t.conn.SetDeadline(deadline)
+	b := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b = append(b, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		b = append(b, arg...)
+		b = append(b, "\r\n"...)
+	}
+	if _, err := t.conn.Write(b); err != nil {
+		return "", err
+	}
+
+	line, err := t.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return "", nil
+	case '-':
+		return "", redisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("redis: invalid reply %q", line)
+		}
+		if n < 0 {
+			return "", errShadowNotFound
+		}
+		b := make([]byte, n+2)
+		if _, err = io.ReadFull(t.r, b); err != nil {
+			return "", err
+		}
+		return string(b[:n]), nil
+	}
+	return "", fmt.Errorf("redis: unexpected reply %q", line)
+}
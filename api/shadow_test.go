@@ -0,0 +1,298 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//fakeShadow is a shadow target keeping the values in memory.
+type fakeShadow struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func newFakeShadow() *fakeShadow {
+	return &fakeShadow{values: make(map[string]string)}
+}
+
+func (t *fakeShadow) set(ctx context.Context, key, value string, ttl time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.values[key] = value
+	return nil
+}
+
+func (t *fakeShadow) delete(ctx context.Context, key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.values, key)
+	return nil
+}
+
+func (t *fakeShadow) get(ctx context.Context, key string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v, ok := t.values[key]
+	if !ok {
+		return "", errShadowNotFound
+	}
+	return v, nil
+}
+
+func newTestShadow(to shadowTarget, size int) *shadow {
+	return &shadow{target: "test", to: to, ops: make(chan shadowOp, size), percent: 100, compareReads: true}
+}
+
+//waitFor polls cond until it holds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestShadow_Sampled(t *testing.T) {
+	sh := newTestShadow(newFakeShadow(), 1)
+	sh.percent = 30
+	n := 0
+	for i := 0; i < 10000; i++ {
+		key := "key" + strconv.Itoa(i)
+		if sh.sampled(key) {
+			n++
+		}
+		if sh.sampled(key) != sh.sampled(key) {
+			t.Fatalf("%s is sampled only sometimes", key)
+		}
+	}
+	if n < 2700 || n > 3300 {
+		t.Errorf("%d of 10000 keys are sampled at 30%%", n)
+	}
+	sh.percent = 100
+	if !sh.sampled("any") {
+		t.Error("key is not sampled at 100%")
+	}
+}
+
+func TestShadow_CompareReads(t *testing.T) {
+	to := newFakeShadow()
+	to.values["same"] = "v"
+	to.values["other"] = "w"
+	to.values["doc"] = `{"a":1}`
+	to.values["gone"] = "v"
+	sh := newTestShadow(to, 100)
+	go sh.run()
+	item := func(v interface{}) *storage.Item {
+		return &storage.Item{Object: v}
+	}
+	sh.mirrorRead("same", item("v"), time.Millisecond)
+	sh.mirrorRead("other", item("v"), time.Millisecond)
+	sh.mirrorRead("doc", item(map[string]int{"a": 1}), time.Millisecond)
+	sh.mirrorRead("missing", nil, time.Millisecond)
+	sh.mirrorRead("gone", nil, time.Millisecond)
+	sh.mirrorRead("new", item("v"), time.Millisecond)
+	waitFor(t, "comparisons", func() bool { return len(sh.stats().RecentMismatches) == 3 })
+
+	st := sh.stats()
+	if st.Compared != 6 || st.Mismatched != 3 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	keys := []string{}
+	for _, m := range st.RecentMismatches {
+		keys = append(keys, m.Key)
+	}
+	if len(keys) != 3 || keys[0] != "other" || keys[1] != "gone" || keys[2] != "new" {
+		t.Errorf("unexpected mismatches: %v", keys)
+	}
+	if m := st.RecentMismatches[0]; *m.Value != "v" || *m.ShadowValue != "w" {
+		t.Errorf("unexpected mismatch: %+v", m)
+	}
+	if st.PrimaryLatency == "" || st.ShadowLatency == "" {
+		t.Errorf("latency is not reported: %+v", st)
+	}
+}
+
+func TestShadow_CompareLeavesRoomForChanges(t *testing.T) {
+	sh := newTestShadow(newFakeShadow(), 4)
+	sh.mirrorRead("a", nil, 0)
+	sh.mirrorRead("b", nil, 0)
+	sh.mirrorRead("c", nil, 0)
+	if len(sh.ops) != 2 || atomic.LoadUint64(&sh.skipped) != 1 {
+		t.Errorf("comparisons took %d of 4 slots, %d skipped", len(sh.ops), sh.skipped)
+	}
+
+	sh.compareReads = false
+	sh.mirrorRead("d", nil, 0)
+	if len(sh.ops) != 2 {
+		t.Error("read was compared with compare_reads disabled")
+	}
+}
+
+//fakeRedis serves SET, GET, DEL, AUTH and SELECT over RESP, failing SET of
+//the key "bad" with an error reply.
+type fakeRedis struct {
+	l        net.Listener
+	mu       sync.Mutex
+	values   map[string]string
+	commands []string
+	conns    int
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{l: l, values: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns++
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			line, err = r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			b := make([]byte, size+2)
+			if _, err = io.ReadFull(r, b); err != nil {
+				return
+			}
+			args[i] = string(b[:size])
+		}
+		conn.Write([]byte(f.reply(args)))
+	}
+}
+
+func (f *fakeRedis) reply(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, strings.Join(args, " "))
+	switch args[0] {
+	case "SET":
+		if args[1] == "bad" {
+			return "-ERR bad key\r\n"
+		}
+		f.values[args[1]] = args[2]
+		return "+OK\r\n"
+	case "GET":
+		v, ok := f.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+	case "DEL":
+		delete(f.values, args[1])
+		return ":1\r\n"
+	}
+	return "+OK\r\n"
+}
+
+func (f *fakeRedis) value(key string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok := f.values[key]
+	return v, ok
+}
+
+func TestRedisShadow(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.l.Close()
+	to, err := newShadowTarget("redis://:secret@" + f.l.Addr().String() + "/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err = to.set(ctx, "k", "v\r\nw", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := to.get(ctx, "k"); err != nil || v != "v\r\nw" {
+		t.Errorf("get returned %q, %v", v, err)
+	}
+	if _, err = to.get(ctx, "missing"); !errors.Is(err, errShadowNotFound) {
+		t.Errorf("get of a missing key returned %v", err)
+	}
+	var re redisError
+	if err = to.set(ctx, "bad", "v", 0); !errors.As(err, &re) {
+		t.Errorf("error reply returned %v", err)
+	}
+	if err = to.delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	want := []string{"AUTH secret", "SELECT 2", "SET k v\r\nw PX 60000", "GET k", "GET missing", "SET bad v", "DEL k"}
+	if strings.Join(f.commands, "|") != strings.Join(want, "|") {
+		t.Errorf("unexpected commands: %q", f.commands)
+	}
+	//neither a missing key nor an error reply drops the connection
+	if f.conns != 1 {
+		t.Errorf("%d connections were dialed", f.conns)
+	}
+}
+
+func TestShadow_Forward(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.l.Close()
+	db := storage.New(storage.DefaultExpiration, 0, 0)
+	srv := NewServer(db)
+	if err := srv.startShadow("redis://"+f.l.Addr().String(), 0, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	db.Set("a", "1", storage.NoExpiration)
+	db.Set("b", map[string]int{"x": 1}, storage.NoExpiration)
+	db.Set("c", "3", storage.NoExpiration)
+	db.Delete("c")
+	waitFor(t, "changes", func() bool { return atomic.LoadUint64(&srv.shadow.forwarded) == 4 })
+	if v, _ := f.value("a"); v != "1" {
+		t.Errorf("a is %q on the target", v)
+	}
+	if v, _ := f.value("b"); v != `{"x":1}` {
+		t.Errorf("b is %q on the target", v)
+	}
+	if _, found := f.value("c"); found {
+		t.Error("deleted c is on the target")
+	}
+
+	db.Flush(false)
+	waitFor(t, "flush", func() bool { return atomic.LoadUint64(&srv.shadow.forwarded) == 6 })
+	if _, found := f.value("a"); found {
+		t.Error("flushed a is on the target")
+	}
+	if _, found := f.value("b"); found {
+		t.Error("flushed b is on the target")
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func parseStreamBound(s string, def storage.StreamID) (storage.StreamID, error) {
+	if s == "" || s == "-" || s == "+" {
+		return def, nil
+	}
+	return storage.ParseStreamID(s)
+}
+
+func (srv *Server) HandleXAdd() http.HandlerFunc {
+	type response struct {
+		ID storage.StreamID `json:"id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+
+		fields := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("body must be a json object of strings"))
+			return
+		}
+
+		id, err := srv.guarded(r).XAdd(key, fields)
+		if err != nil {
+			srv.writeError(w, r, key, http.StatusConflict, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, response{id})
+	}
+}
+
+func (srv *Server) HandleXRange() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+		q := r.URL.Query()
+
+		start, err := parseStreamBound(q.Get("start"), storage.StreamID{})
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		end, err := parseStreamBound(q.Get("end"), storage.StreamID{Ms: math.MaxInt64, Seq: math.MaxInt64})
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		count := 0
+		if v := q.Get("count"); v != "" {
+			if count, err = strconv.Atoi(v); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid count"))
+				return
+			}
+		}
+
+		entries, err := srv.storage.XRange(key, start, end, count)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusConflict, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, entries)
+	}
+}
+
+func (srv *Server) HandleXLen() http.HandlerFunc {
+	type response struct {
+		Len int `json:"len"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+
+		n, err := srv.storage.XLen(key)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusConflict, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, response{n})
+	}
+}
+
+func (srv *Server) HandleXTrim() http.HandlerFunc {
+	type response struct {
+		Removed int `json:"removed"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+		q := r.URL.Query()
+
+		var err error
+		maxLen := 0
+		if v := q.Get("maxlen"); v != "" {
+			if maxLen, err = strconv.Atoi(v); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid maxlen"))
+				return
+			}
+		}
+		var maxAge time.Duration
+		if v := q.Get("maxage"); v != "" {
+			if maxAge, err = time.ParseDuration(v); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid maxage"))
+				return
+			}
+		}
+
+		n, err := srv.guarded(r).XTrim(key, maxLen, maxAge)
+		if err != nil {
+			srv.writeError(w, r, key, http.StatusConflict, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, response{n})
+	}
+}
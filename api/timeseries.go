@@ -0,0 +1,79 @@
+package api
+
+import (
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (srv *Server) HandleTSAdd() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+		q := r.URL.Query()
+
+		value, err := strconv.ParseFloat(q.Get("value"), 64)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid value"))
+			return
+		}
+		at := time.Now()
+		if v := q.Get("time"); v != "" {
+			if at, err = time.Parse(time.RFC3339Nano, v); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid time"))
+				return
+			}
+		}
+		var retention time.Duration
+		if v := q.Get("retention"); v != "" {
+			if retention, err = time.ParseDuration(v); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid retention"))
+				return
+			}
+		}
+
+		if err = srv.guarded(r).TSAdd(key, at, value, retention); err != nil {
+			srv.writeError(w, r, key, http.StatusConflict, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (srv *Server) HandleTSRange() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+		q := r.URL.Query()
+
+		from, to, err := parseTimeRange(q)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		agg := q.Get("agg")
+		if agg == "" {
+			samples, err := srv.storage.TSRange(key, from, to)
+			if err != nil {
+				utils.ErrorMessage(w, r, http.StatusNotFound, err)
+				return
+			}
+			utils.Respond(w, r, http.StatusOK, samples)
+			return
+		}
+
+		interval, err := time.ParseDuration(q.Get("interval"))
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("invalid interval"))
+			return
+		}
+		samples, err := srv.storage.TSAggregate(key, from, to, interval, agg)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusOK, samples)
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/client"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"net/http"
+)
+
+//upstreamLoader reads missing keys from another kvstorage-srv instance,
+//turning this one into a caching tier in front of it.
+func upstreamLoader(c *client.Client) storage.Loader {
+	return func(ctx context.Context, key string) (interface{}, error) {
+		v, err := c.Get(ctx, key)
+		if errors.Is(err, client.ErrNotFound) {
+			return nil, storage.ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if err = json.Unmarshal(v.Raw, &value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+}
+
+//setStaleWarning marks a response serving a value the upstream couldn't refresh.
+func setStaleWarning(w http.ResponseWriter) {
+	w.Header().Add("Warning", `110 - "Response is Stale"`)
+	w.Header().Add("Warning", `111 - "Revalidation Failed"`)
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//warmupReportEvery is how often (in keys) progress of a warmup is reported.
+const warmupReportEvery = 100
+
+const defaultWarmupConcurrency = 8
+
+//warmupRequest lists the keys to load directly or as a file on the server or a
+//URL, both with one key per line.
+type warmupRequest struct {
+	Keys []string `json:"keys"`
+	File string   `json:"file"`
+	URL  string   `json:"url"`
+}
+
+type warmupProgress struct {
+	storage.WarmupStats
+	Processed int    `json:"processed"`
+	Total     int    `json:"total"`
+	Done      bool   `json:"done,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+//readKeys returns the non-empty lines of rd.
+func readKeys(rd io.Reader) ([]string, error) {
+	var keys []string
+	sc := bufio.NewScanner(rd)
+	for sc.Scan() {
+		if key := strings.TrimSpace(sc.Text()); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys, sc.Err()
+}
+
+//warmupKeys collects the keys of req.
+func warmupKeys(r *http.Request, req warmupRequest) ([]string, error) {
+	keys := req.Keys
+	if req.File != "" {
+		f, err := os.Open(req.File)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		fileKeys, err := readKeys(f)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", req.File, err)
+		}
+		keys = append(keys, fileKeys...)
+	}
+	if req.URL != "" {
+		get, err := http.NewRequestWithContext(r.Context(), http.MethodGet, req.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(get)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", req.URL, resp.Status)
+		}
+		urlKeys, err := readKeys(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", req.URL, err)
+		}
+		keys = append(keys, urlKeys...)
+	}
+	return keys, nil
+}
+
+//HandleWarmup fetches the keys given in the body through the read-through loader
+//with ?concurrency (default 8) parallel loads, so that a new instance doesn't send
+//all of its cold misses to the origin. Progress is streamed as JSON lines.
+func (srv *Server) HandleWarmup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if srv.config == nil || srv.config.Upstream == "" {
+			utils.ErrorMessage(w, r, http.StatusConflict, errors.New("warmup requires an upstream to load from"))
+			return
+		}
+		concurrency := defaultWarmupConcurrency
+		if v := r.URL.Query().Get("concurrency"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, fmt.Errorf("invalid concurrency: %s", v))
+				return
+			}
+			concurrency = n
+		}
+		req := warmupRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		keys, err := warmupKeys(r, req)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if len(keys) == 0 {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, errors.New("no keys to warm up"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+		report := func(p warmupProgress) {
+			enc.Encode(p)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		st, err := srv.storage.Warmup(r.Context(), keys, concurrency, func(st storage.WarmupStats) {
+			if st.Processed()%warmupReportEvery == 0 {
+				report(warmupProgress{WarmupStats: st, Processed: st.Processed(), Total: len(keys)})
+			}
+		})
+		progress := warmupProgress{WarmupStats: st, Processed: st.Processed(), Total: len(keys), Done: true}
+		if err != nil {
+			progress.Error = err.Error()
+		}
+		report(progress)
+	}
+}
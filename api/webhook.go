@@ -0,0 +1,190 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	webhookRetries = 3
+	webhookBackoff = 500 * time.Millisecond
+)
+
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+//webhookEvent is the JSON body posted to webhooks.
+type webhookEvent struct {
+	Type      string      `json:"type"`
+	Key       string      `json:"key"`
+	ExpiresAt time.Time   `json:"expires_at,omitempty"`
+	Value     interface{} `json:"value,omitempty"`
+}
+
+//deliver posts event to url, retrying failed attempts with a growing delay.
+func deliver(url string, event webhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		var resp *http.Response
+		resp, err = webhookClient.Post(url, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("%s: %s", url, resp.Status)
+		}
+		if attempt+1 == webhookRetries {
+			return err
+		}
+		time.Sleep(webhookBackoff << attempt)
+	}
+}
+
+//ExpiryHook posts an "expiring" event to URL when a key matching Pattern
+//(see storage.MatchPattern) has less than Before (a duration like "30s") left to live.
+//With Event set to "expired" it instead posts an "expired" event once the item is
+//removed because it expired, including its value if IncludeValue is set.
+//Explicit deletes are never reported.
+type ExpiryHook struct {
+	ID           int    `json:"id" toml:"-"`
+	Event        string `json:"event,omitempty" toml:"event"`
+	Pattern      string `json:"pattern" toml:"pattern"`
+	Before       string `json:"before,omitempty" toml:"before"`
+	URL          string `json:"url" toml:"url"`
+	IncludeValue bool   `json:"include_value,omitempty" toml:"include_value"`
+}
+
+type expiryHooks struct {
+	mu     sync.Mutex
+	nextID int
+	hooks  map[int]ExpiryHook
+	cancel map[int]func()
+}
+
+func (srv *Server) addExpiryHook(h ExpiryHook) (ExpiryHook, error) {
+	if h.Event == "" {
+		h.Event = "expiring"
+	}
+	var before time.Duration
+	switch h.Event {
+	case "expiring":
+		var err error
+		if before, err = time.ParseDuration(h.Before); err != nil || before <= 0 {
+			return h, errors.New("invalid before")
+		}
+	case "expired":
+		if h.Before != "" {
+			return h, errors.New("before only applies to expiring events")
+		}
+	default:
+		return h, fmt.Errorf("invalid event: %s", h.Event)
+	}
+	if h.Pattern == "" || h.URL == "" {
+		return h, errors.New("pattern and url are required")
+	}
+
+	hooks := &srv.expiryHooks
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	if hooks.hooks == nil {
+		hooks.hooks = make(map[int]ExpiryHook)
+		hooks.cancel = make(map[int]func())
+	}
+	hooks.nextID++
+	h.ID = hooks.nextID
+	url, includeValue := h.URL, h.IncludeValue
+	send := func(event webhookEvent) {
+		go srv.deliverOrDeadLetter(url, event)
+	}
+	hooks.hooks[h.ID] = h
+	if h.Event == "expired" {
+		hooks.cancel[h.ID] = srv.storage.NotifyExpired(h.Pattern, func(key string, item storage.Item) {
+			event := webhookEvent{Type: "expired", Key: key, ExpiresAt: time.Unix(0, item.Expiration)}
+			if includeValue {
+				event.Value = item.Object
+			}
+			send(event)
+		})
+		return h, nil
+	}
+	hooks.cancel[h.ID] = srv.storage.NotifyExpiring(h.Pattern, before, func(key string, at time.Time) {
+		send(webhookEvent{Type: "expiring", Key: key, ExpiresAt: at})
+	})
+	return h, nil
+}
+
+func (srv *Server) HandleExpiryHooks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hooks := &srv.expiryHooks
+		hooks.mu.Lock()
+		list := make([]ExpiryHook, 0, len(hooks.hooks))
+		for _, h := range hooks.hooks {
+			list = append(list, h)
+		}
+		hooks.mu.Unlock()
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].ID < list[j].ID
+		})
+		utils.Respond(w, r, http.StatusOK, list)
+	}
+}
+
+func (srv *Server) HandleAddExpiryHook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := ExpiryHook{}
+		if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		h, err := srv.addExpiryHook(h)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		utils.Respond(w, r, http.StatusCreated, h)
+	}
+}
+
+func (srv *Server) HandleDeleteExpiryHook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+		hooks := &srv.expiryHooks
+		hooks.mu.Lock()
+		cancel, ok := hooks.cancel[id]
+		delete(hooks.hooks, id)
+		delete(hooks.cancel, id)
+		hooks.mu.Unlock()
+		if !ok {
+			utils.ErrorMessage(w, r, http.StatusNotFound, errors.New("no such hook"))
+			return
+		}
+		cancel()
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+//notifyOwner posts an "expired" or "evicted" event to url when the item of a
+//key written with ?notify=url is removed, see storage.NotifyKey.
+func (srv *Server) notifyOwner(url string) storage.KeyEventFunc {
+	return func(key string, item storage.Item, reason string) {
+		typ := "evicted"
+		if reason == storage.EvictExpired {
+			typ = "expired"
+		}
+		go srv.deliverOrDeadLetter(url, webhookEvent{Type: typ, Key: key, ExpiresAt: item.ExpiresAt()})
+	}
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"github.com/bulbetski/kvstorage-srv/utils"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+//writeOptions describe expiration of a written item: either a relative ttl
+//(Go duration, -1 for no expiration) or an absolute expires_at (RFC3339).
+//With sliding=true the ttl is refreshed on every read.
+//class (volatile, normal or critical) decides what a full namespace evicts first.
+//contentType is taken from the request of a body write.
+//Conditions come from If-None-Match and If-Match headers, see parseConditions.
+//notify is a URL which is told when the item expires or is evicted, if
+//key_notifications is enabled in config.
+type writeOptions struct {
+	ttl         time.Duration
+	expiresAt   time.Time
+	sliding     bool
+	contentType string
+	class       storage.Class
+	ifAbsent    bool
+	ifExists    bool
+	ifVersion   uint64
+	notify      string
+	//lockToken is the token of the lock of the key, if it is locked
+	lockToken string
+}
+
+func (srv *Server) parseWriteOptions(r *http.Request) (writeOptions, error) {
+	q := r.URL.Query()
+	opts := writeOptions{ttl: storage.DefaultExpiration, lockToken: r.Header.Get(LockTokenHeader)}
+	if q.Get("ttl") != "" && q.Get("expires_at") != "" {
+		return opts, errors.New("ttl and expires_at are mutually exclusive")
+	}
+	if v := q.Get("ttl"); v == "-1" {
+		opts.ttl = storage.NoExpiration
+	} else if v != "" {
+		var err error
+		if opts.ttl, err = time.ParseDuration(v); err != nil || opts.ttl <= 0 {
+			return opts, errors.New("invalid ttl")
+		}
+	}
+	if v := q.Get("expires_at"); v != "" {
+		var err error
+		if opts.expiresAt, err = time.Parse(time.RFC3339, v); err != nil {
+			return opts, errors.New("invalid expires_at")
+		}
+	}
+	opts.sliding = q.Get("sliding") == "true"
+	if opts.sliding && opts.ttl <= 0 {
+		return opts, errors.New("sliding expiration requires ttl")
+	}
+	var err error
+	if opts.class, err = storage.ParseClass(q.Get("class")); err != nil {
+		return opts, err
+	}
+	if opts.notify = q.Get("notify"); opts.notify != "" {
+		if srv.config == nil || !srv.config.KeyNotifications {
+			return opts, errors.New("notify is disabled")
+		}
+		if u, err := url.Parse(opts.notify); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return opts, errors.New("notify must be an http or https URL")
+		}
+	}
+	return opts, parseConditions(r, &opts)
+}
+
+//parseConditions maps conditional headers to storage semantics:
+//If-None-Match: * writes only a new key, If-Match: * only an existing one
+//and If-Match: <version> only the given version of the key.
+func parseConditions(r *http.Request, opts *writeOptions) error {
+	if v := r.Header.Get("If-None-Match"); v != "" {
+		if v != "*" {
+			return errors.New("If-None-Match only supports *")
+		}
+		opts.ifAbsent = true
+	}
+	if r.Header.Get("If-Match") == "*" {
+		opts.ifExists = true
+		return nil
+	}
+	var err error
+	opts.ifVersion, err = requestVersion(r)
+	return err
+}
+
+func (srv *Server) write(key string, value interface{}, opts writeOptions) (uint64, error) {
+	var notify storage.KeyEventFunc
+	if opts.notify != "" {
+		notify = srv.notifyOwner(opts.notify)
+	}
+	return srv.storage.Write(key, value, storage.WriteOptions{
+		TTL:         opts.ttl,
+		ExpiresAt:   opts.expiresAt,
+		Sliding:     opts.sliding,
+		ContentType: opts.contentType,
+		Class:       opts.class,
+		IfAbsent:    opts.ifAbsent,
+		IfExists:    opts.ifExists,
+		IfVersion:   opts.ifVersion,
+		Notify:      notify,
+		Guard:       srv.lockGuard(opts.lockToken),
+	})
+}
+
+//writeFailed reports an error of write: 409 if the key exists despite If-None-Match,
+//412 if If-Match doesn't hold, 423 if the key is locked.
+func (srv *Server) writeFailed(w http.ResponseWriter, r *http.Request, key string, err error) {
+	switch {
+	case errors.Is(err, errKeyLocked):
+		lockedResponse(w, r, err)
+	case errors.Is(err, storage.ErrExists):
+		utils.ErrorMessage(w, r, http.StatusConflict, err)
+	case errors.Is(err, storage.ErrVersionMismatch), errors.Is(err, storage.ErrNotFound):
+		utils.ErrorMessage(w, r, http.StatusPreconditionFailed, err)
+	default:
+		srv.writeError(w, r, key, http.StatusUnprocessableEntity, err)
+	}
+}
+
+//written acknowledges a write with the new version of the item.
+func written(w http.ResponseWriter, version uint64) {
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatUint(version, 10)))
+	w.WriteHeader(http.StatusOK)
+}
+
+//HandleSetBody stores the request body with its Content-Type, which GET returns
+//the body with. Bodies larger than the chunk threshold are stored as chunked
+//values and read segment by segment, never buffered whole.
+func (srv *Server) HandleSetBody() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+
+		opts, err := srv.parseWriteOptions(r)
+		if err != nil {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+		opts.contentType = r.Header.Get("Content-Type")
+
+		threshold, chunkSize := storage.DefaultChunkSize, storage.DefaultChunkSize
+		if srv.config != nil {
+			if srv.config.ChunkThreshold > 0 {
+				threshold = srv.config.ChunkThreshold
+			}
+			if srv.config.ChunkSize > 0 {
+				chunkSize = srv.config.ChunkSize
+			}
+			if srv.config.MaxValueSize > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, srv.config.MaxValueSize)
+			}
+		}
+
+		head := &bytes.Buffer{}
+		n, err := io.CopyN(head, r.Body, int64(threshold)+1)
+		if err != nil && err != io.EOF {
+			utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		var value interface{} = head.String()
+		if n > int64(threshold) {
+			if value, err = storage.ReadChunked(io.MultiReader(head, r.Body), chunkSize); err != nil {
+				utils.ErrorMessage(w, r, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		if isAsync(r) {
+			srv.acceptAsync(w, r, asyncWrite{key: key, value: value, opts: opts})
+			return
+		}
+		version, err := srv.write(key, value, opts)
+		if err != nil {
+			srv.writeFailed(w, r, key, err)
+			return
+		}
+		written(w, version)
+	}
+}
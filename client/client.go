@@ -0,0 +1,235 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("no such key")
+
+type Client struct {
+	baseURL       string
+	httpClient    *http.Client
+	retry         RetryPolicy
+	breakerPolicy BreakerPolicy
+	hedge         HedgePolicy
+	breakers      breakers
+	pool          *poolStats
+	codecs        codecs
+}
+
+func New(baseURL string) *Client {
+	c := &Client{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		retry:         DefaultRetryPolicy,
+		breakerPolicy: DefaultBreakerPolicy,
+		pool:          &poolStats{},
+	}
+	c.SetTransport(DefaultTransportConfig)
+	c.RegisterCodec(GobCodec)
+	c.SetCodec(JSONCodec)
+	return c
+}
+
+type Value struct {
+	Raw     json.RawMessage
+	Version uint64
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+//StatusError is returned for responses with an unexpected status code.
+type StatusError struct {
+	Method  string
+	Path    string
+	Code    int
+	Status  string
+	Message string
+	body    []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s %s", e.Method, e.Path, e.Status, e.Message)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, q url.Values, out interface{}) error {
+	return c.doBody(ctx, method, path, q, nil, out)
+}
+
+//request is a single call to the server, which may be sent several times and
+//to several hosts.
+type request struct {
+	method  string
+	path    string
+	query   string
+	header  http.Header
+	payload []byte
+}
+
+type reply struct {
+	body   []byte
+	header http.Header
+}
+
+//doBody is do with a request body.
+func (c *Client) doBody(ctx context.Context, method, path string, q url.Values, payload []byte, out interface{}) error {
+	req := request{method: method, path: path, payload: payload}
+	if len(q) > 0 {
+		req.query = "?" + q.Encode()
+	}
+	rep, err := c.exchange(ctx, req)
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		return json.Unmarshal(rep.body, out)
+	}
+	return nil
+}
+
+//exchange sends req with the retry and hedging policies of the client.
+func (c *Client) exchange(ctx context.Context, req request) (rep *reply, err error) {
+	err = c.withRetry(ctx, req.method, func() (err error) {
+		if req.method == http.MethodGet && len(c.hedge.Replicas) > 0 {
+			rep, err = c.hedged(ctx, req)
+		} else {
+			rep, err = c.send(ctx, c.baseURL, req)
+		}
+		return err
+	})
+	return rep, err
+}
+
+//send makes a single request to the server at base unless its circuit is open.
+func (c *Client) send(ctx context.Context, base string, req request) (*reply, error) {
+	if !c.allow(base) {
+		return nil, fmt.Errorf("%s %s: %s: %w", req.method, req.path, base, ErrCircuitOpen)
+	}
+	rep, err := c.roundTrip(ctx, base, req)
+	c.record(base, err)
+	return rep, err
+}
+
+func (c *Client) roundTrip(ctx context.Context, base string, r request) (*reply, error) {
+	var rd io.Reader
+	if r.payload != nil {
+		rd = bytes.NewReader(r.payload)
+	}
+	req, err := http.NewRequestWithContext(c.traced(ctx), r.method, base+r.path+r.query, rd)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range r.header {
+		req.Header[name] = values
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	body, err := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		e := errorResponse{}
+		json.Unmarshal(body, &e)
+		return nil, &StatusError{Method: r.method, Path: r.path, Code: resp.StatusCode, Status: resp.Status, Message: e.Error, body: body}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &reply{body, resp.Header}, nil
+}
+
+//Set stores value at key. A zero ttl uses the server default, a negative one disables expiration.
+func (c *Client) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	q := url.Values{}
+	if ttl < 0 {
+		q.Set("ttl", "-1")
+	} else if ttl > 0 {
+		q.Set("ttl", ttl.String())
+	}
+	return c.do(ctx, http.MethodPut, "/items/"+url.PathEscape(key)+"/"+url.PathEscape(value), q, nil)
+}
+
+//SetAsync is Set without waiting for the write to be applied: the server queues it
+//and acknowledges it right away, so errors like a failed validation are never seen.
+func (c *Client) SetAsync(ctx context.Context, key, value string, ttl time.Duration) error {
+	q := url.Values{"async": {"true"}}
+	if ttl < 0 {
+		q.Set("ttl", "-1")
+	} else if ttl > 0 {
+		q.Set("ttl", ttl.String())
+	}
+	return c.do(ctx, http.MethodPut, "/items/"+url.PathEscape(key)+"/"+url.PathEscape(value), q, nil)
+}
+
+func (c *Client) Get(ctx context.Context, key string) (Value, error) {
+	var resp struct {
+		Value   json.RawMessage `json:"value"`
+		Version uint64          `json:"version"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/items/"+url.PathEscape(key), nil, &resp); err != nil {
+		return Value{}, err
+	}
+	return Value{Raw: resp.Value, Version: resp.Version}, nil
+}
+
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.do(ctx, http.MethodDelete, "/items/"+url.PathEscape(key), nil, nil)
+}
+
+//DeleteAsync queues the deletion of key like SetAsync; it doesn't fail for missing keys.
+func (c *Client) DeleteAsync(ctx context.Context, key string) error {
+	return c.do(ctx, http.MethodDelete, "/items/"+url.PathEscape(key), url.Values{"async": {"true"}}, nil)
+}
+
+//Typed stores values of type T as JSON documents.
+type Typed[T any] struct {
+	c *Client
+}
+
+func NewTyped[T any](c *Client) *Typed[T] {
+	return &Typed[T]{c: c}
+}
+
+func (t *Typed[T]) Set(ctx context.Context, key string, value T, ttl time.Duration) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return t.c.Set(ctx, key, string(raw), ttl)
+}
+
+func (t *Typed[T]) Get(ctx context.Context, key string) (T, uint64, error) {
+	var res T
+	v, err := t.c.Get(ctx, key)
+	if err != nil {
+		return res, 0, err
+	}
+	//server returns values set through the path as JSON strings holding the document
+	var doc string
+	if err = json.Unmarshal(v.Raw, &doc); err != nil {
+		return res, 0, err
+	}
+	if err = json.Unmarshal([]byte(doc), &res); err != nil {
+		return res, 0, err
+	}
+	return res, v.Version, nil
+}
+
+func (t *Typed[T]) Delete(ctx context.Context, key string) error {
+	return t.c.Delete(ctx, key)
+}
@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//DefaultVirtualNodes is the number of points every server gets on the ring;
+//more points spread the keys more evenly.
+const DefaultVirtualNodes = 160
+
+//Ring maps keys to servers by consistent hashing, so that adding or removing a
+//server only moves the keys between it and its neighbours.
+type Ring struct {
+	points []uint32
+	owners []string
+	nodes  int
+}
+
+func NewRing(addrs []string, vnodes int) *Ring {
+	if vnodes < 1 {
+		vnodes = 1
+	}
+	type point struct {
+		hash uint32
+		addr string
+	}
+	points := make([]point, 0, len(addrs)*vnodes)
+	for _, addr := range addrs {
+		for i := 0; i < vnodes; i++ {
+			points = append(points, point{ringHash(addr + "#" + strconv.Itoa(i)), addr})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].addr < points[j].addr
+	})
+	r := &Ring{points: make([]uint32, len(points)), owners: make([]string, len(points)), nodes: len(addrs)}
+	for i, p := range points {
+		r.points[i], r.owners[i] = p.hash, p.addr
+	}
+	return r
+}
+
+//ringHash places points and keys on the ring. Unlike a checksum, it spreads
+//similar strings like the points of a server evenly.
+func ringHash(s string) uint32 {
+	sum := md5.Sum([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+//Owners returns up to n distinct servers for key, the primary first.
+func (r *Ring) Owners(key string, n int) []string {
+	if len(r.points) == 0 {
+		return nil
+	}
+	if n > r.nodes {
+		n = r.nodes
+	}
+	h := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	owners := make([]string, 0, n)
+	for j := 0; len(owners) < n && j < len(r.points); j++ {
+		addr := r.owners[(i+j)%len(r.points)]
+		if !contains(owners, addr) {
+			owners = append(owners, addr)
+		}
+	}
+	return owners
+}
+
+func contains(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+//Cluster spreads keys over several independent servers. Every key is written
+//to Replicas servers; reads go to its primary and fall back to the other
+//replicas while servers are unreachable, or to a random replica with spread reads.
+type Cluster struct {
+	addrs       []string
+	clients     map[string]*Client
+	ring        *Ring
+	replicas    int
+	spreadReads bool
+}
+
+func NewCluster(addrs []string) *Cluster {
+	c := &Cluster{clients: make(map[string]*Client, len(addrs)), replicas: 1}
+	for _, addr := range addrs {
+		cl := New(addr)
+		if _, dup := c.clients[cl.baseURL]; dup {
+			continue
+		}
+		c.addrs = append(c.addrs, cl.baseURL)
+		c.clients[cl.baseURL] = cl
+	}
+	c.ring = NewRing(c.addrs, DefaultVirtualNodes)
+	return c
+}
+
+//SetVirtualNodes rebuilds the ring with vnodes points per server. All clients
+//of a cluster must use the same number to agree on the owners of keys.
+func (c *Cluster) SetVirtualNodes(vnodes int) {
+	c.ring = NewRing(c.addrs, vnodes)
+}
+
+//SetReplicas sets the number of servers every key is stored on.
+func (c *Cluster) SetReplicas(n int) {
+	if n < 1 {
+		n = 1
+	}
+	c.replicas = n
+}
+
+//SetSpreadReads makes reads pick a random replica of the key instead of its primary.
+func (c *Cluster) SetSpreadReads(spread bool) {
+	c.spreadReads = spread
+}
+
+//Clients returns the clients of all servers, e.g. to set their policies.
+func (c *Cluster) Clients() []*Client {
+	clients := make([]*Client, len(c.addrs))
+	for i, addr := range c.addrs {
+		clients[i] = c.clients[addr]
+	}
+	return clients
+}
+
+//Client returns the client of the primary server of key.
+func (c *Cluster) Client(key string) *Client {
+	owners := c.ring.Owners(key, 1)
+	if len(owners) == 0 {
+		return nil
+	}
+	return c.clients[owners[0]]
+}
+
+var errNoServers = errors.New("cluster has no servers")
+
+//write applies f to all replicas of key and returns the first error.
+func (c *Cluster) write(key string, f func(*Client) error) error {
+	owners := c.ring.Owners(key, c.replicas)
+	if len(owners) == 0 {
+		return errNoServers
+	}
+	errs := make(chan error, len(owners))
+	for _, addr := range owners {
+		go func(cl *Client) {
+			errs <- f(cl)
+		}(c.clients[addr])
+	}
+	var err error
+	for range owners {
+		if werr := <-errs; werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+func (c *Cluster) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return c.write(key, func(cl *Client) error {
+		return cl.Set(ctx, key, value, ttl)
+	})
+}
+
+func (c *Cluster) Delete(ctx context.Context, key string) error {
+	return c.write(key, func(cl *Client) error {
+		return cl.Delete(ctx, key)
+	})
+}
+
+func (c *Cluster) Get(ctx context.Context, key string) (Value, error) {
+	owners := c.ring.Owners(key, c.replicas)
+	if len(owners) == 0 {
+		return Value{}, errNoServers
+	}
+	if c.spreadReads {
+		i := rand.Intn(len(owners))
+		owners[0], owners[i] = owners[i], owners[0]
+	}
+	var err error
+	for _, addr := range owners {
+		var v Value
+		if v, err = c.clients[addr].Get(ctx, key); err == nil || !retryable(err) && !errors.Is(err, ErrCircuitOpen) {
+			return v, err
+		}
+	}
+	return Value{}, err
+}
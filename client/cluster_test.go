@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRing_Distribution(t *testing.T) {
+	const keys = 30000
+	//servers get within 15% of their fair share, also with similar names
+	for _, addrs := range [][]string{
+		{"http://a:8080", "http://b:8080", "http://c:8080"},
+		{"http://kv1:8080", "http://kv2:8080", "http://kv3:8080", "http://kv4:8080", "http://kv5:8080"},
+	} {
+		r := NewRing(addrs, DefaultVirtualNodes)
+		counts := map[string]int{}
+		for i := 0; i < keys; i++ {
+			counts[r.Owners("key"+strconv.Itoa(i), 1)[0]]++
+		}
+		for _, addr := range addrs {
+			if share := float64(counts[addr]*len(addrs)) / keys; share < 0.85 || share > 1.15 {
+				t.Errorf("%s owns %.2f of its fair share of the keys", addr, share)
+			}
+		}
+	}
+
+	addrs := []string{"http://a:8080", "http://b:8080", "http://c:8080"}
+	r := NewRing(addrs, DefaultVirtualNodes)
+
+	//a new server only takes keys, the others keep theirs
+	grown := NewRing(append(addrs, "http://d:8080"), DefaultVirtualNodes)
+	moved := 0
+	for i := 0; i < keys; i++ {
+		key := "key" + strconv.Itoa(i)
+		before, after := r.Owners(key, 1)[0], grown.Owners(key, 1)[0]
+		if before == after {
+			continue
+		}
+		moved++
+		if after != "http://d:8080" {
+			t.Fatalf("%s moved from %s to %s", key, before, after)
+		}
+	}
+	if share := float64(moved) / keys; share < 0.2 || share > 0.3 {
+		t.Errorf("%.2f of the keys moved to the new server", share)
+	}
+
+	//the ring only depends on the servers, not their order
+	shuffled := NewRing([]string{addrs[2], addrs[0], addrs[1]}, DefaultVirtualNodes)
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		if r.Owners(key, 1)[0] != shuffled.Owners(key, 1)[0] {
+			t.Fatalf("%s has another owner when the servers are reordered", key)
+		}
+	}
+}
+
+func TestRing_Owners(t *testing.T) {
+	r := NewRing([]string{"a", "b", "c"}, 10)
+	for i := 0; i < 100; i++ {
+		owners := r.Owners("key"+strconv.Itoa(i), 5)
+		if len(owners) != 3 || owners[0] == owners[1] || owners[1] == owners[2] || owners[0] == owners[2] {
+			t.Fatalf("unexpected owners %v", owners)
+		}
+		if owners[0] != r.Owners("key"+strconv.Itoa(i), 1)[0] {
+			t.Fatalf("primary isn't first: %v", owners)
+		}
+	}
+	if owners := NewRing(nil, 10).Owners("k", 1); owners != nil {
+		t.Errorf("empty ring returned %v", owners)
+	}
+	if owners := NewRing([]string{"a"}, 0).Owners("k", 2); len(owners) != 1 || owners[0] != "a" {
+		t.Errorf("single server ring returned %v", owners)
+	}
+}
+
+//memServer is a server keeping the values set through the path in memory.
+type memServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func newMemServer() *memServer {
+	s := &memServer{values: make(map[string]string)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/items/"), "/")
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			s.values[parts[0]] = parts[1]
+		case http.MethodGet:
+			v, ok := s.values[parts[0]]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(`{"value":"` + v + `","version":1}`))
+		case http.MethodDelete:
+			delete(s.values, parts[0])
+		}
+	}))
+	return s
+}
+
+func (s *memServer) has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.values[key]
+	return ok
+}
+
+func TestCluster(t *testing.T) {
+	ctx := context.Background()
+	servers := map[string]*memServer{}
+	addrs := []string{}
+	for i := 0; i < 3; i++ {
+		s := newMemServer()
+		defer s.Close()
+		servers[s.URL] = s
+		addrs = append(addrs, s.URL, s.URL+"/")
+	}
+	c := NewCluster(addrs)
+	if len(c.Clients()) != 3 {
+		t.Fatalf("duplicate servers weren't merged: %d clients", len(c.Clients()))
+	}
+	c.SetReplicas(2)
+	for _, cl := range c.Clients() {
+		cl.SetRetryPolicy(RetryPolicy{Attempts: 1})
+	}
+
+	for i := 0; i < 30; i++ {
+		key := "key" + strconv.Itoa(i)
+		if err := c.Set(ctx, key, "v", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		owners := c.ring.Owners(key, 2)
+		stored := 0
+		for addr, s := range servers {
+			if s.has(key) {
+				stored++
+				if !contains(owners, addr) {
+					t.Errorf("%s is stored on %s, which doesn't own it", key, addr)
+				}
+			}
+		}
+		if stored != 2 {
+			t.Errorf("%s is stored on %d servers", key, stored)
+		}
+		if c.Client(key).baseURL != owners[0] {
+			t.Errorf("client of %s isn't its primary", key)
+		}
+	}
+
+	//missing keys aren't looked up on the other replicas
+	if _, err := c.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing key returned %v", err)
+	}
+
+	//reads fall back to the other replica while the primary is down
+	key := "key0"
+	primary := servers[c.ring.Owners(key, 1)[0]]
+	primary.Close()
+	if v, err := c.Get(ctx, key); err != nil || string(v.Raw) != `"v"` {
+		t.Errorf("read with the primary down returned %s, %v", v.Raw, err)
+	}
+	if _, err := NewCluster(nil).Get(ctx, "k"); !errors.Is(err, errNoServers) {
+		t.Errorf("empty cluster returned %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Codec marshals values stored with SetValue. The server keeps the content type
+//of a value and returns it with the value, so GetValue decodes values with the
+//codec they were written with if it is registered.
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string {
+	return "application/x-gob"
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+var (
+	JSONCodec Codec = jsonCodec{}
+	GobCodec  Codec = gobCodec{}
+)
+
+//codecs are the codec used for writing and all codecs values can be read with.
+type codecs struct {
+	write  Codec
+	byType map[string]Codec
+}
+
+func (cs *codecs) register(codec Codec) {
+	if cs.byType == nil {
+		cs.byType = make(map[string]Codec)
+	}
+	cs.byType[codec.ContentType()] = codec
+}
+
+//SetCodec makes SetValue write values with codec instead of JSONCodec.
+//It must be called before the client is used.
+func (c *Client) SetCodec(codec Codec) {
+	c.codecs.register(codec)
+	c.codecs.write = codec
+}
+
+//RegisterCodec lets GetValue read values written with codec, e.g. by other
+//clients. JSONCodec and GobCodec are registered by default. It must be called
+//before the client is used.
+func (c *Client) RegisterCodec(codec Codec) {
+	c.codecs.register(codec)
+}
+
+//SetValue stores v marshaled with the codec of the client at key, with the ttl
+//semantics of Set.
+func (c *Client) SetValue(ctx context.Context, key string, v interface{}, ttl time.Duration) error {
+	data, err := c.codecs.write.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req := request{
+		method:  http.MethodPut,
+		path:    "/items/" + url.PathEscape(key),
+		header:  http.Header{"Content-Type": {c.codecs.write.ContentType()}},
+		payload: data,
+	}
+	if ttl < 0 {
+		req.query = "?ttl=-1"
+	} else if ttl > 0 {
+		req.query = "?ttl=" + url.QueryEscape(ttl.String())
+	}
+	_, err = c.exchange(ctx, req)
+	return err
+}
+
+//GetValue unmarshals the value at key into out and returns its version. Values
+//are decoded by the codec of their content type; values without a registered
+//one, like those written with Set, are decoded by the codec of the client.
+func (c *Client) GetValue(ctx context.Context, key string, out interface{}) (uint64, error) {
+	accept := make([]string, 0, len(c.codecs.byType)+1)
+	for contentType := range c.codecs.byType {
+		accept = append(accept, contentType)
+	}
+	//values written with Set come back as text
+	accept = append(accept, "text/plain")
+	rep, err := c.exchange(ctx, request{
+		method: http.MethodGet,
+		path:   "/items/" + url.PathEscape(key),
+		query:  "?raw=true",
+		header: http.Header{"Accept": {strings.Join(accept, ", ")}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	version, _ := strconv.ParseUint(strings.Trim(rep.header.Get("ETag"), `"`), 10, 64)
+
+	codec := c.codecs.write
+	if mediaType, _, err := mime.ParseMediaType(rep.header.Get("Content-Type")); err == nil && c.codecs.byType[mediaType] != nil {
+		codec = c.codecs.byType[mediaType]
+	}
+	if err = codec.Unmarshal(rep.body, out); err != nil {
+		return version, fmt.Errorf("decoding %s with %s: %w", key, codec.ContentType(), err)
+	}
+	return version, nil
+}
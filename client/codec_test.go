@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//typedServer stores values with their content type like the server does:
+//bodies of PUT /items/{key} as they are and values in the path as text.
+func typedServer(t *testing.T) *httptest.Server {
+	type value struct {
+		contentType string
+		data        []byte
+		version     uint64
+	}
+	var mu sync.Mutex
+	values := map[string]value{}
+	version := uint64(0)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/items/"), "/", 2)
+		switch r.Method {
+		case http.MethodPut:
+			version++
+			if len(parts) == 2 {
+				values[parts[0]] = value{"text/plain; charset=utf-8", []byte(parts[1]), version}
+				return
+			}
+			if ttl := r.URL.Query().Get("ttl"); ttl != "" && ttl != "-1" {
+				if _, err := time.ParseDuration(ttl); err != nil {
+					t.Errorf("invalid ttl %s", ttl)
+				}
+			}
+			data, _ := io.ReadAll(r.Body)
+			values[parts[0]] = value{r.Header.Get("Content-Type"), data, version}
+		case http.MethodGet:
+			if r.URL.Query().Get("raw") != "true" {
+				t.Error("value wasn't read raw")
+			}
+			v, ok := values[parts[0]]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			mediaType, _, _ := mime.ParseMediaType(v.contentType)
+			if !strings.Contains(r.Header.Get("Accept"), mediaType) {
+				w.WriteHeader(http.StatusNotAcceptable)
+				return
+			}
+			w.Header().Set("Content-Type", v.contentType)
+			w.Header().Set("ETag", strconv.Quote(strconv.FormatUint(v.version, 10)))
+			w.Write(v.data)
+		}
+	}))
+}
+
+type position struct {
+	X, Y int
+}
+
+//upperCodec stores strings in upper case.
+type upperCodec struct{}
+
+func (upperCodec) ContentType() string {
+	return "text/x-upper"
+}
+
+func (upperCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(strings.ToUpper(v.(string))), nil
+}
+
+func (upperCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*string) = string(data)
+	return nil
+}
+
+func TestClient_Codecs(t *testing.T) {
+	ts := typedServer(t)
+	defer ts.Close()
+	ctx := context.Background()
+	jsonClient := New(ts.URL)
+	gobClient := New(ts.URL)
+	gobClient.SetCodec(GobCodec)
+
+	if err := jsonClient.SetValue(ctx, "json", position{1, 2}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := gobClient.SetValue(ctx, "gob", position{3, 4}, -1); err != nil {
+		t.Fatal(err)
+	}
+	//values are decoded with the codec they were written with
+	for _, c := range []*Client{jsonClient, gobClient} {
+		p := position{}
+		if version, err := c.GetValue(ctx, "json", &p); err != nil || p != (position{1, 2}) || version != 1 {
+			t.Errorf("json value: %+v, version %d, %v", p, version, err)
+		}
+		p = position{}
+		if version, err := c.GetValue(ctx, "gob", &p); err != nil || p != (position{3, 4}) || version != 2 {
+			t.Errorf("gob value: %+v, version %d, %v", p, version, err)
+		}
+	}
+
+	//values set through the path are decoded with the codec of the client
+	if err := jsonClient.Set(ctx, "text", `{"X":5,"Y":6}`, 0); err != nil {
+		t.Fatal(err)
+	}
+	p := position{}
+	if _, err := jsonClient.GetValue(ctx, "text", &p); err != nil || p != (position{5, 6}) {
+		t.Errorf("text value: %+v, %v", p, err)
+	}
+	if _, err := gobClient.GetValue(ctx, "text", &p); err == nil || !strings.Contains(err.Error(), "decoding text with application/x-gob") {
+		t.Errorf("undecodable value returned %v", err)
+	}
+
+	//codecs must be registered to be read
+	upper := New(ts.URL)
+	upper.SetCodec(upperCodec{})
+	if err := upper.SetValue(ctx, "upper", "shout", 0); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if _, err := upper.GetValue(ctx, "upper", &s); err != nil || s != "SHOUT" {
+		t.Errorf("custom codec value: %q, %v", s, err)
+	}
+	var se *StatusError
+	if _, err := jsonClient.GetValue(ctx, "upper", &s); !errors.As(err, &se) || se.Code != http.StatusNotAcceptable {
+		t.Errorf("value of an unregistered codec returned %v", err)
+	}
+	jsonClient.RegisterCodec(upperCodec{})
+	if _, err := jsonClient.GetValue(ctx, "upper", &s); err != nil || s != "SHOUT" {
+		t.Errorf("value of a registered codec: %q, %v", s, err)
+	}
+
+	if _, err := jsonClient.GetValue(ctx, "missing", &p); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing key returned %v", err)
+	}
+}
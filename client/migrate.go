@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+//MigrateProgress is reported while the server copies its keyspace to another instance.
+type MigrateProgress struct {
+	Migrated int    `json:"migrated"`
+	Total    int    `json:"total"`
+	Done     bool   `json:"done"`
+	Error    string `json:"error"`
+}
+
+//Migrate makes the server copy all its items with their TTLs to the instance at target,
+//sending at most rate items per second (0 means unlimited). progress is called
+//every time the server reports it. Migration is not bounded by the client timeout.
+func (c *Client) Migrate(ctx context.Context, target string, rate int, progress func(MigrateProgress)) error {
+	q := url.Values{}
+	q.Set("target", target)
+	q.Set("rate", strconv.Itoa(rate))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/admin/migrate?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: c.httpClient.Transport}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		e := errorResponse{}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("migrate: %s %s", resp.Status, e.Error)
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		p := MigrateProgress{}
+		if err = dec.Decode(&p); err != nil {
+			return fmt.Errorf("migrate: %w", err)
+		}
+		if progress != nil {
+			progress(p)
+		}
+		if p.Done {
+			if p.Error != "" {
+				return errors.New(p.Error)
+			}
+			return nil
+		}
+	}
+}
+
+//RDBStats is the result of ImportRDB.
+type RDBStats struct {
+	Imported int `json:"imported"`
+	Expired  int `json:"expired"`
+	Skipped  int `json:"skipped"`
+}
+
+//ImportRDB uploads a Redis RDB dump whose string keys are stored with their TTLs.
+func (c *Client) ImportRDB(ctx context.Context, dump io.Reader) (RDBStats, error) {
+	stats := RDBStats{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/admin/import/rdb", dump)
+	if err != nil {
+		return stats, err
+	}
+	resp, err := (&http.Client{Transport: c.httpClient.Transport}).Do(req)
+	if err != nil {
+		return stats, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		e := errorResponse{}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return stats, fmt.Errorf("import rdb: %s %s", resp.Status, e.Error)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	return stats, err
+}
+
+//Snapshot writes a snapshot of the server in the db file format with its checksum
+//trailer to w, see storage.VerifyChecksum. It is not bounded by the client timeout.
+func (c *Client) Snapshot(ctx context.Context, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/admin/snapshot", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: c.httpClient.Transport}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		e := errorResponse{}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("snapshot: %s %s", resp.Status, e.Error)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type pipelineOp struct {
+	Op    string          `json:"op"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+	TTL   string          `json:"ttl,omitempty"`
+}
+
+//Pipeline queues operations and sends them to the server as a single /batch
+//request. It is not safe for concurrent use.
+type Pipeline struct {
+	c   *Client
+	ops []pipelineOp
+}
+
+//OpResult is the outcome of a queued operation: Err is nil if it was applied.
+type OpResult struct {
+	Op  string
+	Key string
+	Err error
+}
+
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{c: c}
+}
+
+//Set queues a Set of key, with the ttl semantics of Client.Set.
+func (p *Pipeline) Set(key, value string, ttl time.Duration) {
+	raw, _ := json.Marshal(value)
+	op := pipelineOp{Op: "set", Key: key, Value: raw}
+	if ttl < 0 {
+		op.TTL = "-1"
+	} else if ttl > 0 {
+		op.TTL = ttl.String()
+	}
+	p.ops = append(p.ops, op)
+}
+
+func (p *Pipeline) Delete(key string) {
+	p.ops = append(p.ops, pipelineOp{Op: "delete", Key: key})
+}
+
+func (p *Pipeline) Len() int {
+	return len(p.ops)
+}
+
+//Exec sends the queued operations and empties the pipeline. The server applies
+//them in order in groups of up to 1000, each group atomically, and stops at the
+//first failing group: operations before it are applied, the later ones fail with
+//the returned error. If the request itself failed, it is unknown how many were
+//applied and all are reported as failed. Results are in the order the operations were queued.
+func (p *Pipeline) Exec(ctx context.Context) ([]OpResult, error) {
+	ops := p.ops
+	p.ops = nil
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	for i := range ops {
+		if err := enc.Encode(&ops[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	resp := struct {
+		Applied int `json:"applied"`
+	}{}
+	err := p.c.doBody(ctx, http.MethodPost, "/batch", nil, buf.Bytes(), &resp)
+	var se *StatusError
+	if errors.As(err, &se) {
+		//the server reports how many operations it applied before failing
+		json.Unmarshal(se.body, &resp)
+	}
+
+	results := make([]OpResult, len(ops))
+	for i, op := range ops {
+		results[i] = OpResult{Op: op.Op, Key: op.Key}
+		if i >= resp.Applied {
+			results[i].Err = err
+		}
+	}
+	return results, err
+}
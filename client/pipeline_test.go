@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//batchServer decodes the operations of /batch requests and applies them up to
+//the first one with the key "bad", like the server does with groups of one.
+func batchServer(t *testing.T, received *[]pipelineOp) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/batch" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		dec := json.NewDecoder(r.Body)
+		applied := 0
+		for dec.More() {
+			op := pipelineOp{}
+			if err := dec.Decode(&op); err != nil {
+				t.Error(err)
+				return
+			}
+			*received = append(*received, op)
+			if op.Key == "bad" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"applied": applied, "error": "invalid key"})
+				return
+			}
+			applied++
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"applied": applied})
+	}))
+}
+
+func TestPipeline(t *testing.T) {
+	var received []pipelineOp
+	ts := batchServer(t, &received)
+	defer ts.Close()
+	p := New(ts.URL).Pipeline()
+	ctx := context.Background()
+
+	if results, err := p.Exec(ctx); results != nil || err != nil {
+		t.Errorf("empty pipeline returned %v, %v", results, err)
+	}
+
+	p.Set("a", "1", 0)
+	p.Set("b", `{"x":"2"}`, time.Minute)
+	p.Set("c", "3", -1)
+	p.Delete("a")
+	if p.Len() != 4 {
+		t.Fatalf("%d operations were queued", p.Len())
+	}
+	results, err := p.Exec(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []pipelineOp{
+		{Op: "set", Key: "a", Value: json.RawMessage(`"1"`)},
+		{Op: "set", Key: "b", Value: json.RawMessage(`"{\"x\":\"2\"}"`), TTL: "1m0s"},
+		{Op: "set", Key: "c", Value: json.RawMessage(`"3"`), TTL: "-1"},
+		{Op: "delete", Key: "a"},
+	}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("unexpected operations were sent: %+v", received)
+	}
+	wantResults := []OpResult{{"set", "a", nil}, {"set", "b", nil}, {"set", "c", nil}, {"delete", "a", nil}}
+	if !reflect.DeepEqual(results, wantResults) {
+		t.Errorf("unexpected results: %+v", results)
+	}
+	if p.Len() != 0 {
+		t.Error("pipeline wasn't emptied")
+	}
+}
+
+func TestPipeline_PartialFailure(t *testing.T) {
+	var received []pipelineOp
+	ts := batchServer(t, &received)
+	defer ts.Close()
+	p := New(ts.URL).Pipeline()
+
+	p.Set("a", "1", 0)
+	p.Set("bad", "2", 0)
+	p.Delete("c")
+	results, err := p.Exec(context.Background())
+	var se *StatusError
+	if !errors.As(err, &se) || se.Code != http.StatusBadRequest || se.Message != "invalid key" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(results) != 3 || results[0].Err != nil || results[1].Err != err || results[2].Err != err {
+		t.Errorf("unexpected results: %+v", results)
+	}
+	if p.Len() != 0 {
+		t.Error("pipeline wasn't emptied")
+	}
+}
+
+func TestPipeline_RequestFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	c := New(ts.URL)
+	c.SetRetryPolicy(RetryPolicy{Attempts: 1})
+	p := c.Pipeline()
+	p.Set("a", "1", 0)
+	p.Delete("b")
+	results, err := p.Exec(context.Background())
+	if err == nil {
+		t.Fatal("failed request returned no error")
+	}
+	//without an answer, nothing is known to be applied
+	for _, res := range results {
+		if res.Err != err {
+			t.Errorf("%s %s: unexpected error %v", res.Op, res.Key, res.Err)
+		}
+	}
+}
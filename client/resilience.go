@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//RetryPolicy retries requests which failed with a network error or a 429, 502,
+//503 or 504 response. Attempts include the first one, so 1 disables retries.
+//The backoff doubles after every attempt up to MaxBackoff, with random jitter.
+//Only idempotent requests (GET, PUT, DELETE) are retried unless NonIdempotent is set.
+type RetryPolicy struct {
+	Attempts      int
+	Backoff       time.Duration
+	MaxBackoff    time.Duration
+	NonIdempotent bool
+}
+
+//BreakerPolicy opens the circuit of a host after Failures consecutive failures:
+//requests to it fail with ErrCircuitOpen for Cooldown, then a single request is
+//let through to probe it. Failures of 0 disables the breaker.
+type BreakerPolicy struct {
+	Failures int
+	Cooldown time.Duration
+}
+
+//HedgePolicy sends a GET to the next of Replicas (base URLs) whenever the previous
+//request took longer than Delay or failed, and uses the first answer.
+type HedgePolicy struct {
+	Replicas []string
+	Delay    time.Duration
+}
+
+var (
+	DefaultRetryPolicy   = RetryPolicy{Attempts: 3, Backoff: 50 * time.Millisecond, MaxBackoff: time.Second}
+	DefaultBreakerPolicy = BreakerPolicy{Failures: 5, Cooldown: 10 * time.Second}
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+//SetRetryPolicy replaces DefaultRetryPolicy. It must be called before the client is used.
+func (c *Client) SetRetryPolicy(p RetryPolicy) {
+	c.retry = p
+}
+
+//SetBreakerPolicy replaces DefaultBreakerPolicy. It must be called before the client is used.
+func (c *Client) SetBreakerPolicy(p BreakerPolicy) {
+	c.breakerPolicy = p
+}
+
+//SetHedgePolicy enables hedged reads. It must be called before the client is used.
+func (c *Client) SetHedgePolicy(p HedgePolicy) {
+	c.hedge = p
+}
+
+//retryable reports whether err may go away when the request is repeated.
+func retryable(err error) bool {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrCircuitOpen) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		switch se.Code {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+//withRetry calls attempt until it succeeds, fails for good or the policy gives up.
+func (c *Client) withRetry(ctx context.Context, method string, attempt func() error) error {
+	p := c.retry
+	if !idempotent(method) && !p.NonIdempotent {
+		p.Attempts = 1
+	}
+	backoff := p.Backoff
+	for i := 1; ; i++ {
+		err := attempt()
+		if err == nil || i >= p.Attempts || !retryable(err) {
+			return err
+		}
+		wait := backoff
+		if wait > 0 {
+			//jitter keeps clients failing together from retrying together
+			wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		if backoff *= 2; p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+//breaker tracks consecutive failures of a host.
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+//breakers holds the breaker of every host the client talks to.
+type breakers struct {
+	mu    sync.Mutex
+	hosts map[string]*breaker
+}
+
+//allow reports whether a request to host may be sent.
+func (c *Client) allow(host string) bool {
+	p := c.breakerPolicy
+	if p.Failures <= 0 {
+		return true
+	}
+	c.breakers.mu.Lock()
+	defer c.breakers.mu.Unlock()
+	b := c.breakers.hosts[host]
+	if b == nil || b.failures < p.Failures {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+//record updates the breaker of host with the outcome of a request.
+func (c *Client) record(host string, err error) {
+	p := c.breakerPolicy
+	if p.Failures <= 0 {
+		return
+	}
+	c.breakers.mu.Lock()
+	defer c.breakers.mu.Unlock()
+	if c.breakers.hosts == nil {
+		c.breakers.hosts = make(map[string]*breaker)
+	}
+	b := c.breakers.hosts[host]
+	if b == nil {
+		b = &breaker{}
+		c.breakers.hosts[host] = b
+	}
+	b.probing = false
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		//a slow host is caught by the retries of the caller, not by its deadline
+		return
+	}
+	//missing keys and client errors say nothing about the health of the host
+	if err == nil || !retryable(err) {
+		b.failures = 0
+		return
+	}
+	if b.failures++; b.failures >= p.Failures {
+		b.openUntil = time.Now().Add(p.Cooldown)
+	}
+}
+
+type hedgeResult struct {
+	rep *reply
+	err error
+}
+
+//hedged sends a GET to the base URL and then to the replicas, one more whenever
+//the requests sent so far took longer than the hedge delay or failed.
+func (c *Client) hedged(ctx context.Context, req request) (*reply, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	hosts := append([]string{c.baseURL}, c.hedge.Replicas...)
+	results := make(chan hedgeResult, len(hosts))
+	start := func(host string) {
+		go func() {
+			rep, err := c.send(ctx, host, req)
+			results <- hedgeResult{rep, err}
+		}()
+	}
+
+	start(hosts[0])
+	sent, pending := 1, 1
+	var err error
+	for pending > 0 {
+		var timer <-chan time.Time
+		if sent < len(hosts) {
+			timer = time.After(c.hedge.Delay)
+		}
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil || !retryable(res.err) {
+				return res.rep, res.err
+			}
+			err = res.err
+		case <-timer:
+		}
+		if sent < len(hosts) {
+			start(hosts[sent])
+			sent++
+			pending++
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,240 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//failingServer answers the first failures requests with status and the
+//others with a value, and counts the requests.
+func failingServer(failures int, status int) (*httptest.Server, *int64) {
+	n := new(int64)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(n, 1) <= int64(failures) {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error":"failed"}`))
+			return
+		}
+		w.Write([]byte(`{"value":"v","version":1}`))
+	}))
+	return ts, n
+}
+
+func newTestClient(base string) *Client {
+	c := New(base)
+	c.SetRetryPolicy(RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond})
+	c.SetBreakerPolicy(BreakerPolicy{})
+	return c
+}
+
+func TestClient_Retry(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name     string
+		failures int
+		status   int
+		method   string
+		requests int64
+		code     int
+	}{
+		{"recovers", 2, http.StatusServiceUnavailable, http.MethodGet, 3, 0},
+		{"gives up", 5, http.StatusServiceUnavailable, http.MethodGet, 3, http.StatusServiceUnavailable},
+		{"rate limited", 1, http.StatusTooManyRequests, http.MethodPut, 2, 0},
+		{"client error", 1, http.StatusBadRequest, http.MethodGet, 1, http.StatusBadRequest},
+		{"server error", 1, http.StatusInternalServerError, http.MethodGet, 1, http.StatusInternalServerError},
+		{"not idempotent", 1, http.StatusServiceUnavailable, http.MethodPost, 1, http.StatusServiceUnavailable},
+	}
+	for _, c := range cases {
+		ts, n := failingServer(c.failures, c.status)
+		err := newTestClient(ts.URL).do(ctx, c.method, "/items/k", nil, nil)
+		ts.Close()
+		var se *StatusError
+		if c.code == 0 && err != nil || c.code != 0 && (!errors.As(err, &se) || se.Code != c.code) {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+		if *n != c.requests {
+			t.Errorf("%s: %d requests were sent, want %d", c.name, *n, c.requests)
+		}
+	}
+
+	//non-idempotent requests are retried when the policy allows it
+	ts, n := failingServer(1, http.StatusServiceUnavailable)
+	defer ts.Close()
+	cl := newTestClient(ts.URL)
+	cl.retry.NonIdempotent = true
+	if err := cl.do(ctx, http.MethodPost, "/batch", nil, nil); err != nil || *n != 2 {
+		t.Errorf("POST was not retried: %v, %d requests", err, *n)
+	}
+
+	//missing keys aren't retried
+	nf := int64(0)
+	ts404 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&nf, 1)
+		http.NotFound(w, r)
+	}))
+	defer ts404.Close()
+	if _, err := newTestClient(ts404.URL).Get(ctx, "k"); !errors.Is(err, ErrNotFound) || nf != 1 {
+		t.Errorf("missing key returned %v after %d requests", err, nf)
+	}
+}
+
+func TestClient_RetryStopsWithContext(t *testing.T) {
+	ts, n := failingServer(10, http.StatusServiceUnavailable)
+	defer ts.Close()
+	c := New(ts.URL)
+	c.SetRetryPolicy(RetryPolicy{Attempts: 10, Backoff: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	var se *StatusError
+	if _, err := c.Get(ctx, "k"); !errors.As(err, &se) || *n != 1 {
+		t.Errorf("unexpected result: %v after %d requests", err, *n)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("backoff outlived the context")
+	}
+}
+
+func TestClient_Breaker(t *testing.T) {
+	ctx := context.Background()
+	failing := int64(1)
+	n := int64(0)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&n, 1)
+		if atomic.LoadInt64(&failing) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"value":"v","version":1}`))
+	}))
+	defer ts.Close()
+	c := New(ts.URL)
+	c.SetRetryPolicy(RetryPolicy{Attempts: 1})
+	c.SetBreakerPolicy(BreakerPolicy{Failures: 2, Cooldown: 50 * time.Millisecond})
+
+	//closed: failures are counted
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get(ctx, "k"); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("circuit opened after %d failures", i)
+		}
+	}
+	//open: requests fail without being sent
+	if _, err := c.Get(ctx, "k"); !errors.Is(err, ErrCircuitOpen) || n != 2 {
+		t.Fatalf("open circuit returned %v, %d requests were sent", err, n)
+	}
+	if retryable(ErrCircuitOpen) {
+		t.Error("open circuit is retried")
+	}
+
+	//half-open: a single probe is let through; its failure opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	if !c.allow(ts.URL) || c.allow(ts.URL) {
+		t.Error("not exactly one probe was allowed")
+	}
+	c.record(ts.URL, &StatusError{Code: http.StatusBadGateway})
+	if c.allow(ts.URL) {
+		t.Error("failed probe didn't open the circuit")
+	}
+
+	//a successful probe closes it
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt64(&failing, 0)
+	if _, err := c.Get(ctx, "k"); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if _, err := c.Get(ctx, "k"); err != nil {
+		t.Errorf("circuit didn't close after the probe: %v", err)
+	}
+
+	//missing keys, client errors and canceled requests don't count as failures
+	c.record(ts.URL, &StatusError{Code: http.StatusBadGateway})
+	c.record(ts.URL, ErrNotFound)
+	c.record(ts.URL, &StatusError{Code: http.StatusBadGateway})
+	c.record(ts.URL, context.Canceled)
+	if !c.allow(ts.URL) {
+		t.Error("circuit opened on failures which weren't consecutive")
+	}
+
+	//breakers are per host
+	c.record("http://other", &StatusError{Code: http.StatusBadGateway})
+	c.record("http://other", &StatusError{Code: http.StatusBadGateway})
+	if c.allow("http://other") || !c.allow(ts.URL) {
+		t.Error("breakers aren't per host")
+	}
+}
+
+func TestClient_Hedge(t *testing.T) {
+	ctx := context.Background()
+	reply := func(value string, delay time.Duration, status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+			w.Write([]byte(`{"value":"` + value + `","version":1}`))
+		}))
+	}
+	slow := reply("slow", time.Second, http.StatusOK)
+	defer slow.Close()
+	fast := reply("fast", 0, http.StatusOK)
+	defer fast.Close()
+	down := reply("", 0, http.StatusServiceUnavailable)
+	defer down.Close()
+	missing := reply("", 0, http.StatusNotFound)
+	defer missing.Close()
+
+	get := func(primary string, delay time.Duration, replicas ...string) (string, error) {
+		c := newTestClient(primary)
+		c.SetRetryPolicy(RetryPolicy{Attempts: 1})
+		c.SetHedgePolicy(HedgePolicy{Replicas: replicas, Delay: delay})
+		v, err := c.Get(ctx, "k")
+		return string(v.Raw), err
+	}
+
+	start := time.Now()
+	if v, err := get(slow.URL, 10*time.Millisecond, fast.URL); err != nil || v != `"fast"` {
+		t.Errorf("slow primary: %s, %v", v, err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("read waited for the slow primary")
+	}
+	//failures are hedged right away
+	start = time.Now()
+	if v, err := get(down.URL, time.Hour, down.URL+"/", fast.URL); err != nil || v != `"fast"` {
+		t.Errorf("failing primary: %s, %v", v, err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("failure waited for the hedge delay")
+	}
+	//answers which aren't failures of the host are final
+	if _, err := get(missing.URL, time.Hour, fast.URL); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing key returned %v", err)
+	}
+	var se *StatusError
+	if _, err := get(down.URL, time.Millisecond, down.URL+"/"); !errors.As(err, &se) || se.Code != http.StatusServiceUnavailable {
+		t.Errorf("failing replicas returned %v", err)
+	}
+	//writes aren't hedged
+	n := int64(0)
+	counted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&n, 1)
+	}))
+	defer counted.Close()
+	c := newTestClient(fast.URL)
+	c.SetRetryPolicy(RetryPolicy{Attempts: 1})
+	c.SetHedgePolicy(HedgePolicy{Replicas: []string{counted.URL}, Delay: time.Millisecond})
+	c.Set(ctx, "k", "v", 0)
+	if n != 0 {
+		t.Error("write was hedged")
+	}
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//TransportConfig tunes the connection pool of a client.
+//
+//ReadTimeout bounds the wait for the response headers after a request is sent;
+//it applies to the streaming calls like Migrate and ImportRDB too, so it is off
+//by default. Timeout bounds whole requests except the streaming calls.
+//HTTP2 is only negotiated with servers behind TLS; plain HTTP always uses HTTP/1.1.
+type TransportConfig struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+	DialTimeout         time.Duration
+	KeepAlive           time.Duration
+	ReadTimeout         time.Duration
+	Timeout             time.Duration
+	HTTP2               bool
+}
+
+//DefaultTransportConfig keeps enough idle connections for a busy client to
+//never dial on the hot path and fails fast on unreachable servers.
+var DefaultTransportConfig = TransportConfig{
+	MaxIdleConns:        256,
+	MaxIdleConnsPerHost: 64,
+	IdleConnTimeout:     90 * time.Second,
+	DialTimeout:         2 * time.Second,
+	KeepAlive:           30 * time.Second,
+	Timeout:             10 * time.Second,
+}
+
+//PoolStats counts the connections of a client.
+//Reused requests went over a connection kept alive from an earlier request.
+type PoolStats struct {
+	Open       int64
+	Dials      int64
+	DialErrors int64
+	Requests   int64
+	Reused     int64
+}
+
+//poolStats is updated by the dialer and the request traces of a client.
+type poolStats struct {
+	open, dials, dialErrors, requests, reused int64
+}
+
+//countedConn decrements the open connections once when closed.
+type countedConn struct {
+	net.Conn
+	once  sync.Once
+	stats *poolStats
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		atomic.AddInt64(&c.stats.open, -1)
+	})
+	return c.Conn.Close()
+}
+
+func newTransport(cfg TransportConfig, stats *poolStats) *http.Transport {
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: cfg.KeepAlive}
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt64(&stats.dials, 1)
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				atomic.AddInt64(&stats.dialErrors, 1)
+				return nil, err
+			}
+			atomic.AddInt64(&stats.open, 1)
+			return &countedConn{Conn: conn, stats: stats}, nil
+		},
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		ResponseHeaderTimeout: cfg.ReadTimeout,
+		TLSHandshakeTimeout:   cfg.DialTimeout,
+		ForceAttemptHTTP2:     cfg.HTTP2,
+	}
+	if !cfg.HTTP2 {
+		//a non-nil empty map turns HTTP/2 off for TLS too
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
+
+//SetTransport replaces the connection pool of the client, which starts with
+//DefaultTransportConfig. It must be called before the client is used.
+func (c *Client) SetTransport(cfg TransportConfig) {
+	if c.httpClient != nil {
+		c.httpClient.CloseIdleConnections()
+	}
+	c.httpClient = &http.Client{Transport: newTransport(cfg, c.pool), Timeout: cfg.Timeout}
+}
+
+//PoolStats returns the connection counters of the client.
+func (c *Client) PoolStats() PoolStats {
+	return PoolStats{
+		Open:       atomic.LoadInt64(&c.pool.open),
+		Dials:      atomic.LoadInt64(&c.pool.dials),
+		DialErrors: atomic.LoadInt64(&c.pool.dialErrors),
+		Requests:   atomic.LoadInt64(&c.pool.requests),
+		Reused:     atomic.LoadInt64(&c.pool.reused),
+	}
+}
+
+//traced counts the connection used by a request in the pool stats.
+func (c *Client) traced(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			atomic.AddInt64(&c.pool.requests, 1)
+			if info.Reused {
+				atomic.AddInt64(&c.pool.reused, 1)
+			}
+		},
+	})
+}
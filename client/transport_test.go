@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClient_PoolStats(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"value":"v","version":1}`))
+	}))
+	defer ts.Close()
+	c := New(ts.URL)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(ctx, "k"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := c.PoolStats(); st != (PoolStats{Open: 1, Dials: 1, Requests: 3, Reused: 2}) {
+		t.Errorf("unexpected stats after sequential requests: %+v", st)
+	}
+
+	c.httpClient.CloseIdleConnections()
+	if st := c.PoolStats(); st.Open != 0 || st.Dials != 1 {
+		t.Errorf("unexpected stats after closing the idle connections: %+v", st)
+	}
+
+	//replacing the transport keeps the counters
+	c.SetTransport(DefaultTransportConfig)
+	if _, err := c.Get(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if st := c.PoolStats(); st != (PoolStats{Open: 1, Dials: 2, Requests: 4, Reused: 2}) {
+		t.Errorf("unexpected stats after replacing the transport: %+v", st)
+	}
+}
+
+func TestClient_PoolStatsDialErrors(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	c := New("http://" + addr)
+	c.SetRetryPolicy(RetryPolicy{Attempts: 2, Backoff: time.Millisecond})
+	if _, err := c.Get(context.Background(), "k"); err == nil {
+		t.Fatal("request to a closed port succeeded")
+	}
+	if st := c.PoolStats(); st != (PoolStats{Dials: 2, DialErrors: 2}) {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
+
+func TestClient_MaxConnsPerHost(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"value":"v","version":1}`))
+	}))
+	defer ts.Close()
+	c := New(ts.URL)
+	cfg := DefaultTransportConfig
+	cfg.MaxConnsPerHost = 2
+	c.SetTransport(cfg)
+
+	done := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			_, err := c.Get(context.Background(), "k")
+			done <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if st := c.PoolStats(); st.Open != 2 || st.Dials != 2 {
+		t.Errorf("connections exceed MaxConnsPerHost: %+v", st)
+	}
+	close(release)
+	for i := 0; i < 4; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+	if st := c.PoolStats(); st.Requests != 4 || st.Reused != 2 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
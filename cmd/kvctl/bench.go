@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/client"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type benchResult struct {
+	latencies []time.Duration
+	reads     int
+	writes    int
+	misses    int
+	errors    int
+}
+
+//bench drives a read/write mix against a server and reports throughput and latency percentiles.
+func bench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	addr := fs.String("addr", "http://localhost:8080", "target instance")
+	duration := fs.Duration("duration", 10*time.Second, "how long to run")
+	concurrency := fs.Int("concurrency", 16, "number of concurrent clients")
+	keys := fs.Int("keys", 10000, "number of distinct keys")
+	reads := fs.Float64("reads", 0.9, "fraction of operations which are reads")
+	dist := fs.String("dist", "uniform", "key distribution: uniform or zipf")
+	zipfS := fs.Float64("zipf-s", 1.1, "zipf exponent, must be > 1")
+	valueSize := fs.Int("value-size", 100, "value size in bytes")
+	prefill := fs.Bool("prefill", true, "write all keys before measuring")
+	fs.Parse(args)
+
+	if *dist != "uniform" && *dist != "zipf" {
+		return fmt.Errorf("bench: unknown distribution %s", *dist)
+	}
+	if *keys <= 0 || *concurrency <= 0 || *reads < 0 || *reads > 1 {
+		return errors.New("bench: keys and concurrency must be positive and reads within [0, 1]")
+	}
+	c := client.New(*addr)
+	tc := client.DefaultTransportConfig
+	tc.MaxIdleConnsPerHost = *concurrency
+	c.SetTransport(tc)
+	value := strings.Repeat("x", *valueSize)
+	key := func(i uint64) string {
+		return fmt.Sprintf("bench:%d", i)
+	}
+
+	ctx := context.Background()
+	if *prefill {
+		for i := 0; i < *keys; i++ {
+			if err := c.Set(ctx, key(uint64(i)), value, -1); err != nil {
+				return fmt.Errorf("bench: prefill: %w", err)
+			}
+		}
+	}
+
+	results := make([]benchResult, *concurrency)
+	deadline := time.Now().Add(*duration)
+	wg := sync.WaitGroup{}
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func(res *benchResult, seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			next := func() uint64 {
+				return uint64(rnd.Intn(*keys))
+			}
+			if *dist == "zipf" {
+				next = rand.NewZipf(rnd, *zipfS, 1, uint64(*keys-1)).Uint64
+			}
+
+			for time.Now().Before(deadline) {
+				k := key(next())
+				start := time.Now()
+				var err error
+				if rnd.Float64() < *reads {
+					res.reads++
+					if _, err = c.Get(ctx, k); errors.Is(err, client.ErrNotFound) {
+						res.misses++
+						err = nil
+					}
+				} else {
+					res.writes++
+					err = c.Set(ctx, k, value, -1)
+				}
+				res.latencies = append(res.latencies, time.Since(start))
+				if err != nil {
+					res.errors++
+				}
+			}
+		}(&results[w], time.Now().UnixNano()+int64(w))
+	}
+	wg.Wait()
+
+	total := benchResult{}
+	for _, res := range results {
+		total.latencies = append(total.latencies, res.latencies...)
+		total.reads += res.reads
+		total.writes += res.writes
+		total.misses += res.misses
+		total.errors += res.errors
+	}
+	printBench(os.Stdout, total, *duration)
+	pool := c.PoolStats()
+	fmt.Printf("conns:      %d dialed, %d of %d requests reused one\n", pool.Dials, pool.Reused, pool.Requests)
+	return nil
+}
+
+func printBench(w io.Writer, res benchResult, d time.Duration) {
+	n := len(res.latencies)
+	fmt.Fprintf(w, "ops:        %d (%d reads, %d writes)\n", n, res.reads, res.writes)
+	fmt.Fprintf(w, "throughput: %.0f ops/s\n", float64(n)/d.Seconds())
+	fmt.Fprintf(w, "misses:     %d\n", res.misses)
+	fmt.Fprintf(w, "errors:     %d\n", res.errors)
+	if n == 0 {
+		return
+	}
+
+	sort.Slice(res.latencies, func(i, j int) bool {
+		return res.latencies[i] < res.latencies[j]
+	})
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		fmt.Fprintf(w, "%-12s%s\n", fmt.Sprintf("p%v:", p), res.latencies[int(float64(n-1)*p/100)])
+	}
+	fmt.Fprintf(w, "max:        %s\n", res.latencies[n-1])
+}
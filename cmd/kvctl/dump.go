@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const dumpUsage = `usage: kvctl dump <command> [flags]
+
+commands:
+  list     print keys, optionally matching -pattern
+  get      print a single item as JSON
+  delete   delete keys matching -pattern from the file
+  convert  convert between the db format (gob) and JSON lines
+`
+
+//dumpItem is an item in JSON lines dumps. Values of storage specific types
+//such as counters and streams are converted to plain JSON.
+type dumpItem struct {
+	Key        string      `json:"key"`
+	Value      interface{} `json:"value"`
+	Expiration int64       `json:"expiration,omitempty"`
+	Version    uint64      `json:"version,omitempty"`
+	Sliding    int64       `json:"sliding,omitempty"`
+}
+
+func dump(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, dumpUsage)
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "list":
+		return dumpList(args[1:])
+	case "get":
+		return dumpGet(args[1:])
+	case "delete":
+		return dumpDelete(args[1:])
+	case "convert":
+		return dumpConvert(args[1:])
+	}
+	fmt.Fprint(os.Stderr, dumpUsage)
+	os.Exit(2)
+	return nil
+}
+
+func readDump(filename, format string) (map[string]storage.Item, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch format {
+	case "gob":
+		return storage.ReadSnapshot(f)
+	case "json":
+		items := make(map[string]storage.Item)
+		dec := json.NewDecoder(bufio.NewReader(f))
+		for {
+			di := dumpItem{}
+			if err = dec.Decode(&di); err == io.EOF {
+				return items, nil
+			} else if err != nil {
+				return nil, err
+			}
+			items[di.Key] = storage.Item{
+				Object:     di.Value,
+				Expiration: di.Expiration,
+				Version:    di.Version,
+				Sliding:    di.Sliding,
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown format %s", format)
+}
+
+//writeDump replaces filename atomically, so a failed write never leaves a truncated file.
+func writeDump(filename, format string, items map[string]storage.Item) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	switch format {
+	case "gob":
+		err = storage.WriteSnapshot(w, items)
+	case "json":
+		enc := json.NewEncoder(w)
+		for _, k := range sortedKeys(items, "*") {
+			item := items[k]
+			if err = enc.Encode(dumpItem{
+				Key:        k,
+				Value:      item.Object,
+				Expiration: item.Expiration,
+				Version:    item.Version,
+				Sliding:    item.Sliding,
+			}); err != nil {
+				break
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown format %s", format)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+func sortedKeys(items map[string]storage.Item, pattern string) []string {
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		if storage.MatchPattern(pattern, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func dumpList(args []string) error {
+	fs := flag.NewFlagSet("dump list", flag.ExitOnError)
+	file := fs.String("file", "db.dat", "db file")
+	format := fs.String("format", "gob", "file format: gob or json")
+	pattern := fs.String("pattern", "*", "glob pattern of keys")
+	fs.Parse(args)
+
+	items, err := readDump(*file, *format)
+	if err != nil {
+		return err
+	}
+	for _, k := range sortedKeys(items, *pattern) {
+		fmt.Printf("%q\n", k)
+	}
+	return nil
+}
+
+func dumpGet(args []string) error {
+	fs := flag.NewFlagSet("dump get", flag.ExitOnError)
+	file := fs.String("file", "db.dat", "db file")
+	format := fs.String("format", "gob", "file format: gob or json")
+	key := fs.String("key", "", "key to print")
+	fs.Parse(args)
+
+	items, err := readDump(*file, *format)
+	if err != nil {
+		return err
+	}
+	item, ok := items[*key]
+	if !ok {
+		return fmt.Errorf("no such key %q", *key)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dumpItem{
+		Key:        *key,
+		Value:      item.Object,
+		Expiration: item.Expiration,
+		Version:    item.Version,
+		Sliding:    item.Sliding,
+	})
+}
+
+func dumpDelete(args []string) error {
+	fs := flag.NewFlagSet("dump delete", flag.ExitOnError)
+	file := fs.String("file", "db.dat", "db file")
+	format := fs.String("format", "gob", "file format: gob or json")
+	pattern := fs.String("pattern", "", "glob pattern of keys to delete")
+	fs.Parse(args)
+	if *pattern == "" {
+		return fmt.Errorf("dump delete: -pattern is required")
+	}
+
+	items, err := readDump(*file, *format)
+	if err != nil {
+		return err
+	}
+	keys := sortedKeys(items, *pattern)
+	for _, k := range keys {
+		delete(items, k)
+	}
+	if err = writeDump(*file, *format, items); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "deleted %d keys\n", len(keys))
+	return nil
+}
+
+func dumpConvert(args []string) error {
+	fs := flag.NewFlagSet("dump convert", flag.ExitOnError)
+	file := fs.String("file", "db.dat", "input file")
+	from := fs.String("from", "gob", "input format: gob or json")
+	out := fs.String("out", "", "output file")
+	to := fs.String("to", "json", "output format: gob or json")
+	fs.Parse(args)
+	if *out == "" {
+		return fmt.Errorf("dump convert: -out is required")
+	}
+
+	items, err := readDump(*file, *from)
+	if err != nil {
+		return err
+	}
+	return writeDump(*out, *to, items)
+}
+
+func dumpInspect(args []string) error {
+	fs := flag.NewFlagSet("dump-inspect", flag.ExitOnError)
+	file := fs.String("file", "db.dat", "db file")
+	samples := fs.Int("samples", 10, "number of keys to print")
+	fs.Parse(args)
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info := storage.InspectSnapshot(f, *samples)
+	fmt.Printf("version: %d\n", info.Version)
+	fmt.Printf("items:   %d\n", info.Items)
+	for _, k := range info.Samples {
+		fmt.Printf("  %q\n", k)
+	}
+	if info.Error != "" {
+		return fmt.Errorf("%s is corrupt: %s", *file, info.Error)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/bulbetski/kvstorage-srv/client"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"os"
+	"path/filepath"
+)
+
+const usage = `usage: kvctl <command> [flags]
+
+commands:
+  migrate       copy all items with their TTLs from one instance to another
+  import-rdb    load string keys from a Redis RDB dump into an instance or a db file
+  bench         measure throughput and latency of an instance
+  backup        download a verified snapshot of an instance into a db file
+  dump-inspect  print format version, item count and sample keys of a db file
+  dump          list, get, delete or convert items of a db file offline
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "migrate":
+		err = migrate(os.Args[2:])
+	case "import-rdb":
+		err = importRDB(os.Args[2:])
+	case "bench":
+		err = bench(os.Args[2:])
+	case "backup":
+		err = backup(os.Args[2:])
+	case "dump-inspect":
+		err = dumpInspect(os.Args[2:])
+	case "dump":
+		err = dump(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kvctl:", err)
+		os.Exit(1)
+	}
+}
+
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "http://localhost:8080", "source instance")
+	to := fs.String("to", "", "target instance")
+	rate := fs.Int("rate", 0, "items per second, 0 for unlimited")
+	fs.Parse(args)
+	if *to == "" {
+		return fmt.Errorf("migrate: -to is required")
+	}
+
+	return client.New(*from).Migrate(context.Background(), *to, *rate, func(p client.MigrateProgress) {
+		fmt.Fprintf(os.Stderr, "\rmigrated %d/%d", p.Migrated, p.Total)
+		if p.Done {
+			fmt.Fprintln(os.Stderr)
+		}
+	})
+}
+
+//importRDB uploads the dump to a running instance, or with -out converts it
+//offline into a db file which the server loads on start.
+func importRDB(args []string) error {
+	fs := flag.NewFlagSet("import-rdb", flag.ExitOnError)
+	file := fs.String("file", "dump.rdb", "redis dump")
+	to := fs.String("to", "", "target instance")
+	out := fs.String("out", "", "db file to write instead of uploading")
+	fs.Parse(args)
+	if (*to == "") == (*out == "") {
+		return fmt.Errorf("import-rdb: exactly one of -to and -out is required")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var stats storage.RDBStats
+	if *out != "" {
+		s := storage.New(storage.NoExpiration, 0, 0)
+		if stats, err = s.ImportRDB(f); err != nil {
+			return err
+		}
+		err = s.SaveFile(*out)
+	} else {
+		var cs client.RDBStats
+		cs, err = client.New(*to).ImportRDB(context.Background(), f)
+		stats = storage.RDBStats(cs)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %d keys, skipped %d expired and %d of other types\n",
+		stats.Imported, stats.Expired, stats.Skipped)
+	return nil
+}
+
+//backup downloads a snapshot next to -out and only replaces it once the
+//checksum is verified. The file can be loaded by the server as its db file.
+func backup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	from := fs.String("from", "http://localhost:8080", "instance to back up")
+	out := fs.String("out", "", "db file to write")
+	fs.Parse(args)
+	if *out == "" {
+		return fmt.Errorf("backup: -out is required")
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(*out), filepath.Base(*out)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = client.New(*from).Snapshot(context.Background(), f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err = storage.VerifyChecksumFile(f.Name()); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	return os.Rename(f.Name(), *out)
+}
@@ -1,8 +1,8 @@
 module github.com/bulbetski/kvstorage-srv
 
-go 1.16
+go 1.18
 
 require (
-	github.com/BurntSushi/toml v0.3.1 // indirect
-	github.com/gorilla/mux v1.8.0 // indirect
+	github.com/BurntSushi/toml v0.3.1
+	github.com/gorilla/mux v1.8.0
 )
@@ -0,0 +1,111 @@
+//Package kvstoragetest runs kvstorage-srv in process, so that services using it
+//can be tested without starting the real binary.
+package kvstoragetest
+
+import (
+	"context"
+	"github.com/bulbetski/kvstorage-srv/api"
+	"github.com/bulbetski/kvstorage-srv/client"
+	"github.com/bulbetski/kvstorage-srv/storage"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+//Clock is a manually advanced clock which controls expiration of items.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+//Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+//Server is an in-process kvstorage-srv. Storage can be used to prepare and
+//inspect data directly, Clock to expire items without waiting.
+type Server struct {
+	*httptest.Server
+	Storage *storage.Storage
+	Clock   *Clock
+	Client  *client.Client
+}
+
+//NewServer starts a server with an empty storage which is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	clock := NewClock(time.Now())
+	s := storage.New(storage.NoExpiration, 0, 0)
+	s.SetClock(clock)
+
+	hs := httptest.NewServer(api.Handler(s))
+	t.Cleanup(hs.Close)
+	return &Server{
+		Server:  hs,
+		Storage: s,
+		Clock:   clock,
+		Client:  client.New(hs.URL),
+	}
+}
+
+//AssertValue fails the test unless key holds want.
+func (s *Server) AssertValue(t testing.TB, key string, want interface{}) {
+	t.Helper()
+	v, found := s.Storage.Get(key)
+	if !found {
+		t.Errorf("kvstoragetest: key %q not found, expected %v", key, want)
+		return
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("kvstoragetest: key %q holds %v, expected %v", key, v, want)
+	}
+}
+
+//AssertMissing fails the test if key exists.
+func (s *Server) AssertMissing(t testing.TB, key string) {
+	t.Helper()
+	if v, found := s.Storage.Get(key); found {
+		t.Errorf("kvstoragetest: key %q holds %v, expected it to be missing", key, v)
+	}
+}
+
+//AssertTTL fails the test unless key expires in want according to the server clock.
+//A negative want means the key must not expire.
+func (s *Server) AssertTTL(t testing.TB, key string, want time.Duration) {
+	t.Helper()
+	item, found := s.Storage.Items()[key]
+	if !found {
+		t.Errorf("kvstoragetest: key %q not found", key)
+		return
+	}
+	ttl := time.Duration(-1)
+	if item.Expiration > 0 {
+		ttl = time.Unix(0, item.Expiration).Sub(s.Clock.Now())
+	}
+	if want < 0 && ttl >= 0 || want >= 0 && ttl != want {
+		t.Errorf("kvstoragetest: key %q has ttl %s, expected %s", key, ttl, want)
+	}
+}
+
+//Set stores value through the API, failing the test on error.
+func (s *Server) Set(t testing.TB, key, value string, ttl time.Duration) {
+	t.Helper()
+	if err := s.Client.Set(context.Background(), key, value, ttl); err != nil {
+		t.Fatalf("kvstoragetest: set %q: %v", key, err)
+	}
+}
@@ -0,0 +1,26 @@
+package kvstoragetest
+
+import (
+	"context"
+	"errors"
+	"github.com/bulbetski/kvstorage-srv/client"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	srv := NewServer(t)
+	srv.Set(t, "session:1", "alice", time.Hour)
+	srv.Set(t, "config", "on", -1)
+
+	srv.AssertValue(t, "session:1", "alice")
+	srv.AssertTTL(t, "session:1", time.Hour)
+	srv.AssertTTL(t, "config", -1)
+
+	srv.Clock.Advance(time.Hour + time.Second)
+	srv.AssertMissing(t, "session:1")
+	if _, err := srv.Client.Get(context.Background(), "session:1"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("expired key returned %v", err)
+	}
+	srv.AssertValue(t, "config", "on")
+}
@@ -1,18 +1,24 @@
 package main
 
 import (
+	"flag"
 	"github.com/BurntSushi/toml"
 	"github.com/bulbetski/kvstorage-srv/api"
 	"log"
 )
 
 func main() {
+	force := flag.Bool("force", false, "start even if another instance seems to use the db file, e.g. after a crash")
+	flag.Parse()
+
 	config := api.NewConfig()
 	_, err := toml.DecodeFile("configs/db_conf.toml", config)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	config.Force = *force
+
 	if err := api.Start(config); err != nil {
 		log.Fatal(err)
 	}
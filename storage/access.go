@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//DefaultAccessWindows is how many intervals of access counts TrackAccess keeps
+//when it is given no number.
+const DefaultAccessWindows = 60
+
+//AccessCounts are the lookups and writes of the keys of a prefix. Reads include
+//the Misses, which found no live item; deletions count as writes.
+type AccessCounts struct {
+	Prefix string `json:"prefix"`
+	Reads  uint64 `json:"reads"`
+	Writes uint64 `json:"writes"`
+	Misses uint64 `json:"misses"`
+}
+
+//Total is the number of reads and writes.
+func (c AccessCounts) Total() uint64 {
+	return c.Reads + c.Writes
+}
+
+//AccessWindow holds the counts of the interval starting at Start, ordered by
+//Total with the busiest prefix first.
+type AccessWindow struct {
+	Start    time.Time      `json:"start"`
+	Prefixes []AccessCounts `json:"prefixes"`
+}
+
+type accessKind int
+
+const (
+	accessRead accessKind = iota
+	accessMiss
+	accessWrite
+)
+
+type accessWindow struct {
+	start    int64
+	prefixes map[string]*AccessCounts
+}
+
+//accessLog keeps a window per interval which saw traffic, oldest first. A new
+//window is started by the first access after the interval of the last one.
+type accessLog struct {
+	enabled  int32
+	mu       sync.Mutex
+	interval int64
+	windows  int
+	depth    int
+	log      []*accessWindow
+}
+
+//TrackAccess counts reads, writes and misses per key prefix in windows of
+//interval, keeping the last windows of them (0 means DefaultAccessWindows).
+//The prefix of a key is up to depth of its segments separated by
+//NamespaceSeparator, never the last one: "users:42" is counted under "users"
+//whatever the depth and keys without a separator under "". Like
+//TrackKeyspace it takes a mutex on every access; an interval of 0 disables it
+//and drops the counts.
+func (s *Storage) TrackAccess(interval time.Duration, windows, depth int) {
+	a := &s.access
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.log = nil
+	if interval <= 0 {
+		atomic.StoreInt32(&a.enabled, 0)
+		return
+	}
+	if windows <= 0 {
+		windows = DefaultAccessWindows
+	}
+	if depth <= 0 {
+		depth = 1
+	}
+	a.interval, a.windows, a.depth = int64(interval), windows, depth
+	atomic.StoreInt32(&a.enabled, 1)
+}
+
+//recordAccess counts an access of key if tracking is enabled.
+func (s *Storage) recordAccess(key string, kind accessKind) {
+	if atomic.LoadInt32(&s.access.enabled) == 0 {
+		return
+	}
+	now := s.now()
+	a := &s.access
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.interval == 0 {
+		return
+	}
+	c := a.window(now).counts(accessPrefix(key, a.depth))
+	switch kind {
+	case accessRead:
+		c.Reads++
+	case accessMiss:
+		c.Reads++
+		c.Misses++
+	case accessWrite:
+		c.Writes++
+	}
+}
+
+//window returns the window of now, starting it if needed. Must be called with a.mu held.
+func (a *accessLog) window(now int64) *accessWindow {
+	start := now - now%a.interval
+	if n := len(a.log); n > 0 && a.log[n-1].start >= start {
+		//a clock going back keeps counting in the last window
+		return a.log[n-1]
+	}
+	w := &accessWindow{start: start, prefixes: make(map[string]*AccessCounts)}
+	a.log = append(a.log, w)
+	if len(a.log) > a.windows {
+		a.log[0] = nil
+		a.log = a.log[1:]
+	}
+	return w
+}
+
+//counts bounds the prefixes of a window like the namespaces of Keyspace.
+func (w *accessWindow) counts(prefix string) *AccessCounts {
+	c, ok := w.prefixes[prefix]
+	if ok {
+		return c
+	}
+	if len(w.prefixes) >= MaxKeyspaceNamespaces {
+		prefix = OtherNamespace
+		if c, ok = w.prefixes[prefix]; ok {
+			return c
+		}
+	}
+	c = &AccessCounts{Prefix: prefix}
+	w.prefixes[prefix] = c
+	return c
+}
+
+func accessPrefix(key string, depth int) string {
+	end := 0
+	for i := 0; i < depth; i++ {
+		from := end
+		if i > 0 {
+			from += len(NamespaceSeparator)
+		}
+		j := strings.Index(key[from:], NamespaceSeparator)
+		if j == -1 {
+			break
+		}
+		end = from + j
+	}
+	return key[:end]
+}
+
+//Access returns up to the last n windows of access counts (0 means all of
+//them), oldest first. It returns nil unless TrackAccess is enabled.
+func (s *Storage) Access(n int) []AccessWindow {
+	a := &s.access
+	if atomic.LoadInt32(&a.enabled) == 0 {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	log := a.log
+	if n > 0 && n < len(log) {
+		log = log[len(log)-n:]
+	}
+	res := make([]AccessWindow, 0, len(log))
+	for _, w := range log {
+		prefixes := make([]AccessCounts, 0, len(w.prefixes))
+		for _, c := range w.prefixes {
+			prefixes = append(prefixes, *c)
+		}
+		sortAccess(prefixes)
+		res = append(res, AccessWindow{Start: time.Unix(0, w.start), Prefixes: prefixes})
+	}
+	return res
+}
+
+//SumAccess adds up the counts of windows per prefix, busiest prefix first.
+func SumAccess(windows []AccessWindow) []AccessCounts {
+	byPrefix := make(map[string]*AccessCounts)
+	for _, w := range windows {
+		for _, c := range w.Prefixes {
+			sum, ok := byPrefix[c.Prefix]
+			if !ok {
+				sum = &AccessCounts{Prefix: c.Prefix}
+				byPrefix[c.Prefix] = sum
+			}
+			sum.Reads += c.Reads
+			sum.Writes += c.Writes
+			sum.Misses += c.Misses
+		}
+	}
+	res := make([]AccessCounts, 0, len(byPrefix))
+	for _, c := range byPrefix {
+		res = append(res, *c)
+	}
+	sortAccess(res)
+	return res
+}
+
+func sortAccess(counts []AccessCounts) {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Total() != counts[j].Total() {
+			return counts[i].Total() > counts[j].Total()
+		}
+		return counts[i].Prefix < counts[j].Prefix
+	})
+}
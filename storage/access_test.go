@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStorage_Access(t *testing.T) {
+	c := &fixedClock{now: time.Unix(1000, 0)}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(c)
+	s.Set("users:1", "a", NoExpiration)
+	if s.Access(0) != nil {
+		t.Error("access stats are returned without tracking")
+	}
+	s.TrackAccess(time.Minute, 2, 1)
+
+	s.Set("users:1", "a", NoExpiration)
+	s.Get("users:1")
+	s.Get("users:2")
+	s.Delete("users:1")
+	s.Delete("users:1")
+	s.Get("plain")
+
+	c.now = c.now.Add(time.Minute)
+	s.Set("orders:1", "a", NoExpiration)
+	s.Get("orders:1")
+	s.Get("orders:1")
+
+	windows := s.Access(0)
+	if len(windows) != 2 {
+		t.Fatalf("expected 2 windows, got %+v", windows)
+	}
+	if !windows[0].Start.Equal(time.Unix(960, 0)) || !windows[1].Start.Equal(time.Unix(1020, 0)) {
+		t.Errorf("unexpected window starts: %v, %v", windows[0].Start, windows[1].Start)
+	}
+	first := windows[0].Prefixes
+	if len(first) != 2 || first[0] != (AccessCounts{Prefix: "users", Reads: 2, Writes: 2, Misses: 1}) ||
+		first[1] != (AccessCounts{Prefix: "", Reads: 1, Misses: 1}) {
+		t.Errorf("unexpected counts of the first window: %+v", first)
+	}
+	if got := s.Access(1); len(got) != 1 || got[0].Prefixes[0] != (AccessCounts{Prefix: "orders", Reads: 2, Writes: 1}) {
+		t.Errorf("unexpected last window: %+v", got)
+	}
+
+	totals := SumAccess(windows)
+	if len(totals) != 3 || totals[0].Prefix != "users" || totals[1].Prefix != "orders" {
+		t.Errorf("unexpected totals: %+v", totals)
+	}
+
+	//only the last 2 windows are kept
+	c.now = c.now.Add(time.Minute)
+	s.Get("users:1")
+	if windows = s.Access(0); len(windows) != 2 || windows[0].Prefixes[0].Prefix != "orders" {
+		t.Errorf("the oldest window wasn't dropped: %+v", windows)
+	}
+
+	s.TrackAccess(0, 0, 0)
+	if s.Access(0) != nil {
+		t.Error("access stats are returned after disabling tracking")
+	}
+}
+
+func TestAccessPrefix(t *testing.T) {
+	for _, c := range []struct {
+		key   string
+		depth int
+		exp   string
+	}{
+		{"plain", 1, ""},
+		{"users:42", 1, "users"},
+		{"users:42", 3, "users"},
+		{"users:eu:42", 2, "users:eu"},
+		{"users:eu:42", 1, "users"},
+		{"a:b:c:d", 2, "a:b"},
+	} {
+		if got := accessPrefix(c.key, c.depth); got != c.exp {
+			t.Errorf("prefix of %q at depth %d is %q, expected %q", c.key, c.depth, got, c.exp)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package storage
+
+import "time"
+
+type batchOp struct {
+	key      string
+	value    interface{}
+	duration time.Duration
+	delete   bool
+}
+
+//Batch stages writes which are then applied under a single lock acquisition.
+//It is not safe for concurrent use.
+type Batch struct {
+	s     *Storage
+	guard KeyGuard
+	ops   []batchOp
+}
+
+func (s *Storage) Batch() *Batch {
+	return s.Guard(nil).Batch()
+}
+
+//Batch is Storage.Batch whose Commit fails if the guard rejects one of the keys.
+func (g Guarded) Batch() *Batch {
+	return &Batch{s: g.s, guard: g.guard}
+}
+
+func (b *Batch) Set(key string, value interface{}, duration time.Duration) {
+	b.ops = append(b.ops, batchOp{key: key, value: value, duration: duration})
+}
+
+func (b *Batch) Delete(key string) {
+	b.ops = append(b.ops, batchOp{key: key, delete: true})
+}
+
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+//Commit applies staged operations in order, atomically for readers. Values
+//go through the write hooks of their namespace like in Write. If any of them
+//is rejected or fails schema validation, the guard of the batch rejects a key
+//or the new keys don't fit into the item limit of their namespace (reported as
+//a *NamespaceFullError), nothing is applied.
+//The batch is empty afterwards and can be reused.
+func (b *Batch) Commit() error {
+	s := b.s
+	s.lock("Commit")
+	defer s.mu.Unlock()
+
+	//exists tracks the keys the ops before have set or deleted and added the
+	//items they add to every namespace, so the batch is checked as a whole.
+	//A namespace which evicts has room as long as it holds an evictable item,
+	//which it does once the batch set one.
+	values := make([]interface{}, len(b.ops))
+	exists := make(map[string]bool)
+	added := make(map[string]int)
+	setIn := make(map[string]bool)
+	for i, op := range b.ops {
+		if err := s.Guard(b.guard).check(op.key); err != nil {
+			return err
+		}
+		found, staged := exists[op.key]
+		if !staged {
+			_, found = s.items[op.key]
+		}
+		exists[op.key] = !op.delete
+		ns := Namespace(op.key)
+		if op.delete {
+			if found {
+				added[ns]--
+			}
+			continue
+		}
+		value, err := s.applyWriteHooks(op.key, op.value)
+		if err != nil {
+			return err
+		}
+		if err := s.validateSchema(op.key, value); err != nil {
+			return err
+		}
+		if !found {
+			if err := s.checkNamespaceRoom(ns, added[ns]+1); err == nil {
+				added[ns]++
+			} else if !(setIn[ns] && s.namespaces[ns].opts.Evict) && !s.canEvict(ns, ClassNormal) {
+				return &NamespaceFullError{op.key}
+			}
+		}
+		values[i], setIn[ns] = value, true
+	}
+	for i, op := range b.ops {
+		if op.delete {
+			if s.remove(op.key) {
+				s.recordAccess(op.key, accessWrite)
+			}
+			continue
+		}
+		//checked above, so it finds room
+		s.makeRoom(op.key, ClassNormal)
+		s.set(op.key, values[i], op.duration)
+	}
+	s.maybeShrink()
+	b.ops = b.ops[:0]
+	return nil
+}
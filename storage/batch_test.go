@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestBatch_Commit(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("old", "v", DefaultExpiration)
+
+	b := s.Batch()
+	b.Set("a", "1", DefaultExpiration)
+	b.Set("b", "2", DefaultExpiration)
+	b.Delete("old")
+	b.Set("a", "3", DefaultExpiration)
+	if _, found := s.Get("a"); found {
+		t.Error("staged write is visible before commit")
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := s.Get("a"); v != "3" {
+		t.Errorf("a is not 3: %v", v)
+	}
+	if _, found := s.Get("old"); found {
+		t.Error("old was not deleted")
+	}
+	if b.Len() != 0 {
+		t.Errorf("batch is not empty after commit: %d", b.Len())
+	}
+}
+
+func TestBatch_CommitInvalid(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	sc, _ := ParseSchema([]byte(`{"type":"object"}`))
+	s.SetSchema("ns", sc)
+
+	b := s.Batch()
+	b.Set("a", "1", DefaultExpiration)
+	b.Set("ns:1", "[]", DefaultExpiration)
+	if err := b.Commit(); err == nil {
+		t.Fatal("invalid batch was committed")
+	}
+	if _, found := s.Get("a"); found {
+		t.Error("part of an invalid batch was applied")
+	}
+}
+
+func TestBatch_CommitNamespaceFull(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.SetNamespaceOptions("ns", NamespaceOptions{MaxItems: 2})
+	s.Set("ns:old", "v", DefaultExpiration)
+
+	//each key fits on its own, but not both
+	b := s.Batch()
+	b.Set("ns:1", "v", DefaultExpiration)
+	b.Set("ns:2", "v", DefaultExpiration)
+	var nf *NamespaceFullError
+	if err := b.Commit(); !errors.As(err, &nf) || nf.Key != "ns:2" || !errors.Is(err, ErrNamespaceFull) {
+		t.Fatalf("batch overflowing the namespace was committed: %v", err)
+	}
+	if _, found := s.Get("ns:1"); found {
+		t.Error("part of an overflowing batch was applied")
+	}
+
+	//a deletion in the batch makes room
+	b = s.Batch()
+	b.Delete("ns:old")
+	b.Set("ns:1", "v", DefaultExpiration)
+	b.Set("ns:2", "v", DefaultExpiration)
+	b.Set("ns:1", "w", DefaultExpiration)
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if items, _, _ := s.NamespaceQuota("ns"); items != 2 {
+		t.Errorf("unexpected items: %d", items)
+	}
+}
+
+func TestBatch_CommitEvicts(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.SetNamespaceOptions("ns", NamespaceOptions{MaxItems: 2, Evict: true})
+	s.Write("ns:critical", "v", WriteOptions{Class: ClassCritical})
+	s.Set("ns:old", "v", DefaultExpiration)
+
+	b := s.Batch()
+	b.Set("ns:1", "v", DefaultExpiration)
+	b.Set("ns:2", "v", DefaultExpiration)
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if items, _, _ := s.NamespaceQuota("ns"); items != 2 {
+		t.Errorf("unexpected items: %d", items)
+	}
+	if _, found := s.Get("ns:critical"); !found {
+		t.Error("critical item was evicted")
+	}
+	if _, found := s.Get("ns:2"); !found {
+		t.Error("last item of the batch was evicted")
+	}
+
+	//nothing can be evicted for a new key
+	s.Delete("ns:2")
+	s.Write("ns:2", "v", WriteOptions{Class: ClassCritical})
+	b = s.Batch()
+	b.Set("ns:3", "v", DefaultExpiration)
+	if err := b.Commit(); !errors.Is(err, ErrNamespaceFull) {
+		t.Errorf("batch was committed into a namespace of critical items: %v", err)
+	}
+}
+
+func BenchmarkBatch_Set(b *testing.B) {
+	b.StopTimer()
+	s := New(NoExpiration, 0, 0)
+	batch := s.Batch()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		batch.Set(strconv.Itoa(i%1000), "value", DefaultExpiration)
+		if batch.Len() == 1000 {
+			batch.Commit()
+		}
+	}
+	batch.Commit()
+}
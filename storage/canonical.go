@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//KeyCanon normalizes keys of a namespace on writes and reads, so that keys
+//formatted differently by clients refer to the same item.
+type KeyCanon struct {
+	//Lower folds the key to lower case
+	Lower bool
+	//Trim removes leading and trailing white space
+	Trim bool
+	//NFC composes letters followed by combining marks into precomposed letters.
+	//Without golang.org/x/text only the Latin letters are covered, which are
+	//what keyboards and file systems sending decomposed accents produce.
+	NFC bool
+}
+
+//ParseKeyCanon reads canonicalizations like "lower", "trim" and "nfc".
+func ParseKeyCanon(names []string) (KeyCanon, error) {
+	c := KeyCanon{}
+	for _, name := range names {
+		switch name {
+		case "lower":
+			c.Lower = true
+		case "trim":
+			c.Trim = true
+		case "nfc":
+			c.NFC = true
+		default:
+			return c, fmt.Errorf("unknown key canonicalization %s", name)
+		}
+	}
+	return c, nil
+}
+
+//Apply returns the canonical form of key.
+func (c KeyCanon) Apply(key string) string {
+	if c.Trim {
+		key = strings.TrimSpace(key)
+	}
+	if c.NFC {
+		key = composeLatin(key)
+	}
+	if c.Lower {
+		key = strings.ToLower(key)
+	}
+	return key
+}
+
+//CanonicalKey applies the canonicalization of the namespace of key, see
+//NamespaceOptions.Canon. The namespace is found after trimming leading white
+//space and, for namespaces folding case, regardless of case.
+func (s *Storage) CanonicalKey(key string) string {
+	s.rlock("CanonicalKey")
+	defer s.mu.RUnlock()
+	if s.canonNamespaces == 0 {
+		return key
+	}
+	name := Namespace(strings.TrimLeftFunc(key, unicode.IsSpace))
+	ns, ok := s.namespaces[name]
+	if !ok {
+		if ns, ok = s.namespaces[strings.ToLower(name)]; !ok || !ns.opts.Canon.Lower {
+			return key
+		}
+	}
+	return ns.opts.Canon.Apply(key)
+}
+
+//latinCompositions lists the Latin letters composed with each combining mark
+//as pairs of the base letter and the composed one, with the canonical
+//combining class of the mark, which orders successive marks.
+var latinCompositions = []struct {
+	mark  rune
+	class int
+	pairs string
+}{
+	{0x0300, 230, "AÀEÈIÌOÒUÙaàeèiìoòuùÜǛüǜNǸnǹĒḔēḕŌṐōṑWẀwẁÂẦâầĂẰăằÊỀêềÔỒôồƠỜơờƯỪưừYỲyỳ"},
+	{0x0301, 230, "AÁEÉIÍOÓUÚYÝaáeéiíoóuúyýCĆcćLĹlĺNŃnńRŔrŕSŚsśZŹzźÜǗüǘGǴgǵÅǺåǻÆǼæǽØǾøǿÇḈçḉĒḖēḗÏḮïḯKḰkḱMḾmḿÕṌõṍŌṒōṓPṔpṕŨṸũṹWẂwẃÂẤâấĂẮăắÊẾêếÔỐôốƠỚơớƯỨưứ"},
+	{0x0302, 230, "AÂEÊIÎOÔUÛaâeêiîoôuûCĈcĉGĜgĝHĤhĥJĴjĵSŜsŝWŴwŵYŶyŷZẐzẑẠẬạậẸỆẹệỌỘọộ"},
+	{0x0303, 230, "AÃNÑOÕaãnñoõIĨiĩUŨuũVṼvṽÂẪâẫĂẴăẵEẼeẽÊỄêễÔỖôỗƠỠơỡƯỮưữYỸyỹ"},
+	{0x0304, 230, "AĀaāEĒeēIĪiīOŌoōUŪuūÜǕüǖÄǞäǟȦǠȧǡÆǢæǣǪǬǫǭÖȪöȫÕȬõȭȮȰȯȱYȲyȳGḠgḡḶḸḷḹṚṜṛṝ"},
+	{0x0306, 230, "AĂaăEĔeĕGĞgğIĬiĭOŎoŏUŬuŭȨḜȩḝẠẶạặ"},
+	{0x0307, 230, "CĊcċEĖeėGĠgġIİZŻzżAȦaȧOȮoȯBḂbḃDḊdḋFḞfḟHḢhḣMṀmṁNṄnṅPṖpṗRṘrṙSṠsṡŚṤśṥŠṦšṧṢṨṣṩTṪtṫWẆwẇXẊxẋYẎyẏſẛ"},
+	{0x0308, 230, "AÄEËIÏOÖUÜaäeëiïoöuüyÿYŸHḦhḧÕṎõṏŪṺūṻWẄwẅXẌxẍtẗ"},
+	{0x0309, 230, "AẢaảÂẨâẩĂẲăẳEẺeẻÊỂêểIỈiỉOỎoỏÔỔôổƠỞơởUỦuủƯỬưửYỶyỷ"},
+	{0x030A, 230, "AÅaåUŮuůwẘyẙ"},
+	{0x030B, 230, "OŐoőUŰuű"},
+	{0x030C, 230, "CČcčDĎdďEĚeěLĽlľNŇnňRŘrřSŠsšTŤtťZŽzžAǍaǎIǏiǐOǑoǒUǓuǔÜǙüǚGǦgǧKǨkǩƷǮʒǯjǰHȞhȟ"},
+	{0x030F, 230, "AȀaȁEȄeȅIȈiȉOȌoȍRȐrȑUȔuȕ"},
+	{0x0311, 230, "AȂaȃEȆeȇIȊiȋOȎoȏRȒrȓUȖuȗ"},
+	{0x031B, 216, "OƠoơUƯuư"},
+	{0x0323, 220, "BḄbḅDḌdḍHḤhḥKḲkḳLḶlḷMṂmṃNṆnṇRṚrṛSṢsṣTṬtṭVṾvṿWẈwẉZẒzẓAẠaạEẸeẹIỊiịOỌoọƠỢơợUỤuụƯỰưựYỴyỵ"},
+	{0x0324, 220, "UṲuṳ"},
+	{0x0325, 220, "AḀaḁ"},
+	{0x0326, 220, "SȘsșTȚtț"},
+	{0x0327, 202, "CÇcçGĢgģKĶkķLĻlļNŅnņRŖrŗSŞsşTŢtţEȨeȩDḐdḑHḨhḩ"},
+	{0x0328, 202, "AĄaąEĘeęIĮiįUŲuųOǪoǫ"},
+	{0x032D, 220, "DḒdḓEḘeḙLḼlḽNṊnṋTṰtṱUṶuṷ"},
+	{0x032E, 220, "HḪhḫ"},
+	{0x0330, 220, "EḚeḛIḬiḭUṴuṵ"},
+	{0x0331, 220, "BḆbḇDḎdḏKḴkḵLḺlḻNṈnṉRṞrṟTṮtṯZẔzẕhẖ"},
+}
+
+var (
+	compositions  = make(map[[2]rune]rune)
+	combiningMark = make(map[rune]int)
+)
+
+func init() {
+	for _, c := range latinCompositions {
+		combiningMark[c.mark] = c.class
+		pairs := []rune(c.pairs)
+		for i := 0; i+1 < len(pairs); i += 2 {
+			compositions[[2]rune{pairs[i], c.mark}] = pairs[i+1]
+		}
+	}
+}
+
+//composeLatin composes letters with the marks following them. Marks are put
+//in canonical order first, so that e.g. e with dot below and circumflex
+//composes to the same letter in either order.
+func composeLatin(key string) string {
+	if strings.IndexFunc(key, isCombiningMark) < 0 {
+		return key
+	}
+	runes := []rune(key)
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && isCombiningMark(runes[j]) {
+			j++
+		}
+		if j > i+1 {
+			marks := runes[i:j]
+			sort.SliceStable(marks, func(a, b int) bool {
+				return combiningMark[marks[a]] < combiningMark[marks[b]]
+			})
+		}
+		if j == i {
+			j++
+		}
+		i = j
+	}
+
+	out := make([]rune, 0, len(runes))
+	for _, r := range runes {
+		if n := len(out); n > 0 && isCombiningMark(r) {
+			if composed, ok := compositions[[2]rune{out[n-1], r}]; ok {
+				out[n-1] = composed
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+func isCombiningMark(r rune) bool {
+	_, ok := combiningMark[r]
+	return ok
+}
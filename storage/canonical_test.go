@@ -0,0 +1,62 @@
+package storage
+
+import "testing"
+
+func TestKeyCanon_Apply(t *testing.T) {
+	all := KeyCanon{Lower: true, Trim: true, NFC: true}
+	for _, c := range []struct {
+		canon    KeyCanon
+		key, exp string
+	}{
+		{KeyCanon{}, " Users:Bob ", " Users:Bob "},
+		{KeyCanon{Trim: true}, " Users:Bob\t", "Users:Bob"},
+		{KeyCanon{Lower: true}, "Users:Bob", "users:bob"},
+		{KeyCanon{NFC: true}, "users:José", "users:José"},
+		{KeyCanon{NFC: true}, "users:José", "users:José"},
+		//marks in either order compose to the same letter
+		{KeyCanon{NFC: true}, "ệ", "ệ"},
+		{KeyCanon{NFC: true}, "ệ", "ệ"},
+		{KeyCanon{NFC: true}, "q́", "q́"},
+		{all, "  Users:RENÉ ", "users:rené"},
+	} {
+		if got := c.canon.Apply(c.key); got != c.exp {
+			t.Errorf("%+v: %q became %q, expected %q", c.canon, c.key, got, c.exp)
+		}
+	}
+}
+
+func TestStorage_CanonicalKey(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	if got := s.CanonicalKey(" A "); got != " A " {
+		t.Errorf("key was changed without canonicalization: %q", got)
+	}
+	s.SetNamespaceOptions("users", NamespaceOptions{Canon: KeyCanon{Lower: true, Trim: true}})
+	s.SetNamespaceOptions("orders", NamespaceOptions{Canon: KeyCanon{Trim: true}})
+	for key, exp := range map[string]string{
+		" Users:Bob ": "users:bob",
+		"USERS:Bob":   "users:bob",
+		" orders:A ":  "orders:A",
+		"Orders:A ":   "Orders:A ",
+		" other:A ":   " other:A ",
+	} {
+		if got := s.CanonicalKey(key); got != exp {
+			t.Errorf("%q became %q, expected %q", key, got, exp)
+		}
+	}
+
+	s.SetNamespaceOptions("users", NamespaceOptions{})
+	s.SetNamespaceOptions("orders", NamespaceOptions{})
+	if got := s.CanonicalKey("Users:Bob"); got != "Users:Bob" {
+		t.Errorf("key was changed after removing canonicalization: %q", got)
+	}
+}
+
+func TestParseKeyCanon(t *testing.T) {
+	c, err := ParseKeyCanon([]string{"lower", "nfc"})
+	if err != nil || c != (KeyCanon{Lower: true, NFC: true}) {
+		t.Errorf("unexpected result: %+v, %v", c, err)
+	}
+	if _, err = ParseKeyCanon([]string{"upper"}); err == nil {
+		t.Error("unknown canonicalization was accepted")
+	}
+}
@@ -0,0 +1,127 @@
+package storage
+
+//minShrinkSize keeps small maps from being rebuilt over and over.
+const minShrinkSize = 1024
+
+type Stats struct {
+	Items      int               `json:"items"`
+	Live       int               `json:"live"`
+	Expired    int               `json:"expired"`
+	Capacity   int               `json:"capacity"`
+	Peak       int               `json:"peak"`
+	LoadFactor float64           `json:"load_factor"`
+	Rebuilds   int               `json:"rebuilds"`
+	Janitor    JanitorStats      `json:"janitor"`
+	Evictions  map[string]uint64 `json:"evictions"`
+	//Corrupted counts items which failed their checksum, see SetItemChecksums
+	Corrupted uint64 `json:"corrupted"`
+}
+
+//SetShrinkThreshold makes the storage rebuild its map after deletions when
+//the number of items drops below ratio of the peak size since the last rebuild.
+//Go maps never release buckets, so without it memory stays at the peak. 0 disables it.
+func (s *Storage) SetShrinkThreshold(ratio float64) {
+	s.lock("SetShrinkThreshold")
+	s.shrinkRatio = ratio
+	s.mu.Unlock()
+}
+
+//Flush deletes all items. If reserve is true, the new map is presized to the
+//capacity the storage was created with. Change watchers are told about every
+//deleted key, like for Delete.
+func (s *Storage) Flush(reserve bool) {
+	s.lock("Flush")
+	defer s.mu.Unlock()
+
+	size := 0
+	if reserve {
+		size = s.capacity
+	}
+	s.dirty += uint64(len(s.items))
+	if s.changed != nil || len(s.changeWatches) > 0 {
+		for key := range s.items {
+			s.trackChange(key)
+			s.notifyChange(key, nil)
+		}
+	}
+	s.items = make(map[string]Item, size)
+	s.peak = 0
+	//a running Compact would bring the items back
+	s.compaction = nil
+	s.expiry = newExpiryTracker()
+	s.scheduled = make(map[string]int64)
+	s.keyEvents = nil
+	if s.overflow != nil {
+		s.overflow.clear()
+	}
+	for _, ns := range s.namespaces {
+		ns.items = 0
+	}
+	for _, indexes := range s.indexes {
+		for _, idx := range indexes {
+			idx.entries = make(map[string]map[string]struct{})
+		}
+	}
+	if s.search != nil {
+		s.search = &searchIndex{
+			postings: make(map[string]map[string]int),
+			lengths:  make(map[string]int),
+		}
+	}
+}
+
+//maybeShrink must be called with the write lock held and not while iterating over s.items.
+func (s *Storage) maybeShrink() {
+	if s.shrinkRatio <= 0 || s.peak < minShrinkSize {
+		return
+	}
+	if float64(len(s.items)) >= float64(s.peak)*s.shrinkRatio {
+		return
+	}
+	s.rebuild()
+}
+
+//rebuild copies items into a right-sized map.
+func (s *Storage) rebuild() {
+	size := len(s.items)
+	if size < s.capacity {
+		size = s.capacity
+	}
+	m := make(map[string]Item, size)
+	for k, v := range s.items {
+		m[k] = v
+	}
+	s.items = m
+	s.peak = len(m)
+	s.rebuilds++
+}
+
+func (s *Storage) Stats() Stats {
+	s.lock("Stats")
+	defer s.mu.Unlock()
+
+	expired := s.countExpired(s.now())
+	st := Stats{
+		Items:    len(s.items),
+		Live:     len(s.items) - expired,
+		Expired:  expired,
+		Capacity: s.capacity,
+		Peak:     s.peak,
+		Rebuilds: s.rebuilds,
+		Janitor:  s.janitorStats,
+		//reasons are reported even before anything was evicted
+		Evictions: map[string]uint64{EvictExpired: 0, EvictCapacity: 0},
+		Corrupted: s.Corruptions(),
+	}
+	for reason, n := range s.evictions {
+		st.Evictions[reason] = n
+	}
+	allocated := s.peak
+	if s.capacity > allocated {
+		allocated = s.capacity
+	}
+	if allocated > 0 {
+		st.LoadFactor = float64(st.Items) / float64(allocated)
+	}
+	return st
+}
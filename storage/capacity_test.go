@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestStorage_Shrink(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.SetShrinkThreshold(0.25)
+	for i := 0; i < 2*minShrinkSize; i++ {
+		s.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	for i := 0; i < 2*minShrinkSize; i++ {
+		if i%8 != 0 {
+			s.Delete(strconv.Itoa(i))
+		}
+	}
+
+	st := s.Stats()
+	if st.Rebuilds == 0 {
+		t.Fatal("map was not rebuilt after mass deletion")
+	}
+	if st.Items != 2*minShrinkSize/8 {
+		t.Errorf("items were lost during rebuild: %d", st.Items)
+	}
+	if st.LoadFactor < 0.25 {
+		t.Errorf("load factor is too low after rebuild: %f", st.LoadFactor)
+	}
+	if v, _ := s.Get("8"); v != 8 {
+		t.Errorf("unexpected value after rebuild: %v", v)
+	}
+}
+
+func TestStorage_Flush(t *testing.T) {
+	s := New(DefaultExpiration, 0, 100)
+	s.CreateIndex("ns", "f")
+	s.Set("ns:1", `{"f":"a"}`, DefaultExpiration)
+	s.Flush(true)
+
+	if n := s.ItemCount(); n != 0 {
+		t.Errorf("items left after flush: %d", n)
+	}
+	if keys, _ := s.Query("ns", "f", "a"); len(keys) != 0 {
+		t.Errorf("index was not flushed: %v", keys)
+	}
+	if st := s.Stats(); st.Capacity != 100 || st.Peak != 0 {
+		t.Errorf("unexpected stats after flush: %+v", st)
+	}
+}
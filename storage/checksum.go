@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+//checksumMagic starts the trailer of a checksummed snapshot, which is followed
+//by the SHA-256 of everything before the trailer. Load ignores the trailer, so a
+//checksummed snapshot can be used as a db file as it is.
+var checksumMagic = []byte("KVSUM256")
+
+//ChecksumTrailerSize is the length of the trailer appended by SaveChecksummed.
+const ChecksumTrailerSize = 8 + sha256.Size
+
+var ErrChecksumMismatch = errors.New("snapshot checksum doesn't match")
+
+//SaveChecksummed writes a point-in-time snapshot like Save followed by a trailer
+//holding its checksum, so that a transferred snapshot can be verified.
+func (s *Storage) SaveChecksummed(w io.Writer) error {
+	h := sha256.New()
+	if err := s.Save(io.MultiWriter(w, h)); err != nil {
+		return err
+	}
+	_, err := w.Write(append(append([]byte{}, checksumMagic...), h.Sum(nil)...))
+	return err
+}
+
+//VerifyChecksum checks the trailer of a snapshot of size bytes written by SaveChecksummed.
+func VerifyChecksum(r io.ReaderAt, size int64) error {
+	if size < ChecksumTrailerSize {
+		return errors.New("snapshot has no checksum trailer")
+	}
+	trailer := make([]byte, ChecksumTrailerSize)
+	if _, err := r.ReadAt(trailer, size-ChecksumTrailerSize); err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(trailer, checksumMagic) {
+		return errors.New("snapshot has no checksum trailer")
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size-ChecksumTrailerSize)); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), trailer[len(checksumMagic):]) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+//VerifyChecksumFile checks the checksum of a snapshot file written by SaveChecksummed.
+func VerifyChecksumFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return VerifyChecksum(f, info.Size())
+}
+
+//SetItemChecksums makes writes store a CRC-32 of the value with every item.
+//Reads verify it and treat an item whose value doesn't match as missing, and
+//loads skip it; both count it, see Corruptions. Items written while it was
+//disabled have no checksum and are never verified.
+func (s *Storage) SetItemChecksums(enabled bool) {
+	s.lock("SetItemChecksums")
+	s.checksums = enabled
+	s.mu.Unlock()
+}
+
+//Corruptions returns how many items failed their checksum on read or load.
+func (s *Storage) Corruptions() uint64 {
+	return atomic.LoadUint64(&s.corruptions)
+}
+
+//sum returns item with the checksum of its value as it is stored, so it is
+//called after compress. Must be called with the write lock held.
+func (s *Storage) sum(item Item) Item {
+	item.Checksum = 0
+	if s.checksums {
+		item.Checksum = valueChecksum(item.Object)
+	}
+	return item
+}
+
+//intact reports whether the value of item matches its checksum and counts it if not.
+func (s *Storage) intact(item *Item) bool {
+	if item.Checksum == 0 || valueChecksum(item.Object) == item.Checksum {
+		return true
+	}
+	atomic.AddUint64(&s.corruptions, 1)
+	return false
+}
+
+//valueChecksum is never 0, which marks items without a checksum. Values other
+//than strings and bytes are hashed in a canonical form, see hashValue.
+func valueChecksum(v interface{}) uint32 {
+	var sum uint32
+	switch v := v.(type) {
+	case string:
+		sum = crc32.ChecksumIEEE([]byte(v))
+	case []byte:
+		sum = crc32.ChecksumIEEE(v)
+	case compressedValue:
+		sum = crc32.ChecksumIEEE(v.Data)
+	case ChunkedValue:
+		for _, c := range v.Chunks {
+			sum = crc32.Update(sum, crc32.IEEETable, c)
+		}
+	default:
+		h := crc32.NewIEEE()
+		hashValue(h, reflect.ValueOf(v))
+		sum = h.Sum32()
+	}
+	if sum == 0 {
+		sum = 1
+	}
+	return sum
+}
+
+var binaryMarshaler = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+
+//hashValue writes v to h in a form which survives a gob save and load: times
+//without their monotonic reading, pointers by what they point to, maps in key
+//order and nil like empty slices and maps. Unexported fields aren't saved, so
+//they aren't hashed either.
+func hashValue(h io.Writer, v reflect.Value) {
+	var buf [8]byte
+	writeUint := func(n uint64) {
+		binary.BigEndian.PutUint64(buf[:], n)
+		h.Write(buf[:])
+	}
+	if !v.IsValid() {
+		h.Write([]byte{0})
+		return
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		writeUint(uint64(t.UnixNano()))
+		return
+	}
+	if v.Type().Implements(binaryMarshaler) && v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface {
+		if b, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary(); err == nil {
+			h.Write(b)
+			return
+		}
+	}
+	h.Write([]byte{byte(v.Kind())})
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			h.Write([]byte{1})
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeUint(uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		writeUint(math.Float64bits(v.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		writeUint(math.Float64bits(real(v.Complex())))
+		writeUint(math.Float64bits(imag(v.Complex())))
+	case reflect.String:
+		writeUint(uint64(v.Len()))
+		h.Write([]byte(v.String()))
+	case reflect.Ptr:
+		//gob doesn't keep pointers to zero values
+		if v.IsNil() {
+			hashValue(h, reflect.Zero(v.Type().Elem()))
+		} else {
+			hashValue(h, v.Elem())
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			h.Write([]byte(v.Elem().Type().String()))
+		}
+		hashValue(h, v.Elem())
+	case reflect.Slice, reflect.Array:
+		writeUint(uint64(v.Len()))
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			h.Write(v.Bytes())
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			hashValue(h, v.Index(i))
+		}
+	case reflect.Map:
+		writeUint(uint64(v.Len()))
+		type entry struct {
+			key   []byte
+			value reflect.Value
+		}
+		entries := make([]entry, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := &bytes.Buffer{}
+			hashValue(key, iter.Key())
+			entries = append(entries, entry{key.Bytes(), iter.Value()})
+		}
+		sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].key, entries[j].key) < 0 })
+		for _, e := range entries {
+			h.Write(e.key)
+			hashValue(h, e.value)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				hashValue(h, v.Field(i))
+			}
+		}
+	}
+}
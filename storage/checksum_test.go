@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorage_SaveChecksummed(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("a", "1", NoExpiration)
+	s.Set("b", "2", NoExpiration)
+	buf := &bytes.Buffer{}
+	if err := s.SaveChecksummed(buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if err := VerifyChecksum(bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Errorf("valid snapshot failed verification: %v", err)
+	}
+
+	loaded := New(DefaultExpiration, 0, 0)
+	if err := loaded.Load(bytes.NewReader(data)); err != nil {
+		t.Fatalf("checksummed snapshot can't be loaded: %v", err)
+	}
+	if v, _ := loaded.Get("b"); v != "2" {
+		t.Errorf("unexpected value: %v", v)
+	}
+
+	corrupt := append([]byte{}, data...)
+	corrupt[len(snapshotMagic)+10] ^= 0xff
+	if err := VerifyChecksum(bytes.NewReader(corrupt), int64(len(corrupt))); err != ErrChecksumMismatch {
+		t.Errorf("corrupt snapshot returned %v", err)
+	}
+	truncated := data[:len(data)-1]
+	if err := VerifyChecksum(bytes.NewReader(truncated), int64(len(truncated))); err == nil {
+		t.Error("truncated snapshot passed verification")
+	}
+}
+
+func TestStorage_ItemChecksums(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("unchecked", "1", NoExpiration)
+	s.SetItemChecksums(true)
+	s.Set("a", "1", NoExpiration)
+	s.Set("doc", map[string]interface{}{"x": 1.0, "y": "z"}, NoExpiration)
+	if s.items["unchecked"].Checksum != 0 || s.items["a"].Checksum == 0 {
+		t.Fatal("checksums were not stored as enabled")
+	}
+	if _, found := s.Get("doc"); !found {
+		t.Error("intact document failed its checksum")
+	}
+
+	//flip the value in memory behind the storage's back
+	item := s.items["a"]
+	item.Object = "2"
+	s.items["a"] = item
+	if _, found := s.Get("a"); found {
+		t.Error("corrupt item was returned")
+	}
+	if n := s.Corruptions(); n != 1 {
+		t.Errorf("unexpected corruptions: %d", n)
+	}
+	if _, found := s.Get("unchecked"); !found {
+		t.Error("item without checksum was not returned")
+	}
+
+	//a snapshot of the corrupt item skips it on load
+	buf := &bytes.Buffer{}
+	if err := s.Save(buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := New(DefaultExpiration, 0, 0)
+	if err := loaded.Load(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := loaded.Get("a"); found {
+		t.Error("corrupt item was loaded")
+	}
+	if _, found := loaded.Get("doc"); !found {
+		t.Error("intact item was not loaded")
+	}
+	if n := loaded.Stats().Corrupted; n != 1 {
+		t.Errorf("unexpected corruptions after load: %d", n)
+	}
+}
+
+type checkedDoc struct {
+	At    time.Time
+	Ref   *int
+	Zero  *int
+	Tags  map[string]int
+	Empty []string
+}
+
+func TestStorage_ItemChecksumsSurviveLoad(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.SetItemChecksums(true)
+	s.SetCompression(16)
+	now := time.Now()
+	n, zero := 7, 0
+
+	s.Set("string", "v", NoExpiration)
+	s.Set("compressed", strings.Repeat("v", 100), NoExpiration)
+	s.Set("bytes", []byte("v"), NoExpiration)
+	s.Set("int", int64(1), NoExpiration)
+	s.Set("float", 1.5, NoExpiration)
+	s.Set("bool", true, NoExpiration)
+	s.Set("doc", map[string]interface{}{"a": "x", "b": 1.0, "c": map[string]interface{}{}, "d": nil}, NoExpiration)
+	chunked, _ := ReadChunked(strings.NewReader("chunked value"), 4)
+	s.Set("chunked", chunked, NoExpiration)
+	NewTyped[checkedDoc](s).Set("typed", checkedDoc{At: now, Ref: &n, Zero: &zero, Tags: map[string]int{"a": 1, "b": 2}, Empty: []string{}}, NoExpiration)
+	s.XAdd("stream", map[string]string{"f": "v"})
+	s.QPush("queue", "m")
+	msgs, _ := s.QReceive("queue", 1, time.Minute)
+	s.QPush("queue", "n")
+	s.QAck("queue", msgs[0].Receipt())
+	s.TSAdd("series", now, 1, 0)
+	s.IncrWindow("window", time.Minute, 10, 1)
+	if _, err := s.Schedule(ScheduledOp{At: now.Add(time.Hour), Op: "set", Key: "later", Value: "v"}); err != nil {
+		t.Fatal(err)
+	}
+	keys := s.Snapshot(nil).Keys()
+	if len(keys) != 14 {
+		t.Fatalf("%d keys were stored", len(keys))
+	}
+
+	buf := &bytes.Buffer{}
+	if err := s.Save(buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := New(DefaultExpiration, 0, 0)
+	if err := loaded.Load(buf); err != nil {
+		t.Fatal(err)
+	}
+	if n := loaded.Stats().Corrupted; n != 0 {
+		t.Errorf("%d intact items failed their checksum on load", n)
+	}
+	for _, key := range keys {
+		if _, found := loaded.Get(key); !found {
+			t.Errorf("%s was not loaded", key)
+		}
+	}
+}
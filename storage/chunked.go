@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+const DefaultChunkSize = 64 << 10
+
+//ChunkedValue holds a large value as fixed-size segments, so it is never
+//buffered as one contiguous slice. It must not be modified once stored.
+type ChunkedValue struct {
+	Chunks [][]byte
+	Size   int64
+}
+
+//ReadChunked reads r into segments of chunkSize bytes.
+func ReadChunked(r io.Reader, chunkSize int) (ChunkedValue, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	v := ChunkedValue{}
+	for {
+		chunk := make([]byte, chunkSize)
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			v.Chunks = append(v.Chunks, chunk[:n])
+			v.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return v, nil
+		}
+		if err != nil {
+			return ChunkedValue{}, err
+		}
+	}
+}
+
+//Reader returns an io.ReadSeeker over the value, suitable for http.ServeContent.
+func (v ChunkedValue) Reader() *ChunkReader {
+	return &ChunkReader{v: v}
+}
+
+type ChunkReader struct {
+	v   ChunkedValue
+	off int64
+}
+
+func (r *ChunkReader) Read(p []byte) (int, error) {
+	if r.off >= r.v.Size {
+		return 0, io.EOF
+	}
+	n := 0
+	pos := int64(0)
+	for _, chunk := range r.v.Chunks {
+		end := pos + int64(len(chunk))
+		if r.off < end {
+			c := copy(p[n:], chunk[r.off-pos:])
+			n += c
+			r.off += int64(c)
+			if n == len(p) {
+				break
+			}
+		}
+		pos = end
+	}
+	return n, nil
+}
+
+func (r *ChunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.v.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.off = offset
+	return offset, nil
+}
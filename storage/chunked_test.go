@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestReadChunked(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	v, err := ReadChunked(bytes.NewReader(data), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Size != 100 || len(v.Chunks) != 7 {
+		t.Errorf("unexpected chunking: size %d, %d chunks", v.Size, len(v.Chunks))
+	}
+
+	all, _ := ioutil.ReadAll(v.Reader())
+	if !bytes.Equal(all, data) {
+		t.Error("read value differs from the written one")
+	}
+
+	r := v.Reader()
+	r.Seek(30, io.SeekStart)
+	part := make([]byte, 25)
+	if _, err = io.ReadFull(r, part); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(part, data[30:55]) {
+		t.Errorf("unexpected range: %s", part)
+	}
+	if pos, _ := r.Seek(-5, io.SeekEnd); pos != 95 {
+		t.Errorf("seek from end returned %d", pos)
+	}
+
+	empty, _ := ReadChunked(bytes.NewReader(nil), 16)
+	if empty.Size != 0 || len(empty.Chunks) != 0 {
+		t.Errorf("empty value has chunks: %+v", empty)
+	}
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+//coarseClock caches the current time, updated every resolution by a goroutine,
+//so hot paths read it with a single atomic load.
+type coarseClock struct {
+	now  int64
+	stop chan struct{}
+}
+
+func (c *coarseClock) run(resolution time.Duration) {
+	ticker := time.NewTicker(resolution)
+	for {
+		select {
+		case t := <-ticker.C:
+			atomic.StoreInt64(&c.now, t.UnixNano())
+		case <-c.stop:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+//UseCoarseClock makes expiration checks use time cached with the given resolution,
+//so items may live up to resolution longer than their ttl.
+//It must be called before the storage is used concurrently.
+func (s *Storage) UseCoarseClock(resolution time.Duration) {
+	if s.clock != nil || resolution <= 0 {
+		return
+	}
+	c := &coarseClock{
+		now:  time.Now().UnixNano(),
+		stop: make(chan struct{}),
+	}
+	s.clock = c
+	go c.run(resolution)
+	if s.janitor == nil {
+		runtime.SetFinalizer(s, stopJanitor)
+	}
+}
+
+//Clock is a source of time, see SetClock.
+type Clock interface {
+	Now() time.Time
+}
+
+//SetClock makes the storage read the current time from c instead of the system
+//clock, mainly to control expiration in tests. It takes precedence over UseCoarseClock
+//and must be called before the storage is used concurrently.
+func (s *Storage) SetClock(c Clock) {
+	s.source = c
+}
+
+func (s *Storage) now() int64 {
+	if s.source != nil {
+		return s.source.Now().UnixNano()
+	}
+	if s.clock != nil {
+		return atomic.LoadInt64(&s.clock.now)
+	}
+	return time.Now().UnixNano()
+}
+
+//expired reports whether item is expired according to the storage clock.
+func (s *Storage) expired(item *Item) bool {
+	return item.expiredAt(s.now())
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+	"unsafe"
+)
+
+//DefaultCompactBatch is how many items Compact copies per hold of the lock.
+const DefaultCompactBatch = 4096
+
+var (
+	ErrCompacting        = errors.New("a compaction is already running")
+	ErrCompactionAborted = errors.New("compaction was aborted by a flush")
+)
+
+//CompactStats describes a Compact run. The byte counts estimate the memory of
+//the items map, which keeps the buckets of its largest size until it is rebuilt.
+type CompactStats struct {
+	Items       int           `json:"items"`
+	Indexes     int           `json:"indexes"`
+	BytesBefore int64         `json:"bytes_before"`
+	BytesAfter  int64         `json:"bytes_after"`
+	Duration    time.Duration `json:"duration_ns"`
+	//MaxPause is the longest time the lock was held
+	MaxPause time.Duration `json:"max_pause_ns"`
+}
+
+//compaction collects the keys written while Compact copies items.
+type compaction struct {
+	changed map[string]struct{}
+}
+
+//trackCompaction is called by replace and remove.
+func (s *Storage) trackCompaction(key string) {
+	if s.compaction != nil {
+		s.compaction.changed[key] = struct{}{}
+	}
+}
+
+//Compact rebuilds the items map, the field and search indexes and the expiry
+//queue into right-sized structures, which Go maps never become after deletions.
+//Items are copied batch items at a time under the read lock (0 means
+//DefaultCompactBatch); keys written meanwhile are copied again before the new
+//map replaces the old one, and each index is rebuilt in a separate hold of the lock.
+func (s *Storage) Compact(ctx context.Context, batch int) (CompactStats, error) {
+	if batch <= 0 {
+		batch = DefaultCompactBatch
+	}
+	start := time.Now()
+	st := CompactStats{}
+	held := func(since time.Time) {
+		if d := time.Since(since); d > st.MaxPause {
+			st.MaxPause = d
+		}
+	}
+
+	s.lock("Compact")
+	if s.compaction != nil {
+		s.mu.Unlock()
+		return st, ErrCompacting
+	}
+	c := &compaction{changed: make(map[string]struct{})}
+	s.compaction = c
+	s.mu.Unlock()
+
+	s.rlock("Compact")
+	t := time.Now()
+	keys := make([]string, 0, len(s.items))
+	for k := range s.items {
+		keys = append(keys, k)
+	}
+	size := len(keys)
+	if size < s.capacity {
+		size = s.capacity
+	}
+	held(t)
+	s.mu.RUnlock()
+
+	m := make(map[string]Item, size)
+	for i := 0; i < len(keys); i += batch {
+		if err := ctx.Err(); err != nil {
+			s.endCompaction(c)
+			return st, err
+		}
+		end := i + batch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		s.rlock("Compact")
+		t = time.Now()
+		for _, k := range keys[i:end] {
+			if v, ok := s.items[k]; ok {
+				m[k] = v
+			}
+		}
+		held(t)
+		s.mu.RUnlock()
+	}
+
+	s.lock("Compact")
+	t = time.Now()
+	if s.compaction != c {
+		s.mu.Unlock()
+		return st, ErrCompactionAborted
+	}
+	for k := range c.changed {
+		if v, ok := s.items[k]; ok {
+			m[k] = v
+		} else {
+			delete(m, k)
+		}
+	}
+	s.compaction = nil
+	peak := s.peak
+	if peak < s.capacity {
+		peak = s.capacity
+	}
+	st.BytesBefore = itemsMapBytes(peak)
+	s.items = m
+	s.peak = len(m)
+	s.rebuilds++
+	st.Items = len(m)
+	if size = len(m); size < s.capacity {
+		size = s.capacity
+	}
+	st.BytesAfter = itemsMapBytes(size)
+	s.rebuildExpiry()
+	s.expiry.expired = copySet(s.expiry.expired)
+	s.expiry.sliding = copySet(s.expiry.sliding)
+	scheduled := make(map[string]int64, len(s.scheduled))
+	for k, at := range s.scheduled {
+		scheduled[k] = at
+	}
+	s.scheduled = scheduled
+	var indexes []*fieldIndex
+	for _, byName := range s.indexes {
+		for _, idx := range byName {
+			indexes = append(indexes, idx)
+		}
+	}
+	held(t)
+	s.mu.Unlock()
+
+	for _, idx := range indexes {
+		s.lock("Compact")
+		t = time.Now()
+		entries := make(map[string]map[string]struct{}, len(idx.entries))
+		for value, keys := range idx.entries {
+			entries[value] = copySet(keys)
+		}
+		idx.entries = entries
+		held(t)
+		s.mu.Unlock()
+		st.Indexes++
+	}
+
+	s.lock("Compact")
+	t = time.Now()
+	if s.search != nil {
+		search := &searchIndex{
+			postings: make(map[string]map[string]int, len(s.search.postings)),
+			lengths:  make(map[string]int, len(s.search.lengths)),
+		}
+		for term, keys := range s.search.postings {
+			postings := make(map[string]int, len(keys))
+			for k, n := range keys {
+				postings[k] = n
+			}
+			search.postings[term] = postings
+		}
+		for k, n := range s.search.lengths {
+			search.lengths[k] = n
+		}
+		s.search = search
+		st.Indexes++
+	}
+	held(t)
+	s.mu.Unlock()
+
+	st.Duration = time.Since(start)
+	return st, nil
+}
+
+func (s *Storage) endCompaction(c *compaction) {
+	s.lock("Compact")
+	if s.compaction == c {
+		s.compaction = nil
+	}
+	s.mu.Unlock()
+}
+
+func copySet(set map[string]struct{}) map[string]struct{} {
+	c := make(map[string]struct{}, len(set))
+	for k := range set {
+		c[k] = struct{}{}
+	}
+	return c
+}
+
+//itemsMapBytes estimates the memory of an items map which held up to n items:
+//maps have a power of two buckets of 8 entries, filled to 6.5 on average.
+func itemsMapBytes(n int) int64 {
+	buckets := int64(1)
+	for float64(buckets)*6.5 < float64(n) {
+		buckets *= 2
+	}
+	entry := int64(unsafe.Sizeof("")+unsafe.Sizeof(Item{})) + 1
+	//each bucket also has an overflow pointer
+	return buckets * (8*entry + 8)
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestStorage_Compact(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	if err := s.CreateIndex("users", "name"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10000; i++ {
+		s.Set("users:"+strconv.Itoa(i), `{"name": "n`+strconv.Itoa(i%10)+`"}`, NoExpiration)
+	}
+	for i := 100; i < 10000; i++ {
+		s.Delete("users:" + strconv.Itoa(i))
+	}
+
+	//writes during the compaction must survive it
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			s.Set("w"+strconv.Itoa(i%50), i, NoExpiration)
+			s.Delete("users:" + strconv.Itoa(i%10))
+		}
+	}()
+	st, err := s.Compact(context.Background(), 10)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.BytesAfter >= st.BytesBefore || st.Indexes != 1 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+
+	items := s.Items()
+	for i := 10; i < 100; i++ {
+		if _, found := items["users:"+strconv.Itoa(i)]; !found {
+			t.Errorf("users:%d was lost", i)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if _, found := items["users:"+strconv.Itoa(i)]; found {
+			t.Errorf("deleted users:%d came back", i)
+		}
+	}
+	if len(items) != 90+50 {
+		t.Errorf("expected 140 items, got %d", len(items))
+	}
+	if keys, err := s.Query("users", "name", "n5"); err != nil || len(keys) != 9 {
+		t.Errorf("unexpected lookup after compaction: %v, %v", keys, err)
+	}
+}
+
+func TestStorage_CompactCancelled(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("a", "v", NoExpiration)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.Compact(ctx, 0); err != context.Canceled {
+		t.Errorf("expected the compaction to be cancelled, got %v", err)
+	}
+	if _, err := s.Compact(context.Background(), 0); err != nil {
+		t.Errorf("compaction after a cancelled one failed: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+)
+
+//DefaultCompressThreshold is the size in bytes above which namespaces with
+//CompressOn compress values if SetCompression set no threshold.
+const DefaultCompressThreshold = 1024
+
+//Compression overrides for a namespace whether values are compressed. The zero
+//value follows SetCompression.
+type Compression int8
+
+const (
+	CompressInherit Compression = 0
+	CompressOn      Compression = 1
+	CompressOff     Compression = -1
+)
+
+//compressedValue is a string value kept DEFLATE-compressed in memory. It is
+//stored and persisted in place of the string, and every read of the item gets
+//the string back, see plain.
+type compressedValue struct {
+	Data []byte
+	Size int
+}
+
+//CompressionStats describe the compressed values; Bytes is their size before
+//compression and CompressedBytes the memory they take.
+type CompressionStats struct {
+	Items           int   `json:"items"`
+	Bytes           int64 `json:"bytes"`
+	CompressedBytes int64 `json:"compressed_bytes"`
+}
+
+//Ratio is how many times smaller the compressed values are.
+func (st CompressionStats) Ratio() float64 {
+	if st.CompressedBytes == 0 {
+		return 0
+	}
+	return float64(st.Bytes) / float64(st.CompressedBytes)
+}
+
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+//SetCompression makes string values longer than threshold bytes be kept
+//compressed, trading CPU on every write and read for memory. 0 disables it
+//except for namespaces with CompressOn. Values written before keep their form.
+func (s *Storage) SetCompression(threshold int) {
+	s.lock("SetCompression")
+	s.compressThreshold = threshold
+	s.mu.Unlock()
+}
+
+//compress returns item with its value compressed if the namespace of key
+//compresses values and it shrinks. Must be called with the lock held.
+func (s *Storage) compress(key string, item Item) Item {
+	v, ok := item.Object.(string)
+	if !ok {
+		return item
+	}
+	threshold := s.compressThreshold
+	if len(s.namespaces) > 0 {
+		if ns, ok := s.namespaces[Namespace(key)]; ok {
+			switch ns.opts.Compression {
+			case CompressOff:
+				return item
+			case CompressOn:
+				if threshold <= 0 {
+					threshold = DefaultCompressThreshold
+				}
+			}
+		}
+	}
+	if threshold <= 0 || len(v) <= threshold {
+		return item
+	}
+
+	buf := &bytes.Buffer{}
+	w := flateWriters.Get().(*flate.Writer)
+	w.Reset(buf)
+	io.WriteString(w, v)
+	w.Close()
+	flateWriters.Put(w)
+	//incompressible values are kept as they are
+	if buf.Len() >= len(v) {
+		return item
+	}
+	item.Object = compressedValue{Data: append([]byte(nil), buf.Bytes()...), Size: len(v)}
+	return item
+}
+
+//plain returns v, decompressed if it is a compressed value.
+func plain(v interface{}) interface{} {
+	c, ok := v.(compressedValue)
+	if !ok {
+		return v
+	}
+	b := make([]byte, 0, c.Size)
+	buf := bytes.NewBuffer(b)
+	//the data was compressed by the storage, so it can't be corrupt
+	buf.ReadFrom(flate.NewReader(bytes.NewReader(c.Data)))
+	return buf.String()
+}
+
+//plainItems decompresses the values of m in place.
+func plainItems(m map[string]Item) map[string]Item {
+	for k, v := range m {
+		if _, ok := v.Object.(compressedValue); ok {
+			v.Object = plain(v.Object)
+			m[k] = v
+		}
+	}
+	return m
+}
+
+//Compression returns the stats of the compressed values. It scans all items.
+func (s *Storage) Compression() CompressionStats {
+	s.rlock("Compression")
+	defer s.mu.RUnlock()
+	st := CompressionStats{}
+	for _, v := range s.items {
+		if c, ok := v.Object.(compressedValue); ok {
+			st.Items++
+			st.Bytes += int64(c.Size)
+			st.CompressedBytes += int64(len(c.Data))
+		}
+	}
+	return st
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStorage_Compression(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.SetCompression(100)
+	s.SetNamespaceOptions("raw", NamespaceOptions{Compression: CompressOff})
+	large := strings.Repeat("compressible ", 100)
+
+	s.Set("small", "abc", NoExpiration)
+	s.Set("large", large, NoExpiration)
+	s.Set("raw:large", large, NoExpiration)
+	if _, ok := s.items["small"].Object.(string); !ok {
+		t.Error("small value was compressed")
+	}
+	if _, ok := s.items["large"].Object.(compressedValue); !ok {
+		t.Error("large value was not compressed")
+	}
+	if _, ok := s.items["raw:large"].Object.(string); !ok {
+		t.Error("value of a namespace with compression off was compressed")
+	}
+
+	if v, _ := s.Get("large"); v != large {
+		t.Errorf("Get returned %.20q...", v)
+	}
+	if item, _ := s.GetItem("large"); item.Object != large {
+		t.Errorf("GetItem returned %.20q...", item.Object)
+	}
+	if v := s.Items()["large"].Object; v != large {
+		t.Errorf("Items returned %.20q...", v)
+	}
+
+	st := s.Compression()
+	if st.Items != 1 || st.Bytes != int64(len(large)) || st.Ratio() <= 10 {
+		t.Errorf("unexpected stats: %+v, ratio %v", st, st.Ratio())
+	}
+
+	//compressed values are saved as they are and read back
+	buf := &bytes.Buffer{}
+	if err := s.Save(buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := New(DefaultExpiration, 0, 0)
+	if err := loaded.Load(buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := loaded.Get("large"); v != large {
+		t.Errorf("loaded value is %.20q...", v)
+	}
+}
+
+func TestStorage_CompressionNamespace(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.SetNamespaceOptions("docs", NamespaceOptions{Compression: CompressOn})
+	s.EnableSearch()
+	large := strings.Repeat("needle haystack ", DefaultCompressThreshold/8)
+
+	s.Set("docs:1", large, NoExpiration)
+	s.Set("other", large, NoExpiration)
+	if _, ok := s.items["docs:1"].Object.(compressedValue); !ok {
+		t.Error("value of a namespace with compression on was not compressed")
+	}
+	if _, ok := s.items["other"].Object.(string); !ok {
+		t.Error("value was compressed without a threshold")
+	}
+	if res, _ := s.Search("needle", 10); len(res) != 2 {
+		t.Errorf("compressed value was not indexed: %+v", res)
+	}
+	s.Delete("docs:1")
+	if res, _ := s.Search("needle", 10); len(res) != 1 {
+		t.Errorf("compressed value was not removed from the index: %+v", res)
+	}
+}
@@ -0,0 +1,53 @@
+package storage
+
+import "fmt"
+
+//SnapshotMode defines what writers see while Save is encoding.
+type SnapshotMode int
+
+const (
+	//SnapshotCopy copies items under the read lock and encodes the copy without it,
+	//so writes proceed during encoding at the cost of holding two copies in memory.
+	SnapshotCopy SnapshotMode = iota
+	//SnapshotBlock holds the read lock until encoding finishes: writes wait, and
+	//since a waiting writer blocks new readers of sync.RWMutex, so do reads.
+	SnapshotBlock
+)
+
+//RestoreMode defines what readers see while Load is decoding.
+type RestoreMode int
+
+const (
+	//RestoreSwap decodes without the lock and serves the old items until the
+	//loaded ones are merged in under the write lock.
+	RestoreSwap RestoreMode = iota
+	//RestoreBlock holds the write lock while decoding, so nobody observes the old state.
+	RestoreBlock
+)
+
+func ParseSnapshotMode(s string) (SnapshotMode, error) {
+	switch s {
+	case "", "copy":
+		return SnapshotCopy, nil
+	case "block":
+		return SnapshotBlock, nil
+	}
+	return 0, fmt.Errorf("unknown snapshot mode %s", s)
+}
+
+func ParseRestoreMode(s string) (RestoreMode, error) {
+	switch s {
+	case "", "swap":
+		return RestoreSwap, nil
+	case "block":
+		return RestoreBlock, nil
+	}
+	return 0, fmt.Errorf("unknown restore mode %s", s)
+}
+
+func (s *Storage) SetConsistency(snapshot SnapshotMode, restore RestoreMode) {
+	s.lock("SetConsistency")
+	s.snapshotMode = snapshot
+	s.restoreMode = restore
+	s.mu.Unlock()
+}
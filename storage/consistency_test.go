@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+//blockingWriter blocks the first write until release is closed.
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+	buf     bytes.Buffer
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	select {
+	case <-w.started:
+	default:
+		close(w.started)
+		<-w.release
+	}
+	return w.buf.Write(p)
+}
+
+//finishesWithin reports whether f returns before d passes.
+func finishesWithin(d time.Duration, f func()) bool {
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func testSnapshotMode(t *testing.T, mode SnapshotMode, writesBlocked bool) {
+	s := New(DefaultExpiration, 0, 0)
+	s.SetConsistency(mode, RestoreSwap)
+	s.Set("a", "1", DefaultExpiration)
+
+	w := newBlockingWriter()
+	saved := make(chan error)
+	go func() { saved <- s.Save(w) }()
+	<-w.started
+
+	finished := finishesWithin(20*time.Millisecond, func() { s.Set("a", "2", DefaultExpiration) })
+	close(w.release)
+	if err := <-saved; err != nil {
+		t.Fatal(err)
+	}
+	if finished == writesBlocked {
+		t.Errorf("mode %d: write finished during save: %v", mode, finished)
+	}
+
+	loaded := New(DefaultExpiration, 0, 0)
+	if err := loaded.Load(&w.buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := loaded.Get("a"); v != "1" {
+		t.Errorf("mode %d: snapshot is not point-in-time: %v", mode, v)
+	}
+}
+
+func TestStorage_SnapshotModes(t *testing.T) {
+	testSnapshotMode(t, SnapshotCopy, false)
+	testSnapshotMode(t, SnapshotBlock, true)
+}
+
+//blockingReader blocks the first read until release is closed.
+type blockingReader struct {
+	r       io.Reader
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	select {
+	case <-r.started:
+	default:
+		close(r.started)
+		<-r.release
+	}
+	return r.r.Read(p)
+}
+
+func testRestoreMode(t *testing.T, mode RestoreMode, readsBlocked bool) {
+	src := New(DefaultExpiration, 0, 0)
+	src.Set("a", "new", DefaultExpiration)
+	buf := &bytes.Buffer{}
+	src.Save(buf)
+
+	s := New(DefaultExpiration, 0, 0)
+	s.SetConsistency(SnapshotCopy, mode)
+	s.Set("a", "old", DefaultExpiration)
+
+	r := &blockingReader{r: buf, started: make(chan struct{}), release: make(chan struct{})}
+	loaded := make(chan error)
+	go func() { loaded <- s.Load(r) }()
+	<-r.started
+
+	var during interface{}
+	finished := finishesWithin(20*time.Millisecond, func() { during, _ = s.Get("a") })
+	if finished && during != "old" {
+		t.Errorf("mode %d: read during load returned %v", mode, during)
+	}
+	close(r.release)
+	if err := <-loaded; err != nil {
+		t.Fatal(err)
+	}
+	if finished == readsBlocked {
+		t.Errorf("mode %d: read finished during load: %v", mode, finished)
+	}
+	if v, _ := s.Get("a"); v != "new" {
+		t.Errorf("mode %d: value was not restored: %v", mode, v)
+	}
+}
+
+func TestStorage_RestoreModes(t *testing.T) {
+	testRestoreMode(t, RestoreSwap, false)
+	testRestoreMode(t, RestoreBlock, true)
+}
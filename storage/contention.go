@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//LockWaitBuckets are the upper bounds of the lock wait histogram buckets.
+var LockWaitBuckets = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+//LockWaitHistogram counts how long an operation waited for the storage lock.
+//Counts[i] is the number of waits not longer than LockWaitBuckets[i], the last
+//element counts the longer ones; unlike Prometheus buckets they are not cumulative.
+type LockWaitHistogram struct {
+	Counts []uint64      `json:"counts"`
+	Count  uint64        `json:"count"`
+	Sum    time.Duration `json:"sum_ns"`
+}
+
+type lockWaits struct {
+	enabled int32
+	mu      sync.Mutex
+	ops     map[string]*LockWaitHistogram
+}
+
+func (lw *lockWaits) record(op string, wait time.Duration) {
+	lw.mu.Lock()
+	h, ok := lw.ops[op]
+	if !ok {
+		h = &LockWaitHistogram{Counts: make([]uint64, len(LockWaitBuckets)+1)}
+		lw.ops[op] = h
+	}
+	i := 0
+	for i < len(LockWaitBuckets) && wait > LockWaitBuckets[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += wait
+	lw.mu.Unlock()
+}
+
+//TraceLockWaits makes operations record how long they waited for the storage
+//lock. It costs two clock reads per operation, so it is disabled by default.
+func (s *Storage) TraceLockWaits(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&s.lockWaits.enabled, v)
+}
+
+//LockWaits returns the lock wait histograms by operation, e.g. "Get" or "Set".
+func (s *Storage) LockWaits() map[string]LockWaitHistogram {
+	lw := &s.lockWaits
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	waits := make(map[string]LockWaitHistogram, len(lw.ops))
+	for op, h := range lw.ops {
+		hc := *h
+		hc.Counts = append([]uint64(nil), h.Counts...)
+		waits[op] = hc
+	}
+	return waits
+}
+
+//lock takes the write lock, recording the wait under op if tracing is enabled.
+//The wall clock is used even with a coarse clock, which is too coarse for lock waits.
+func (s *Storage) lock(op string) {
+	if atomic.LoadInt32(&s.lockWaits.enabled) == 0 {
+		s.mu.Lock()
+		return
+	}
+	start := time.Now()
+	s.mu.Lock()
+	s.lockWaits.record(op, time.Since(start))
+}
+
+//rlock is lock for the read lock.
+func (s *Storage) rlock(op string) {
+	if atomic.LoadInt32(&s.lockWaits.enabled) == 0 {
+		s.mu.RLock()
+		return
+	}
+	start := time.Now()
+	s.mu.RLock()
+	s.lockWaits.record(op, time.Since(start))
+}
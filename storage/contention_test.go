@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStorage_TraceLockWaits(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("a", "1", DefaultExpiration)
+	if waits := s.LockWaits(); len(waits) != 0 {
+		t.Errorf("waits were recorded without tracing: %v", waits)
+	}
+
+	s.TraceLockWaits(true)
+	s.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		s.Get("a")
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.mu.Unlock()
+	<-done
+	s.Set("b", "2", DefaultExpiration)
+
+	waits := s.LockWaits()
+	get := waits["Get"]
+	if get.Count != 1 || get.Sum < 10*time.Millisecond {
+		t.Errorf("wait of Get was not recorded: %+v", get)
+	}
+	//the 10ms bucket is too small, the 100ms one holds the wait
+	if get.Counts[4] != 0 || get.Counts[5] != 1 {
+		t.Errorf("wait is in the wrong bucket: %v", get.Counts)
+	}
+	if waits["Set"].Count != 1 {
+		t.Errorf("wait of Set was not recorded: %+v", waits["Set"])
+	}
+}
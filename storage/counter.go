@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+//WindowCounter is a counter bucketed by fixed time windows. Like TimeSeries it
+//is only appended to or copied, so a value returned by Get can be read without locking.
+type WindowCounter struct {
+	Window    time.Duration
+	Retention int
+	//Buckets are the earlier windows with increments, ordered by start
+	Buckets []WindowBucket
+	//Current is the bucket of the latest window with increments, kept apart
+	//so increments within a window don't copy the others
+	Current WindowBucket
+}
+
+//MaxWindowRetention is the greatest number of windows a counter keeps.
+const MaxWindowRetention = 10000
+
+type WindowBucket struct {
+	Start time.Time `json:"start"`
+	Count int64     `json:"count"`
+}
+
+//IncrWindow adds delta to the bucket of the current window and returns its new value.
+//Buckets older than retention windows are dropped; the whole counter expires
+//when no increments happened during the retention period.
+func (s *Storage) IncrWindow(key string, window time.Duration, retention int, delta int64) (int64, error) {
+	return s.Guard(nil).IncrWindow(key, window, retention, delta)
+}
+
+//IncrWindow is Storage.IncrWindow for the keys the guard accepts.
+func (g Guarded) IncrWindow(key string, window time.Duration, retention int, delta int64) (int64, error) {
+	s := g.s
+	if window <= 0 {
+		return 0, fmt.Errorf("window must be positive")
+	}
+	if retention <= 0 || retention > MaxWindowRetention {
+		return 0, fmt.Errorf("retention must be between 1 and %d", MaxWindowRetention)
+	}
+	if time.Duration(retention) > math.MaxInt64/window {
+		return 0, fmt.Errorf("retention of %d windows of %s is too long", retention, window)
+	}
+
+	start := time.Unix(0, s.now()).Truncate(window)
+	oldest := start.Add(-time.Duration(retention-1) * window)
+
+	s.lock("IncrWindow")
+	defer s.mu.Unlock()
+	if err := g.check(key); err != nil {
+		return 0, err
+	}
+
+	wc := WindowCounter{}
+	if item, found := s.items[key]; found && !s.expired(&item) {
+		var ok bool
+		if wc, ok = item.Object.(WindowCounter); !ok {
+			return 0, fmt.Errorf("item %s is not a window counter", key)
+		}
+		if wc.Window != window {
+			return 0, fmt.Errorf("item %s has window %s", key, wc.Window)
+		}
+	}
+	if err := s.makeRoom(key, ClassNormal); err != nil {
+		return 0, err
+	}
+	wc.Window = window
+	wc.Retention = retention
+
+	var count int64
+	switch {
+	case wc.Current.Start.Equal(start):
+		wc.Current.Count += delta
+		count = wc.Current.Count
+	case wc.Current.Start.Before(start):
+		if !wc.Current.Start.IsZero() {
+			wc.Buckets = append(wc.Buckets, wc.Current)
+		}
+		wc.Current = WindowBucket{Start: start, Count: delta}
+		count = delta
+	default:
+		//the clock went back to an earlier window
+		wc.Buckets, count = addToBucket(wc.Buckets, start, delta)
+	}
+	i := sort.Search(len(wc.Buckets), func(i int) bool { return !wc.Buckets[i].Start.Before(oldest) })
+	wc.Buckets = wc.Buckets[i:]
+
+	s.put(key, Item{
+		Object:     wc,
+		Expiration: wc.Current.Start.Add(time.Duration(retention) * window).UnixNano(),
+	})
+	return count, nil
+}
+
+//addToBucket returns a copy of buckets with delta added to the bucket starting
+//at start, which is inserted if it is missing, and the new count of that bucket.
+func addToBucket(buckets []WindowBucket, start time.Time, delta int64) ([]WindowBucket, int64) {
+	i := sort.Search(len(buckets), func(i int) bool { return !buckets[i].Start.Before(start) })
+	b := WindowBucket{Start: start, Count: delta}
+	copied := make([]WindowBucket, 0, len(buckets)+1)
+	copied = append(copied, buckets[:i]...)
+	if i < len(buckets) && buckets[i].Start.Equal(start) {
+		b.Count += buckets[i].Count
+		i++
+	}
+	copied = append(copied, b)
+	return append(copied, buckets[i:]...), b.Count
+}
+
+//WindowRange returns buckets of the counter which start within [from, to], ordered by time.
+func (s *Storage) WindowRange(key string, from, to time.Time) ([]WindowBucket, error) {
+	v, found := s.Get(key)
+	if !found {
+		return nil, fmt.Errorf("item %s not found", key)
+	}
+	wc, ok := v.(WindowCounter)
+	if !ok {
+		return nil, fmt.Errorf("item %s is not a window counter", key)
+	}
+
+	oldest := time.Unix(0, s.now()).Truncate(wc.Window).Add(-time.Duration(wc.Retention-1) * wc.Window)
+	in := func(b WindowBucket) bool {
+		return !b.Start.Before(oldest) && !b.Start.Before(from) && !b.Start.After(to)
+	}
+	buckets := make([]WindowBucket, 0, len(wc.Buckets)+1)
+	for _, b := range wc.Buckets {
+		if in(b) {
+			buckets = append(buckets, b)
+		}
+	}
+	if !wc.Current.Start.IsZero() && in(wc.Current) {
+		buckets = append(buckets, wc.Current)
+	}
+	return buckets, nil
+}
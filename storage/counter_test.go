@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStorage_IncrWindow(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	for i := 0; i < 3; i++ {
+		if _, err := s.IncrWindow("hits", time.Hour, 2, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := s.IncrWindow("hits", time.Hour, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("counter is not 5: %d", n)
+	}
+
+	if _, err = s.IncrWindow("hits", time.Minute, 2, 1); err == nil {
+		t.Error("incremented counter with a different window")
+	}
+	s.Set("str", "v", DefaultExpiration)
+	if _, err = s.IncrWindow("str", time.Minute, 2, 1); err == nil {
+		t.Error("incremented a string item")
+	}
+
+	if _, err = s.IncrWindow("big", time.Minute, 2000000000, 1); err == nil {
+		t.Error("counter was created with a huge retention")
+	}
+	if _, err = s.IncrWindow("long", time.Duration(math.MaxInt64/2), 3, 1); err == nil {
+		t.Error("counter was created with an overflowing retention period")
+	}
+}
+
+func TestStorage_WindowRange(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.IncrWindow("hits", time.Hour, 3, 4)
+
+	now := time.Now()
+	buckets, err := s.WindowRange("hits", now.Add(-time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 || buckets[0].Count != 4 {
+		t.Errorf("unexpected buckets: %v", buckets)
+	}
+
+	buckets, _ = s.WindowRange("hits", now.Add(time.Hour), now.Add(2*time.Hour))
+	if len(buckets) != 0 {
+		t.Errorf("buckets outside of range returned: %v", buckets)
+	}
+}
+
+func TestStorage_WindowExpiry(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.IncrWindow("hits", 5*time.Millisecond, 1, 1)
+	time.Sleep(10 * time.Millisecond)
+
+	if _, found := s.Get("hits"); found {
+		t.Error("counter didn't expire after retention period")
+	}
+	n, _ := s.IncrWindow("hits", 5*time.Millisecond, 1, 1)
+	if n != 1 {
+		t.Errorf("expired bucket was reused: %d", n)
+	}
+}
+
+func TestStorage_WindowBuckets(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	c := &fixedClock{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s.SetClock(c)
+	counter := func() WindowCounter {
+		v, _ := s.Get("hits")
+		return v.(WindowCounter)
+	}
+
+	for i := 0; i < 3; i++ {
+		s.IncrWindow("hits", time.Minute, 3, 1)
+		c.now = c.now.Add(time.Minute)
+	}
+	if n, _ := s.IncrWindow("hits", time.Minute, 3, 2); n != 2 {
+		t.Errorf("bucket of the new window is %d", n)
+	}
+	//increments within a window share the earlier buckets
+	before := counter()
+	s.IncrWindow("hits", time.Minute, 3, 1)
+	after := counter()
+	if len(after.Buckets) != 2 || &after.Buckets[0] != &before.Buckets[0] || after.Current.Count != 3 {
+		t.Errorf("unexpected buckets %v, current %v", after.Buckets, after.Current)
+	}
+	if before.Current.Count != 2 {
+		t.Error("increment changed a counter returned before")
+	}
+
+	//the clock going back increments an earlier bucket
+	c.now = c.now.Add(-time.Minute)
+	if n, _ := s.IncrWindow("hits", time.Minute, 3, 5); n != 6 {
+		t.Errorf("earlier bucket is %d", n)
+	}
+	if after.Buckets[1].Count != 1 {
+		t.Error("increment of an earlier bucket changed a counter returned before")
+	}
+	buckets, _ := s.WindowRange("hits", c.now.Add(-time.Hour), c.now.Add(time.Hour))
+	want := []int64{1, 6, 3}
+	if len(buckets) != len(want) {
+		t.Fatalf("unexpected buckets %v", buckets)
+	}
+	for i, b := range buckets {
+		if b.Count != want[i] || !b.Start.Equal(c.now.Add(time.Duration(i-1)*time.Minute)) {
+			t.Errorf("unexpected bucket %d: %v", i, b)
+		}
+	}
+
+	//buckets older than the retention are dropped
+	c.now = c.now.Add(3 * time.Minute)
+	s.IncrWindow("hits", time.Minute, 3, 1)
+	if wc := counter(); len(wc.Buckets) != 1 || wc.Buckets[0].Count != 3 || wc.Current.Count != 1 {
+		t.Errorf("unexpected buckets %v, current %v", wc.Buckets, wc.Current)
+	}
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+//KeyDebug is everything the storage knows about a key, see DebugKey.
+type KeyDebug struct {
+	Key       string `json:"key"`
+	Namespace string `json:"namespace"`
+	//Found is false if the key has no item in memory; Spilled tells whether it
+	//has one in the overflow directory instead
+	Found   bool   `json:"found"`
+	Spilled bool   `json:"spilled,omitempty"`
+	Expired bool   `json:"expired,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+	//Type is the Go type of the value, Encoding "raw", "compressed" or "chunked"
+	Type     string `json:"type,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	//Size estimates the memory the item takes, see NamespaceStats.Bytes
+	Size        int64      `json:"size,omitempty"`
+	ContentType string     `json:"content_type,omitempty"`
+	Class       string     `json:"class,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	TTL         string     `json:"ttl,omitempty"`
+	Sliding     string     `json:"sliding,omitempty"`
+	LastAccess  *time.Time `json:"last_access,omitempty"`
+	Checksum    uint32     `json:"checksum,omitempty"`
+	ChecksumOK  bool       `json:"checksum_ok,omitempty"`
+	//Unsaved is true if the key changed since the last save, when deltas are enabled
+	Unsaved bool `json:"unsaved,omitempty"`
+	//Notify tells whether an owner is notified when the item expires or is evicted
+	Notify    bool          `json:"notify,omitempty"`
+	Scheduled []ScheduledOp `json:"scheduled,omitempty"`
+	//History holds the recorded operations of the key, see RecordHistory
+	History []HistoryOp `json:"history,omitempty"`
+}
+
+//DebugKey describes key without reading it: neither sliding expiration nor
+//access stats are updated, and a spilled item stays spilled.
+func (s *Storage) DebugKey(key string) KeyDebug {
+	d := KeyDebug{Key: key, Namespace: Namespace(key)}
+
+	s.rlock("DebugKey")
+	now := s.now()
+	item, found := s.items[key]
+	d.Found = found
+	d.Spilled = !found && s.overflow.has(key)
+	_, d.Unsaved = s.changed[key]
+	_, d.Notify = s.keyEvents[key]
+	for k := range s.scheduled {
+		if op := s.items[k].Object.(ScheduledOp); op.Key == key {
+			d.Scheduled = append(d.Scheduled, op)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(d.Scheduled, func(i, j int) bool { return d.Scheduled[i].At.Before(d.Scheduled[j].At) })
+
+	if found {
+		d.Expired = item.expiredAt(now)
+		d.Version = item.Version
+		d.Type = fmt.Sprintf("%T", plainType(item.Object))
+		d.Encoding = "raw"
+		switch item.Object.(type) {
+		case compressedValue:
+			d.Encoding = "compressed"
+		case ChunkedValue:
+			d.Encoding = "chunked"
+		}
+		d.Size = itemOverhead + int64(len(key)) + valueSize(item.Object)
+		d.ContentType = item.ContentType
+		d.Class = item.Class.String()
+		if at := item.expiresAt(); at > 0 {
+			expires := time.Unix(0, at)
+			d.ExpiresAt = &expires
+			d.TTL = time.Duration(at - now).Round(time.Millisecond).String()
+		}
+		if item.Sliding > 0 {
+			d.Sliding = time.Duration(item.Sliding).String()
+		}
+		if item.LastAccess != nil {
+			last := time.Unix(0, atomic.LoadInt64(item.LastAccess))
+			d.LastAccess = &last
+		}
+		d.Checksum = item.Checksum
+		d.ChecksumOK = item.Checksum != 0 && valueChecksum(item.Object) == item.Checksum
+	}
+
+	ops, _ := s.History()
+	for _, op := range ops {
+		if op.Key == key {
+			d.History = append(d.History, op)
+		}
+	}
+	return d
+}
+
+//plainType returns v, or a string in place of a compressed value, whose
+//type is what readers get.
+func plainType(v interface{}) interface{} {
+	if _, ok := v.(compressedValue); ok {
+		return ""
+	}
+	return v
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorage_DebugKey(t *testing.T) {
+	c := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(c)
+	s.SetCompression(10)
+	s.RecordHistory(true)
+	s.Write("docs:1", strings.Repeat("a", 100), WriteOptions{TTL: time.Minute, Sliding: true})
+	s.Schedule(ScheduledOp{At: c.now.Add(time.Hour), Op: "delete", Key: "docs:1"})
+	c.now = c.now.Add(10 * time.Second)
+
+	d := s.DebugKey("docs:1")
+	if !d.Found || d.Namespace != "docs" || d.Type != "string" || d.Encoding != "compressed" {
+		t.Errorf("unexpected description: %+v", d)
+	}
+	if d.TTL != "50s" || d.Sliding != "1m0s" || d.LastAccess == nil {
+		t.Errorf("unexpected expiration: ttl %s, sliding %s", d.TTL, d.Sliding)
+	}
+	if len(d.Scheduled) != 1 || len(d.History) != 1 || d.History[0].Kind != HistoryWrite {
+		t.Errorf("unexpected operations: %+v, %+v", d.Scheduled, d.History)
+	}
+	//debugging doesn't count as a read
+	if d := s.DebugKey("docs:1"); d.TTL != "50s" {
+		t.Errorf("sliding expiration was refreshed: %s", d.TTL)
+	}
+
+	if d := s.DebugKey("missing"); d.Found || d.Version != 0 {
+		t.Errorf("missing key was described: %+v", d)
+	}
+}
@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//Delta files hold only the keys changed since the previous save, so that frequent
+//saves of a large, slowly changing storage write little. Deltas of filename are
+//named filename.delta.<seq> and apply in seq order on top of the snapshot with
+//the same generation; a full SaveFile starts a new generation and removes them.
+var deltaMagic = []byte("KVDELTA\n")
+
+//ErrNoBaseSnapshot is returned by SaveDelta before the first full SaveFile.
+var ErrNoBaseSnapshot = errors.New("no base snapshot to write a delta for")
+
+//deltaHeader precedes the records of a delta. KeyID and DataKey are only set
+//in encrypted deltas.
+type deltaHeader struct {
+	Generation string
+	Seq        int
+	Records    int
+	KeyID      string
+	DataKey    []byte
+}
+
+//deltaRecord is the item of a changed key, in Sealed if the delta is encrypted,
+//or a key which was deleted or expired.
+type deltaRecord struct {
+	Key     string
+	Item    Item
+	Sealed  []byte
+	Deleted bool
+}
+
+func newGeneration() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+//DeltaFileName returns the name of delta seq of the snapshot filename.
+func DeltaFileName(filename string, seq int) string {
+	return fmt.Sprintf("%s.delta.%06d", filename, seq)
+}
+
+//EnableDeltas starts tracking changed keys for SaveDelta.
+func (s *Storage) EnableDeltas() {
+	s.lock("EnableDeltas")
+	if s.changed == nil {
+		s.changed = make(map[string]uint64)
+	}
+	s.mu.Unlock()
+}
+
+//Changed returns the number of keys changed since the last SaveFile or SaveDelta.
+func (s *Storage) Changed() int {
+	s.rlock("Changed")
+	defer s.mu.RUnlock()
+	return len(s.changed)
+}
+
+//Deltas returns the number of deltas written on top of the last full snapshot.
+func (s *Storage) Deltas() int {
+	s.rlock("Deltas")
+	defer s.mu.RUnlock()
+	return s.deltaSeq
+}
+
+//trackChange remembers key for the next delta; it is called by replace and remove.
+func (s *Storage) trackChange(key string) {
+	if s.changed != nil {
+		s.changeSeq++
+		s.changed[key] = s.changeSeq
+	}
+}
+
+//saved forgets changes up to seq, which a snapshot or delta includes now.
+//Must be called with the write lock held.
+func (s *Storage) saved(seq uint64) {
+	for key, changed := range s.changed {
+		if changed <= seq {
+			delete(s.changed, key)
+		}
+	}
+}
+
+//SaveDelta writes the keys changed since the last save to the next delta of
+//filename and returns their number. Deltas must be enabled and a full snapshot
+//must have been saved or loaded before, otherwise ErrNoBaseSnapshot is returned.
+func (s *Storage) SaveDelta(filename string) (int, error) {
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+
+	s.rlock("SaveDelta")
+	if s.changed == nil {
+		s.mu.RUnlock()
+		return 0, errors.New("deltas aren't enabled")
+	}
+	if s.generation == "" {
+		s.mu.RUnlock()
+		return 0, ErrNoBaseSnapshot
+	}
+	h := deltaHeader{Generation: s.generation, Seq: s.deltaSeq + 1, Records: len(s.changed)}
+	records := make([]deltaRecord, 0, len(s.changed))
+	for key := range s.changed {
+		item, found := s.items[key]
+		if !found || s.expired(&item) {
+			records = append(records, deltaRecord{Key: key, Deleted: true})
+			continue
+		}
+		records = append(records, deltaRecord{Key: key, Item: item})
+	}
+	upTo := s.changeSeq
+	kek := s.currentKEK()
+	s.mu.RUnlock()
+
+	name := DeltaFileName(filename, h.Seq)
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	if err = writeDelta(f, h, records, kek); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err = f.Close(); err != nil {
+		return 0, err
+	}
+	if err = os.Rename(f.Name(), name); err != nil {
+		return 0, err
+	}
+
+	s.lock("SaveDelta")
+	s.deltaSeq = h.Seq
+	s.saved(upTo)
+	s.mu.Unlock()
+	return len(records), nil
+}
+
+func writeDelta(w io.Writer, h deltaHeader, records []deltaRecord, kek *KEK) error {
+	if _, err := w.Write(deltaMagic); err != nil {
+		return err
+	}
+	var aead cipher.AEAD
+	if kek != nil {
+		var err error
+		if aead, h.DataKey, err = newDataKey(kek); err != nil {
+			return err
+		}
+		h.KeyID = kek.ID
+	}
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(&h); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if aead != nil && !rec.Deleted {
+			sealed, err := sealItem(aead, rec.Item, recordData(rec.Key, h.Generation, h.Seq))
+			if err != nil {
+				return err
+			}
+			rec.Item, rec.Sealed = Item{}, sealed
+		} else if !rec.Deleted {
+			gob.Register(rec.Item.Object)
+		}
+		if err := enc.Encode(&rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//readDelta decodes a whole delta, so that a truncated one is never applied in part.
+func readDelta(r io.Reader, keys []KEK) (deltaHeader, []deltaRecord, error) {
+	h := deltaHeader{}
+	magic := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != string(deltaMagic) {
+		return h, nil, errors.New("not a delta file")
+	}
+	dec := gob.NewDecoder(r)
+	if err := dec.Decode(&h); err != nil {
+		return h, nil, err
+	}
+	var aead cipher.AEAD
+	if h.KeyID != "" {
+		var err error
+		if aead, err = openDataKey(keys, h.KeyID, h.DataKey); err != nil {
+			return h, nil, err
+		}
+	}
+	records := make([]deltaRecord, h.Records)
+	for i := range records {
+		err := dec.Decode(&records[i])
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil && records[i].Sealed != nil {
+			records[i].Item, err = openItem(aead, records[i].Sealed, recordData(records[i].Key, h.Generation, h.Seq))
+		}
+		if err != nil {
+			return h, nil, fmt.Errorf("delta %d is corrupt after %d of %d records: %w", h.Seq, i, h.Records, err)
+		}
+	}
+	return h, records, nil
+}
+
+//deltaFiles returns the deltas of filename by their seq.
+func deltaFiles(filename string) (map[int]string, error) {
+	names, err := filepath.Glob(filename + ".delta.*")
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[int]string, len(names))
+	for _, name := range names {
+		//temporary files of an interrupted SaveDelta have another suffix
+		seq, err := strconv.Atoi(strings.TrimPrefix(name, filename+".delta."))
+		if err == nil {
+			files[seq] = name
+		}
+	}
+	return files, nil
+}
+
+//loadDeltas applies the records of keys selected by filter from the deltas of
+//filename which belong to generation in order and returns the number of deltas.
+//All of them are read before any is applied: if one is missing or corrupt, none
+//are applied, unless partial is set, which applies the ones before it.
+func (s *Storage) loadDeltas(filename, generation string, filter KeyFilter, partial bool) (int, error) {
+	files, err := deltaFiles(filename)
+	if err != nil || len(files) == 0 {
+		return 0, err
+	}
+	s.rlock("loadDeltas")
+	keys := s.keys
+	s.mu.RUnlock()
+
+	seqs := make([]int, 0, len(files))
+	for seq := range files {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	var deltas [][]deltaRecord
+	for _, seq := range seqs {
+		var records []deltaRecord
+		if records, err = readDeltaFile(files[seq], generation, len(deltas)+1, keys); err == errStaleDelta {
+			//left behind by a crash right after a full save
+			err = nil
+			continue
+		}
+		if err != nil {
+			break
+		}
+		deltas = append(deltas, records)
+	}
+	if err != nil && !partial {
+		return 0, err
+	}
+
+	s.lock("loadDeltas")
+	defer s.mu.Unlock()
+	for _, records := range deltas {
+		for _, rec := range records {
+			if !filter.match(rec.Key) {
+				continue
+			}
+			if rec.Deleted {
+				s.remove(rec.Key)
+				continue
+			}
+			if rec.Item.Version > s.version {
+				s.version = rec.Item.Version
+			}
+			s.replace(rec.Key, rec.Item)
+		}
+	}
+	return len(deltas), err
+}
+
+var errStaleDelta = errors.New("delta belongs to another generation")
+
+//readDeltaFile returns the records of the delta in name, which must be number
+//seq of generation.
+func readDeltaFile(name, generation string, seq int, keys []KEK) ([]deltaRecord, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	h, records, err := readDelta(f, keys)
+	f.Close()
+	switch {
+	case h.Generation != "" && h.Generation != generation:
+		return nil, errStaleDelta
+	case err != nil:
+		return nil, fmt.Errorf("%s: %w", name, err)
+	case h.Seq != seq:
+		return nil, fmt.Errorf("%s: delta %d is missing", name, seq)
+	}
+	return records, nil
+}
+
+//removeDeltas deletes all deltas of filename, which a new full snapshot replaces.
+func removeDeltas(filename string) error {
+	files, err := deltaFiles(filename)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if rerr := os.Remove(name); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStorage_SaveDelta(t *testing.T) {
+	file := t.TempDir() + "/db"
+	s := New(DefaultExpiration, 0, 0)
+	s.EnableDeltas()
+	s.Set("a", "1", NoExpiration)
+	if _, err := s.SaveDelta(file); err != ErrNoBaseSnapshot {
+		t.Errorf("delta without a base snapshot returned %v", err)
+	}
+	s.Set("b", "1", NoExpiration)
+	s.Set("c", "1", NoExpiration)
+	if err := s.SaveFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if s.Changed() != 0 {
+		t.Errorf("%d changes left after a full save", s.Changed())
+	}
+
+	s.Set("a", "2", NoExpiration)
+	s.Delete("b")
+	if n, err := s.SaveDelta(file); err != nil || n != 2 {
+		t.Fatalf("first delta wrote %d records: %v", n, err)
+	}
+	s.Set("d", "1", NoExpiration)
+	s.Set("e", "1", time.Nanosecond)
+	if n, err := s.SaveDelta(file); err != nil || n != 2 {
+		t.Fatalf("second delta wrote %d records: %v", n, err)
+	}
+
+	loaded := New(DefaultExpiration, 0, 0)
+	rec, err := loaded.LoadFileRecover(file, RecoverFail)
+	if err != nil || rec.Deltas != 2 {
+		t.Fatalf("loaded %d deltas: %v", rec.Deltas, err)
+	}
+	for key, want := range map[string]interface{}{"a": "2", "b": nil, "c": "1", "d": "1", "e": nil} {
+		if v, _ := loaded.Get(key); v != want {
+			t.Errorf("%s is %v instead of %v", key, v, want)
+		}
+	}
+	if loaded.Deltas() != 2 {
+		t.Errorf("loaded storage continues after delta %d", loaded.Deltas())
+	}
+
+	//a full save starts a new generation, deltas of the old one must not apply
+	if err = s.SaveFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(DeltaFileName(file, 1)); !os.IsNotExist(err) {
+		t.Errorf("delta of the previous generation wasn't removed: %v", err)
+	}
+}
+
+func TestStorage_LoadFileDeltas(t *testing.T) {
+	file := t.TempDir() + "/db"
+	s := New(DefaultExpiration, 0, 0)
+	s.EnableDeltas()
+	s.Set("a", "1", NoExpiration)
+	s.Set("b", "1", NoExpiration)
+	if err := s.SaveFile(file); err != nil {
+		t.Fatal(err)
+	}
+	s.Set("a", "2", NoExpiration)
+	s.Set("b", "2", NoExpiration)
+	s.Set("c", "2", NoExpiration)
+	if _, err := s.SaveDelta(file); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := New(DefaultExpiration, 0, 0)
+	if err := loaded.LoadFile(file); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]interface{}{"a": "2", "b": "2", "c": "2"} {
+		if v, _ := loaded.Get(key); v != want {
+			t.Errorf("LoadFile: %s is %v instead of %v", key, v, want)
+		}
+	}
+
+	//deltas are filtered like the snapshot
+	filtered := New(DefaultExpiration, 0, 0)
+	n, err := filtered.LoadFileFilter(file, func(key string) bool { return key != "b" })
+	if err != nil || n != 1 {
+		t.Fatalf("LoadFileFilter merged %d items: %v", n, err)
+	}
+	for key, want := range map[string]interface{}{"a": "2", "b": nil, "c": "2"} {
+		if v, _ := filtered.Get(key); v != want {
+			t.Errorf("LoadFileFilter: %s is %v instead of %v", key, v, want)
+		}
+	}
+}
+
+func TestStorage_LoadDeltasStale(t *testing.T) {
+	file := t.TempDir() + "/db"
+	s := New(DefaultExpiration, 0, 0)
+	s.EnableDeltas()
+	s.SaveFile(file)
+	s.Set("a", "1", NoExpiration)
+	s.SaveDelta(file)
+	stale, _ := os.ReadFile(DeltaFileName(file, 1))
+
+	//as if the server crashed between writing a snapshot and removing the deltas
+	s.Set("a", "2", NoExpiration)
+	s.SaveFile(file)
+	os.WriteFile(DeltaFileName(file, 1), stale, 0644)
+
+	loaded := New(DefaultExpiration, 0, 0)
+	if rec, err := loaded.LoadFileRecover(file, RecoverFail); err != nil || rec.Deltas != 0 {
+		t.Fatalf("applied %d deltas: %v", rec.Deltas, err)
+	}
+	if v, _ := loaded.Get("a"); v != "2" {
+		t.Errorf("stale delta was applied: %v", v)
+	}
+}
+
+func TestStorage_SaveDeltaEncrypted(t *testing.T) {
+	file := t.TempDir() + "/db"
+	s := New(DefaultExpiration, 0, 0)
+	s.SetEncryption([]KEK{oldKEK})
+	s.EnableDeltas()
+	s.SaveFile(file)
+	s.Set("secret", "value", NoExpiration)
+	if _, err := s.SaveDelta(file); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := os.ReadFile(DeltaFileName(file, 1))
+	if bytes.Contains(raw, []byte("value")) {
+		t.Error("delta holds the plain value")
+	}
+
+	loaded := New(DefaultExpiration, 0, 0)
+	loaded.SetEncryption([]KEK{newKEK, oldKEK})
+	if _, err := loaded.LoadFileRecover(file, RecoverFail); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := loaded.Get("secret"); v != "value" {
+		t.Errorf("unexpected value: %v", v)
+	}
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"sort"
+)
+
+//DefaultDryRunSample is how many keys a dry run lists by default.
+const DefaultDryRunSample = 10
+
+//DryRun describes the items a destructive operation would affect, without
+//performing it. Bytes estimates their memory like Keyspace does.
+type DryRun struct {
+	Items int `json:"items"`
+	//Replaced counts the affected items which exist already, for loads
+	Replaced int      `json:"replaced,omitempty"`
+	Bytes    int64    `json:"bytes"`
+	Sample   []string `json:"sample"`
+}
+
+func newDryRun(sample int) DryRun {
+	if sample < 0 {
+		sample = DefaultDryRunSample
+	}
+	return DryRun{Sample: make([]string, 0, sample)}
+}
+
+func (d *DryRun) add(key string, item Item) {
+	d.Items++
+	d.Bytes += itemOverhead + int64(len(key)) + valueSize(item.Object)
+	if len(d.Sample) < cap(d.Sample) {
+		d.Sample = append(d.Sample, key)
+	}
+}
+
+func (d *DryRun) done() DryRun {
+	sort.Strings(d.Sample)
+	return *d
+}
+
+//FlushDryRun reports the items Flush would delete, listing up to sample of
+//their keys (a negative sample means DefaultDryRunSample).
+func (s *Storage) FlushDryRun(sample int) DryRun {
+	d := newDryRun(sample)
+	s.rlock("FlushDryRun")
+	for k, v := range s.items {
+		d.add(k, v)
+	}
+	s.mu.RUnlock()
+	return d.done()
+}
+
+//LoadDryRun reports the items of a snapshot selected by filter which Load
+//would merge, counting those replacing existing items as Replaced.
+func (s *Storage) LoadDryRun(r io.Reader, filter KeyFilter, sample int) (DryRun, error) {
+	s.rlock("LoadDryRun")
+	keys := s.keys
+	s.mu.RUnlock()
+
+	items, _, err := readSnapshot(r, keys)
+	if err != nil {
+		return DryRun{}, err
+	}
+	d := newDryRun(sample)
+	s.rlock("LoadDryRun")
+	for k, v := range items {
+		if !filter.match(k) {
+			continue
+		}
+		d.add(k, v)
+		if _, found := s.items[k]; found {
+			d.Replaced++
+		}
+	}
+	s.mu.RUnlock()
+	return d.done(), nil
+}
+
+//LoadFileDryRun is LoadDryRun of the snapshot in filename.
+func (s *Storage) LoadFileDryRun(filename string, filter KeyFilter, sample int) (DryRun, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return DryRun{}, err
+	}
+	defer f.Close()
+	return s.LoadDryRun(f, filter, sample)
+}
+
+//CompactDryRun estimates what Compact would reclaim without rebuilding anything.
+func (s *Storage) CompactDryRun() CompactStats {
+	s.rlock("CompactDryRun")
+	defer s.mu.RUnlock()
+	peak, size := s.peak, len(s.items)
+	if peak < s.capacity {
+		peak = s.capacity
+	}
+	if size < s.capacity {
+		size = s.capacity
+	}
+	st := CompactStats{
+		Items:       len(s.items),
+		BytesBefore: itemsMapBytes(peak),
+		BytesAfter:  itemsMapBytes(size),
+	}
+	for _, byName := range s.indexes {
+		st.Indexes += len(byName)
+	}
+	if s.search != nil {
+		st.Indexes++
+	}
+	return st
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestStorage_FlushDryRun(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	for _, key := range []string{"c", "a", "b"} {
+		s.Set(key, "abc", NoExpiration)
+	}
+	d := s.FlushDryRun(2)
+	if d.Items != 3 || d.Bytes != 3*(itemOverhead+1+3) || len(d.Sample) != 2 {
+		t.Errorf("unexpected dry run: %+v", d)
+	}
+	if d = s.FlushDryRun(0); len(d.Sample) != 0 {
+		t.Errorf("expected no sample, got %v", d.Sample)
+	}
+	if s.ItemCount() != 3 {
+		t.Error("dry run deleted items")
+	}
+}
+
+func TestStorage_LoadDryRun(t *testing.T) {
+	src := New(DefaultExpiration, 0, 0)
+	src.Set("users:1", "new", NoExpiration)
+	src.Set("users:2", "new", NoExpiration)
+	src.Set("orders:1", "new", NoExpiration)
+	buf := bytes.Buffer{}
+	if err := src.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("users:1", "old", NoExpiration)
+	d, err := s.LoadDryRun(bytes.NewReader(buf.Bytes()), NamespaceFilter("users"), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Items != 2 || d.Replaced != 1 || len(d.Sample) != 2 || d.Sample[0] != "users:1" {
+		t.Errorf("unexpected dry run: %+v", d)
+	}
+	if v, _ := s.Get("users:1"); v != "old" || s.ItemCount() != 1 {
+		t.Error("dry run changed items")
+	}
+}
+
+func TestStorage_CompactDryRun(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	for i := 0; i < 2000; i++ {
+		s.Set(strconv.Itoa(i), "v", NoExpiration)
+	}
+	for i := 1; i < 2000; i++ {
+		s.Delete(strconv.Itoa(i))
+	}
+	st := s.CompactDryRun()
+	if st.Items != 1 || st.BytesAfter >= st.BytesBefore {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+	if after := s.CompactDryRun(); after != st {
+		t.Errorf("dry run compacted: %+v", after)
+	}
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//dataKeySize is the size of the AES-256 key generated for every encrypted snapshot.
+const dataKeySize = 32
+
+//KEK is a key encryption key. Values of a snapshot are encrypted with a data key
+//generated for that snapshot, and only the data key is encrypted with the KEK.
+type KEK struct {
+	ID string
+	//Key is 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256
+	Key []byte
+}
+
+//sealedRecord is a record of an encrypted snapshot. Keys stay readable, so an
+//encrypted snapshot can be inspected without its KEK.
+type sealedRecord struct {
+	Key  string
+	Item []byte
+}
+
+//ParseKEKs parses keys in the form "id:base64key,id:base64key". The first key
+//is the current one, the rest are only used to read snapshots written before a rotation.
+func ParseKEKs(s string) ([]KEK, error) {
+	var keys []KEK
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		i := strings.Index(f, ":")
+		if i < 1 {
+			return nil, fmt.Errorf("encryption key must be \"<id>:<base64 key>\"")
+		}
+		key, err := base64.StdEncoding.DecodeString(f[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %s: %w", f[:i], err)
+		}
+		keys = append(keys, KEK{ID: f[:i], Key: key})
+	}
+	return keys, nil
+}
+
+//SetEncryption makes snapshots encrypt values with keys[0]; the other keys are
+//kept to read snapshots encrypted with them. Without keys snapshots are written in clear.
+//Keys are not persisted anywhere, so losing them makes the snapshots unreadable.
+func (s *Storage) SetEncryption(keys []KEK) error {
+	ids := make(map[string]bool)
+	for _, k := range keys {
+		if _, err := aes.NewCipher(k.Key); err != nil {
+			return fmt.Errorf("encryption key %s: %w", k.ID, err)
+		}
+		if ids[k.ID] {
+			return fmt.Errorf("encryption key %s is given twice", k.ID)
+		}
+		ids[k.ID] = true
+	}
+	s.lock("SetEncryption")
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+//EncryptionKey returns the id of the key new snapshots are encrypted with, or "" if encryption is off.
+func (s *Storage) EncryptionKey() string {
+	s.rlock("EncryptionKey")
+	defer s.mu.RUnlock()
+	if len(s.keys) == 0 {
+		return ""
+	}
+	return s.keys[0].ID
+}
+
+//currentKEK must be called with the lock held.
+func (s *Storage) currentKEK() *KEK {
+	if len(s.keys) == 0 {
+		return nil
+	}
+	return &s.keys[0]
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//seal returns the nonce followed by the ciphertext. open fails unless it is
+//given the same additional data.
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, sealed[:n], sealed[n:], additional)
+}
+
+//recordData is the additional data of the sealed item of key in delta seq of
+//generation, or in its snapshot if seq is 0. It binds the item to its record,
+//so an item copied to another key, snapshot or delta doesn't open.
+func recordData(key, generation string, seq int) []byte {
+	//generations and seqs contain no NUL, keys may
+	return []byte(generation + "\x00" + strconv.Itoa(seq) + "\x00" + key)
+}
+
+//newDataKey generates a data key and returns it with its copy encrypted by kek.
+func newDataKey(kek *KEK) (cipher.AEAD, []byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, err
+	}
+	kekAEAD, err := newAEAD(kek.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := seal(kekAEAD, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAEAD(key)
+	return aead, wrapped, err
+}
+
+//openDataKey decrypts the data key of a snapshot with the KEK it was encrypted with.
+func openDataKey(keys []KEK, id string, wrapped []byte) (cipher.AEAD, error) {
+	for _, k := range keys {
+		if k.ID != id {
+			continue
+		}
+		kekAEAD, err := newAEAD(k.Key)
+		if err != nil {
+			return nil, err
+		}
+		key, err := open(kekAEAD, wrapped, nil)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting data key with key %s: %w", id, err)
+		}
+		return newAEAD(key)
+	}
+	return nil, fmt.Errorf("snapshot is encrypted with key %s which is not configured", id)
+}
+
+//sealItem encrypts item with additional data, see recordData.
+func sealItem(aead cipher.AEAD, item Item, additional []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	gob.Register(item.Object)
+	if err := gob.NewEncoder(buf).Encode(&item); err != nil {
+		return nil, err
+	}
+	return seal(aead, buf.Bytes(), additional)
+}
+
+func openItem(aead cipher.AEAD, sealed, additional []byte) (Item, error) {
+	item := Item{}
+	plain, err := open(aead, sealed, additional)
+	if err != nil {
+		return item, err
+	}
+	err = gob.NewDecoder(bytes.NewReader(plain)).Decode(&item)
+	return item, err
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strings"
+	"testing"
+)
+
+var (
+	oldKEK = KEK{ID: "old", Key: bytes.Repeat([]byte{1}, 32)}
+	newKEK = KEK{ID: "new", Key: bytes.Repeat([]byte{2}, 32)}
+)
+
+func TestParseKEKs(t *testing.T) {
+	keys, err := ParseKEKs("new:AgICAgICAgICAgICAgICAg==, old:AQEBAQEBAQEBAQEBAQEBAQ==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].ID != "new" || len(keys[1].Key) != 16 {
+		t.Errorf("unexpected keys: %+v", keys)
+	}
+	for _, s := range []string{"nokey", ":AQ==", "id:not base64"} {
+		if _, err := ParseKEKs(s); err == nil {
+			t.Errorf("%q was accepted", s)
+		}
+	}
+}
+
+func TestStorage_SaveLoadEncrypted(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	if err := s.SetEncryption([]KEK{oldKEK}); err != nil {
+		t.Fatal(err)
+	}
+	s.Set("secret", "plaintext value", NoExpiration)
+	buf := &bytes.Buffer{}
+	if err := s.Save(buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("plaintext value")) {
+		t.Error("value was saved in clear")
+	}
+	raw := buf.Bytes()
+
+	if info := InspectSnapshot(bytes.NewReader(raw), 10); info.Version != sealedSnapshotVersion {
+		t.Errorf("unexpected version: %+v", info)
+	}
+	if err := New(DefaultExpiration, 0, 0).Load(bytes.NewReader(raw)); err == nil || !strings.Contains(err.Error(), "not configured") {
+		t.Errorf("snapshot was loaded without its key: %v", err)
+	}
+
+	dst := New(DefaultExpiration, 0, 0)
+	dst.SetEncryption([]KEK{newKEK, oldKEK})
+	if err := dst.Load(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := dst.Get("secret"); v != "plaintext value" {
+		t.Errorf("secret was not decrypted: %v", v)
+	}
+}
+
+func TestStorage_SetEncryptionInvalidKey(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	if err := s.SetEncryption([]KEK{{ID: "short", Key: []byte("short")}}); err == nil {
+		t.Error("invalid key was accepted")
+	}
+	if err := s.SetEncryption([]KEK{oldKEK, oldKEK}); err == nil {
+		t.Error("duplicate key was accepted")
+	}
+}
+
+func TestStorage_SealedRecordsAreBound(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.SetEncryption([]KEK{oldKEK})
+	s.Set("admin", "yes", NoExpiration)
+	s.Set("guest", "no", NoExpiration)
+	buf := &bytes.Buffer{}
+	if err := s.Save(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	//rewrite the snapshot with the sealed items of the keys swapped
+	dec := gob.NewDecoder(bytes.NewReader(buf.Bytes()[len(snapshotMagic):]))
+	h := snapshotHeader{}
+	if err := dec.Decode(&h); err != nil {
+		t.Fatal(err)
+	}
+	records := make([]sealedRecord, h.Items)
+	for i := range records {
+		if err := dec.Decode(&records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records[0].Key, records[1].Key = records[1].Key, records[0].Key
+	swapped := bytes.NewBuffer(append([]byte{}, snapshotMagic...))
+	enc := gob.NewEncoder(swapped)
+	enc.Encode(&h)
+	for i := range records {
+		enc.Encode(&records[i])
+	}
+
+	dst := New(DefaultExpiration, 0, 0)
+	dst.SetEncryption([]KEK{oldKEK})
+	if err := dst.Load(swapped); err == nil {
+		t.Error("items swapped between keys were loaded")
+	}
+	if v, _ := dst.Get("guest"); v == "yes" {
+		t.Error("item of admin was loaded as guest")
+	}
+}
@@ -0,0 +1,123 @@
+package storage
+
+import "fmt"
+
+//EvictCapacity is the eviction reason of items removed to make room in a full namespace.
+const EvictCapacity = "capacity"
+
+//Class tells which items a full namespace sacrifices first. The zero value is ClassNormal.
+type Class int8
+
+const (
+	//ClassVolatile items are evicted before any other
+	ClassVolatile Class = -1
+	ClassNormal   Class = 0
+	//ClassCritical items are never evicted
+	ClassCritical Class = 1
+)
+
+func ParseClass(s string) (Class, error) {
+	switch s {
+	case "", "normal":
+		return ClassNormal, nil
+	case "volatile":
+		return ClassVolatile, nil
+	case "critical":
+		return ClassCritical, nil
+	}
+	return 0, fmt.Errorf("unknown class %s", s)
+}
+
+func (c Class) String() string {
+	switch c {
+	case ClassVolatile:
+		return "volatile"
+	case ClassCritical:
+		return "critical"
+	}
+	return "normal"
+}
+
+//makeRoom returns ErrNamespaceFull if key is new and its namespace is full, unless
+//the namespace allows eviction and an item can be evicted for an item of class.
+//Expired items go first, then the lowest class and the item expiring soonest.
+//Items of a higher class than the new item and critical items are never evicted.
+//Finding the victim scans all items. Must be called with the write lock held.
+func (s *Storage) makeRoom(key string, class Class) error {
+	err := s.checkNamespaceLimit(key)
+	if err == nil {
+		return nil
+	}
+	name := Namespace(key)
+	if !s.namespaces[name].opts.Evict {
+		return err
+	}
+
+	now := s.now()
+	victim, worst, found := s.findVictim(name, class, now)
+	if !found {
+		return err
+	}
+	fn := s.takeKeyEvent(victim)
+	s.remove(victim)
+	reason := EvictCapacity
+	if worst.expiredAt(now) {
+		reason = EvictExpired
+	}
+	s.evictions[reason]++
+	//a spilled item isn't gone, so its owner is told once it expires instead
+	if reason == EvictCapacity && s.spill(victim, worst) {
+		if fn != nil {
+			s.notifyKey(victim, fn)
+		}
+		return nil
+	}
+	if fn != nil {
+		go fn(victim, worst, reason)
+	}
+	return nil
+}
+
+//findVictim returns the item of the namespace which goes first to make room for
+//an item of class, if any.
+func (s *Storage) findVictim(namespace string, class Class, now int64) (string, Item, bool) {
+	victim, found := "", false
+	var worst Item
+	for k, v := range s.items {
+		if Namespace(k) != namespace {
+			continue
+		}
+		if !v.expiredAt(now) && (v.Class == ClassCritical || v.Class > class) {
+			continue
+		}
+		if !found || evictsBefore(&v, &worst, now) {
+			victim, worst, found = k, v, true
+		}
+	}
+	return victim, worst, found
+}
+
+//canEvict tells whether makeRoom can evict an item of the namespace for an item of class.
+func (s *Storage) canEvict(namespace string, class Class) bool {
+	if !s.namespaces[namespace].opts.Evict {
+		return false
+	}
+	_, _, found := s.findVictim(namespace, class, s.now())
+	return found
+}
+
+//evictsBefore reports whether a should be evicted before b.
+func evictsBefore(a, b *Item, now int64) bool {
+	if ea, eb := a.expiredAt(now), b.expiredAt(now); ea != eb {
+		return ea
+	}
+	if a.Class != b.Class {
+		return a.Class < b.Class
+	}
+	//items without expiration are kept longest
+	ta, tb := a.expiresAt(), b.expiresAt()
+	if ta == 0 || tb == 0 {
+		return tb == 0 && ta != 0
+	}
+	return ta < tb
+}
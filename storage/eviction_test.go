@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStorage_EvictionClasses(t *testing.T) {
+	s := New(NoExpiration, 0, 0)
+	s.SetNamespaceOptions("cache", NamespaceOptions{MaxItems: 3, Evict: true})
+	s.Write("cache:critical", "v", WriteOptions{Class: ClassCritical})
+	s.Write("cache:normal", "v", WriteOptions{})
+	s.Write("cache:volatile", "v", WriteOptions{Class: ClassVolatile})
+
+	if _, err := s.Write("cache:a", "v", WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := s.Get("cache:volatile"); found {
+		t.Error("volatile item was not evicted first")
+	}
+	if _, err := s.Write("cache:b", "v", WriteOptions{Class: ClassVolatile}); err != ErrNamespaceFull {
+		t.Errorf("volatile item evicted a normal one: %v", err)
+	}
+
+	//of normal items the one expiring soonest goes first
+	s.Write("cache:normal", "v", WriteOptions{TTL: time.Hour})
+	if _, err := s.Write("cache:c", "v", WriteOptions{Class: ClassCritical}); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := s.Get("cache:normal"); found {
+		t.Error("item expiring soonest was not evicted")
+	}
+	if _, err := s.Write("cache:d", "v", WriteOptions{Class: ClassCritical}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write("cache:e", "v", WriteOptions{Class: ClassCritical}); err != ErrNamespaceFull {
+		t.Errorf("critical item was evicted: %v", err)
+	}
+	if n := s.Stats().Evictions[EvictCapacity]; n != 3 {
+		t.Errorf("%d evictions were counted instead of 3", n)
+	}
+}
+
+func TestStorage_EvictionDisabled(t *testing.T) {
+	s := New(NoExpiration, 0, 0)
+	s.SetNamespaceOptions("cache", NamespaceOptions{MaxItems: 1})
+	s.Write("cache:a", "v", WriteOptions{Class: ClassVolatile})
+	if _, err := s.Write("cache:b", "v", WriteOptions{}); err != ErrNamespaceFull {
+		t.Errorf("item was evicted although eviction is disabled: %v", err)
+	}
+}
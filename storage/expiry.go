@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+//expiryEntry is the deadline of a specific version of a key.
+type expiryEntry struct {
+	at      int64
+	key     string
+	version uint64
+}
+
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].at < h[j].at }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiryEntry)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+//expiryTracker counts expired items without scanning all of them: deadlines are
+//kept in a heap and keys are moved to expired once their deadline passes.
+//Entries of overwritten items stay in the heap until they are popped or compacted.
+//Sliding items move their deadline on every read, so they are checked one by one.
+type expiryTracker struct {
+	deadlines expiryHeap
+	expired   map[string]struct{}
+	sliding   map[string]struct{}
+}
+
+func newExpiryTracker() *expiryTracker {
+	return &expiryTracker{
+		expired: make(map[string]struct{}),
+		sliding: make(map[string]struct{}),
+	}
+}
+
+func (t *expiryTracker) track(key string, item Item) {
+	t.untrack(key)
+	switch {
+	case item.Sliding > 0:
+		t.sliding[key] = struct{}{}
+	case item.Expiration > 0:
+		heap.Push(&t.deadlines, expiryEntry{at: item.Expiration, key: key, version: item.Version})
+	}
+}
+
+func (t *expiryTracker) untrack(key string) {
+	delete(t.expired, key)
+	delete(t.sliding, key)
+}
+
+//compact drops entries of overwritten items once they outnumber the items.
+//Must be called with the write lock held.
+func (s *Storage) compactExpiry() {
+	if len(s.expiry.deadlines) < 2*len(s.items)+minShrinkSize {
+		return
+	}
+	s.rebuildExpiry()
+}
+
+//rebuildExpiry drops outdated deadlines from the expiry queue.
+func (s *Storage) rebuildExpiry() {
+	t := s.expiry
+	deadlines := make(expiryHeap, 0, len(s.items))
+	for _, e := range t.deadlines {
+		if item, ok := s.items[e.key]; ok && item.Version == e.version && item.Expiration == e.at {
+			deadlines = append(deadlines, e)
+		}
+	}
+	heap.Init(&deadlines)
+	t.deadlines = deadlines
+}
+
+//countExpired returns the number of items expired at now but not deleted yet.
+//Must be called with the write lock held.
+func (s *Storage) countExpired(now int64) int {
+	t := s.expiry
+	for len(t.deadlines) > 0 && t.deadlines[0].at < now {
+		e := heap.Pop(&t.deadlines).(expiryEntry)
+		if item, ok := s.items[e.key]; ok && item.Version == e.version && item.Expiration == e.at && item.Sliding == 0 {
+			t.expired[e.key] = struct{}{}
+		}
+	}
+
+	n := len(t.expired)
+	for k := range t.sliding {
+		if item := s.items[k]; item.expiredAt(now) {
+			n++
+		}
+	}
+	return n
+}
+
+//Counts returns the number of live items and of expired items which
+//haven't been deleted by the janitor yet.
+func (s *Storage) Counts() (live, expired int) {
+	s.lock("Counts")
+	defer s.mu.Unlock()
+	expired = s.countExpired(s.now())
+	return len(s.items) - expired, expired
+}
+
+//ExpiryBucket holds the live items expiring in [Start, Start+width).
+type ExpiryBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+	Keys  []string  `json:"keys,omitempty"`
+}
+
+//Expirations groups live items expiring within the given duration from now
+//into buckets of width, empty ones included, so upcoming expiration storms can
+//be spotted. Sliding items are counted as if they weren't read anymore.
+//With withKeys set, the sorted keys of each bucket are returned as well.
+func (s *Storage) Expirations(within, width time.Duration, withKeys bool) []ExpiryBucket {
+	s.rlock("Expirations")
+	defer s.mu.RUnlock()
+
+	now := s.now()
+	n := int((within + width - 1) / width)
+	buckets := make([]ExpiryBucket, n)
+	for i := range buckets {
+		buckets[i].Start = time.Unix(0, now+int64(i)*int64(width))
+	}
+	for k, v := range s.items {
+		at := v.expiresAt()
+		if at == 0 || at < now || at >= now+int64(within) {
+			continue
+		}
+		b := &buckets[(at-now)/int64(width)]
+		b.Count++
+		if withKeys {
+			b.Keys = append(b.Keys, k)
+		}
+	}
+	for _, b := range buckets {
+		sort.Strings(b.Keys)
+	}
+	return buckets
+}
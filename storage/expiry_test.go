@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStorage_Counts(t *testing.T) {
+	c := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(c)
+	s.Set("forever", "v", NoExpiration)
+	s.Set("short", "v", time.Minute)
+	s.Set("overwritten", "v", time.Minute)
+	s.Set("overwritten", "v", time.Hour)
+	s.Set("deleted", "v", time.Minute)
+	s.SetSliding("sliding", "v", time.Minute)
+
+	if live, expired := s.Counts(); live != 5 || expired != 0 {
+		t.Errorf("expected 5 live and 0 expired items, got %d and %d", live, expired)
+	}
+
+	c.now = c.now.Add(2 * time.Minute)
+	s.Delete("deleted")
+	if live, expired := s.Counts(); live != 2 || expired != 2 {
+		t.Errorf("expected 2 live and 2 expired items, got %d and %d", live, expired)
+	}
+
+	s.Set("short", "v", time.Minute)
+	if st := s.Stats(); st.Live != 3 || st.Expired != 1 || st.Items != 4 {
+		t.Errorf("unexpected stats after rewriting an expired key: %+v", st)
+	}
+
+	s.DeleteExpired()
+	if live, expired := s.Counts(); live != 3 || expired != 0 {
+		t.Errorf("expected 3 live and 0 expired items, got %d and %d", live, expired)
+	}
+}
+
+func TestStorage_CompactExpiry(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	for i := 0; i < 10*minShrinkSize; i++ {
+		s.Set("k", i, time.Hour)
+	}
+	if n := len(s.expiry.deadlines); n > 2+minShrinkSize {
+		t.Errorf("%d deadlines are tracked for a single key", n)
+	}
+}
+
+func TestStorage_Expirations(t *testing.T) {
+	c := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(c)
+	s.Set("forever", "v", NoExpiration)
+	s.Set("b", "v", 30*time.Second)
+	s.Set("a", "v", 59*time.Second)
+	s.Set("c", "v", 90*time.Second)
+	s.SetSliding("sliding", "v", 150*time.Second)
+	s.Set("later", "v", time.Hour)
+
+	buckets := s.Expirations(3*time.Minute, time.Minute, true)
+	if len(buckets) != 3 {
+		t.Fatalf("%d buckets instead of 3", len(buckets))
+	}
+	counts := []int{2, 1, 1}
+	for i, b := range buckets {
+		if b.Count != counts[i] {
+			t.Errorf("bucket %d has %d items instead of %d", i, b.Count, counts[i])
+		}
+		if !b.Start.Equal(c.now.Add(time.Duration(i) * time.Minute)) {
+			t.Errorf("bucket %d starts at %s", i, b.Start)
+		}
+	}
+	if keys := buckets[0].Keys; len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+	if buckets := s.Expirations(time.Minute, time.Minute, false); buckets[0].Keys != nil {
+		t.Error("keys were returned without withKeys")
+	}
+}
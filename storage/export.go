@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+func init() {
+	//values of these types are created by the storage itself and must be
+	//decodable even if nothing was saved by this process before
+	gob.Register(WindowCounter{})
+	gob.Register(Stream{})
+	gob.Register(Queue{})
+	gob.Register(TimeSeries{})
+	gob.Register(ChunkedValue{})
+	gob.Register(ScheduledOp{})
+	gob.Register(compressedValue{})
+}
+
+//Record is a single item in an export stream.
+type Record struct {
+	Key  string
+	Item Item
+}
+
+//Snapshot is a read-only view of the live items at one point in time.
+//Writes made after it was taken are never visible through it.
+type Snapshot struct {
+	//At is the time of the storage clock and Version the last version
+	//assigned when the snapshot was taken
+	At      time.Time
+	Version uint64
+	items   map[string]Item
+}
+
+//Snapshot copies the live items selected by filter under the read lock, so
+//long exports and scans of the copy neither block writers nor see a mix of
+//old and new values. Values are never modified in place, so the copy is shallow.
+func (s *Storage) Snapshot(filter KeyFilter) *Snapshot {
+	sn, _ := s.SnapshotContext(context.Background(), filter)
+	return sn
+}
+
+//SnapshotContext is Snapshot which gives up with the error of ctx once it is done.
+func (s *Storage) SnapshotContext(ctx context.Context, filter KeyFilter) (*Snapshot, error) {
+	s.rlock("Snapshot")
+	defer s.mu.RUnlock()
+	items, err := s.liveItemsContext(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		At:      time.Unix(0, s.now()),
+		Version: s.version,
+		items:   items,
+	}, nil
+}
+
+func (sn *Snapshot) Len() int {
+	return len(sn.items)
+}
+
+func (sn *Snapshot) Get(key string) (Item, bool) {
+	item, ok := sn.items[key]
+	item.Object = plain(item.Object)
+	return item, ok
+}
+
+//Keys returns the keys of the snapshot in order.
+func (sn *Snapshot) Keys() []string {
+	keys := make([]string, 0, len(sn.items))
+	for k := range sn.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//Range calls fn for every item in no particular order until fn returns false.
+func (sn *Snapshot) Range(fn func(key string, item Item) bool) {
+	for k, v := range sn.items {
+		v.Object = plain(v.Object)
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+//Export writes live items selected by filter as a stream of gob-encoded records,
+//see Snapshot.Export.
+func (s *Storage) Export(w io.Writer, filter KeyFilter, progress func(n, total int) error) (int, error) {
+	return s.Snapshot(filter).Export(w, progress)
+}
+
+//Export writes the items as a stream of gob-encoded records, which unlike Save
+//can be consumed item by item. progress is called after every record with the
+//number of records written and the total; returning an error aborts the export.
+func (sn *Snapshot) Export(w io.Writer, progress func(n, total int) error) (int, error) {
+	enc := gob.NewEncoder(w)
+	n := 0
+	for k, v := range sn.items {
+		gob.Register(v.Object)
+		if err := enc.Encode(&Record{Key: k, Item: v}); err != nil {
+			return n, err
+		}
+		n++
+		if progress != nil {
+			if err := progress(n, len(sn.items)); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+//Import reads records written by Export and stores the ones selected by filter
+//keeping their expiration. Items get new versions of this storage. It stops at
+//the first new key which doesn't fit into the item limit of its namespace.
+func (s *Storage) Import(r io.Reader, filter KeyFilter, progress func(n int) error) (int, error) {
+	dec := gob.NewDecoder(r)
+	n := 0
+	for {
+		rec := Record{}
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if !filter.match(rec.Key) {
+			continue
+		}
+
+		s.lock("Import")
+		err = s.makeRoom(rec.Key, rec.Item.Class)
+		if err == nil {
+			s.put(rec.Key, rec.Item)
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return n, fmt.Errorf("%s: %w", rec.Key, err)
+		}
+
+		n++
+		if progress != nil {
+			if err = progress(n); err != nil {
+				return n, err
+			}
+		}
+	}
+}
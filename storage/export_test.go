@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStorage_ExportImport(t *testing.T) {
+	src := New(DefaultExpiration, 0, 0)
+	src.Set("a", "1", time.Hour)
+	src.Set("b", "2", NoExpiration)
+	src.Set("expired", "3", time.Nanosecond)
+	src.IncrWindow("hits", time.Minute, 5, 3)
+	time.Sleep(time.Millisecond)
+
+	buf := &bytes.Buffer{}
+	n, err := src.Export(buf, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("exported %d records instead of 3", n)
+	}
+
+	dst := New(DefaultExpiration, 0, 0)
+	if n, err = dst.Import(buf, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("imported %d records instead of 3", n)
+	}
+	if v, _ := dst.Get("a"); v != "1" {
+		t.Errorf("a is not 1: %v", v)
+	}
+	if a := dst.Items()["a"]; a.Expiration != src.Items()["a"].Expiration {
+		t.Error("expiration was not preserved")
+	}
+	if _, ok := dst.Items()["hits"].Object.(WindowCounter); !ok {
+		t.Error("window counter was not restored")
+	}
+}
+
+func TestStorage_ExportAbort(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("a", "1", DefaultExpiration)
+	s.Set("b", "2", DefaultExpiration)
+
+	stop := errors.New("stop")
+	n, err := s.Export(&bytes.Buffer{}, nil, func(n, total int) error {
+		if total != 2 {
+			t.Errorf("total is not 2: %d", total)
+		}
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("export was not aborted: %d, %v", n, err)
+	}
+}
+
+func TestStorage_Snapshot(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("a", "1", DefaultExpiration)
+	s.Set("b", "1", DefaultExpiration)
+	s.Set("expired", "1", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	sn := s.Snapshot(nil)
+	s.Set("a", "2", DefaultExpiration)
+	s.Delete("b")
+	s.Set("c", "1", DefaultExpiration)
+
+	if keys := sn.Keys(); len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+	if item, _ := sn.Get("a"); item.Object != "1" {
+		t.Errorf("snapshot sees a later write: %v", item.Object)
+	}
+	if sn.Version >= s.version {
+		t.Error("snapshot version is not older than the storage version")
+	}
+
+	dst := New(DefaultExpiration, 0, 0)
+	buf := &bytes.Buffer{}
+	if _, err := sn.Export(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	dst.Import(buf, nil, nil)
+	if v, _ := dst.Get("b"); v != "1" {
+		t.Error("deleted item is missing from the export of the snapshot")
+	}
+}
+
+func TestStorage_SnapshotContext(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	for i := 0; i < 3*ctxCheckInterval; i++ {
+		s.Set(fmt.Sprint(i), i, DefaultExpiration)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.SnapshotContext(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("snapshot wasn't aborted: %v", err)
+	}
+	if _, err := s.ItemsContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("listing items wasn't aborted: %v", err)
+	}
+	if m, err := s.ItemsContext(context.Background()); err != nil || len(m) != 3*ctxCheckInterval {
+		t.Errorf("unexpected items: %d, %v", len(m), err)
+	}
+}
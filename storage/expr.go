@@ -0,0 +1,443 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//Expr is a side effect free expression evaluated against a JSON document, e.g.
+//	$.qty > 0 && ($.status == "new" || $.status == "paid")
+//Operands are JSON values: numbers, "strings", true, false, null and paths like
+//$.items[0].price, which are null if the document has no such field.
+//Operators are || && ! == != < <= > >= + - and functions are now(), the time
+//of the storage clock in RFC 3339, and len(v) of strings, arrays and objects.
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+//exprEnv is what expressions are evaluated against.
+type exprEnv struct {
+	doc interface{}
+	now time.Time
+}
+
+type exprNode interface {
+	eval(env *exprEnv) (interface{}, error)
+}
+
+func ParseExpr(src string) (*Expr, error) {
+	p := &exprParser{src: src}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s in %s", p.tok.text, src)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+//Eval evaluates the expression against doc at the time now.
+func (e *Expr) Eval(doc interface{}, now time.Time) (interface{}, error) {
+	return e.root.eval(&exprEnv{doc: doc, now: now})
+}
+
+const (
+	tokEOF = iota
+	tokNumber
+	tokString
+	tokPath
+	tokIdent
+	tokOp
+)
+
+type exprToken struct {
+	kind int
+	text string
+}
+
+type exprParser struct {
+	src string
+	pos int
+	tok exprToken
+}
+
+//next reads the following token into p.tok.
+func (p *exprParser) next() error {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	if p.pos == len(p.src) {
+		p.tok = exprToken{kind: tokEOF, text: "end"}
+		return nil
+	}
+	start := p.pos
+	c := p.src[p.pos]
+	switch {
+	case c == '"':
+		p.pos++
+		for p.pos < len(p.src) && p.src[p.pos] != '"' {
+			if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.pos >= len(p.src) {
+			return fmt.Errorf("unterminated string in %s", p.src)
+		}
+		p.pos++
+		p.tok = exprToken{kind: tokString, text: p.src[start:p.pos]}
+	case c == '$':
+		p.pos++
+		for p.pos < len(p.src) {
+			c := p.src[p.pos]
+			if c == '[' {
+				end := strings.IndexByte(p.src[p.pos:], ']')
+				if end == -1 {
+					return fmt.Errorf("unclosed bracket in %s", p.src)
+				}
+				p.pos += end + 1
+				continue
+			}
+			if c != '.' && c != '_' && !unicode.IsLetter(rune(c)) && !unicode.IsDigit(rune(c)) {
+				break
+			}
+			p.pos++
+		}
+		p.tok = exprToken{kind: tokPath, text: p.src[start:p.pos]}
+	case c >= '0' && c <= '9' || c == '.':
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		p.tok = exprToken{kind: tokNumber, text: p.src[start:p.pos]}
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+		p.tok = exprToken{kind: tokIdent, text: p.src[start:p.pos]}
+	default:
+		for _, op := range []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "(", ")", ","} {
+			if strings.HasPrefix(p.src[p.pos:], op) {
+				p.pos += len(op)
+				p.tok = exprToken{kind: tokOp, text: op}
+				return nil
+			}
+		}
+		return fmt.Errorf("unexpected %q in %s", c, p.src)
+	}
+	return nil
+}
+
+func (p *exprParser) isOp(ops ...string) bool {
+	if p.tok.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if p.tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.isOp(op) {
+		return fmt.Errorf("expected %s instead of %s in %s", op, p.tok.text, p.src)
+	}
+	return p.next()
+}
+
+//parseBinary parses operands separated by any of ops, left associative.
+func (p *exprParser) parseBinary(operand func() (exprNode, error), ops ...string) (exprNode, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(ops...) {
+		op := p.tok.text
+		if err = p.next(); err != nil {
+			return nil, err
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.parseBinary(p.parseCompare, "&&")
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	return p.parseBinary(p.parseSum, "==", "!=", "<", "<=", ">", ">=")
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	return p.parseBinary(p.parseUnary, "+", "-")
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.isOp("!", "-") {
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.tok
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	switch tok.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s in %s", tok.text, p.src)
+		}
+		return literalNode{f}, nil
+	case tokString:
+		s, err := strconv.Unquote(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s in %s", tok.text, p.src)
+		}
+		return literalNode{s}, nil
+	case tokPath:
+		segs, err := parseJSONPath(tok.text)
+		if err != nil {
+			return nil, err
+		}
+		return pathNode(segs), nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return literalNode{true}, nil
+		case "false":
+			return literalNode{false}, nil
+		case "null":
+			return literalNode{nil}, nil
+		}
+		return p.parseCall(tok.text)
+	}
+	if tok.kind == tokOp && tok.text == "(" {
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	}
+	return nil, fmt.Errorf("unexpected %s in %s", tok.text, p.src)
+}
+
+func (p *exprParser) parseCall(name string) (exprNode, error) {
+	arity, ok := exprFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s in %s", name, p.src)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []exprNode
+	for !p.isOp(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if len(args) != arity {
+		return nil, fmt.Errorf("%s takes %d arguments in %s", name, arity, p.src)
+	}
+	return &callNode{name: name, args: args}, p.next()
+}
+
+//exprFuncs maps function names to the number of their arguments.
+var exprFuncs = map[string]int{
+	"now": 0,
+	"len": 1,
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) eval(*exprEnv) (interface{}, error) {
+	return n.value, nil
+}
+
+type pathNode []interface{}
+
+func (n pathNode) eval(env *exprEnv) (interface{}, error) {
+	v, err := lookupJSONPath(env.doc, n)
+	if err != nil {
+		//missing fields are null, so that optional fields can be checked
+		return nil, nil
+	}
+	return v, nil
+}
+
+type callNode struct {
+	name string
+	args []exprNode
+}
+
+func (n *callNode) eval(env *exprEnv) (interface{}, error) {
+	switch n.name {
+	case "now":
+		return env.now.UTC().Format(time.RFC3339Nano), nil
+	case "len":
+		v, err := n.args[0].eval(env)
+		if err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case string:
+			return float64(len(v)), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		}
+		return nil, fmt.Errorf("len of %s", jsonType(v))
+	}
+	return nil, fmt.Errorf("unknown function %s", n.name)
+}
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+func (n *unaryNode) eval(env *exprEnv) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "!":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("! of %s", jsonType(v))
+		}
+		return !b, nil
+	default:
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("- of %s", jsonType(v))
+		}
+		return -f, nil
+	}
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *binaryNode) eval(env *exprEnv) (interface{}, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	//|| and && short-circuit like in Go
+	if n.op == "||" || n.op == "&&" {
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s of %s", n.op, jsonType(l))
+		}
+		if lb == (n.op == "||") {
+			return lb, nil
+		}
+		r, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := r.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s of %s", n.op, jsonType(r))
+		}
+		return rb, nil
+	}
+
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return reflect.DeepEqual(l, r), nil
+	case "!=":
+		return !reflect.DeepEqual(l, r), nil
+	case "+":
+		if ls, ok := l.(string); ok {
+			if rs, ok := r.(string); ok {
+				return ls + rs, nil
+			}
+		}
+	}
+
+	lf, lok := l.(float64)
+	rf, rok := r.(float64)
+	if lok && rok {
+		switch n.op {
+		case "+":
+			return lf + rf, nil
+		case "-":
+			return lf - rf, nil
+		case "<":
+			return lf < rf, nil
+		case "<=":
+			return lf <= rf, nil
+		case ">":
+			return lf > rf, nil
+		case ">=":
+			return lf >= rf, nil
+		}
+	}
+	ls, lok := l.(string)
+	rs, rok := r.(string)
+	if lok && rok {
+		switch n.op {
+		case "<":
+			return ls < rs, nil
+		case "<=":
+			return ls <= rs, nil
+		case ">":
+			return ls > rs, nil
+		case ">=":
+			return ls >= rs, nil
+		}
+	}
+	return nil, fmt.Errorf("%s %s %s", jsonType(l), n.op, jsonType(r))
+}
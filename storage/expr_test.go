@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestExpr_Eval(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(`{"qty":3,"status":"paid","tags":["a","b"],"user":{"name":"bob"}}`), &doc)
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{`$.qty > 0 && $.status == "paid"`, true},
+		{`$.qty - 5 >= 0 || !($.status != "paid")`, true},
+		{`-$.qty + 1`, -2.0},
+		{`len($.tags) == 2 && len($.user.name) == 3`, true},
+		{`$.tags[1] + "c"`, "bc"},
+		{`$.missing == null`, true},
+		{`$.user["name"] < "carl"`, true},
+		{`now()`, "2020-01-02T03:04:05Z"},
+		//the right side is not evaluated, so its type error doesn't matter
+		{`false && $.qty`, false},
+	}
+	for _, tt := range tests {
+		e, err := ParseExpr(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		got, err := e.Eval(doc, now)
+		if err != nil || got != tt.want {
+			t.Errorf("%s = %v, %v; want %v", tt.src, got, err, tt.want)
+		}
+	}
+}
+
+func TestExpr_Errors(t *testing.T) {
+	for _, src := range []string{`$.a ==`, `"open`, `foo()`, `len()`, `($.a`, `$.a # 1`} {
+		if _, err := ParseExpr(src); err == nil {
+			t.Errorf("%s was parsed", src)
+		}
+	}
+	e, _ := ParseExpr(`$.a + 1`)
+	if _, err := e.Eval(map[string]interface{}{"a": "x"}, time.Now()); err == nil {
+		t.Error("string + number was evaluated")
+	}
+}
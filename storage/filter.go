@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"os"
+	"strings"
+)
+
+//KeyFilter selects the keys of a selective backup or restore, e.g. of a single tenant.
+//A nil filter selects every key.
+type KeyFilter func(key string) bool
+
+func PrefixFilter(prefix string) KeyFilter {
+	return func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	}
+}
+
+func NamespaceFilter(namespace string) KeyFilter {
+	return func(key string) bool {
+		return Namespace(key) == namespace
+	}
+}
+
+func (f KeyFilter) match(key string) bool {
+	return f == nil || f(key)
+}
+
+//SaveFileFilter saves only the items selected by filter. Since the file holds
+//a part of the keyspace, it does not count as a save for Dirty and LastSave.
+func (s *Storage) SaveFileFilter(filename string, filter KeyFilter) error {
+	s.rlock("SaveFileFilter")
+	m := s.liveItems(filter)
+	kek := s.currentKEK()
+	s.mu.RUnlock()
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = writeSnapshot(f, m, kek, ""); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//LoadFileFilter merges only the items of filename and of its deltas selected by
+//filter and returns the number of items of the snapshot; other keys of the
+//storage are left untouched.
+func (s *Storage) LoadFileFilter(filename string, filter KeyFilter) (int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n, generation, err := s.load(f, false, filter)
+	if err != nil || generation == "" {
+		return n, err
+	}
+	_, err = s.loadDeltas(filename, generation, filter, false)
+	return n, err
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyFilter(t *testing.T) {
+	if !PrefixFilter("user:1").match("user:10") || PrefixFilter("user:1").match("user:2") {
+		t.Error("prefix filter")
+	}
+	if !NamespaceFilter("user").match("user:1") || NamespaceFilter("user").match("users:1") {
+		t.Error("namespace filter")
+	}
+	if !KeyFilter(nil).match("anything") {
+		t.Error("nil filter must select every key")
+	}
+}
+
+func TestStorage_ExportImportFilter(t *testing.T) {
+	src := New(DefaultExpiration, 0, 0)
+	src.Set("acme:a", "1", NoExpiration)
+	src.Set("acme:b", "2", NoExpiration)
+	src.Set("other:a", "3", NoExpiration)
+
+	buf := &bytes.Buffer{}
+	if n, err := src.Export(buf, NamespaceFilter("acme"), nil); err != nil || n != 2 {
+		t.Fatalf("exported %d records: %v", n, err)
+	}
+	dst := New(DefaultExpiration, 0, 0)
+	if n, err := dst.Import(buf, PrefixFilter("acme:a"), nil); err != nil || n != 1 {
+		t.Fatalf("imported %d records: %v", n, err)
+	}
+	if _, found := dst.Get("acme:b"); found {
+		t.Error("acme:b was imported")
+	}
+}
+
+func TestStorage_SaveLoadFileFilter(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "acme.dat")
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("acme:a", "1", NoExpiration)
+	s.Set("other:a", "2", NoExpiration)
+	if err := s.SaveFileFilter(filename, NamespaceFilter("acme")); err != nil {
+		t.Fatal(err)
+	}
+	if s.Dirty() == 0 {
+		t.Error("partial save reset dirty")
+	}
+
+	s.Set("acme:a", "changed", NoExpiration)
+	s.Set("other:a", "changed", NoExpiration)
+	if n, err := s.LoadFileFilter(filename, NamespaceFilter("acme")); err != nil || n != 1 {
+		t.Fatalf("loaded %d items: %v", n, err)
+	}
+	if v, _ := s.Get("acme:a"); v != "1" {
+		t.Errorf("acme:a was not restored: %v", v)
+	}
+	if v, _ := s.Get("other:a"); v != "changed" {
+		t.Errorf("other:a was touched: %v", v)
+	}
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+//DefaultGrowthSamples is how many samples TrackGrowth keeps if given no number.
+const DefaultGrowthSamples = 60
+
+type growthSample struct {
+	at    int64
+	items int
+	//namespaces holds the items of namespaces with max items
+	namespaces map[string]int
+}
+
+type growth struct {
+	mu      sync.Mutex
+	max     int
+	samples []growthSample
+}
+
+//Forecast extrapolates the number of items of a namespace, or of the whole
+//storage if Namespace is empty, from its growth over the samples.
+type Forecast struct {
+	Namespace string `json:"namespace,omitempty"`
+	Items     int    `json:"items"`
+	MaxItems  int    `json:"max_items,omitempty"`
+	//Rate is the growth in items per second, negative if the items decrease
+	Rate float64 `json:"rate"`
+	//FullAt is when MaxItems is reached at Rate, unset if it isn't
+	FullAt *time.Time `json:"full_at,omitempty"`
+}
+
+//TrackGrowth keeps the last samples taken by SampleGrowth (DefaultGrowthSamples
+//if it is 0), dropping the ones taken before. A negative number disables it.
+func (s *Storage) TrackGrowth(samples int) {
+	if samples == 0 {
+		samples = DefaultGrowthSamples
+	}
+	g := &s.growth
+	g.mu.Lock()
+	g.max, g.samples = samples, nil
+	g.mu.Unlock()
+}
+
+//SampleGrowth records the number of items of the storage and of every namespace
+//with max items, so they include writes, deletes, expirations and evictions.
+//It is meant to be called at a regular interval.
+func (s *Storage) SampleGrowth() {
+	s.rlock("SampleGrowth")
+	sample := growthSample{at: s.now(), items: len(s.items), namespaces: make(map[string]int)}
+	for name, ns := range s.namespaces {
+		if ns.opts.MaxItems > 0 {
+			sample.namespaces[name] = ns.items
+		}
+	}
+	s.mu.RUnlock()
+
+	g := &s.growth
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.max <= 0 {
+		return
+	}
+	g.samples = append(g.samples, sample)
+	if len(g.samples) > g.max {
+		g.samples = append(g.samples[:0], g.samples[len(g.samples)-g.max:]...)
+	}
+}
+
+//Forecast returns the forecast of the whole storage followed by the ones of
+//namespaces with max items, ordered by namespace. It returns nil until
+//TrackGrowth is enabled and needs two samples to tell a rate.
+func (s *Storage) Forecast() []Forecast {
+	g := &s.growth
+	g.mu.Lock()
+	if g.max <= 0 {
+		g.mu.Unlock()
+		return nil
+	}
+	samples := append([]growthSample(nil), g.samples...)
+	g.mu.Unlock()
+
+	s.rlock("Forecast")
+	now := s.now()
+	res := []Forecast{{Items: len(s.items)}}
+	for name, ns := range s.namespaces {
+		if ns.opts.MaxItems > 0 {
+			res = append(res, Forecast{Namespace: name, Items: ns.items, MaxItems: ns.opts.MaxItems})
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(res[1:], func(i, j int) bool { return res[1+i].Namespace < res[1+j].Namespace })
+
+	for i := range res {
+		f := &res[i]
+		f.Rate = growthRate(samples, f.Namespace)
+		if f.MaxItems == 0 {
+			continue
+		}
+		switch {
+		case f.Items >= f.MaxItems:
+			at := time.Unix(0, now)
+			f.FullAt = &at
+		case f.Rate > 0:
+			at := time.Unix(0, now).Add(time.Duration(float64(f.MaxItems-f.Items) / f.Rate * float64(time.Second)))
+			f.FullAt = &at
+		}
+	}
+	return res
+}
+
+//growthRate is the slope of the least squares line through the items of
+//namespace in samples, in items per second.
+func growthRate(samples []growthSample, namespace string) float64 {
+	var n, sumT, sumY, sumTT, sumTY float64
+	for _, sm := range samples {
+		y := sm.items
+		if namespace != "" {
+			var ok bool
+			if y, ok = sm.namespaces[namespace]; !ok {
+				continue
+			}
+		}
+		//seconds since the first sample keep the sums small
+		t := time.Duration(sm.at - samples[0].at).Seconds()
+		n++
+		sumT += t
+		sumY += float64(y)
+		sumTT += t * t
+		sumTY += t * float64(y)
+	}
+	d := n*sumTT - sumT*sumT
+	if n < 2 || d == 0 {
+		return 0
+	}
+	return (n*sumTY - sumT*sumY) / d
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStorage_Forecast(t *testing.T) {
+	c := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(c)
+	s.SetNamespaceOptions("users", NamespaceOptions{MaxItems: 100})
+	s.SetNamespaceOptions("cache", NamespaceOptions{MaxItems: 1000})
+	if f := s.Forecast(); f != nil {
+		t.Fatalf("forecast without tracking: %+v", f)
+	}
+	s.TrackGrowth(3)
+
+	//users grow by 10 items a minute, cache doesn't grow
+	s.Set("cache:1", "v", NoExpiration)
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 10; j++ {
+			s.Set("users:"+strconv.Itoa(i*10+j), "v", NoExpiration)
+		}
+		s.SampleGrowth()
+		c.now = c.now.Add(time.Minute)
+	}
+	if n := len(s.growth.samples); n != 3 {
+		t.Errorf("%d samples were kept", n)
+	}
+
+	f := s.Forecast()
+	if len(f) != 3 || f[0].Namespace != "" || f[1].Namespace != "cache" || f[2].Namespace != "users" {
+		t.Fatalf("unexpected forecasts: %+v", f)
+	}
+	if f[0].Items != 41 || f[0].FullAt != nil {
+		t.Errorf("unexpected forecast of the storage: %+v", f[0])
+	}
+	if f[1].Rate != 0 || f[1].FullAt != nil {
+		t.Errorf("cache is expected to never fill up: %+v", f[1])
+	}
+	users := f[2]
+	if users.Items != 40 || users.Rate < 0.16 || users.Rate > 0.17 {
+		t.Fatalf("unexpected forecast of users: %+v", users)
+	}
+	//60 items left at 10 a minute
+	want := c.now.Add(6 * time.Minute)
+	if users.FullAt == nil || users.FullAt.Before(want.Add(-time.Second)) || users.FullAt.After(want.Add(time.Second)) {
+		t.Errorf("users are expected to be full at %v: %v", want, users.FullAt)
+	}
+}
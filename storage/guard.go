@@ -0,0 +1,32 @@
+package storage
+
+//KeyGuard rejects writes of a key with an error, e.g. because another client
+//locked it.
+type KeyGuard func(key string) error
+
+//Guarded writes to a storage only the keys its guard accepts. The guard is
+//called with the write lock held, so the check and the write are atomic: once
+//the guard rejects a key, e.g. because it was locked, the writes it accepted
+//before are applied already.
+type Guarded struct {
+	s     *Storage
+	guard KeyGuard
+}
+
+//Guard returns s writing only the keys guard accepts. A nil guard accepts every key.
+func (s *Storage) Guard(guard KeyGuard) Guarded {
+	return Guarded{s, guard}
+}
+
+//check must be called with the write lock held.
+func (g Guarded) check(keys ...string) error {
+	if g.guard == nil {
+		return nil
+	}
+	for _, key := range keys {
+		if err := g.guard(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
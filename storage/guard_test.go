@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGuarded(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("locked", "1", NoExpiration)
+	s.Set("free", "1", NoExpiration)
+	s.XAdd("locked-stream", map[string]string{"f": "v"})
+	errLocked := errors.New("locked")
+	g := s.Guard(func(key string) error {
+		//the check and the write are atomic
+		if s.mu.TryRLock() {
+			s.mu.RUnlock()
+			t.Error("guard was called without the write lock")
+		}
+		if key == "locked" || key == "locked-stream" {
+			return errLocked
+		}
+		return nil
+	})
+
+	rejected := map[string]error{
+		"Write": func() error {
+			_, err := s.Write("locked", "2", WriteOptions{Guard: g.guard})
+			return err
+		}(),
+		"Delete": func() error {
+			_, err := g.Delete("locked")
+			return err
+		}(),
+		"Incr": func() error {
+			_, err := g.Incr("locked", 1, 0)
+			return err
+		}(),
+		"IncrWindow": func() error {
+			_, err := g.IncrWindow("locked", time.Minute, 1, 1)
+			return err
+		}(),
+		"JSONSet": g.JSONSet("locked", "$.a", 1),
+		"QPush": func() error {
+			_, err := g.QPush("locked", "m")
+			return err
+		}(),
+		"XTrim": func() error {
+			_, err := g.XTrim("locked-stream", 0, time.Nanosecond)
+			return err
+		}(),
+		"TSAdd": g.TSAdd("locked", time.Now(), 1, 0),
+		"Rename": func() error {
+			_, err := g.Rename("free", "locked", true)
+			return err
+		}(),
+		"Rotate": func() error {
+			_, err := g.Rotate([]string{"free", "locked"}, true)
+			return err
+		}(),
+		"DeleteVersions": g.DeleteVersions([]DeleteGuard{{Key: "locked", Version: 1}})[0],
+		"Batch": func() error {
+			b := g.Batch()
+			b.Set("free", "2", NoExpiration)
+			b.Delete("locked")
+			return b.Commit()
+		}(),
+	}
+	for op, err := range rejected {
+		if err != errLocked {
+			t.Errorf("%s of a locked key returned %v", op, err)
+		}
+	}
+	if v, _ := s.Get("locked"); v != "1" {
+		t.Errorf("locked key was written: %v", v)
+	}
+	if v, _ := s.Get("free"); v != "1" {
+		t.Errorf("batch with a locked key was applied: %v", v)
+	}
+	if n, _ := s.XLen("locked-stream"); n != 1 {
+		t.Errorf("locked stream was trimmed to %d entries", n)
+	}
+
+	//other keys are written
+	if _, err := g.Incr("free", 1, 0); err != nil {
+		t.Error(err)
+	}
+	if _, err := s.Write("free", "3", WriteOptions{Guard: g.guard}); err != nil {
+		t.Error(err)
+	}
+	if deleted, err := g.Delete("free"); !deleted || err != nil {
+		t.Errorf("delete of a free key: %v, %v", deleted, err)
+	}
+}
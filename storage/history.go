@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//Every operation of the storage takes effect atomically at a single point
+//between its call and its return: writes hold the write lock while they check
+//their conditions and store the item, reads see the item of the last write which
+//released the lock before they took the read lock. Operations on one storage are
+//therefore linearizable, which makes Write with IfVersion usable as a
+//compare-and-set, e.g. for locks. Versions only grow, so a read never sees an
+//older version of a key than a read which returned before it was called.
+//
+//RecordHistory records Get, GetWithVersion, GetItem, Write and Delete so that
+//CheckLinearizable can verify these guarantees in tests and benchmarks. Histories
+//must not contain other writes of the recorded keys, nor expirations.
+
+//MaxHistory bounds the recorded operations; later ones are dropped and the
+//history is marked truncated.
+var MaxHistory = 100000
+
+const (
+	HistoryRead   = "read"
+	HistoryWrite  = "write"
+	HistoryDelete = "delete"
+)
+
+//HistoryOp is a recorded operation. Call and Return order the operation against
+//the others: it took effect after every operation whose Return is less than its Call.
+type HistoryOp struct {
+	Kind  string      `json:"kind"`
+	Key   string      `json:"key"`
+	Value interface{} `json:"value,omitempty"`
+	//Version is the version read or written, 0 if the operation doesn't tell it
+	Version   uint64 `json:"version,omitempty"`
+	IfAbsent  bool   `json:"if_absent,omitempty"`
+	IfExists  bool   `json:"if_exists,omitempty"`
+	IfVersion uint64 `json:"if_version,omitempty"`
+	//OK reports that a read found the key, a write stored the value or a delete
+	//deleted the key; Conflict that a write failed because of its conditions
+	OK       bool   `json:"ok"`
+	Conflict bool   `json:"conflict,omitempty"`
+	Call     uint64 `json:"call"`
+	Return   uint64 `json:"return"`
+}
+
+type history struct {
+	enabled   int32
+	seq       uint64
+	mu        sync.Mutex
+	ops       []HistoryOp
+	truncated bool
+}
+
+//RecordHistory starts recording a new history, or stops recording.
+func (s *Storage) RecordHistory(enabled bool) {
+	h := &s.history
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if enabled {
+		h.ops, h.truncated = nil, false
+		atomic.StoreInt32(&h.enabled, 1)
+	} else {
+		atomic.StoreInt32(&h.enabled, 0)
+	}
+}
+
+//History returns the operations recorded so far and whether some were dropped.
+func (s *Storage) History() ([]HistoryOp, bool) {
+	h := &s.history
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]HistoryOp(nil), h.ops...), h.truncated
+}
+
+//call returns the call sequence number of an operation, 0 if nothing is recorded.
+func (h *history) call() uint64 {
+	if atomic.LoadInt32(&h.enabled) == 0 {
+		return 0
+	}
+	return atomic.AddUint64(&h.seq, 1)
+}
+
+func (h *history) record(op HistoryOp) {
+	if op.Call == 0 {
+		return
+	}
+	op.Return = atomic.AddUint64(&h.seq, 1)
+	h.mu.Lock()
+	if len(h.ops) < MaxHistory {
+		h.ops = append(h.ops, op)
+	} else {
+		h.truncated = true
+	}
+	h.mu.Unlock()
+}
+
+func (h *history) read(call uint64, key string, item *Item, found bool, withVersion bool) {
+	if call == 0 {
+		return
+	}
+	op := HistoryOp{Kind: HistoryRead, Key: key, OK: found, Call: call}
+	if found {
+		op.Value = plain(item.Object)
+		if withVersion {
+			op.Version = item.Version
+		}
+	}
+	h.record(op)
+}
+
+func (h *history) write(call uint64, key string, value interface{}, opts WriteOptions, version uint64, err error) {
+	if call == 0 {
+		return
+	}
+	h.record(HistoryOp{
+		Kind:      HistoryWrite,
+		Key:       key,
+		Value:     value,
+		Version:   version,
+		IfAbsent:  opts.IfAbsent,
+		IfExists:  opts.IfExists,
+		IfVersion: opts.IfVersion,
+		OK:        err == nil,
+		Conflict:  errors.Is(err, ErrExists) || errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionMismatch),
+		Call:      call,
+	})
+}
+
+//LinearizabilityResult lists the keys whose operations can't be ordered
+//consistently with a single copy of the key.
+type LinearizabilityResult struct {
+	Ops        int      `json:"ops"`
+	Keys       int      `json:"keys"`
+	Violations []string `json:"violations"`
+}
+
+func (r LinearizabilityResult) Linearizable() bool {
+	return len(r.Violations) == 0
+}
+
+//CheckLinearizable searches every key of ops for an order of its operations
+//which respects their Call and Return and in which each operation sees the
+//effect of the ones before it. Keys are independent, so they are checked apart.
+//The search is exponential in the number of concurrent operations of a key.
+func CheckLinearizable(ops []HistoryOp) LinearizabilityResult {
+	byKey := make(map[string][]HistoryOp)
+	for _, op := range ops {
+		byKey[op.Key] = append(byKey[op.Key], op)
+	}
+	res := LinearizabilityResult{Ops: len(ops), Keys: len(byKey), Violations: []string{}}
+	for key, ops := range byKey {
+		sort.Slice(ops, func(i, j int) bool { return ops[i].Call < ops[j].Call })
+		c := &linChecker{ops: ops, done: make([]bool, len(ops)), failed: make(map[string]bool)}
+		if !c.search(regState{}, 0) {
+			res.Violations = append(res.Violations, key)
+		}
+	}
+	sort.Strings(res.Violations)
+	return res
+}
+
+//regState is a key as seen by the checker. Before the first operation which
+//tells, whether the key exists is not known, and its version may never be.
+type regState struct {
+	known   bool
+	exists  bool
+	value   interface{}
+	version uint64
+}
+
+//step applies op to st and reports whether op could have returned what it did.
+func step(st regState, op HistoryOp) (bool, regState) {
+	switch op.Kind {
+	case HistoryRead:
+		if st.known && st.exists != op.OK {
+			return false, st
+		}
+		if !op.OK {
+			return true, regState{known: true}
+		}
+		if st.known && !reflect.DeepEqual(st.value, op.Value) {
+			return false, st
+		}
+		if st.known && op.Version != 0 && st.version != 0 && st.version != op.Version {
+			return false, st
+		}
+		if op.Version != 0 {
+			st.version = op.Version
+		}
+		st.known, st.exists, st.value = true, true, op.Value
+		return true, st
+	case HistoryDelete:
+		if st.known && st.exists != op.OK {
+			return false, st
+		}
+		return true, regState{known: true}
+	case HistoryWrite:
+		if !op.OK && !op.Conflict {
+			//rejected by validation, which changes nothing
+			return true, st
+		}
+		if !st.known {
+			//any state could have let the write succeed or fail, except
+			//that IfVersion or IfExists only succeed on an existing key
+			if op.OK {
+				return true, regState{known: true, exists: true, value: op.Value, version: op.Version}
+			}
+			return true, st
+		}
+		holds := !(op.IfAbsent && st.exists) && !(op.IfExists && !st.exists) &&
+			!(op.IfVersion != 0 && (!st.exists || (st.version != 0 && st.version != op.IfVersion)))
+		if op.OK != holds {
+			return false, st
+		}
+		if op.OK {
+			return true, regState{known: true, exists: true, value: op.Value, version: op.Version}
+		}
+		return true, st
+	}
+	return false, st
+}
+
+type linChecker struct {
+	ops  []HistoryOp
+	done []bool
+	//failed holds the states which are known to lead nowhere
+	failed map[string]bool
+}
+
+func (c *linChecker) search(st regState, n int) bool {
+	if n == len(c.ops) {
+		return true
+	}
+	//only operations called before the first pending one returned can go next
+	minReturn := ^uint64(0)
+	for i, op := range c.ops {
+		if !c.done[i] && op.Return < minReturn {
+			minReturn = op.Return
+		}
+	}
+	for i, op := range c.ops {
+		if op.Call > minReturn {
+			break
+		}
+		if c.done[i] {
+			continue
+		}
+		ok, next := step(st, op)
+		if !ok {
+			continue
+		}
+		c.done[i] = true
+		key := c.key(next)
+		if !c.failed[key] {
+			if c.search(next, n+1) {
+				return true
+			}
+			c.failed[key] = true
+		}
+		c.done[i] = false
+	}
+	return false
+}
+
+func (c *linChecker) key(st regState) string {
+	b := &strings.Builder{}
+	for _, d := range c.done {
+		if d {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	fmt.Fprintf(b, "|%t|%t|%d|%v", st.known, st.exists, st.version, st.value)
+	return b.String()
+}
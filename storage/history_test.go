@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestStorage_HistoryLinearizable(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.RecordHistory(true)
+
+	//workers take a lock with a CAS, bump a counter under it and release it
+	wg := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if _, err := s.Write("lock", w, WriteOptions{IfAbsent: true}); err != nil {
+					continue
+				}
+				v, version, found := s.GetWithVersion("counter")
+				n := 0
+				if found {
+					n = v.(int)
+				}
+				if _, err := s.Write("counter", n+1, WriteOptions{IfVersion: version}); found && err != nil {
+					t.Errorf("counter changed under the lock: %v", err)
+				}
+				s.Get("lock")
+				s.Delete("lock")
+			}
+		}(w)
+	}
+	wg.Wait()
+	s.RecordHistory(false)
+	s.Get("counter")
+
+	ops, truncated := s.History()
+	if truncated || len(ops) == 0 {
+		t.Fatalf("unexpected history: %d ops, truncated %v", len(ops), truncated)
+	}
+	for _, op := range ops {
+		if op.Return <= op.Call {
+			t.Fatalf("operation returned before it was called: %+v", op)
+		}
+	}
+	if res := CheckLinearizable(ops); !res.Linearizable() || res.Keys != 2 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
+
+func TestCheckLinearizable(t *testing.T) {
+	//a read which starts after a write returned must see it
+	ops := []HistoryOp{
+		{Kind: HistoryWrite, Key: "k", Value: 1, Version: 1, OK: true, Call: 1, Return: 2},
+		{Kind: HistoryWrite, Key: "k", Value: 2, Version: 2, OK: true, Call: 3, Return: 4},
+		{Kind: HistoryRead, Key: "k", Value: 1, Version: 1, OK: true, Call: 5, Return: 6},
+	}
+	if res := CheckLinearizable(ops); res.Linearizable() {
+		t.Error("stale read was not detected")
+	}
+	//unless they overlap
+	ops[2].Call = 3
+	if res := CheckLinearizable(ops); !res.Linearizable() {
+		t.Errorf("concurrent read was reported: %+v", res)
+	}
+
+	//two CAS of the same version can't both succeed
+	ops = []HistoryOp{
+		{Kind: HistoryRead, Key: "k", Value: 1, Version: 1, OK: true, Call: 1, Return: 2},
+		{Kind: HistoryWrite, Key: "k", Value: 2, Version: 2, IfVersion: 1, OK: true, Call: 3, Return: 6},
+		{Kind: HistoryWrite, Key: "k", Value: 3, Version: 3, IfVersion: 1, OK: true, Call: 4, Return: 5},
+	}
+	if res := CheckLinearizable(ops); res.Linearizable() {
+		t.Error("double CAS was not detected")
+	}
+	ops[2].OK, ops[2].Conflict, ops[2].Version = false, true, 0
+	if res := CheckLinearizable(ops); !res.Linearizable() {
+		t.Errorf("failed CAS was reported: %+v", res)
+	}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+//IDNamespace holds the last ID generated for every ID namespace, so it is saved
+//with the other items and IDs are never reissued after a restart.
+const IDNamespace = "ids"
+
+//IDs are made of the milliseconds since IDEpoch, the node and a sequence number.
+const (
+	idNodeBits = 10
+	idSeqBits  = 12
+	MaxNodeID  = 1<<idNodeBits - 1
+	idMaxSeq   = 1<<idSeqBits - 1
+)
+
+//IDEpoch is the time IDs count from.
+var IDEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var ErrNodeID = errors.New("node id must be between 0 and 1023")
+
+func idKey(namespace string) string {
+	return IDNamespace + NamespaceSeparator + namespace
+}
+
+//SetNodeID sets the node which NextID puts in the IDs. Nodes generating IDs
+//for the same namespaces must have different ids.
+func (s *Storage) SetNodeID(node int) error {
+	if node < 0 || node > MaxNodeID {
+		return ErrNodeID
+	}
+	s.lock("SetNodeID")
+	s.nodeID = uint64(node)
+	s.mu.Unlock()
+	return nil
+}
+
+//NextID returns a new 64-bit ID of namespace, greater than any ID of the
+//namespace returned before. IDs are roughly sorted by the time they were made
+//across nodes. Up to 4096 IDs are made per millisecond; after that, or if the
+//clock went back, the IDs borrow from the following milliseconds.
+func (s *Storage) NextID(namespace string) uint64 {
+	key := idKey(namespace)
+	s.lock("NextID")
+	defer s.mu.Unlock()
+
+	ms := uint64(0)
+	if now := (s.now() - IDEpoch.UnixNano()) / int64(time.Millisecond); now > 0 {
+		ms = uint64(now)
+	}
+	id := ms<<(idNodeBits+idSeqBits) | s.nodeID<<idSeqBits
+	if item, found := s.items[key]; found {
+		if last, ok := item.Object.(uint64); ok && id <= last {
+			//continue in the millisecond of last, or the next one if it is
+			//used up or was used by a node with a greater id
+			lastMs, seq := last>>(idNodeBits+idSeqBits), last&idMaxSeq+1
+			id = lastMs<<(idNodeBits+idSeqBits) | s.nodeID<<idSeqBits | seq
+			if seq > idMaxSeq || id <= last {
+				id = (lastMs+1)<<(idNodeBits+idSeqBits) | s.nodeID<<idSeqBits
+			}
+		}
+	}
+	s.put(key, Item{Object: id})
+	return id
+}
+
+//IDTime returns the time an ID was made at.
+func IDTime(id uint64) time.Time {
+	return IDEpoch.Add(time.Duration(id>>(idNodeBits+idSeqBits)) * time.Millisecond)
+}
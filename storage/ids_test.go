@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestStorage_NextID(t *testing.T) {
+	c := &fixedClock{now: IDEpoch.Add(time.Hour)}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(c)
+	if err := s.SetNodeID(MaxNodeID + 1); err != ErrNodeID {
+		t.Errorf("invalid node id was accepted: %v", err)
+	}
+	s.SetNodeID(7)
+
+	//the sequence overflows into the next millisecond
+	last := uint64(0)
+	for i := 0; i < idMaxSeq+2; i++ {
+		id := s.NextID("orders")
+		if id <= last {
+			t.Fatalf("id %d is not greater than %d", id, last)
+		}
+		last = id
+	}
+	if got := IDTime(last); !got.Equal(c.now.Add(time.Millisecond)) {
+		t.Errorf("unexpected time of the last id: %v", got)
+	}
+	if node := last >> idSeqBits & MaxNodeID; node != 7 {
+		t.Errorf("unexpected node: %d", node)
+	}
+	if id := s.NextID("users"); IDTime(id) != c.now || id&idMaxSeq != 0 {
+		t.Errorf("namespaces share a sequence: %d", id)
+	}
+
+	//a restart with the clock set back continues after the saved id
+	buf := &bytes.Buffer{}
+	if err := s.Save(buf); err != nil {
+		t.Fatal(err)
+	}
+	restarted := New(DefaultExpiration, 0, 0)
+	restarted.SetClock(&fixedClock{now: c.now.Add(-time.Minute)})
+	if err := restarted.Load(buf); err != nil {
+		t.Fatal(err)
+	}
+	if id := restarted.NextID("orders"); id <= last {
+		t.Errorf("id %d was reissued after a restart, last was %d", id, last)
+	}
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+//fieldIndex maps encoded field values to the set of keys holding them.
+type fieldIndex struct {
+	path    []interface{}
+	entries map[string]map[string]struct{}
+}
+
+//indexValue encodes a JSON value so that strings are matched by their raw text
+//and everything else by its JSON representation.
+func indexValue(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return val, true
+	case map[string]interface{}, []interface{}:
+		return "", false
+	}
+	raw, err := json.Marshal(v)
+	return string(raw), err == nil
+}
+
+func (idx *fieldIndex) lookup(doc interface{}) (string, bool) {
+	v, err := lookupJSONPath(doc, idx.path)
+	if err != nil {
+		return "", false
+	}
+	return indexValue(v)
+}
+
+func (idx *fieldIndex) add(key string, doc interface{}) {
+	val, ok := idx.lookup(doc)
+	if !ok {
+		return
+	}
+	keys, ok := idx.entries[val]
+	if !ok {
+		keys = make(map[string]struct{})
+		idx.entries[val] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func (idx *fieldIndex) remove(key string, doc interface{}) {
+	val, ok := idx.lookup(doc)
+	if !ok {
+		return
+	}
+	delete(idx.entries[val], key)
+	if len(idx.entries[val]) == 0 {
+		delete(idx.entries, val)
+	}
+}
+
+func (s *Storage) index(key string, item Item) {
+	item.Object = plain(item.Object)
+	if s.search != nil {
+		s.search.add(key, item.Object)
+	}
+	indexes := s.indexes[Namespace(key)]
+	if len(indexes) == 0 {
+		return
+	}
+	doc, err := decodeJSONObject(key, item.Object)
+	if err != nil {
+		return
+	}
+	for _, idx := range indexes {
+		idx.add(key, doc)
+	}
+}
+
+func (s *Storage) unindex(key string, item Item) {
+	if s.search == nil && len(s.indexes[Namespace(key)]) == 0 {
+		return
+	}
+	item.Object = plain(item.Object)
+	if s.search != nil {
+		s.search.remove(key, item.Object)
+	}
+	indexes := s.indexes[Namespace(key)]
+	if len(indexes) == 0 {
+		return
+	}
+	doc, err := decodeJSONObject(key, item.Object)
+	if err != nil {
+		return
+	}
+	for _, idx := range indexes {
+		idx.remove(key, doc)
+	}
+}
+
+//CreateIndex starts indexing field (a dotted path like "user.status") of JSON values
+//in namespace. Existing items are indexed immediately.
+func (s *Storage) CreateIndex(namespace, field string) error {
+	path, err := parseJSONPath("$." + field)
+	if err != nil {
+		return err
+	}
+	idx := &fieldIndex{
+		path:    path,
+		entries: make(map[string]map[string]struct{}),
+	}
+
+	s.lock("CreateIndex")
+	defer s.mu.Unlock()
+
+	if _, ok := s.indexes[namespace][field]; ok {
+		return fmt.Errorf("index on %s already exists in namespace %s", field, namespace)
+	}
+	for k, v := range s.items {
+		if Namespace(k) != namespace {
+			continue
+		}
+		if doc, err := decodeJSONObject(k, plain(v.Object)); err == nil {
+			idx.add(k, doc)
+		}
+	}
+	if s.indexes[namespace] == nil {
+		s.indexes[namespace] = make(map[string]*fieldIndex)
+	}
+	s.indexes[namespace][field] = idx
+	return nil
+}
+
+func (s *Storage) DropIndex(namespace, field string) bool {
+	s.lock("DropIndex")
+	defer s.mu.Unlock()
+	if _, ok := s.indexes[namespace][field]; !ok {
+		return false
+	}
+	delete(s.indexes[namespace], field)
+	if len(s.indexes[namespace]) == 0 {
+		delete(s.indexes, namespace)
+	}
+	return true
+}
+
+//Indexes returns indexed fields of namespace.
+func (s *Storage) Indexes(namespace string) []string {
+	s.rlock("Indexes")
+	defer s.mu.RUnlock()
+	fields := make([]string, 0, len(s.indexes[namespace]))
+	for f := range s.indexes[namespace] {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+//Query returns sorted keys of namespace whose indexed field equals value.
+func (s *Storage) Query(namespace, field, value string) ([]string, error) {
+	s.rlock("Query")
+	defer s.mu.RUnlock()
+
+	idx, ok := s.indexes[namespace][field]
+	if !ok {
+		return nil, fmt.Errorf("no index on %s in namespace %s", field, namespace)
+	}
+	keys := make([]string, 0, len(idx.entries[value]))
+	for k := range idx.entries[value] {
+		if item := s.items[k]; !s.expired(&item) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
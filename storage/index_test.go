@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestStorage_Query(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("orders:1", `{"status":"pending","user":{"id":1}}`, DefaultExpiration)
+	s.Set("orders:2", `{"status":"done","user":{"id":2}}`, DefaultExpiration)
+	s.Set("users:1", `{"status":"pending"}`, DefaultExpiration)
+
+	if err := s.CreateIndex("orders", "status"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateIndex("orders", "user.id"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateIndex("orders", "status"); err == nil {
+		t.Error("created the same index twice")
+	}
+
+	s.Set("orders:3", `{"status":"pending"}`, DefaultExpiration)
+	keys, err := s.Query("orders", "status", "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "orders:1" || keys[1] != "orders:3" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+
+	s.JSONSet("orders:1", "$.status", "done")
+	s.Delete("orders:3")
+	if keys, _ = s.Query("orders", "status", "pending"); len(keys) != 0 {
+		t.Errorf("index wasn't updated: %v", keys)
+	}
+	if keys, _ = s.Query("orders", "user.id", "2"); len(keys) != 1 || keys[0] != "orders:2" {
+		t.Errorf("unexpected keys for nested field: %v", keys)
+	}
+
+	if !s.DropIndex("orders", "status") {
+		t.Error("index was not dropped")
+	}
+	if _, err = s.Query("orders", "status", "done"); err == nil {
+		t.Error("queried a dropped index")
+	}
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+//EvictExpired is the eviction reason of items removed because they expired.
+const EvictExpired = "expired"
+
+//janitorBudgetCheck is how many items a run with a budget scans between clock reads.
+const janitorBudgetCheck = 256
+
+//JanitorRun describes a single removal of expired items. Namespace is set for
+//runs of a namespace janitor, Partial for runs which ran out of their budget.
+type JanitorRun struct {
+	At        time.Time     `json:"at"`
+	Namespace string        `json:"namespace,omitempty"`
+	Scanned   int           `json:"scanned"`
+	Reclaimed int           `json:"reclaimed"`
+	Duration  time.Duration `json:"duration_ns"`
+	LockWait  time.Duration `json:"lock_wait_ns"`
+	Partial   bool          `json:"partial,omitempty"`
+}
+
+//JanitorStats add up all runs since the storage was created.
+type JanitorStats struct {
+	Runs      int           `json:"runs"`
+	Scanned   int           `json:"scanned"`
+	Reclaimed int           `json:"reclaimed"`
+	Duration  time.Duration `json:"duration_ns"`
+	LockWait  time.Duration `json:"lock_wait_ns"`
+	Last      *JanitorRun   `json:"last,omitempty"`
+}
+
+//OnJanitorRun makes fn be called after every run, e.g. to log slow ones.
+//fn is called without the lock held. A nil fn removes it.
+func (s *Storage) OnJanitorRun(fn func(JanitorRun)) {
+	s.lock("OnJanitorRun")
+	s.onJanitorRun = fn
+	s.mu.Unlock()
+}
+
+//PauseJanitor stops scheduled janitor runs, including those of namespaces, for d
+//or until ResumeJanitor if d <= 0. Expired items stay invisible to reads meanwhile.
+func (s *Storage) PauseJanitor(d time.Duration) {
+	until := int64(math.MaxInt64)
+	if d > 0 {
+		until = s.now() + int64(d)
+	}
+	atomic.StoreInt64(&s.pausedUntil, until)
+}
+
+func (s *Storage) ResumeJanitor() {
+	atomic.StoreInt64(&s.pausedUntil, 0)
+}
+
+//JanitorPaused reports whether scheduled janitor runs are paused.
+func (s *Storage) JanitorPaused() bool {
+	until := atomic.LoadInt64(&s.pausedUntil)
+	return until != 0 && s.now() < until
+}
+
+//RunJanitor removes expired items right away, even if the janitor is paused.
+//A budget > 0 stops the run once it held the lock that long; since runs start
+//at a random position, repeated runs eventually cover all items.
+func (s *Storage) RunJanitor(budget time.Duration) JanitorRun {
+	return s.deleteExpired("", true, budget)
+}
+
+//deleteExpired removes expired items of namespace, or of all namespaces if all is set.
+func (s *Storage) deleteExpired(namespace string, all bool, budget time.Duration) JanitorRun {
+	start := time.Now()
+	now := s.now()
+	var due []expiredKey
+	s.lock("janitor")
+	run := JanitorRun{At: start, Namespace: namespace, LockWait: time.Since(start)}
+	for k, v := range s.items {
+		run.Scanned++
+		if (all || Namespace(k) == namespace) && v.expiredAt(now) {
+			due = s.removeExpired(k, v, due)
+			run.Reclaimed++
+		}
+		if budget > 0 && run.Scanned%janitorBudgetCheck == 0 && time.Since(start)-run.LockWait > budget {
+			run.Partial = true
+			break
+		}
+	}
+	if all {
+		s.expireOverflow(now)
+	}
+	s.maybeShrink()
+	run.Duration = time.Since(start)
+	s.recordJanitorRun(run)
+	onRun := s.onJanitorRun
+	s.mu.Unlock()
+
+	notifyExpired(due)
+	if onRun != nil {
+		onRun(run)
+	}
+	return run
+}
+
+//recordJanitorRun must be called with the write lock held.
+func (s *Storage) recordJanitorRun(run JanitorRun) {
+	js := &s.janitorStats
+	js.Runs++
+	js.Scanned += run.Scanned
+	js.Reclaimed += run.Reclaimed
+	js.Duration += run.Duration
+	js.LockWait += run.LockWait
+	js.Last = &run
+	s.evictions[EvictExpired] += uint64(run.Reclaimed)
+}
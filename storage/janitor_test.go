@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStorage_JanitorStats(t *testing.T) {
+	clock := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(clock)
+	s.Set("a", "v", time.Minute)
+	s.Set("b", "v", time.Minute)
+	s.Set("c", "v", NoExpiration)
+
+	var runs []JanitorRun
+	s.OnJanitorRun(func(run JanitorRun) {
+		runs = append(runs, run)
+	})
+	clock.now = clock.now.Add(2 * time.Minute)
+	s.DeleteExpired()
+	s.DeleteExpired()
+
+	if len(runs) != 2 || runs[0].Scanned != 3 || runs[0].Reclaimed != 2 || runs[1].Reclaimed != 0 {
+		t.Errorf("unexpected runs: %+v", runs)
+	}
+	st := s.Stats()
+	if st.Janitor.Runs != 2 || st.Janitor.Reclaimed != 2 || st.Janitor.Scanned != 4 || st.Janitor.Last == nil {
+		t.Errorf("unexpected janitor stats: %+v", st.Janitor)
+	}
+	if st.Evictions[EvictExpired] != 2 {
+		t.Errorf("unexpected evictions: %v", st.Evictions)
+	}
+}
+
+func TestStorage_JanitorStatsNamespace(t *testing.T) {
+	clock := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(clock)
+	s.Set("ns:a", "v", time.Minute)
+	s.Set("other", "v", time.Minute)
+
+	clock.now = clock.now.Add(2 * time.Minute)
+	s.DeleteExpiredNamespace("ns")
+	last := s.Stats().Janitor.Last
+	if last == nil || last.Namespace != "ns" || last.Reclaimed != 1 {
+		t.Errorf("unexpected run: %+v", last)
+	}
+}
+
+func TestStorage_PauseJanitor(t *testing.T) {
+	clock := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(clock)
+
+	s.PauseJanitor(time.Minute)
+	if !s.JanitorPaused() {
+		t.Error("janitor is not paused")
+	}
+	clock.now = clock.now.Add(2 * time.Minute)
+	if s.JanitorPaused() {
+		t.Error("janitor is still paused after the pause ended")
+	}
+	s.PauseJanitor(0)
+	clock.now = clock.now.Add(time.Hour)
+	if !s.JanitorPaused() {
+		t.Error("janitor paused until resumed is not paused")
+	}
+	s.ResumeJanitor()
+	if s.JanitorPaused() {
+		t.Error("janitor was not resumed")
+	}
+}
+
+func TestStorage_RunJanitorBudget(t *testing.T) {
+	clock := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(clock)
+	for i := 0; i < 10*janitorBudgetCheck; i++ {
+		s.Set(fmt.Sprint(i), "v", time.Minute)
+	}
+	clock.now = clock.now.Add(2 * time.Minute)
+	s.PauseJanitor(0)
+
+	run := s.RunJanitor(time.Nanosecond)
+	if !run.Partial || run.Scanned != janitorBudgetCheck {
+		t.Errorf("run did not stop at its budget: %+v", run)
+	}
+	run = s.RunJanitor(0)
+	if run.Partial || run.Reclaimed != 9*janitorBudgetCheck {
+		t.Errorf("unexpected run: %+v", run)
+	}
+}
@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//PatchOp is a single RFC 6902 JSON Patch operation.
+type PatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+//MergePatch applies an RFC 7386 merge patch to doc and returns the result.
+func MergePatch(doc, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	target, ok := doc.(map[string]interface{})
+	if !ok {
+		target = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(target, k)
+			continue
+		}
+		target[k] = MergePatch(target[k], v)
+	}
+	return target
+}
+
+//ApplyJSONPatch applies RFC 6902 operations to doc in order. If any operation fails,
+//an error is returned and the result must be discarded.
+func ApplyJSONPatch(doc interface{}, ops []PatchOp) (interface{}, error) {
+	var err error
+	for i, op := range ops {
+		if doc, err = applyPatchOp(doc, op); err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %v", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+func applyPatchOp(doc interface{}, op PatchOp) (interface{}, error) {
+	path, err := parseJSONPointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		return patchPointer(doc, path, addValue(op.Value))
+	case "remove":
+		return patchPointer(doc, path, removeValue)
+	case "replace":
+		return patchPointer(doc, path, replaceValue(op.Value))
+	case "move", "copy":
+		from, err := parseJSONPointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		v, err := getPointer(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if doc, err = patchPointer(doc, from, removeValue); err != nil {
+				return nil, err
+			}
+		} else if v, err = deepCopyJSON(v); err != nil {
+			return nil, err
+		}
+		return patchPointer(doc, path, addValue(v))
+	case "test":
+		v, err := getPointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		expected, err := deepCopyJSON(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(v, expected) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown operation")
+}
+
+//parseJSONPointer splits an RFC 6901 pointer into unescaped reference tokens.
+func parseJSONPointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("pointer %s must start with /", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func arrayIndex(arr []interface{}, token string, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return len(arr), nil
+	}
+	i, err := strconv.Atoi(token)
+	max := len(arr) - 1
+	if allowEnd {
+		max = len(arr)
+	}
+	if err != nil || i < 0 || i > max {
+		return 0, fmt.Errorf("invalid array index %s", token)
+	}
+	return i, nil
+}
+
+func getPointer(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if doc, ok = v[token]; !ok {
+				return nil, fmt.Errorf("no such field %s", token)
+			}
+		case []interface{}:
+			i, err := arrayIndex(v, token, false)
+			if err != nil {
+				return nil, err
+			}
+			doc = v[i]
+		default:
+			return nil, fmt.Errorf("cannot index %s into a scalar", token)
+		}
+	}
+	return doc, nil
+}
+
+type patchFunc func(parent interface{}, token string) (interface{}, error)
+
+//patchPointer calls fn with the parent container of path and rebuilds the
+//document above it, since changing an array length yields a new slice.
+func patchPointer(doc interface{}, path []string, fn patchFunc) (interface{}, error) {
+	if len(path) == 0 {
+		return fn(nil, "")
+	}
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	child, err := getPointer(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = patchPointer(child, path[1:], fn); err != nil {
+		return nil, err
+	}
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		v[path[0]] = child
+	case []interface{}:
+		i, _ := arrayIndex(v, path[0], false)
+		v[i] = child
+	}
+	return doc, nil
+}
+
+func addValue(value interface{}) patchFunc {
+	return func(parent interface{}, token string) (interface{}, error) {
+		switch v := parent.(type) {
+		case nil:
+			return value, nil
+		case map[string]interface{}:
+			v[token] = value
+			return v, nil
+		case []interface{}:
+			i, err := arrayIndex(v, token, true)
+			if err != nil {
+				return nil, err
+			}
+			v = append(v, nil)
+			copy(v[i+1:], v[i:])
+			v[i] = value
+			return v, nil
+		}
+		return nil, fmt.Errorf("cannot add %s to a scalar", token)
+	}
+}
+
+func removeValue(parent interface{}, token string) (interface{}, error) {
+	switch v := parent.(type) {
+	case nil:
+		return nil, fmt.Errorf("cannot remove the whole document")
+	case map[string]interface{}:
+		if _, ok := v[token]; !ok {
+			return nil, fmt.Errorf("no such field %s", token)
+		}
+		delete(v, token)
+		return v, nil
+	case []interface{}:
+		i, err := arrayIndex(v, token, false)
+		if err != nil {
+			return nil, err
+		}
+		return append(v[:i], v[i+1:]...), nil
+	}
+	return nil, fmt.Errorf("cannot remove %s from a scalar", token)
+}
+
+func replaceValue(value interface{}) patchFunc {
+	return func(parent interface{}, token string) (interface{}, error) {
+		switch v := parent.(type) {
+		case nil:
+			return value, nil
+		case map[string]interface{}:
+			if _, ok := v[token]; !ok {
+				return nil, fmt.Errorf("no such field %s", token)
+			}
+			v[token] = value
+			return v, nil
+		case []interface{}:
+			i, err := arrayIndex(v, token, false)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = value
+			return v, nil
+		}
+		return nil, fmt.Errorf("cannot replace %s in a scalar", token)
+	}
+}
+
+//deepCopyJSON copies v and normalizes it to the types produced by json.Unmarshal.
+func deepCopyJSON(v interface{}) (interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var res interface{}
+	err = json.Unmarshal(raw, &res)
+	return res, err
+}
+
+//PatchJSON atomically replaces the JSON document stored at key with the result of fn.
+//If version is not 0 and differs from the item version, ErrVersionMismatch is returned.
+//It returns the new document and version; the item keeps its expiration time.
+func (s *Storage) PatchJSON(key string, version uint64, fn func(doc interface{}) (interface{}, error)) (interface{}, uint64, error) {
+	return s.Guard(nil).PatchJSON(key, version, fn)
+}
+
+//PatchJSON is Storage.PatchJSON for the keys the guard accepts.
+func (g Guarded) PatchJSON(key string, version uint64, fn func(doc interface{}) (interface{}, error)) (interface{}, uint64, error) {
+	s := g.s
+	s.lock("PatchJSON")
+	defer s.mu.Unlock()
+	if err := g.check(key); err != nil {
+		return nil, 0, err
+	}
+
+	item, found := s.items[key]
+	if !found || s.expired(&item) {
+		return nil, 0, ErrNotFound
+	}
+	if version != 0 && item.Version != version {
+		return nil, 0, ErrVersionMismatch
+	}
+	item.Object = plain(item.Object)
+	doc, err := decodeJSONObject(key, item.Object)
+	if err != nil {
+		return nil, 0, err
+	}
+	if doc, err = fn(doc); err != nil {
+		return nil, 0, err
+	}
+	if item.Object, err = encodeJSONObject(item.Object, doc); err != nil {
+		return nil, 0, err
+	}
+	if item.Object, err = s.checkWrite(key, item.Object, item.Class); err != nil {
+		return nil, 0, err
+	}
+	//the write hooks may have changed the document
+	if _, ok := s.writeHooks[Namespace(key)]; ok {
+		if doc, err = decodeJSONObject(key, item.Object); err != nil {
+			return nil, 0, err
+		}
+	}
+	return doc, s.put(key, item), nil
+}
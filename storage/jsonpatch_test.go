@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func mustJSON(t *testing.T, s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func jsonString(t *testing.T, v interface{}) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+func TestMergePatch(t *testing.T) {
+	doc := mustJSON(t, `{"a":"b","c":{"d":"e","f":"g"}}`)
+	patch := mustJSON(t, `{"a":"z","c":{"f":null}}`)
+
+	if res := jsonString(t, MergePatch(doc, patch)); res != `{"a":"z","c":{"d":"e"}}` {
+		t.Errorf("unexpected merge result: %s", res)
+	}
+	if res := jsonString(t, MergePatch(doc, mustJSON(t, `["x"]`))); res != `["x"]` {
+		t.Errorf("non-object patch didn't replace the document: %s", res)
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	doc := mustJSON(t, `{"foo":["bar","baz"],"a/b":{"c":1}}`)
+	ops := []PatchOp{
+		{Op: "test", Path: "/a~1b/c", Value: 1},
+		{Op: "add", Path: "/foo/1", Value: "qux"},
+		{Op: "add", Path: "/foo/-", Value: "end"},
+		{Op: "remove", Path: "/foo/0"},
+		{Op: "replace", Path: "/a~1b/c", Value: 2},
+		{Op: "copy", From: "/a~1b", Path: "/copy"},
+		{Op: "move", From: "/foo/2", Path: "/moved"},
+	}
+
+	res, err := ApplyJSONPatch(doc, ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"a/b":{"c":2},"copy":{"c":2},"foo":["qux","baz"],"moved":"end"}`
+	if got := jsonString(t, res); got != want {
+		t.Errorf("unexpected patch result: %s", got)
+	}
+
+	failing := [][]PatchOp{
+		{{Op: "test", Path: "/foo", Value: "x"}},
+		{{Op: "remove", Path: "/missing"}},
+		{{Op: "replace", Path: "/foo/5", Value: 1}},
+		{{Op: "unknown", Path: "/foo"}},
+	}
+	for _, ops := range failing {
+		if _, err = ApplyJSONPatch(mustJSON(t, `{"foo":[1]}`), ops); err == nil {
+			t.Errorf("patch %v should have failed", ops)
+		}
+	}
+}
+
+func TestStorage_PatchJSON(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("doc", `{"n":1}`, DefaultExpiration)
+	_, version, _ := s.GetWithVersion("doc")
+
+	merge := func(doc interface{}) (interface{}, error) {
+		return MergePatch(doc, map[string]interface{}{"n": 2}), nil
+	}
+	_, newVersion, err := s.PatchJSON("doc", version, merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newVersion == version {
+		t.Error("version didn't change after patch")
+	}
+	if _, _, err = s.PatchJSON("doc", version, merge); err != ErrVersionMismatch {
+		t.Errorf("patch with stale version returned %v", err)
+	}
+	if _, _, err = s.PatchJSON("missing", 0, merge); err != ErrNotFound {
+		t.Errorf("patch of missing key returned %v", err)
+	}
+	if v, _ := s.Get("doc"); v != `{"n":2}` {
+		t.Errorf("unexpected document: %v", v)
+	}
+}
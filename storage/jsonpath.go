@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//parseJSONPath splits a path like $.user.tags[0].name into object keys (string)
+//and array indexes (int).
+func parseJSONPath(path string) ([]interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path %s must start with $", path)
+	}
+	rest := path[1:]
+	var segs []interface{}
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in path %s", path)
+			}
+			segs = append(segs, rest[:end])
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("unclosed bracket in path %s", path)
+			}
+			idx := rest[1:end]
+			if n, err := strconv.Atoi(idx); err == nil {
+				segs = append(segs, n)
+			} else if unq, err := strconv.Unquote(strings.Replace(idx, "'", "\"", -1)); err == nil {
+				segs = append(segs, unq)
+			} else {
+				return nil, fmt.Errorf("invalid index %s in path %s", idx, path)
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q in path %s", rest[0], path)
+		}
+	}
+	return segs, nil
+}
+
+//decodeJSONObject returns the parsed document if the stored value is a JSON string or []byte.
+func decodeJSONObject(key string, v interface{}) (interface{}, error) {
+	var raw []byte
+	switch val := v.(type) {
+	case string:
+		raw = []byte(val)
+	case []byte:
+		raw = val
+	default:
+		return nil, fmt.Errorf("item %s is not a json document", key)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("item %s is not a json document", key)
+	}
+	return doc, nil
+}
+
+//encodeJSONObject marshals doc into the same representation as the original value.
+func encodeJSONObject(orig, doc interface{}) (interface{}, error) {
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := orig.([]byte); ok {
+		return raw, nil
+	}
+	return string(raw), nil
+}
+
+func lookupJSONPath(doc interface{}, segs []interface{}) (interface{}, error) {
+	cur := doc
+	for _, seg := range segs {
+		switch s := seg.(type) {
+		case string:
+			obj, ok := cur.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: not an object", s)
+			}
+			if cur, ok = obj[s]; !ok {
+				return nil, fmt.Errorf("%s: no such field", s)
+			}
+		case int:
+			arr, ok := cur.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("[%d]: not an array", s)
+			}
+			if s < 0 || s >= len(arr) {
+				return nil, fmt.Errorf("[%d]: index out of range", s)
+			}
+			cur = arr[s]
+		}
+	}
+	return cur, nil
+}
+
+//setJSONPath replaces the value at path and returns the new document.
+//Missing object fields on the last segment are created.
+func setJSONPath(doc interface{}, segs []interface{}, value interface{}) (interface{}, error) {
+	if len(segs) == 0 {
+		return value, nil
+	}
+	parent, err := lookupJSONPath(doc, segs[:len(segs)-1])
+	if err != nil {
+		return nil, err
+	}
+	switch s := segs[len(segs)-1].(type) {
+	case string:
+		obj, ok := parent.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: not an object", s)
+		}
+		obj[s] = value
+	case int:
+		arr, ok := parent.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("[%d]: not an array", s)
+		}
+		if s < 0 || s >= len(arr) {
+			return nil, fmt.Errorf("[%d]: index out of range", s)
+		}
+		arr[s] = value
+	}
+	return doc, nil
+}
+
+//JSONGet returns the part of a JSON document stored at key selected by path.
+func (s *Storage) JSONGet(key, path string) (interface{}, error) {
+	segs, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	v, found := s.Get(key)
+	if !found {
+		return nil, fmt.Errorf("item %s not found", key)
+	}
+	doc, err := decodeJSONObject(key, v)
+	if err != nil {
+		return nil, err
+	}
+	return lookupJSONPath(doc, segs)
+}
+
+//JSONSet atomically replaces the part of a JSON document stored at key selected by path.
+//The item keeps its expiration time.
+func (s *Storage) JSONSet(key, path string, value interface{}) error {
+	return s.Guard(nil).JSONSet(key, path, value)
+}
+
+//JSONSet is Storage.JSONSet for the keys the guard accepts.
+func (g Guarded) JSONSet(key, path string, value interface{}) error {
+	s := g.s
+	segs, err := parseJSONPath(path)
+	if err != nil {
+		return err
+	}
+
+	s.lock("JSONSet")
+	defer s.mu.Unlock()
+	if err := g.check(key); err != nil {
+		return err
+	}
+
+	item, found := s.items[key]
+	if !found || s.expired(&item) {
+		return fmt.Errorf("item %s not found", key)
+	}
+	item.Object = plain(item.Object)
+	doc, err := decodeJSONObject(key, item.Object)
+	if err != nil {
+		return err
+	}
+	if doc, err = setJSONPath(doc, segs, value); err != nil {
+		return err
+	}
+	if item.Object, err = encodeJSONObject(item.Object, doc); err != nil {
+		return err
+	}
+	if item.Object, err = s.checkWrite(key, item.Object, item.Class); err != nil {
+		return err
+	}
+	s.put(key, item)
+	return nil
+}
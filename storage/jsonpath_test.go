@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	segs, err := parseJSONPath(`$.user.tags[1]['first name']`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segs) != 4 || segs[0] != "user" || segs[1] != "tags" || segs[2] != 1 || segs[3] != "first name" {
+		t.Errorf("unexpected segments: %v", segs)
+	}
+
+	for _, p := range []string{"user", "$..a", "$[1", "$x"} {
+		if _, err = parseJSONPath(p); err == nil {
+			t.Errorf("invalid path %s was parsed", p)
+		}
+	}
+}
+
+func TestStorage_JSONGet(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("doc", `{"user":{"name":"bob","tags":["a","b"]}}`, DefaultExpiration)
+
+	v, err := s.JSONGet("doc", "$.user.name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "bob" {
+		t.Errorf("name is not bob: %v", v)
+	}
+	if v, _ = s.JSONGet("doc", "$.user.tags[1]"); v != "b" {
+		t.Errorf("second tag is not b: %v", v)
+	}
+	if _, err = s.JSONGet("doc", "$.user.age"); err == nil {
+		t.Error("missing field was found")
+	}
+
+	s.Set("str", "not json", DefaultExpiration)
+	if _, err = s.JSONGet("str", "$"); err == nil {
+		t.Error("non-json value was parsed")
+	}
+}
+
+func TestStorage_JSONSet(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("doc", []byte(`{"user":{"name":"bob"}}`), DefaultExpiration)
+
+	if err := s.JSONSet("doc", "$.user.name", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.JSONSet("doc", "$.user.age", 30); err != nil {
+		t.Fatal(err)
+	}
+
+	v, _ := s.Get("doc")
+	if string(v.([]byte)) != `{"user":{"age":30,"name":"alice"}}` {
+		t.Errorf("unexpected document: %s", v)
+	}
+	if err := s.JSONSet("doc", "$.user.name.first", "a"); err == nil {
+		t.Error("set a field of a string")
+	}
+}
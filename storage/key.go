@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+const DefaultMaxKeyLength = 1024
+
+//KeyPolicy defines which keys are accepted from clients.
+//Binary keys may contain arbitrary bytes; otherwise keys must be valid UTF-8
+//without control characters.
+type KeyPolicy struct {
+	MaxLength   int
+	AllowBinary bool
+}
+
+var DefaultKeyPolicy = KeyPolicy{MaxLength: DefaultMaxKeyLength}
+
+func (p KeyPolicy) Validate(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is empty")
+	}
+	if p.MaxLength > 0 && len(key) > p.MaxLength {
+		return fmt.Errorf("key is longer than %d bytes", p.MaxLength)
+	}
+	if p.AllowBinary {
+		return nil
+	}
+	if !utf8.ValidString(key) {
+		return fmt.Errorf("key is not valid utf-8")
+	}
+	for _, r := range key {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("key contains control character %U", r)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyPolicy_Validate(t *testing.T) {
+	valid := []string{"a", "a/b", "100%", "ключ", strings.Repeat("k", DefaultMaxKeyLength)}
+	for _, k := range valid {
+		if err := DefaultKeyPolicy.Validate(k); err != nil {
+			t.Errorf("key %q rejected: %v", k, err)
+		}
+	}
+
+	invalid := []string{"", "a\nb", "\x00", "\xff\xfe", strings.Repeat("k", DefaultMaxKeyLength+1)}
+	for _, k := range invalid {
+		if err := DefaultKeyPolicy.Validate(k); err == nil {
+			t.Errorf("key %q accepted", k)
+		}
+	}
+
+	binary := KeyPolicy{MaxLength: 4, AllowBinary: true}
+	if err := binary.Validate("\x00\xff"); err != nil {
+		t.Errorf("binary key rejected: %v", err)
+	}
+	if err := binary.Validate("\x00\xff\x00\xff\x00"); err == nil {
+		t.Error("binary key longer than max length accepted")
+	}
+}
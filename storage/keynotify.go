@@ -0,0 +1,42 @@
+package storage
+
+//KeyEventFunc is called with a key, the item removed from it and why it was
+//removed: EvictExpired or EvictCapacity.
+type KeyEventFunc func(key string, item Item, reason string)
+
+//NotifyKey calls fn once when the item of key is removed because it expired or
+//was evicted from its full namespace, so that the owner of a key can renew it.
+//It replaces an earlier fn of key and is kept across overwrites; explicit
+//deletes, renames and flushes drop it without a call. Expirations are reported
+//on the janitor goroutine, evictions on a new one. Write registers fn along
+//with the item through WriteOptions.Notify.
+func (s *Storage) NotifyKey(key string, fn KeyEventFunc) {
+	s.lock("NotifyKey")
+	s.notifyKey(key, fn)
+	s.mu.Unlock()
+}
+
+//notifyKey must be called with the write lock held.
+func (s *Storage) notifyKey(key string, fn KeyEventFunc) {
+	if s.keyEvents == nil {
+		s.keyEvents = make(map[string]KeyEventFunc)
+	}
+	s.keyEvents[key] = fn
+}
+
+//takeKeyEvent removes and returns the fn of key, if any, before the item of
+//key is expired or evicted. Must be called with the write lock held.
+func (s *Storage) takeKeyEvent(key string) KeyEventFunc {
+	fn, ok := s.keyEvents[key]
+	if ok {
+		delete(s.keyEvents, key)
+	}
+	return fn
+}
+
+//forgetKeyEvent is called by remove, so any other removal drops the fn.
+func (s *Storage) forgetKeyEvent(key string) {
+	if len(s.keyEvents) > 0 {
+		delete(s.keyEvents, key)
+	}
+}
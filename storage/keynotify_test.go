@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStorage_NotifyKey(t *testing.T) {
+	c := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(c)
+	events := make(chan string, 10)
+	notify := func(key string, item Item, reason string) {
+		events <- key + " " + reason
+	}
+
+	s.Write("lease:a", "v", WriteOptions{TTL: time.Minute, Notify: notify})
+	//an overwrite keeps the registration
+	s.Set("lease:a", "v2", time.Minute)
+	s.Write("lease:b", "v", WriteOptions{TTL: time.Minute, Notify: notify})
+	s.Delete("lease:b")
+	s.Set("lease:b", "v", time.Minute)
+
+	c.now = c.now.Add(2 * time.Minute)
+	s.DeleteExpired()
+	select {
+	case e := <-events:
+		if e != "lease:a expired" {
+			t.Errorf("unexpected event %q", e)
+		}
+	default:
+		t.Fatal("expiration was not notified")
+	}
+	if len(events) != 0 {
+		t.Errorf("deleted key was notified: %q", <-events)
+	}
+
+	//notified once only
+	s.Write("lease:a", "v", WriteOptions{TTL: time.Minute})
+	c.now = c.now.Add(2 * time.Minute)
+	s.DeleteExpired()
+	if len(events) != 0 {
+		t.Errorf("key was notified twice: %q", <-events)
+	}
+}
+
+func TestStorage_NotifyKeyEviction(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.SetNamespaceOptions("cache", NamespaceOptions{MaxItems: 1, Evict: true})
+	events := make(chan string, 1)
+	s.Write("cache:a", "v", WriteOptions{Notify: func(key string, item Item, reason string) {
+		events <- key + " " + reason
+	}})
+	if _, err := s.Write("cache:b", "v", WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-events:
+		if e != "cache:a "+EvictCapacity {
+			t.Errorf("unexpected event %q", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("eviction was not notified")
+	}
+}
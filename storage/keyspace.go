@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+//MaxKeyspaceNamespaces bounds the namespaces whose hits, misses and expirations
+//are counted separately, so that lookups of arbitrary keys can't grow the
+//counters without limit. Further namespaces are counted under OtherNamespace.
+var MaxKeyspaceNamespaces = 1000
+
+const OtherNamespace = "_other"
+
+//itemOverhead estimates the memory an item takes besides its key and value.
+const itemOverhead = 96
+
+//NamespaceStats describes the keys of a namespace; Bytes is an estimate of
+//the memory they take.
+type NamespaceStats struct {
+	Namespace   string `json:"namespace"`
+	Items       int    `json:"items"`
+	Expired     int    `json:"expired"`
+	Bytes       int64  `json:"bytes"`
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Expirations uint64 `json:"expirations"`
+}
+
+//HitRatio is the share of lookups which found a live item.
+func (st NamespaceStats) HitRatio() float64 {
+	if st.Hits+st.Misses == 0 {
+		return 0
+	}
+	return float64(st.Hits) / float64(st.Hits+st.Misses)
+}
+
+type keyspaceCounters struct {
+	hits        uint64
+	misses      uint64
+	expirations uint64
+}
+
+type keyspace struct {
+	enabled    int32
+	mu         sync.Mutex
+	namespaces map[string]*keyspaceCounters
+}
+
+func (ks *keyspace) counters(key string) *keyspaceCounters {
+	name := Namespace(key)
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	c, ok := ks.namespaces[name]
+	if ok {
+		return c
+	}
+	if len(ks.namespaces) >= MaxKeyspaceNamespaces {
+		name = OtherNamespace
+		if c, ok = ks.namespaces[name]; ok {
+			return c
+		}
+	}
+	c = &keyspaceCounters{}
+	ks.namespaces[name] = c
+	return c
+}
+
+//recordGet counts a lookup of key if tracking is enabled.
+func (s *Storage) recordGet(key string, hit bool) {
+	if hit {
+		s.recordAccess(key, accessRead)
+	} else {
+		s.recordAccess(key, accessMiss)
+	}
+	if atomic.LoadInt32(&s.keyspace.enabled) == 0 {
+		return
+	}
+	c := s.keyspace.counters(key)
+	if hit {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
+}
+
+func (s *Storage) recordExpiration(key string) {
+	if atomic.LoadInt32(&s.keyspace.enabled) == 0 {
+		return
+	}
+	atomic.AddUint64(&s.keyspace.counters(key).expirations, 1)
+}
+
+//TrackKeyspace makes lookups and expirations count per namespace for Keyspace.
+//Counting takes a mutex on every lookup, so it is disabled by default.
+func (s *Storage) TrackKeyspace(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+		s.keyspace.mu.Lock()
+		if s.keyspace.namespaces == nil {
+			s.keyspace.namespaces = make(map[string]*keyspaceCounters)
+		}
+		s.keyspace.mu.Unlock()
+	}
+	atomic.StoreInt32(&s.keyspace.enabled, v)
+}
+
+//Keyspace returns the stats of every namespace, keys without one are in "",
+//ordered by namespace. It scans all items and returns nil unless TrackKeyspace is enabled.
+func (s *Storage) Keyspace() []NamespaceStats {
+	if atomic.LoadInt32(&s.keyspace.enabled) == 0 {
+		return nil
+	}
+	byName := make(map[string]*NamespaceStats)
+	stats := func(name string) *NamespaceStats {
+		st, ok := byName[name]
+		if !ok {
+			st = &NamespaceStats{Namespace: name}
+			byName[name] = st
+		}
+		return st
+	}
+
+	s.rlock("Keyspace")
+	now := s.now()
+	for k, v := range s.items {
+		st := stats(Namespace(k))
+		st.Items++
+		if v.expiredAt(now) {
+			st.Expired++
+		}
+		st.Bytes += itemOverhead + int64(len(k)) + valueSize(v.Object)
+	}
+	s.mu.RUnlock()
+
+	s.keyspace.mu.Lock()
+	for name, c := range s.keyspace.namespaces {
+		st := stats(name)
+		st.Hits = atomic.LoadUint64(&c.hits)
+		st.Misses = atomic.LoadUint64(&c.misses)
+		st.Expirations = atomic.LoadUint64(&c.expirations)
+	}
+	s.keyspace.mu.Unlock()
+
+	res := make([]NamespaceStats, 0, len(byName))
+	for _, st := range byName {
+		res = append(res, *st)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Namespace < res[j].Namespace
+	})
+	return res
+}
+
+//valueSize estimates the memory taken by a value.
+func valueSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case ChunkedValue:
+		return v.Size
+	case compressedValue:
+		return int64(len(v.Data))
+	case []interface{}:
+		n := int64(len(v)) * 16
+		for _, e := range v {
+			n += valueSize(e)
+		}
+		return n
+	case map[string]interface{}:
+		n := int64(len(v)) * 32
+		for k, e := range v {
+			n += int64(len(k)) + valueSize(e)
+		}
+		return n
+	}
+	return 8
+}
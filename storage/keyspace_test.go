@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStorage_Keyspace(t *testing.T) {
+	c := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(c)
+	if s.Keyspace() != nil {
+		t.Error("keyspace is returned without tracking")
+	}
+	s.TrackKeyspace(true)
+
+	s.Set("orders:1", "abc", NoExpiration)
+	s.Set("orders:2", "abc", time.Minute)
+	s.Set("orders:3", "abc", time.Minute)
+	s.Set("plain", 1, NoExpiration)
+	s.Get("orders:1")
+	s.Get("orders:1")
+	s.Get("orders:missing")
+	s.GetItem("plain")
+	s.Get("users:1")
+
+	c.now = c.now.Add(2 * time.Minute)
+	s.Get("orders:2")
+	ks := s.Keyspace()
+	if len(ks) != 3 || ks[0].Namespace != "" || ks[1].Namespace != "orders" || ks[2].Namespace != "users" {
+		t.Fatalf("unexpected namespaces: %+v", ks)
+	}
+	orders := ks[1]
+	if orders.Items != 3 || orders.Expired != 2 || orders.Hits != 2 || orders.Misses != 2 {
+		t.Errorf("unexpected orders stats: %+v", orders)
+	}
+	if orders.HitRatio() != 0.5 {
+		t.Errorf("expected a hit ratio of 0.5, got %v", orders.HitRatio())
+	}
+	if want := int64(3 * (itemOverhead + len("orders:1") + 3)); orders.Bytes != want {
+		t.Errorf("expected %d bytes, got %d", want, orders.Bytes)
+	}
+	if ks[0].Items != 1 || ks[0].Hits != 1 || ks[2].Items != 0 || ks[2].Misses != 1 {
+		t.Errorf("unexpected stats: %+v", ks)
+	}
+
+	s.DeleteExpired()
+	if orders = s.Keyspace()[1]; orders.Items != 1 || orders.Expirations != 2 {
+		t.Errorf("unexpected orders stats after expiration: %+v", orders)
+	}
+}
+
+func TestStorage_KeyspaceLimit(t *testing.T) {
+	defer func(max int) { MaxKeyspaceNamespaces = max }(MaxKeyspaceNamespaces)
+	MaxKeyspaceNamespaces = 2
+	s := New(DefaultExpiration, 0, 0)
+	s.TrackKeyspace(true)
+	for _, key := range []string{"a:1", "b:1", "c:1", "d:1"} {
+		s.Get(key)
+	}
+	ks := s.Keyspace()
+	if len(ks) != 3 || ks[0].Namespace != OtherNamespace || ks[0].Misses != 2 {
+		t.Errorf("unexpected namespaces: %+v", ks)
+	}
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrNoLoader = errors.New("no loader is set")
+
+//Loader fetches a value missing from the storage, e.g. from an upstream server.
+//It must return ErrNotFound if the value doesn't exist there either.
+type Loader func(ctx context.Context, key string) (interface{}, error)
+
+type loadCall struct {
+	done chan struct{}
+	item Item
+	err  error
+}
+
+//SetLoader makes GetOrLoad fetch missing keys with l and keep them for ttl
+//(0 means the default expiration). A nil loader disables it.
+func (s *Storage) SetLoader(l Loader, ttl time.Duration) {
+	s.lock("SetLoader")
+	s.loader = l
+	s.loadTTL = ttl
+	s.mu.Unlock()
+}
+
+//detachedContext keeps the values of its parent but not its deadline and
+//cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+//GetOrLoad returns the item of key, fetching its value with the loader on a miss.
+//Concurrent misses of the same key share a single load, which isn't canceled
+//when the ctx of the caller which started it is done. If the load fails and
+//serving stale items is enabled (see SetServeStale), it may return the last
+//known item along with a *StaleError.
+func (s *Storage) GetOrLoad(ctx context.Context, key string) (Item, error) {
+	if item, found := s.GetItem(key); found {
+		return item, nil
+	}
+
+	s.lock("GetOrLoad")
+	if s.loader == nil {
+		s.mu.Unlock()
+		return Item{}, ErrNotFound
+	}
+	call, ok := s.loads[key]
+	if !ok {
+		call = &loadCall{done: make(chan struct{})}
+		s.loads[key] = call
+		go s.runLoad(detachedContext{ctx}, key, call, s.loader, s.loadTTL)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.item, call.err
+	case <-ctx.Done():
+		return Item{}, ctx.Err()
+	}
+}
+
+//runLoad runs a load shared by the callers of GetOrLoad waiting for call.
+func (s *Storage) runLoad(ctx context.Context, key string, call *loadCall, load Loader, ttl time.Duration) {
+	v, err := load(ctx, key)
+
+	s.lock("GetOrLoad")
+	delete(s.loads, key)
+	if err == nil {
+		//a value written while loading is newer than the loaded one
+		if item, found := s.items[key]; !found || s.expired(&item) {
+			s.set(key, v, ttl)
+		}
+		call.item = s.items[key]
+		call.item.Object = plain(call.item.Object)
+	} else if errors.Is(err, ErrNotFound) {
+		s.forgetStale(key)
+	} else if item, found := s.items[key]; found && !s.expired(&item) {
+		//written while loading
+		item.Object = plain(item.Object)
+		call.item, err = item, nil
+	} else if item, age, ok := s.staleItem(key); ok {
+		call.item, err = item, &StaleError{Err: err, Age: age}
+	}
+	call.err = err
+	s.mu.Unlock()
+	close(call.done)
+}
+
+//WarmupStats counts the keys of a Warmup: Cached ones were present already,
+//Missing ones don't exist upstream and Failed ones couldn't be loaded.
+type WarmupStats struct {
+	Loaded  int `json:"loaded"`
+	Cached  int `json:"cached"`
+	Missing int `json:"missing"`
+	Failed  int `json:"failed"`
+}
+
+//Processed is the number of keys handled so far.
+func (st WarmupStats) Processed() int {
+	return st.Loaded + st.Cached + st.Missing + st.Failed
+}
+
+//Warmup fetches keys with the loader using workers concurrent loads, so that
+//they are present before traffic arrives. progress, if not nil, is called
+//after every key, one call at a time. It stops early if ctx is done.
+func (s *Storage) Warmup(ctx context.Context, keys []string, workers int, progress func(WarmupStats)) (WarmupStats, error) {
+	s.rlock("Warmup")
+	hasLoader := s.loader != nil
+	s.mu.RUnlock()
+	if !hasLoader {
+		return WarmupStats{}, ErrNoLoader
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	var mu sync.Mutex
+	st := WarmupStats{}
+	next := make(chan string)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range next {
+				_, cached := s.GetItem(key)
+				var err error
+				if !cached {
+					_, err = s.GetOrLoad(ctx, key)
+				}
+
+				mu.Lock()
+				switch {
+				case cached:
+					st.Cached++
+				case err == nil:
+					st.Loaded++
+				case errors.Is(err, ErrNotFound):
+					st.Missing++
+				default:
+					st.Failed++
+				}
+				if progress != nil {
+					progress(st)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var err error
+	for _, key := range keys {
+		select {
+		case next <- key:
+			continue
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		break
+	}
+	close(next)
+	wg.Wait()
+	return st, err
+}
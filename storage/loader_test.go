@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStorage_GetOrLoad(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	if _, err := s.GetOrLoad(context.Background(), "a"); err != ErrNotFound {
+		t.Errorf("miss without loader returned %v", err)
+	}
+
+	var calls int32
+	s.SetLoader(func(ctx context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		if key == "missing" {
+			return nil, ErrNotFound
+		}
+		return "loaded " + key, nil
+	}, time.Hour)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			item, err := s.GetOrLoad(context.Background(), "a")
+			if err != nil || item.Object != "loaded a" || item.Version == 0 {
+				t.Errorf("unexpected result: %+v, %v", item, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("loader was called %d times", calls)
+	}
+	if item := s.Items()["a"]; item.Expiration == 0 {
+		t.Error("loaded item has no expiration")
+	}
+
+	if _, err := s.GetOrLoad(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing key returned %v", err)
+	}
+	if _, found := s.Get("missing"); found {
+		t.Error("missing key was stored")
+	}
+}
+
+func TestStorage_Warmup(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	if _, err := s.Warmup(context.Background(), []string{"a"}, 1, nil); err != ErrNoLoader {
+		t.Errorf("warmup without loader returned %v", err)
+	}
+
+	s.SetLoader(func(ctx context.Context, key string) (interface{}, error) {
+		switch key {
+		case "missing":
+			return nil, ErrNotFound
+		case "broken":
+			return nil, errors.New("upstream is down")
+		}
+		return "loaded " + key, nil
+	}, time.Hour)
+	s.Set("cached", "1", DefaultExpiration)
+
+	calls := 0
+	st, err := s.Warmup(context.Background(), []string{"a", "b", "cached", "missing", "broken"}, 3, func(WarmupStats) {
+		calls++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != (WarmupStats{Loaded: 2, Cached: 1, Missing: 1, Failed: 1}) || calls != 5 {
+		t.Errorf("unexpected stats: %+v after %d progress calls", st, calls)
+	}
+	if v, _ := s.Get("b"); v != "loaded b" {
+		t.Errorf("b wasn't loaded: %v", v)
+	}
+}
+
+func TestStorage_GetOrLoadShared(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.SetCompression(16)
+	long := strings.Repeat("v", 100)
+	release := make(chan struct{})
+	s.SetLoader(func(ctx context.Context, key string) (interface{}, error) {
+		<-release
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return long, nil
+	}, time.Hour)
+
+	//the caller which started the load goes away, the others still get its result
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := s.GetOrLoad(ctx, "a")
+		first <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	second := make(chan Item)
+	go func() {
+		item, err := s.GetOrLoad(context.Background(), "a")
+		if err != nil {
+			t.Error(err)
+		}
+		second <- item
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-first:
+		if err != context.Canceled {
+			t.Errorf("canceled caller returned %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("canceled caller waits for the load")
+	}
+	close(release)
+	if item := <-second; item.Object != long {
+		t.Errorf("loaded value is returned compressed: %T", item.Object)
+	}
+	if item, err := s.GetOrLoad(context.Background(), "a"); err != nil || item.Object != long {
+		t.Errorf("stored value: %T, %v", item.Object, err)
+	}
+}
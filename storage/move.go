@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+//Rename moves the item of src to dst with its expiration and metadata. If dst holds
+//a live item, ErrExists is returned unless overwrite is set. Readers see either
+//the old or the new key, never both or neither.
+func (s *Storage) Rename(src, dst string, overwrite bool) (uint64, error) {
+	return s.Guard(nil).Rename(src, dst, overwrite)
+}
+
+//Rename is Storage.Rename for the keys the guard accepts.
+func (g Guarded) Rename(src, dst string, overwrite bool) (uint64, error) {
+	s := g.s
+	s.lock("Rename")
+	defer s.mu.Unlock()
+	if err := g.check(src, dst); err != nil {
+		return 0, err
+	}
+
+	item, err := s.move(src, dst, overwrite)
+	if err != nil {
+		return 0, err
+	}
+	if src != dst {
+		s.remove(src)
+		s.recordAccess(src, accessWrite)
+	}
+	return s.put(dst, item), nil
+}
+
+//Copy stores a copy of the item of src at dst, replacing any item there.
+//ttl works like in Set except that DefaultExpiration keeps the expiration of src.
+func (s *Storage) Copy(src, dst string, ttl time.Duration) (uint64, error) {
+	return s.Guard(nil).Copy(src, dst, ttl)
+}
+
+//Copy is Storage.Copy for the keys the guard accepts.
+func (g Guarded) Copy(src, dst string, ttl time.Duration) (uint64, error) {
+	s := g.s
+	s.lock("Copy")
+	defer s.mu.Unlock()
+	if err := g.check(dst); err != nil {
+		return 0, err
+	}
+
+	item, err := s.move(src, dst, true)
+	if err != nil {
+		return 0, err
+	}
+	if ttl != DefaultExpiration {
+		expiring := s.newItem(dst, item.Object, ttl)
+		item.Expiration, item.Sliding, item.LastAccess = expiring.Expiration, expiring.Sliding, expiring.LastAccess
+	}
+	return s.put(dst, item), nil
+}
+
+//move returns a copy of src which can be stored at dst. Must be called with the write lock held.
+func (s *Storage) move(src, dst string, overwrite bool) (Item, error) {
+	item, found := s.items[src]
+	if !found || s.expired(&item) {
+		return Item{}, ErrNotFound
+	}
+	if cur, found := s.items[dst]; found && !s.expired(&cur) && !overwrite && src != dst {
+		return Item{}, ErrExists
+	}
+	if err := s.validate(dst, plain(item.Object)); err != nil {
+		return Item{}, err
+	}
+	return detach(item), nil
+}
+
+//detach returns item with its own last access time, so a moved or copied
+//item doesn't share it with the original. The entries of streams, time series
+//and queues are clipped, so appending to either copy reallocates them instead
+//of writing to the array the other one reads.
+func detach(item Item) Item {
+	if item.LastAccess != nil {
+		access := atomic.LoadInt64(item.LastAccess)
+		item.LastAccess = &access
+	}
+	switch v := item.Object.(type) {
+	case Stream:
+		v.Entries = v.Entries[:len(v.Entries):len(v.Entries)]
+		item.Object = v
+	case TimeSeries:
+		v.Samples = v.Samples[:len(v.Samples):len(v.Samples)]
+		item.Object = v
+	case Queue:
+		v.Messages = v.Messages[:len(v.Messages):len(v.Messages)]
+		item.Object = v
+	case WindowCounter:
+		v.Buckets = v.Buckets[:len(v.Buckets):len(v.Buckets)]
+		item.Object = v
+	}
+	return item
+}
+
+//Rotate moves the item of every key to the next key under a single lock
+//acquisition, so readers see either all keys before or all keys after it:
+//rotating ["new", "current", "previous"] promotes new to current and keeps the
+//replaced item as previous. The first key ends up deleted and the item of the
+//last one is dropped, unless wrap is set, which moves it to the first key; with
+//two keys that swaps them. A missing item moves as well, deleting its next key.
+//It returns the new version of every key, 0 for deleted ones, or a
+//*NamespaceFullError if an item doesn't fit into the namespace of its next key.
+func (s *Storage) Rotate(keys []string, wrap bool) ([]uint64, error) {
+	return s.Guard(nil).Rotate(keys, wrap)
+}
+
+//Rotate is Storage.Rotate for the keys the guard accepts.
+func (g Guarded) Rotate(keys []string, wrap bool) ([]uint64, error) {
+	s := g.s
+	if len(keys) < 2 {
+		return nil, errors.New("rotate needs at least two keys")
+	}
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			return nil, fmt.Errorf("key %s is given twice", key)
+		}
+		seen[key] = true
+	}
+
+	s.lock("Rotate")
+	defer s.mu.Unlock()
+	if err := g.check(keys...); err != nil {
+		return nil, err
+	}
+
+	//items[i] moves to keys[i]; all of them are checked before anything changes
+	items := make([]*Item, len(keys))
+	for i, key := range keys {
+		src := i - 1
+		if src < 0 {
+			if !wrap {
+				continue
+			}
+			src = len(keys) - 1
+		}
+		item, found := s.items[keys[src]]
+		if !found || s.expired(&item) {
+			continue
+		}
+		if err := s.validate(key, plain(item.Object)); err == ErrNamespaceFull {
+			return nil, &NamespaceFullError{key}
+		} else if err != nil {
+			return nil, err
+		}
+		item = detach(item)
+		items[i] = &item
+	}
+
+	versions := make([]uint64, len(keys))
+	for i, key := range keys {
+		if items[i] == nil {
+			if s.remove(key) {
+				s.recordAccess(key, accessWrite)
+			}
+			continue
+		}
+		versions[i] = s.put(key, *items[i])
+	}
+	return versions, nil
+}
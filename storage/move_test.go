@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStorage_Rename(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	if _, err := s.Rename("missing", "b", false); err != ErrNotFound {
+		t.Errorf("missing key was renamed: %v", err)
+	}
+
+	s.Write("staging", "v", WriteOptions{TTL: time.Hour, ContentType: "text/plain"})
+	src := s.Items()["staging"]
+	s.Set("live", "old", NoExpiration)
+	if _, err := s.Rename("staging", "live", false); err != ErrExists {
+		t.Errorf("existing key was overwritten: %v", err)
+	}
+	version, err := s.Rename("staging", "live", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := s.Get("staging"); found {
+		t.Error("staging still exists")
+	}
+	item, _ := s.GetItem("live")
+	if item.Object != "v" || item.Version != version || item.Expiration != src.Expiration || item.ContentType != "text/plain" {
+		t.Errorf("unexpected item: %+v", item)
+	}
+	if live, _ := s.Counts(); live != 1 {
+		t.Errorf("%d live items instead of 1", live)
+	}
+}
+
+func TestStorage_Copy(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.SetSliding("a", "v", time.Hour)
+	if _, err := s.Copy("a", "b", DefaultExpiration); err != nil {
+		t.Fatal(err)
+	}
+	a, b := s.Items()["a"], s.Items()["b"]
+	if b.Object != "v" || b.Sliding != a.Sliding || b.LastAccess == a.LastAccess {
+		t.Errorf("unexpected copy: %+v", b)
+	}
+
+	if _, err := s.Copy("a", "c", NoExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if c := s.Items()["c"]; c.Expiration != 0 || c.Sliding != 0 {
+		t.Errorf("copy expires: %+v", c)
+	}
+	if _, err := s.Copy("missing", "d", DefaultExpiration); err != ErrNotFound {
+		t.Errorf("missing key was copied: %v", err)
+	}
+}
+
+func TestStorage_CopyDetachesEntries(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		s.XAdd("a", map[string]string{"n": "a"})
+		s.TSAdd("ts-a", now.Add(time.Duration(i)*time.Second), 1, 0)
+		s.QPush("q-a", "a")
+	}
+	if _, err := s.Copy("a", "b", DefaultExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Copy("ts-a", "ts-b", DefaultExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Copy("q-a", "q-b", DefaultExpiration); err != nil {
+		t.Fatal(err)
+	}
+	s.XAdd("a", map[string]string{"n": "a"})
+	s.XAdd("b", map[string]string{"n": "b"})
+	s.TSAdd("ts-a", now.Add(time.Minute), 1, 0)
+	s.TSAdd("ts-b", now.Add(time.Minute), 2, 0)
+	s.QPush("q-a", "a")
+	s.QPush("q-b", "b")
+
+	entries, _ := s.XRange("a", StreamID{}, StreamID{Ms: math.MaxInt64}, 0)
+	if len(entries) != 4 || entries[3].Fields["n"] != "a" {
+		t.Errorf("stream a was overwritten by its copy: %+v", entries)
+	}
+	samples, _ := s.TSRange("ts-a", now, now.Add(time.Hour))
+	if len(samples) != 4 || samples[3].Value != 1 {
+		t.Errorf("series ts-a was overwritten by its copy: %+v", samples)
+	}
+	msgs, _ := s.QReceive("q-a", 10, time.Minute)
+	if len(msgs) != 4 || msgs[3].Body != "a" {
+		t.Errorf("queue q-a was overwritten by its copy: %+v", msgs)
+	}
+
+	c := &fixedClock{now.Truncate(time.Minute)}
+	s.SetClock(c)
+	for i := 0; i < 4; i++ {
+		s.IncrWindow("w-a", time.Minute, 10, 1)
+		c.now = c.now.Add(time.Minute)
+	}
+	c.now = c.now.Add(-time.Minute)
+	if _, err := s.Copy("w-a", "w-b", DefaultExpiration); err != nil {
+		t.Fatal(err)
+	}
+	s.IncrWindow("w-b", time.Minute, 10, 5)
+	c.now = c.now.Add(time.Minute)
+	s.IncrWindow("w-a", time.Minute, 10, 1)
+	s.IncrWindow("w-b", time.Minute, 10, 1)
+	buckets, _ := s.WindowRange("w-a", now.Add(-time.Hour), now.Add(time.Hour))
+	if len(buckets) != 5 || buckets[3].Count != 1 {
+		t.Errorf("counter w-a was overwritten by its copy: %+v", buckets)
+	}
+}
+
+func TestStorage_Rotate(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("config:new", "v2", NoExpiration)
+	s.Set("config:current", "v1", NoExpiration)
+	s.Set("config:previous", "v0", NoExpiration)
+
+	versions, err := s.Rotate([]string{"config:new", "config:current", "config:previous"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions[0] != 0 || versions[1] == 0 || versions[2] == 0 {
+		t.Errorf("unexpected versions: %v", versions)
+	}
+	if _, found := s.Get("config:new"); found {
+		t.Error("first key was kept")
+	}
+	if v, _ := s.Get("config:current"); v != "v2" {
+		t.Errorf("current is %v", v)
+	}
+	if v, _ := s.Get("config:previous"); v != "v1" {
+		t.Errorf("previous is %v", v)
+	}
+
+	//wrapping two keys swaps them
+	if _, err := s.Rotate([]string{"config:current", "config:previous"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("config:current"); v != "v1" {
+		t.Errorf("current is %v after a swap", v)
+	}
+
+	s.SetSchema("strict", &Schema{Type: "object"})
+	if _, err := s.Rotate([]string{"config:current", "strict:1"}, false); err == nil {
+		t.Error("invalid value was rotated into a namespace with a schema")
+	}
+	if v, _ := s.Get("config:current"); v != "v1" {
+		t.Error("failed rotation changed keys")
+	}
+	s.SetNamespaceOptions("full", NamespaceOptions{MaxItems: 1})
+	s.Set("full:1", "v", NoExpiration)
+	var nf *NamespaceFullError
+	if _, err := s.Rotate([]string{"config:current", "full:2"}, false); !errors.As(err, &nf) || nf.Key != "full:2" {
+		t.Errorf("rotation into a full namespace returned %v", err)
+	}
+	if _, err := s.Rotate([]string{"a", "a"}, false); err == nil {
+		t.Error("duplicate keys were rotated")
+	}
+}
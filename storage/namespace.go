@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//NamespaceSeparator separates namespace from the rest of the key: "orders:42" is in namespace "orders".
+const NamespaceSeparator = ":"
+
+var ErrNamespaceFull = errors.New("namespace is full")
+
+//NamespaceFullError is returned by writes of several keys when the namespace
+//of Key has no room for it. It matches ErrNamespaceFull with errors.Is.
+type NamespaceFullError struct {
+	Key string
+}
+
+func (e *NamespaceFullError) Error() string {
+	return fmt.Sprintf("item %s: %v", e.Key, ErrNamespaceFull)
+}
+
+func (e *NamespaceFullError) Is(target error) bool {
+	return target == ErrNamespaceFull
+}
+
+//Namespace returns the namespace of key or "" if key has none.
+func Namespace(key string) string {
+	i := strings.Index(key, NamespaceSeparator)
+	if i == -1 {
+		return ""
+	}
+	return key[:i]
+}
+
+//NamespaceOptions override storage settings for the keys of a namespace.
+type NamespaceOptions struct {
+	//DefaultExpiration is used for items written with DefaultExpiration, 0 keeps the storage default
+	DefaultExpiration time.Duration
+	//CleanupInterval > 0 runs a separate janitor deleting expired items of the namespace
+	CleanupInterval time.Duration
+	//MaxItems > 0 limits the number of items; expired items count until they are deleted
+	MaxItems int
+	//Evict makes writes evict an item of a full namespace instead of failing with ErrNamespaceFull
+	Evict bool
+	//Canon normalizes keys of the namespace, see CanonicalKey
+	Canon KeyCanon
+	//Compression overrides SetCompression for the namespace
+	Compression Compression
+}
+
+type namespace struct {
+	opts    NamespaceOptions
+	items   int
+	janitor *janitor
+}
+
+//SetNamespaceOptions replaces options of namespace; zero options remove them.
+func (s *Storage) SetNamespaceOptions(name string, opts NamespaceOptions) {
+	s.lock("SetNamespaceOptions")
+	old := s.namespaces[name]
+	s.setNamespaceOptions(name, opts)
+	s.mu.Unlock()
+
+	//the old janitor may be waiting for the lock, so it's stopped after releasing it
+	if old != nil && old.janitor != nil {
+		old.janitor.stop <- true
+	}
+}
+
+func (s *Storage) setNamespaceOptions(name string, opts NamespaceOptions) {
+	defer s.countCanonNamespaces()
+	if opts == (NamespaceOptions{}) {
+		delete(s.namespaces, name)
+		return
+	}
+
+	ns := &namespace{opts: opts}
+	for k := range s.items {
+		if Namespace(k) == name {
+			ns.items++
+		}
+	}
+	if opts.CleanupInterval > 0 {
+		ns.janitor = &janitor{
+			Interval: opts.CleanupInterval,
+			stop:     make(chan bool),
+		}
+		go ns.janitor.run(s, func() {
+			s.DeleteExpiredNamespace(name)
+		})
+	}
+	s.namespaces[name] = ns
+}
+
+func (s *Storage) NamespaceOptions(name string) (NamespaceOptions, bool) {
+	s.rlock("NamespaceOptions")
+	defer s.mu.RUnlock()
+	ns, ok := s.namespaces[name]
+	if !ok {
+		return NamespaceOptions{}, false
+	}
+	return ns.opts, true
+}
+
+//NamespaceQuota returns the item count and the item limit of namespace, ok is
+//false if it has no limit.
+func (s *Storage) NamespaceQuota(name string) (items, maxItems int, ok bool) {
+	s.rlock("NamespaceQuota")
+	defer s.mu.RUnlock()
+	ns, found := s.namespaces[name]
+	if !found || ns.opts.MaxItems <= 0 {
+		return 0, 0, false
+	}
+	return ns.items, ns.opts.MaxItems, true
+}
+
+//countCanonNamespaces lets CanonicalKey skip the lookup if no namespace canonicalizes keys.
+func (s *Storage) countCanonNamespaces() {
+	s.canonNamespaces = 0
+	for _, ns := range s.namespaces {
+		if ns.opts.Canon != (KeyCanon{}) {
+			s.canonNamespaces++
+		}
+	}
+}
+
+//DeleteExpiredNamespace deletes expired items of a single namespace.
+func (s *Storage) DeleteExpiredNamespace(name string) {
+	s.deleteExpired(name, false, 0)
+}
+
+//checkNamespaceLimit reports ErrNamespaceFull if key is new and its namespace
+//has no room left. Must be called with the lock held.
+func (s *Storage) checkNamespaceLimit(key string) error {
+	if err := s.checkNamespaceRoom(Namespace(key), 1); err == nil {
+		return nil
+	}
+	if _, found := s.items[key]; found {
+		return nil
+	}
+	return ErrNamespaceFull
+}
+
+//checkNamespaceRoom reports ErrNamespaceFull if the namespace has no room for
+//n more items. Must be called with the lock held.
+func (s *Storage) checkNamespaceRoom(namespace string, n int) error {
+	if len(s.namespaces) == 0 {
+		return nil
+	}
+	ns, ok := s.namespaces[namespace]
+	if !ok || ns.opts.MaxItems <= 0 || ns.items+n <= ns.opts.MaxItems {
+		return nil
+	}
+	return ErrNamespaceFull
+}
+
+//countNamespace adjusts the item count of key's namespace by delta.
+func (s *Storage) countNamespace(key string, delta int) {
+	if len(s.namespaces) == 0 {
+		return
+	}
+	if ns, ok := s.namespaces[Namespace(key)]; ok {
+		ns.items += delta
+	}
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStorage_NamespaceOptions(t *testing.T) {
+	c := &fixedClock{now: time.Now()}
+	s := New(NoExpiration, 0, 0)
+	s.SetClock(c)
+	s.Set("sessions:old", "v", DefaultExpiration)
+	s.SetNamespaceOptions("sessions", NamespaceOptions{
+		DefaultExpiration: time.Minute,
+		MaxItems:          2,
+	})
+
+	s.Set("sessions:new", "v", DefaultExpiration)
+	s.Set("other", "v", DefaultExpiration)
+	items := s.Items()
+	if exp := items["sessions:new"].Expiration; exp != c.now.Add(time.Minute).UnixNano() {
+		t.Errorf("namespace default expiration was not used: %d", exp)
+	}
+	if exp := items["other"].Expiration; exp != 0 {
+		t.Errorf("namespace expiration leaked to other keys: %d", exp)
+	}
+
+	if err := s.Validate("sessions:third", "v"); err != ErrNamespaceFull {
+		t.Errorf("new key in a full namespace was accepted: %v", err)
+	}
+	if err := s.Add("sessions:third", "v", DefaultExpiration); err != ErrNamespaceFull {
+		t.Errorf("new key in a full namespace was added: %v", err)
+	}
+	if err := s.Validate("sessions:old", "v"); err != nil {
+		t.Errorf("existing key in a full namespace was rejected: %v", err)
+	}
+	if items, max, ok := s.NamespaceQuota("sessions"); !ok || items != 2 || max != 2 {
+		t.Errorf("unexpected quota: %d of %d, %v", items, max, ok)
+	}
+	if _, _, ok := s.NamespaceQuota("other"); ok {
+		t.Error("quota is reported for a namespace without a limit")
+	}
+	s.Delete("sessions:old")
+	if err := s.Validate("sessions:third", "v"); err != nil {
+		t.Errorf("key was rejected after a deletion: %v", err)
+	}
+
+	s.SetNamespaceOptions("sessions", NamespaceOptions{})
+	if _, ok := s.NamespaceOptions("sessions"); ok {
+		t.Error("options were not removed")
+	}
+}
+
+func TestStorage_NamespaceLimitOfTypes(t *testing.T) {
+	s := New(NoExpiration, 0, 0)
+	s.SetNamespaceOptions("ns", NamespaceOptions{MaxItems: 1})
+	if err := s.Set("ns:full", "v", DefaultExpiration); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set("ns:set", "v", DefaultExpiration); err != ErrNamespaceFull {
+		t.Errorf("value was set in a full namespace: %v", err)
+	}
+	if err := NewTyped[int](s).Set("ns:typed", 1, DefaultExpiration); err != ErrNamespaceFull {
+		t.Errorf("typed value was set in a full namespace: %v", err)
+	}
+	if err := s.Set("ns:full", "replaced", DefaultExpiration); err != nil {
+		t.Errorf("existing key wasn't replaced: %v", err)
+	}
+	if _, err := s.XAdd("ns:stream", map[string]string{"f": "v"}); err != ErrNamespaceFull {
+		t.Errorf("stream was added to a full namespace: %v", err)
+	}
+	if err := s.TSAdd("ns:series", time.Now(), 1, 0); err != ErrNamespaceFull {
+		t.Errorf("time series was added to a full namespace: %v", err)
+	}
+	if _, err := s.IncrWindow("ns:counter", time.Minute, 2, 1); err != ErrNamespaceFull {
+		t.Errorf("window counter was added to a full namespace: %v", err)
+	}
+	if _, err := s.QPush("ns:queue", "m"); err != ErrNamespaceFull {
+		t.Errorf("queue was added to a full namespace: %v", err)
+	}
+
+	src := New(NoExpiration, 0, 0)
+	src.Set("ns:imported", "v", DefaultExpiration)
+	buf := &bytes.Buffer{}
+	if _, err := src.Export(buf, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Import(buf, nil, nil); !errors.Is(err, ErrNamespaceFull) {
+		t.Errorf("import overflowed the namespace: %v", err)
+	}
+	if items, _, _ := s.NamespaceQuota("ns"); items != 1 {
+		t.Errorf("unexpected items: %d", items)
+	}
+}
+
+func TestStorage_NamespaceJanitor(t *testing.T) {
+	s := New(NoExpiration, 0, 0)
+	s.SetNamespaceOptions("tmp", NamespaceOptions{CleanupInterval: time.Millisecond})
+	defer s.SetNamespaceOptions("tmp", NamespaceOptions{})
+	s.Set("tmp:a", "v", time.Millisecond)
+	s.Set("keep", "v", time.Millisecond)
+
+	time.Sleep(20 * time.Millisecond)
+	if n := s.ItemCount(); n != 1 {
+		t.Errorf("%d items left, expected only the one outside the namespace", n)
+	}
+}
@@ -0,0 +1,210 @@
+package storage
+
+import "time"
+
+//ExpiringCheckInterval is how often keys are checked for NotifyExpiring,
+//so notifications may come up to this late.
+var ExpiringCheckInterval = time.Second
+
+//ExpiringFunc is called with a key and the time it expires at.
+type ExpiringFunc func(key string, at time.Time)
+
+type expiryWatch struct {
+	pattern string
+	before  time.Duration
+	fn      ExpiringFunc
+	//notified holds the expiration each key was notified for, so a key is
+	//notified again only if its expiration changes
+	notified map[string]int64
+}
+
+//NotifyExpiring calls fn once for every key matching pattern (see MatchPattern)
+//when it has less than before left to live. fn is called from a separate goroutine
+//and must not block for long. The returned function cancels the notification.
+func (s *Storage) NotifyExpiring(pattern string, before time.Duration, fn ExpiringFunc) (cancel func()) {
+	w := &expiryWatch{
+		pattern:  pattern,
+		before:   before,
+		fn:       fn,
+		notified: make(map[string]int64),
+	}
+
+	s.lock("NotifyExpiring")
+	s.watchID++
+	id := s.watchID
+	s.watches[id] = w
+	if !s.watching {
+		s.watching = true
+		go s.watchExpiring()
+	}
+	s.mu.Unlock()
+
+	return func() {
+		s.lock("NotifyExpiring")
+		delete(s.watches, id)
+		s.mu.Unlock()
+	}
+}
+
+//watchExpiring checks watches until all of them are cancelled.
+func (s *Storage) watchExpiring() {
+	ticker := time.NewTicker(ExpiringCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.checkExpiring() {
+			continue
+		}
+		s.lock("watchExpiring")
+		if len(s.watches) == 0 {
+			s.watching = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+	}
+}
+
+type expiringKey struct {
+	fn  ExpiringFunc
+	key string
+	at  int64
+}
+
+func (s *Storage) checkExpiring() bool {
+	s.rlock("checkExpiring")
+	if len(s.watches) == 0 {
+		s.mu.RUnlock()
+		return false
+	}
+
+	now := s.now()
+	var due []expiringKey
+	check := func(w *expiryWatch, key string, item Item) {
+		at := item.expiresAt()
+		if at <= now || at > now+int64(w.before) || w.notified[key] == at {
+			return
+		}
+		w.notified[key] = at
+		due = append(due, expiringKey{fn: w.fn, key: key, at: at})
+	}
+	for _, w := range s.watches {
+		for key, at := range w.notified {
+			if at <= now {
+				delete(w.notified, key)
+			}
+		}
+		if !isPattern(w.pattern) {
+			if item, found := s.items[w.pattern]; found {
+				check(w, w.pattern, item)
+			}
+			continue
+		}
+		for key, item := range s.items {
+			if MatchPattern(w.pattern, key) {
+				check(w, key, item)
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, d := range due {
+		d.fn(d.key, time.Unix(0, d.at))
+	}
+	return true
+}
+
+//ExpiredFunc is called with a key and the item removed from it because it expired.
+type ExpiredFunc func(key string, item Item)
+
+type expiredWatch struct {
+	pattern string
+	fn      ExpiredFunc
+}
+
+type expiredKey struct {
+	fn   ExpiredFunc
+	key  string
+	item Item
+}
+
+//NotifyExpired calls fn for every key matching pattern whose item is removed
+//because it expired, which happens when the janitor runs. Explicit deletes and
+//overwrites are not reported. fn is called without the lock held, on the janitor
+//goroutine, and must not block for long. The returned function cancels the notification.
+func (s *Storage) NotifyExpired(pattern string, fn ExpiredFunc) (cancel func()) {
+	s.lock("NotifyExpired")
+	s.watchID++
+	id := s.watchID
+	s.expiredWatches[id] = &expiredWatch{pattern: pattern, fn: fn}
+	s.mu.Unlock()
+
+	return func() {
+		s.lock("NotifyExpired")
+		delete(s.expiredWatches, id)
+		s.mu.Unlock()
+	}
+}
+
+//removeExpired removes the expired item of key and appends the notifications
+//due for it to due. Must be called with the write lock held.
+func (s *Storage) removeExpired(key string, item Item, due []expiredKey) []expiredKey {
+	fn := s.takeKeyEvent(key)
+	s.remove(key)
+	s.recordExpiration(key)
+	//sliding items are reported with the time they actually expired at
+	item.Expiration = item.expiresAt()
+	if s.stale != nil {
+		s.stale.remember(key, item, item.Expiration)
+	}
+	for _, w := range s.expiredWatches {
+		if MatchPattern(w.pattern, key) {
+			due = append(due, expiredKey{fn: w.fn, key: key, item: item})
+		}
+	}
+	if fn != nil {
+		due = append(due, expiredKey{fn: func(key string, item Item) { fn(key, item, EvictExpired) }, key: key, item: item})
+	}
+	return due
+}
+
+func notifyExpired(due []expiredKey) {
+	for _, d := range due {
+		d.item.Object = plain(d.item.Object)
+		d.fn(d.key, d.item)
+	}
+}
+
+//ChangeFunc is called with a key and its new item, or nil if it was removed.
+type ChangeFunc func(key string, item *Item)
+
+type changeWatch struct {
+	pattern string
+	fn      ChangeFunc
+}
+
+//NotifyChanged calls fn for every write, delete and expiry of a key matching
+//pattern. fn is called with the write lock held, so it must not use the storage
+//and must only hand the change off, e.g. to a buffered channel, dropping it
+//rather than blocking. The returned function cancels the notification.
+func (s *Storage) NotifyChanged(pattern string, fn ChangeFunc) (cancel func()) {
+	s.lock("NotifyChanged")
+	s.watchID++
+	id := s.watchID
+	s.changeWatches[id] = &changeWatch{pattern: pattern, fn: fn}
+	s.mu.Unlock()
+
+	return func() {
+		s.lock("NotifyChanged")
+		delete(s.changeWatches, id)
+		s.mu.Unlock()
+	}
+}
+
+//notifyChange is called by replace and remove with the write lock held.
+func (s *Storage) notifyChange(key string, item *Item) {
+	for _, w := range s.changeWatches {
+		if MatchPattern(w.pattern, key) {
+			w.fn(key, item)
+		}
+	}
+}
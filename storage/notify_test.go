@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStorage_NotifyExpiring(t *testing.T) {
+	interval := ExpiringCheckInterval
+	ExpiringCheckInterval = time.Millisecond
+	defer func() { ExpiringCheckInterval = interval }()
+
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("lease:a", "v", 20*time.Millisecond)
+	s.Set("lease:b", "v", time.Hour)
+	s.Set("other", "v", 20*time.Millisecond)
+
+	mu := sync.Mutex{}
+	notified := map[string]int{}
+	cancel := s.NotifyExpiring("lease:*", 50*time.Millisecond, func(key string, at time.Time) {
+		mu.Lock()
+		notified[key]++
+		mu.Unlock()
+	})
+	defer cancel()
+	cancelExact := s.NotifyExpiring("other", 50*time.Millisecond, func(key string, at time.Time) {
+		mu.Lock()
+		notified[key]++
+		mu.Unlock()
+	})
+
+	time.Sleep(30 * time.Millisecond)
+	cancelExact()
+	mu.Lock()
+	defer mu.Unlock()
+	if notified["lease:a"] != 1 {
+		t.Errorf("lease:a was notified %d times instead of once", notified["lease:a"])
+	}
+	if notified["lease:b"] != 0 {
+		t.Error("lease:b was notified although it expires much later")
+	}
+	if notified["other"] != 1 {
+		t.Errorf("other was notified %d times instead of once", notified["other"])
+	}
+}
+
+func TestStorage_NotifyExpired(t *testing.T) {
+	clock := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(clock)
+	s.Set("cache:a", "v", time.Minute)
+	s.Set("cache:deleted", "v", time.Minute)
+	s.Set("other", "v", time.Minute)
+
+	expired := map[string]interface{}{}
+	cancel := s.NotifyExpired("cache:*", func(key string, item Item) {
+		expired[key] = item.Object
+	})
+	s.Delete("cache:deleted")
+	clock.now = clock.now.Add(2 * time.Minute)
+	s.DeleteExpired()
+
+	if len(expired) != 1 || expired["cache:a"] != "v" {
+		t.Errorf("unexpected notifications: %v", expired)
+	}
+
+	cancel()
+	s.Set("cache:b", "v", time.Minute)
+	clock.now = clock.now.Add(2 * time.Minute)
+	s.DeleteExpired()
+	if _, ok := expired["cache:b"]; ok {
+		t.Error("cancelled notification was called")
+	}
+}
+
+func TestStorage_NotifyChanged(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	type change struct {
+		key     string
+		value   interface{}
+		deleted bool
+	}
+	var changes []change
+	cancel := s.NotifyChanged("sensor:*", func(key string, item *Item) {
+		if item == nil {
+			changes = append(changes, change{key: key, deleted: true})
+			return
+		}
+		changes = append(changes, change{key: key, value: item.Object})
+	})
+
+	s.Set("sensor:1", "20", NoExpiration)
+	s.Set("other", "x", NoExpiration)
+	if _, err := s.Incr("sensor:2", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	s.Delete("sensor:1")
+	s.Delete("sensor:3")
+	cancel()
+	s.Set("sensor:4", "x", NoExpiration)
+
+	want := []change{{"sensor:1", "20", false}, {"sensor:2", int64(1), false}, {"sensor:1", nil, true}}
+	if len(changes) != len(want) {
+		t.Fatalf("got changes %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d is %v, want %v", i, changes[i], want[i])
+		}
+	}
+}
+
+func TestStorage_NotifyChangedFlush(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("a", "1", NoExpiration)
+	s.Set("b", "2", NoExpiration)
+	deleted := map[string]bool{}
+	s.NotifyChanged("*", func(key string, item *Item) {
+		deleted[key] = item == nil
+	})
+	s.Flush(false)
+	if len(deleted) != 2 || !deleted["a"] || !deleted["b"] {
+		t.Errorf("flush was not notified: %v", deleted)
+	}
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+//ErrNotCoercible means a value can't be converted to the requested type.
+var ErrNotCoercible = errors.New("value can't be converted")
+
+//Coerce converts v to "int", "float", "bool" or "string". Strings are parsed,
+//numbers and bools are formatted, and floats become ints only if they are integral.
+func Coerce(v interface{}, as string) (interface{}, error) {
+	switch as {
+	case "int":
+		return toInt(v)
+	case "float":
+		return toFloat(v)
+	case "bool":
+		switch val := v.(type) {
+		case bool:
+			return val, nil
+		case string:
+			if b, err := strconv.ParseBool(val); err == nil {
+				return b, nil
+			}
+		}
+	case "string":
+		switch val := v.(type) {
+		case string:
+			return val, nil
+		case bool:
+			return strconv.FormatBool(val), nil
+		case float64:
+			return strconv.FormatFloat(val, 'f', -1, 64), nil
+		}
+		if n, err := toInt(v); err == nil {
+			return strconv.FormatInt(n, 10), nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %s", as)
+	}
+	return nil, fmt.Errorf("%w to %s: %T", ErrNotCoercible, as, v)
+}
+
+func toInt(v interface{}) (int64, error) {
+	switch val := v.(type) {
+	case int:
+		return int64(val), nil
+	case int64:
+		return val, nil
+	case int32:
+		return int64(val), nil
+	case uint64:
+		if val <= math.MaxInt64 {
+			return int64(val), nil
+		}
+	case float64:
+		if val == math.Trunc(val) && val >= math.MinInt64 && val < math.MaxInt64 {
+			return int64(val), nil
+		}
+	case string:
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("%w to int: %v", ErrNotCoercible, v)
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch val := v.(type) {
+	case float64:
+		return val, nil
+	case string:
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return f, nil
+		}
+	}
+	if n, err := toInt(v); err == nil {
+		return float64(n), nil
+	}
+	return 0, fmt.Errorf("%w to float: %v", ErrNotCoercible, v)
+}
+
+//Incr adds delta to the integer value of key and returns the result. A missing key
+//is created as initial+delta with the default expiration. An existing item keeps
+//its expiration and representation, so a value written as the string "5" stays a string.
+func (s *Storage) Incr(key string, delta, initial int64) (int64, error) {
+	return s.Guard(nil).Incr(key, delta, initial)
+}
+
+//Incr is Storage.Incr for the keys the guard accepts.
+func (g Guarded) Incr(key string, delta, initial int64) (int64, error) {
+	s := g.s
+	s.lock("Incr")
+	defer s.mu.Unlock()
+	if err := g.check(key); err != nil {
+		return 0, err
+	}
+
+	item, found := s.items[key]
+	if !found || s.expired(&item) {
+		n := initial + delta
+		v, err := s.checkWrite(key, n, ClassNormal)
+		if err != nil {
+			return 0, err
+		}
+		s.set(key, v, DefaultExpiration)
+		return n, nil
+	}
+
+	item.Object = plain(item.Object)
+	cur, err := toInt(item.Object)
+	if err != nil {
+		return 0, fmt.Errorf("item %s: %w", key, err)
+	}
+	n := cur + delta
+	if (delta > 0 && n < cur) || (delta < 0 && n > cur) {
+		return 0, fmt.Errorf("item %s: increment would overflow", key)
+	}
+	var v interface{} = n
+	if _, ok := item.Object.(string); ok {
+		v = strconv.FormatInt(n, 10)
+	}
+	if item.Object, err = s.checkWrite(key, v, item.Class); err != nil {
+		return 0, err
+	}
+	s.put(key, item)
+	return n, nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCoerce(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		as   string
+		want interface{}
+	}{
+		{"42", "int", int64(42)},
+		{float64(3), "int", int64(3)},
+		{"1.5", "float", 1.5},
+		{int64(2), "float", float64(2)},
+		{"true", "bool", true},
+		{false, "bool", false},
+		{int64(7), "string", "7"},
+		{2.5, "string", "2.5"},
+		{true, "string", "true"},
+	}
+	for _, tt := range tests {
+		got, err := Coerce(tt.v, tt.as)
+		if err != nil || got != tt.want {
+			t.Errorf("Coerce(%v, %s) = %v, %v; want %v", tt.v, tt.as, got, err, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		v  interface{}
+		as string
+	}{
+		{"abc", "int"},
+		{1.5, "int"},
+		{"yes?", "bool"},
+		{map[string]interface{}{}, "string"},
+	} {
+		if _, err := Coerce(tt.v, tt.as); !errors.Is(err, ErrNotCoercible) {
+			t.Errorf("Coerce(%v, %s) returned %v", tt.v, tt.as, err)
+		}
+	}
+	if _, err := Coerce("1", "complex"); err == nil || errors.Is(err, ErrNotCoercible) {
+		t.Errorf("unknown type returned %v", err)
+	}
+}
+
+func TestStorage_Incr(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	if n, err := s.Incr("new", 1, 10); err != nil || n != 11 {
+		t.Errorf("missing key: %d, %v", n, err)
+	}
+	if n, _ := s.Incr("new", -2, 10); n != 9 {
+		t.Errorf("existing key: %d", n)
+	}
+
+	s.Set("str", "5", time.Hour)
+	exp := s.Items()["str"].Expiration
+	if n, err := s.Incr("str", 1, 0); err != nil || n != 6 {
+		t.Errorf("string value: %d, %v", n, err)
+	}
+	if item := s.Items()["str"]; item.Object != "6" || item.Expiration != exp {
+		t.Errorf("representation or expiration changed: %+v", item)
+	}
+
+	s.Set("text", "abc", NoExpiration)
+	if _, err := s.Incr("text", 1, 0); !errors.Is(err, ErrNotCoercible) {
+		t.Errorf("non-numeric value: %v", err)
+	}
+	s.Set("max", int64(math.MaxInt64), NoExpiration)
+	if _, err := s.Incr("max", 1, 0); err == nil {
+		t.Error("overflow was not detected")
+	}
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const overflowExt = ".item"
+
+//overflow keeps the items evicted from full namespaces in files of dir, one
+//per item, until they expire. The files are encrypted with a key which only
+//lives in memory, since the overflow is a cache of this process.
+type overflow struct {
+	dir  string
+	aead cipher.AEAD
+	//keys holds the expiration of every spilled item
+	keys    map[string]int64
+	spilled uint64
+	fetched uint64
+	errors  uint64
+}
+
+//OverflowStats describe the overflow store; Errors counts items which couldn't
+//be written or read back.
+type OverflowStats struct {
+	Items   int    `json:"items"`
+	Spilled uint64 `json:"spilled"`
+	Fetched uint64 `json:"fetched"`
+	Errors  uint64 `json:"errors"`
+}
+
+//SetOverflow makes items evicted from full namespaces before they expire spill
+//to files in dir, and reads of their keys fetch them back into the namespace,
+//evicting another item if needed; writes and deletes of the keys drop them.
+//Files left in dir by an earlier run are removed. An empty dir disables it.
+func (s *Storage) SetOverflow(dir string) error {
+	var o *overflow
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		old, err := filepath.Glob(filepath.Join(dir, "*"+overflowExt))
+		if err != nil {
+			return err
+		}
+		for _, f := range old {
+			os.Remove(f)
+		}
+		key := make([]byte, dataKeySize)
+		if _, err = io.ReadFull(rand.Reader, key); err != nil {
+			return err
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return err
+		}
+		o = &overflow{dir: dir, aead: aead, keys: make(map[string]int64)}
+	}
+
+	s.lock("SetOverflow")
+	prev := s.overflow
+	s.overflow = o
+	s.mu.Unlock()
+	if prev != nil && (o == nil || prev.dir != o.dir) {
+		prev.clear()
+	}
+	return nil
+}
+
+//Overflow returns the stats of the overflow store, ok is false if it is disabled.
+func (s *Storage) Overflow() (OverflowStats, bool) {
+	s.rlock("Overflow")
+	defer s.mu.RUnlock()
+	o := s.overflow
+	if o == nil {
+		return OverflowStats{}, false
+	}
+	return OverflowStats{Items: len(o.keys), Spilled: o.spilled, Fetched: o.fetched, Errors: o.errors}, true
+}
+
+func (o *overflow) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(o.dir, hex.EncodeToString(sum[:])+overflowExt)
+}
+
+//spill writes the evicted item of key and reports whether it was kept.
+//Must be called with the write lock held.
+func (s *Storage) spill(key string, item Item) bool {
+	o := s.overflow
+	if o == nil {
+		return false
+	}
+	sealed, err := sealItem(o.aead, item, recordData(key, "", 0))
+	if err == nil {
+		err = os.WriteFile(o.path(key), sealed, 0600)
+	}
+	if err != nil {
+		o.errors++
+		return false
+	}
+	o.keys[key] = item.expiresAt()
+	o.spilled++
+	return true
+}
+
+//fetchOverflow moves the spilled item of key back into memory if there is room
+//for it, and returns it unless it expired.
+func (s *Storage) fetchOverflow(key string) (Item, bool) {
+	s.lock("fetchOverflow")
+	defer s.mu.Unlock()
+	//another read may have fetched it already
+	if item, found := s.items[key]; found {
+		return item, true
+	}
+	o := s.overflow
+	if !o.has(key) {
+		return Item{}, false
+	}
+	item, err := o.read(key)
+	if err != nil {
+		o.errors++
+		o.drop(key)
+		return Item{}, false
+	}
+	if item.expiredAt(s.now()) {
+		o.drop(key)
+		return Item{}, false
+	}
+	o.fetched++
+	//without room it stays spilled and is read from the file again next time
+	if s.makeRoom(key, item.Class) == nil {
+		o.drop(key)
+		s.replace(key, item)
+	}
+	return item, true
+}
+
+//has is nil-safe, so reads can check for a spilled item under the read lock.
+func (o *overflow) has(key string) bool {
+	if o == nil {
+		return false
+	}
+	_, ok := o.keys[key]
+	return ok
+}
+
+func (o *overflow) read(key string) (Item, error) {
+	sealed, err := os.ReadFile(o.path(key))
+	if err != nil {
+		return Item{}, err
+	}
+	return openItem(o.aead, sealed, recordData(key, "", 0))
+}
+
+func (o *overflow) drop(key string) {
+	delete(o.keys, key)
+	os.Remove(o.path(key))
+}
+
+//forgetOverflow is called by replace and remove, so a spilled item never
+//shadows a newer one or comes back after a delete.
+func (s *Storage) forgetOverflow(key string) {
+	if o := s.overflow; o != nil && len(o.keys) > 0 {
+		if _, ok := o.keys[key]; ok {
+			o.drop(key)
+		}
+	}
+}
+
+//expireOverflow drops the spilled items which expired by now. Must be called
+//with the write lock held.
+func (s *Storage) expireOverflow(now int64) {
+	o := s.overflow
+	if o == nil {
+		return
+	}
+	for key, at := range o.keys {
+		if at > 0 && at <= now {
+			o.drop(key)
+			if fn := s.takeKeyEvent(key); fn != nil {
+				go fn(key, Item{Expiration: at}, EvictExpired)
+			}
+		}
+	}
+}
+
+func (o *overflow) clear() {
+	for key := range o.keys {
+		o.drop(key)
+	}
+}
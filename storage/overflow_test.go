@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStorage_Overflow(t *testing.T) {
+	dir := t.TempDir()
+	c := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(c)
+	s.SetNamespaceOptions("cache", NamespaceOptions{MaxItems: 1, Evict: true})
+	if err := s.SetOverflow(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Write("cache:a", "A", WriteOptions{TTL: time.Hour})
+	s.Write("cache:b", "B", WriteOptions{TTL: time.Minute})
+	if _, found := s.items["cache:a"]; found {
+		t.Fatal("item was not evicted")
+	}
+	if st, _ := s.Overflow(); st.Items != 1 || st.Spilled != 1 {
+		t.Errorf("unexpected stats after eviction: %+v", st)
+	}
+
+	//reading a fetches it back and spills b in turn
+	if v, found := s.Get("cache:a"); !found || v != "A" {
+		t.Fatalf("spilled item was not fetched: %v, %v", v, found)
+	}
+	if _, found := s.items["cache:a"]; !found {
+		t.Error("fetched item was not moved back into memory")
+	}
+	if v, _, found := s.GetWithVersion("cache:b"); !found || v != "B" {
+		t.Errorf("second spilled item was not fetched: %v, %v", v, found)
+	}
+
+	//a write drops the spilled copy
+	s.Write("cache:a", "A2", WriteOptions{TTL: time.Hour})
+	if v, _ := s.Get("cache:a"); v != "A2" {
+		t.Errorf("spilled item shadowed a newer one: %v", v)
+	}
+	s.Delete("cache:a")
+	if _, found := s.Get("cache:a"); found {
+		t.Error("spilled item came back after a delete")
+	}
+
+	//b was spilled by the write of a; once it expires the janitor drops it
+	if st, _ := s.Overflow(); st.Items != 1 {
+		t.Errorf("expected b to be spilled, got %+v", st)
+	}
+	c.now = c.now.Add(2 * time.Minute)
+	s.DeleteExpired()
+	if st, _ := s.Overflow(); st.Items != 0 {
+		t.Errorf("expired item was kept: %+v", st)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("files were left behind: %d", len(files))
+	}
+}
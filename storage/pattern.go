@@ -0,0 +1,38 @@
+package storage
+
+//MatchPattern reports whether key matches a glob pattern where "*" matches
+//any sequence of bytes (including "/" and ":") and "?" matches a single byte.
+func MatchPattern(pattern, key string) bool {
+	//star and its match position for backtracking
+	star, match := -1, 0
+	p, k := 0, 0
+	for k < len(key) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == key[k]):
+			p++
+			k++
+		case p < len(pattern) && pattern[p] == '*':
+			star, match = p, k
+			p++
+		case star != -1:
+			match++
+			p, k = star+1, match
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+//isPattern reports whether pattern has wildcards.
+func isPattern(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '*' || pattern[i] == '?' {
+			return true
+		}
+	}
+	return false
+}
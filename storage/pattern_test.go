@@ -0,0 +1,27 @@
+package storage
+
+import "testing"
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		match        bool
+	}{
+		{"sessions:*", "sessions:42", true},
+		{"sessions:*", "sessions:", true},
+		{"sessions:*", "users:42", false},
+		{"*", "a/b:c", true},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{"user:?", "user:1", true},
+		{"user:?", "user:12", false},
+		{"exact", "exact", true},
+		{"exact", "exactly", false},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		if got := MatchPattern(tt.pattern, tt.key); got != tt.match {
+			t.Errorf("MatchPattern(%q, %q) = %v", tt.pattern, tt.key, got)
+		}
+	}
+}
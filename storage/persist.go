@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//SaveRule asks to save the storage once at least Changes writes happened and
+//After passed since the last save, like "save 300 10" in Redis.
+type SaveRule struct {
+	After   time.Duration
+	Changes uint64
+}
+
+//ParseSaveRule parses a rule in Redis format: "<seconds> <changes>".
+func ParseSaveRule(rule string) (SaveRule, error) {
+	fields := strings.Fields(rule)
+	if len(fields) != 2 {
+		return SaveRule{}, fmt.Errorf("save rule %q must be \"<seconds> <changes>\"", rule)
+	}
+	seconds, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return SaveRule{}, fmt.Errorf("save rule %q: invalid seconds", rule)
+	}
+	changes, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil || changes == 0 {
+		return SaveRule{}, fmt.Errorf("save rule %q: invalid changes", rule)
+	}
+	return SaveRule{After: time.Duration(seconds) * time.Second, Changes: changes}, nil
+}
+
+//Dirty returns the number of writes since the last successful SaveFile.
+func (s *Storage) Dirty() uint64 {
+	s.rlock("Dirty")
+	defer s.mu.RUnlock()
+	return s.dirty
+}
+
+//LastSave returns the time of the last successful SaveFile, or the creation
+//time of the storage if it was never saved.
+func (s *Storage) LastSave() time.Time {
+	s.rlock("LastSave")
+	defer s.mu.RUnlock()
+	return s.lastSave
+}
+
+//NeedsSave reports whether any of rules is satisfied.
+func (s *Storage) NeedsSave(rules []SaveRule) bool {
+	s.rlock("NeedsSave")
+	defer s.mu.RUnlock()
+	since := time.Since(s.lastSave)
+	for _, r := range rules {
+		if s.dirty >= r.Changes && since >= r.After {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSaveRule(t *testing.T) {
+	r, err := ParseSaveRule("300 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.After != 5*time.Minute || r.Changes != 10 {
+		t.Errorf("unexpected rule: %+v", r)
+	}
+	for _, bad := range []string{"", "300", "a 10", "300 0", "300 10 1"} {
+		if _, err = ParseSaveRule(bad); err == nil {
+			t.Errorf("rule %q was parsed", bad)
+		}
+	}
+}
+
+func TestStorage_Dirty(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("a", "1", DefaultExpiration)
+	s.Set("b", "2", DefaultExpiration)
+	s.Delete("a")
+	if d := s.Dirty(); d != 3 {
+		t.Errorf("dirty is %d instead of 3", d)
+	}
+
+	rules := []SaveRule{{After: 0, Changes: 3}}
+	if !s.NeedsSave(rules) {
+		t.Error("satisfied rule is not reported")
+	}
+	if s.NeedsSave([]SaveRule{{After: time.Hour, Changes: 1}}) {
+		t.Error("rule is reported before its time")
+	}
+
+	before := s.LastSave()
+	if err := s.SaveFile(filepath.Join(t.TempDir(), "db.dat")); err != nil {
+		t.Fatal(err)
+	}
+	if d := s.Dirty(); d != 0 {
+		t.Errorf("dirty is %d after save", d)
+	}
+	if !s.LastSave().After(before) {
+		t.Error("last save time was not updated")
+	}
+	if s.NeedsSave(rules) {
+		t.Error("rule is reported right after a save")
+	}
+
+	s.Set("c", "3", DefaultExpiration)
+	if err := s.SaveFile(filepath.Join(os.DevNull, "db.dat")); err == nil {
+		t.Fatal("save into an invalid path succeeded")
+	}
+	if d := s.Dirty(); d != 1 {
+		t.Errorf("dirty is %d after a failed save instead of 1", d)
+	}
+}
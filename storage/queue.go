@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//DefaultVisibilityTimeout is how long received messages stay invisible if
+//QReceive is given no timeout.
+const DefaultVisibilityTimeout = 30 * time.Second
+
+var ErrStaleReceipt = errors.New("message was received again since")
+
+//QueueMessage is a message of a queue. A received message is invisible until
+//VisibleAt, then it is delivered again unless it was acked.
+type QueueMessage struct {
+	ID        uint64 `json:"id"`
+	Body      string `json:"body"`
+	Receives  int    `json:"receives"`
+	VisibleAt int64  `json:"-"`
+}
+
+//Receipt identifies a delivery of the message; only the last one can ack it.
+func (m QueueMessage) Receipt() string {
+	return fmt.Sprintf("%d-%d", m.ID, m.Receives)
+}
+
+func parseReceipt(receipt string) (id uint64, receives int, err error) {
+	parts := strings.SplitN(receipt, "-", 2)
+	if len(parts) == 2 {
+		if id, err = strconv.ParseUint(parts[0], 10, 64); err == nil {
+			receives, err = strconv.Atoi(parts[1])
+		}
+	}
+	if len(parts) != 2 || err != nil {
+		return 0, 0, fmt.Errorf("invalid receipt %s", receipt)
+	}
+	return id, receives, nil
+}
+
+//Queue is a FIFO queue of messages. Pushes only append past the stored length
+//and every other change copies Messages, so a Queue returned by Get stays
+//consistent without locking.
+type Queue struct {
+	LastID   uint64
+	Messages []QueueMessage
+}
+
+//QueueStats count the messages of a queue waiting to be received and the ones
+//received but not acked yet.
+type QueueStats struct {
+	Visible  int `json:"visible"`
+	InFlight int `json:"in_flight"`
+}
+
+//getQueue returns the queue of key and its item, which is empty for a missing key.
+func (s *Storage) getQueue(key string) (Item, Queue, error) {
+	item, found := s.items[key]
+	if !found || s.expired(&item) {
+		return Item{}, Queue{}, nil
+	}
+	q, ok := item.Object.(Queue)
+	if !ok {
+		return Item{}, Queue{}, fmt.Errorf("item %s is not a queue", key)
+	}
+	return item, q, nil
+}
+
+//QPush appends a message to the queue, creating it if needed, and returns its
+//ID. An existing queue keeps its expiration and class.
+func (s *Storage) QPush(key, body string) (uint64, error) {
+	return s.Guard(nil).QPush(key, body)
+}
+
+//QPush is Storage.QPush for the keys the guard accepts.
+func (g Guarded) QPush(key, body string) (uint64, error) {
+	s := g.s
+	s.lock("QPush")
+	defer s.mu.Unlock()
+	if err := g.check(key); err != nil {
+		return 0, err
+	}
+
+	item, q, err := s.getQueue(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.makeRoom(key, ClassNormal); err != nil {
+		return 0, err
+	}
+	q.LastID++
+	q.Messages = append(q.Messages, QueueMessage{ID: q.LastID, Body: body})
+	item.Object = q
+	s.put(key, item)
+	return q.LastID, nil
+}
+
+//QReceive returns up to max (at least 1) visible messages, oldest first, and
+//makes them invisible for visibility (DefaultVisibilityTimeout if it is 0).
+//Messages which aren't acked by then are received again.
+func (s *Storage) QReceive(key string, max int, visibility time.Duration) ([]QueueMessage, error) {
+	return s.Guard(nil).QReceive(key, max, visibility)
+}
+
+//QReceive is Storage.QReceive for the keys the guard accepts.
+func (g Guarded) QReceive(key string, max int, visibility time.Duration) ([]QueueMessage, error) {
+	s := g.s
+	if max <= 0 {
+		max = 1
+	}
+	if visibility <= 0 {
+		visibility = DefaultVisibilityTimeout
+	}
+	s.lock("QReceive")
+	defer s.mu.Unlock()
+	if err := g.check(key); err != nil {
+		return nil, err
+	}
+
+	item, q, err := s.getQueue(key)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	res := make([]QueueMessage, 0)
+	var msgs []QueueMessage
+	for i, m := range q.Messages {
+		if len(res) == max {
+			break
+		}
+		if m.VisibleAt > now {
+			continue
+		}
+		if msgs == nil {
+			msgs = append([]QueueMessage(nil), q.Messages...)
+		}
+		m.Receives++
+		m.VisibleAt = now + int64(visibility)
+		msgs[i] = m
+		res = append(res, m)
+	}
+	if msgs != nil {
+		q.Messages = msgs
+		item.Object = q
+		s.put(key, item)
+	}
+	return res, nil
+}
+
+//QAck deletes the message of receipt. It fails with ErrNotFound if the message
+//was acked already and with ErrStaleReceipt if it was received again since.
+func (s *Storage) QAck(key, receipt string) error {
+	return s.Guard(nil).QAck(key, receipt)
+}
+
+//QAck is Storage.QAck for the keys the guard accepts.
+func (g Guarded) QAck(key, receipt string) error {
+	s := g.s
+	id, receives, err := parseReceipt(receipt)
+	if err != nil {
+		return err
+	}
+	s.lock("QAck")
+	defer s.mu.Unlock()
+	if err := g.check(key); err != nil {
+		return err
+	}
+
+	item, q, err := s.getQueue(key)
+	if err != nil {
+		return err
+	}
+	for i, m := range q.Messages {
+		if m.ID != id {
+			continue
+		}
+		if m.Receives != receives {
+			return ErrStaleReceipt
+		}
+		msgs := make([]QueueMessage, 0, len(q.Messages)-1)
+		q.Messages = append(append(msgs, q.Messages[:i]...), q.Messages[i+1:]...)
+		item.Object = q
+		s.put(key, item)
+		return nil
+	}
+	return ErrNotFound
+}
+
+//QStats returns how many messages of the queue are visible and in flight.
+func (s *Storage) QStats(key string) (QueueStats, error) {
+	s.rlock("QStats")
+	_, q, err := s.getQueue(key)
+	s.mu.RUnlock()
+
+	st := QueueStats{}
+	now := s.now()
+	for _, m := range q.Messages {
+		if m.VisibleAt > now {
+			st.InFlight++
+		} else {
+			st.Visible++
+		}
+	}
+	return st, err
+}
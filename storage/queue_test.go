@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestStorage_Queue(t *testing.T) {
+	c := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(c)
+	for _, body := range []string{"a", "b", "c"} {
+		if _, err := s.QPush("jobs", body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs, err := s.QReceive("jobs", 2, time.Minute)
+	if err != nil || len(msgs) != 2 || msgs[0].Body != "a" || msgs[1].Body != "b" {
+		t.Fatalf("unexpected messages: %+v, %v", msgs, err)
+	}
+	if st, _ := s.QStats("jobs"); st.Visible != 1 || st.InFlight != 2 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+	if err := s.QAck("jobs", msgs[0].Receipt()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.QAck("jobs", msgs[0].Receipt()); err != ErrNotFound {
+		t.Errorf("message was acked twice: %v", err)
+	}
+
+	//b wasn't acked in time, so it is received again before c
+	c.now = c.now.Add(2 * time.Minute)
+	again, _ := s.QReceive("jobs", 1, 0)
+	if len(again) != 1 || again[0].Body != "b" || again[0].Receives != 2 {
+		t.Fatalf("unacked message was not received again: %+v", again)
+	}
+	if err := s.QAck("jobs", msgs[1].Receipt()); err != ErrStaleReceipt {
+		t.Errorf("stale receipt acked the message: %v", err)
+	}
+
+	//in-flight messages survive a restart
+	buf := &bytes.Buffer{}
+	if err := s.Save(buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := New(DefaultExpiration, 0, 0)
+	loaded.SetClock(c)
+	if err := loaded.Load(buf); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := loaded.QStats("jobs"); st.Visible != 1 || st.InFlight != 1 {
+		t.Errorf("unexpected stats after load: %+v", st)
+	}
+	if err := loaded.QAck("jobs", again[0].Receipt()); err != nil {
+		t.Errorf("receipt was lost on load: %v", err)
+	}
+
+	s.Set("plain", "v", NoExpiration)
+	if _, err := s.QPush("plain", "x"); err == nil {
+		t.Error("message was pushed to a string")
+	}
+}
+
+func TestStorage_QueueKeepsItem(t *testing.T) {
+	c := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(c)
+	if _, err := s.Write("jobs", Queue{}, WriteOptions{TTL: time.Hour, Class: ClassCritical}); err != nil {
+		t.Fatal(err)
+	}
+	created, _ := s.GetItem("jobs")
+	check := func(op string) {
+		t.Helper()
+		item, _ := s.GetItem("jobs")
+		if item.Class != ClassCritical || !item.ExpiresAt().Equal(created.ExpiresAt()) {
+			t.Errorf("%s dropped the expiration or class: %+v", op, item)
+		}
+	}
+	s.QPush("jobs", "a")
+	check("QPush")
+	msgs, _ := s.QReceive("jobs", 1, time.Minute)
+	check("QReceive")
+	s.QAck("jobs", msgs[0].Receipt())
+	check("QAck")
+}
+
+func TestStorage_QueuePushAppends(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	for i := 0; i < 5; i++ {
+		s.QPush("jobs", "m")
+	}
+	v, _ := s.Get("jobs")
+	read := v.(Queue)
+	if len(read.Messages) == cap(read.Messages) {
+		t.Fatalf("no room to append to %d messages", len(read.Messages))
+	}
+	s.QPush("jobs", "n")
+	v, _ = s.Get("jobs")
+	if pushed := v.(Queue); &pushed.Messages[0] != &read.Messages[0] {
+		t.Error("push copied the messages")
+	}
+	//a queue read before stays as it was
+	s.QReceive("jobs", 6, time.Minute)
+	if len(read.Messages) != 5 || read.Messages[0].Receives != 0 {
+		t.Errorf("queue read before was changed: %+v", read.Messages)
+	}
+}
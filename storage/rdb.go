@@ -0,0 +1,345 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+//RDBStats describes the result of ImportRDB.
+type RDBStats struct {
+	Imported int `json:"imported"`
+	Expired  int `json:"expired"`
+	//Skipped counts keys of types other than string.
+	Skipped int `json:"skipped"`
+}
+
+const (
+	rdbOpSlotInfo     = 0xF4
+	rdbOpModuleAux    = 0xF7
+	rdbOpFunction     = 0xF5
+	rdbOpIdle         = 0xF8
+	rdbOpFreq         = 0xF9
+	rdbOpAux          = 0xFA
+	rdbOpResizeDB     = 0xFB
+	rdbOpExpireTimeMs = 0xFC
+	rdbOpExpireTime   = 0xFD
+	rdbOpSelectDB     = 0xFE
+	rdbOpEOF          = 0xFF
+
+	rdbTypeString = 0
+	rdbTypeList   = 1
+	rdbTypeSet    = 2
+	rdbTypeZSet   = 3
+	rdbTypeHash   = 4
+	rdbTypeZSet2  = 5
+	//types from 9 on are stored as a single encoded blob
+	rdbTypeZipmap         = 9
+	rdbTypeListZiplist    = 10
+	rdbTypeSetIntset      = 11
+	rdbTypeZSetZiplist    = 12
+	rdbTypeHashZiplist    = 13
+	rdbTypeListQuicklist  = 14
+	rdbTypeHashListpack   = 16
+	rdbTypeZSetListpack   = 17
+	rdbTypeListQuicklist2 = 18
+	rdbTypeSetListpack    = 20
+)
+
+type rdbReader struct {
+	r *bufio.Reader
+}
+
+func (rd *rdbReader) byte() (byte, error) {
+	return rd.r.ReadByte()
+}
+
+func (rd *rdbReader) bytes(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(rd.r, buf)
+	return buf, err
+}
+
+//length reads a length; special encodings of strings are reported with encoded = true.
+func (rd *rdbReader) length() (n uint64, encoded bool, err error) {
+	b, err := rd.byte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false, nil
+	case 1:
+		next, err := rd.byte()
+		return uint64(b&0x3F)<<8 | uint64(next), false, err
+	case 2:
+		switch b {
+		case 0x80:
+			buf, err := rd.bytes(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf, err := rd.bytes(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		}
+		return 0, false, fmt.Errorf("rdb: unknown length encoding %#x", b)
+	}
+	return uint64(b & 0x3F), true, nil
+}
+
+func (rd *rdbReader) string() (string, error) {
+	n, encoded, err := rd.length()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		buf, err := rd.bytes(int(n))
+		return string(buf), err
+	}
+
+	switch n {
+	case 0:
+		b, err := rd.byte()
+		return strconv.Itoa(int(int8(b))), err
+	case 1:
+		buf, err := rd.bytes(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf)))), nil
+	case 2:
+		buf, err := rd.bytes(4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf)))), nil
+	case 3:
+		clen, _, err := rd.length()
+		if err != nil {
+			return "", err
+		}
+		ulen, _, err := rd.length()
+		if err != nil {
+			return "", err
+		}
+		buf, err := rd.bytes(int(clen))
+		if err != nil {
+			return "", err
+		}
+		out, err := lzfDecompress(buf, int(ulen))
+		return string(out), err
+	}
+	return "", fmt.Errorf("rdb: unknown string encoding %d", n)
+}
+
+//skipStrings skips n strings.
+func (rd *rdbReader) skipStrings(n uint64) error {
+	for i := uint64(0); i < n; i++ {
+		if _, err := rd.string(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//skipValue skips a value of a type other than string.
+func (rd *rdbReader) skipValue(typ byte) error {
+	switch typ {
+	case rdbTypeZipmap, rdbTypeListZiplist, rdbTypeSetIntset, rdbTypeZSetZiplist,
+		rdbTypeHashZiplist, rdbTypeHashListpack, rdbTypeZSetListpack, rdbTypeSetListpack:
+		_, err := rd.string()
+		return err
+	}
+
+	n, _, err := rd.length()
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case rdbTypeList, rdbTypeSet, rdbTypeListQuicklist:
+		return rd.skipStrings(n)
+	case rdbTypeHash:
+		return rd.skipStrings(2 * n)
+	case rdbTypeZSet:
+		for i := uint64(0); i < n; i++ {
+			if _, err = rd.string(); err != nil {
+				return err
+			}
+			//scores are stored as strings prefixed with a one byte length
+			l, err := rd.byte()
+			if err != nil {
+				return err
+			}
+			if l < 253 {
+				if _, err = rd.bytes(int(l)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case rdbTypeZSet2:
+		for i := uint64(0); i < n; i++ {
+			if _, err = rd.string(); err != nil {
+				return err
+			}
+			if _, err = rd.bytes(8); err != nil {
+				return err
+			}
+		}
+		return nil
+	case rdbTypeListQuicklist2:
+		for i := uint64(0); i < n; i++ {
+			//container type followed by the node
+			if _, _, err = rd.length(); err != nil {
+				return err
+			}
+			if _, err = rd.string(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("rdb: unsupported value type %d", typ)
+}
+
+func lzfDecompress(in []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, errors.New("rdb: corrupt lzf data")
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errors.New("rdb: corrupt lzf data")
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errors.New("rdb: corrupt lzf data")
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errors.New("rdb: corrupt lzf data")
+		}
+		//the reference may overlap the bytes being written, so copy byte by byte
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != size {
+		return nil, fmt.Errorf("rdb: lzf data has %d bytes instead of %d", len(out), size)
+	}
+	return out, nil
+}
+
+//ImportRDB reads string keys with their TTLs from a Redis RDB dump. Keys of all
+//databases are merged; keys which are already expired and other types are skipped.
+func (s *Storage) ImportRDB(r io.Reader) (RDBStats, error) {
+	stats := RDBStats{}
+	rd := &rdbReader{r: bufio.NewReader(r)}
+
+	magic, err := rd.bytes(9)
+	if err != nil {
+		return stats, err
+	}
+	if string(magic[:5]) != "REDIS" {
+		return stats, errors.New("rdb: not a redis dump")
+	}
+
+	var exp int64
+	now := s.now()
+	for {
+		op, err := rd.byte()
+		if err != nil {
+			return stats, err
+		}
+
+		switch op {
+		case rdbOpEOF:
+			return stats, nil
+		case rdbOpAux:
+			err = rd.skipStrings(2)
+		case rdbOpSelectDB:
+			_, _, err = rd.length()
+		case rdbOpResizeDB:
+			if _, _, err = rd.length(); err == nil {
+				_, _, err = rd.length()
+			}
+		case rdbOpSlotInfo:
+			for i := 0; i < 3 && err == nil; i++ {
+				_, _, err = rd.length()
+			}
+		case rdbOpIdle:
+			_, _, err = rd.length()
+		case rdbOpFreq:
+			_, err = rd.byte()
+		case rdbOpModuleAux, rdbOpFunction:
+			err = fmt.Errorf("rdb: unsupported opcode %#x", op)
+		case rdbOpExpireTime:
+			var buf []byte
+			if buf, err = rd.bytes(4); err == nil {
+				exp = int64(binary.LittleEndian.Uint32(buf)) * int64(time.Second)
+			}
+		case rdbOpExpireTimeMs:
+			var buf []byte
+			if buf, err = rd.bytes(8); err == nil {
+				exp = int64(binary.LittleEndian.Uint64(buf)) * int64(time.Millisecond)
+			}
+		default:
+			err = s.importRDBKey(rd, op, exp, now, &stats)
+			exp = 0
+		}
+		if err != nil {
+			return stats, err
+		}
+	}
+}
+
+func (s *Storage) importRDBKey(rd *rdbReader, typ byte, exp, now int64, stats *RDBStats) error {
+	key, err := rd.string()
+	if err != nil {
+		return err
+	}
+	if typ != rdbTypeString {
+		stats.Skipped++
+		return rd.skipValue(typ)
+	}
+	value, err := rd.string()
+	if err != nil {
+		return err
+	}
+	if exp > 0 && exp <= now {
+		stats.Expired++
+		return nil
+	}
+
+	s.lock("importRDBKey")
+	s.put(key, Item{
+		Object:     value,
+		Expiration: exp,
+	})
+	s.mu.Unlock()
+	stats.Imported++
+	return nil
+}
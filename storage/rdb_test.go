@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func rdbString(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func TestStorage_ImportRDB(t *testing.T) {
+	buf := bytes.NewBufferString("REDIS0009")
+	buf.WriteByte(rdbOpAux)
+	buf.Write(rdbString("redis-ver"))
+	buf.Write(rdbString("6.2.0"))
+	buf.Write([]byte{rdbOpSelectDB, 0, rdbOpResizeDB, 4, 1})
+
+	buf.WriteByte(rdbTypeString)
+	buf.Write(rdbString("plain"))
+	buf.Write(rdbString("value"))
+
+	//integer encoded value
+	buf.WriteByte(rdbTypeString)
+	buf.Write(rdbString("int"))
+	buf.Write([]byte{0xC1, 0x39, 0x30})
+
+	//lzf compressed "aaaaaaaaaa"
+	buf.WriteByte(rdbTypeString)
+	buf.Write(rdbString("lzf"))
+	buf.Write([]byte{0xC3, 5, 10, 0x00, 'a', 0xE0, 0x00, 0x00})
+
+	exp := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	ms := make([]byte, 8)
+	binary.LittleEndian.PutUint64(ms, uint64(exp.UnixNano()/int64(time.Millisecond)))
+	buf.WriteByte(rdbOpExpireTimeMs)
+	buf.Write(ms)
+	buf.WriteByte(rdbTypeString)
+	buf.Write(rdbString("ttl"))
+	buf.Write(rdbString("x"))
+
+	binary.LittleEndian.PutUint64(ms, 1000)
+	buf.WriteByte(rdbOpExpireTimeMs)
+	buf.Write(ms)
+	buf.WriteByte(rdbTypeString)
+	buf.Write(rdbString("expired"))
+	buf.Write(rdbString("x"))
+
+	buf.WriteByte(rdbTypeHash)
+	buf.Write(rdbString("hash"))
+	buf.WriteByte(1)
+	buf.Write(rdbString("field"))
+	buf.Write(rdbString("value"))
+
+	buf.WriteByte(rdbOpEOF)
+	buf.Write(make([]byte, 8))
+
+	s := New(DefaultExpiration, 0, 0)
+	stats, err := s.ImportRDB(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (RDBStats{Imported: 4, Expired: 1, Skipped: 1}) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	want := map[string]string{"plain": "value", "int": "12345", "lzf": "aaaaaaaaaa", "ttl": "x"}
+	for k, v := range want {
+		if got, _ := s.Get(k); got != v {
+			t.Errorf("%s is %v, expected %s", k, got, v)
+		}
+	}
+	if item := s.Items()["ttl"]; item.Expiration != exp.UnixNano() {
+		t.Errorf("expiration was not imported: %d", item.Expiration)
+	}
+
+	if _, err = s.ImportRDB(bytes.NewBufferString("NOTREDIS0")); err == nil {
+		t.Error("non-rdb input was imported")
+	}
+}
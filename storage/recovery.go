@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+)
+
+//RecoveryMode defines what LoadFileRecover does with a corrupt file.
+type RecoveryMode int
+
+const (
+	//RecoverFail returns the error, so the server refuses to start.
+	RecoverFail RecoveryMode = iota
+	//RecoverEmpty ignores the file and continues without its items.
+	RecoverEmpty
+	//RecoverPartial keeps the items decoded before the corruption.
+	RecoverPartial
+)
+
+func ParseRecoveryMode(s string) (RecoveryMode, error) {
+	switch s {
+	case "", "fail":
+		return RecoverFail, nil
+	case "empty":
+		return RecoverEmpty, nil
+	case "partial":
+		return RecoverPartial, nil
+	}
+	return 0, fmt.Errorf("unknown recovery mode %s", s)
+}
+
+//Recovery describes how a db file was loaded.
+type Recovery struct {
+	File    string `json:"file"`
+	Corrupt bool   `json:"corrupt"`
+	Error   string `json:"error,omitempty"`
+	//Action is "loaded", "missing", "failed", "started empty", "loaded without
+	//deltas" or "partially recovered"
+	Action string `json:"action"`
+	Items  int    `json:"items"`
+	//Deltas is the number of delta files applied after the snapshot
+	Deltas int `json:"deltas,omitempty"`
+}
+
+//LoadFileRecover loads filename like LoadFile, handling a corrupt file according to mode.
+//A missing file is not an error. If the snapshot is intact but one of its deltas
+//isn't, RecoverEmpty keeps the snapshot without any delta and RecoverPartial
+//keeps the deltas before the corrupt one.
+func (s *Storage) LoadFileRecover(filename string, mode RecoveryMode) (Recovery, error) {
+	rec := Recovery{File: filename}
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		rec.Action = "missing"
+		return rec, nil
+	}
+	if err != nil {
+		rec.Action = "failed"
+		rec.Error = err.Error()
+		return rec, err
+	}
+	defer f.Close()
+
+	//unless recovering partially, nothing is merged if the file is corrupt
+	var generation string
+	rec.Items, generation, err = s.load(f, mode == RecoverPartial, nil)
+	if err == nil {
+		return s.recoverDeltas(rec, generation, mode)
+	}
+
+	rec.Corrupt = true
+	rec.Error = err.Error()
+	switch mode {
+	case RecoverEmpty:
+		rec.Action = "started empty"
+		return rec, nil
+	case RecoverPartial:
+		rec.Action = "partially recovered"
+		return rec, nil
+	}
+	rec.Action = "failed"
+	return rec, err
+}
+
+//recoverDeltas loads the deltas of the snapshot of rec, which is merged already.
+//If one of them is corrupt, the storage doesn't continue the generation of the
+//snapshot: deltas are only saved again after a full save removed the old ones.
+func (s *Storage) recoverDeltas(rec Recovery, generation string, mode RecoveryMode) (Recovery, error) {
+	var err error
+	if generation != "" {
+		rec.Deltas, err = s.loadDeltas(rec.File, generation, nil, mode == RecoverPartial)
+	}
+	if err == nil {
+		//the next delta continues the loaded ones
+		s.lock("LoadFileRecover")
+		s.generation, s.deltaSeq = generation, rec.Deltas
+		s.mu.Unlock()
+		rec.Action = "loaded"
+		return rec, nil
+	}
+
+	rec.Corrupt = true
+	rec.Error = err.Error()
+	switch mode {
+	case RecoverEmpty:
+		rec.Action = "loaded without deltas"
+		return rec, nil
+	case RecoverPartial:
+		rec.Action = "partially recovered"
+		return rec, nil
+	}
+	rec.Action = "failed"
+	return rec, err
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//truncatedSnapshot writes a snapshot of n items cut in the middle.
+func truncatedSnapshot(t *testing.T, n int) string {
+	src := New(DefaultExpiration, 0, 0)
+	for i := 0; i < n; i++ {
+		src.Set(fmt.Sprint("k", i), "value", NoExpiration)
+	}
+	buf := &bytes.Buffer{}
+	if err := src.Save(buf); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "db.dat")
+	if err := os.WriteFile(path, buf.Bytes()[:buf.Len()/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStorage_LoadFileRecover(t *testing.T) {
+	path := truncatedSnapshot(t, 100)
+
+	s := New(DefaultExpiration, 0, 0)
+	rec, err := s.LoadFileRecover(path, RecoverFail)
+	if err == nil || !rec.Corrupt || rec.Action != "failed" {
+		t.Errorf("corrupt file was not rejected: %+v, %v", rec, err)
+	}
+	if s.ItemCount() != 0 {
+		t.Error("items of a rejected file were loaded")
+	}
+
+	rec, err = s.LoadFileRecover(path, RecoverEmpty)
+	if err != nil || rec.Action != "started empty" || s.ItemCount() != 0 {
+		t.Errorf("unexpected recovery: %+v, %v, %d items", rec, err, s.ItemCount())
+	}
+
+	rec, err = s.LoadFileRecover(path, RecoverPartial)
+	if err != nil || rec.Action != "partially recovered" {
+		t.Errorf("unexpected recovery: %+v, %v", rec, err)
+	}
+	if n := s.ItemCount(); n == 0 || n >= 100 || n != rec.Items {
+		t.Errorf("%d items recovered, reported %d", n, rec.Items)
+	}
+
+	rec, err = s.LoadFileRecover(filepath.Join(t.TempDir(), "missing.dat"), RecoverFail)
+	if err != nil || rec.Action != "missing" {
+		t.Errorf("missing file: %+v, %v", rec, err)
+	}
+}
+
+func TestStorage_LoadFileRecoverCorruptDelta(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "db.dat")
+	src := New(DefaultExpiration, 0, 0)
+	src.EnableDeltas()
+	src.Set("a", "1", NoExpiration)
+	if err := src.SaveFile(file); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		src.Set(key, "2", NoExpiration)
+		if _, err := src.SaveDelta(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, _ := os.ReadFile(DeltaFileName(file, 2))
+	if err := os.WriteFile(DeltaFileName(file, 2), data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		mode   RecoveryMode
+		action string
+		deltas int
+		want   map[string]interface{}
+	}{
+		{RecoverFail, "failed", 0, nil},
+		{RecoverEmpty, "loaded without deltas", 0, map[string]interface{}{"a": "1", "b": nil, "c": nil}},
+		{RecoverPartial, "partially recovered", 1, map[string]interface{}{"a": "2", "b": nil, "c": nil}},
+	}
+	for _, c := range cases {
+		s := New(DefaultExpiration, 0, 0)
+		s.EnableDeltas()
+		rec, err := s.LoadFileRecover(file, c.mode)
+		if (err != nil) != (c.mode == RecoverFail) || !rec.Corrupt || rec.Action != c.action || rec.Deltas != c.deltas {
+			t.Errorf("mode %d: unexpected recovery %+v, %v", c.mode, rec, err)
+		}
+		for key, want := range c.want {
+			if v, _ := s.Get(key); v != want {
+				t.Errorf("mode %d: %s is %v instead of %v", c.mode, key, v, want)
+			}
+		}
+		//deltas would mix with the ones left of the snapshot
+		if _, err = s.SaveDelta(file); err != ErrNoBaseSnapshot {
+			t.Errorf("mode %d: delta was saved after a corrupt one: %v", c.mode, err)
+		}
+	}
+}
+
+func TestStorage_LoadLegacySnapshot(t *testing.T) {
+	items := map[string]Item{"a": {Object: "1", Version: 7}, "b": {Object: "2", Expiration: time.Now().Add(time.Hour).UnixNano()}}
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(&items); err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(DefaultExpiration, 0, 0)
+	if err := s.Load(buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, version, _ := s.GetWithVersion("a"); v != "1" || version != 7 {
+		t.Errorf("legacy item a was loaded as %v, %d", v, version)
+	}
+	if s.ItemCount() != 2 {
+		t.Errorf("%d items loaded from a legacy snapshot", s.ItemCount())
+	}
+	if _, version, _ := s.GetWithVersion("b"); version <= 7 {
+		t.Errorf("unversioned legacy item got version %d", version)
+	}
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+//ScheduleNamespace holds scheduled operations, so they are saved and loaded
+//with the other items and survive restarts.
+const ScheduleNamespace = "_schedule"
+
+//ScheduledOp is a write ("set") or a delete ("delete") of Key executed At.
+type ScheduledOp struct {
+	ID    string        `json:"id"`
+	At    time.Time     `json:"at"`
+	Op    string        `json:"op"`
+	Key   string        `json:"key"`
+	Value interface{}   `json:"value,omitempty"`
+	TTL   time.Duration `json:"ttl,omitempty"`
+}
+
+func scheduleKey(id string) string {
+	return ScheduleNamespace + NamespaceSeparator + id
+}
+
+//Schedule stores op to be executed by RunScheduled once op.At has passed
+//and returns it with its generated ID.
+func (s *Storage) Schedule(op ScheduledOp) (ScheduledOp, error) {
+	switch op.Op {
+	case "set":
+	case "delete":
+		op.Value, op.TTL = nil, 0
+	default:
+		return op, fmt.Errorf("unknown op %q", op.Op)
+	}
+	if op.Key == "" {
+		return op, errors.New("key is empty")
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return op, err
+	}
+	op.ID = hex.EncodeToString(b)
+
+	s.lock("Schedule")
+	s.put(scheduleKey(op.ID), Item{Object: op})
+	s.mu.Unlock()
+	return op, nil
+}
+
+//Scheduled returns the pending operations ordered by time.
+func (s *Storage) Scheduled() []ScheduledOp {
+	s.rlock("Scheduled")
+	ops := make([]ScheduledOp, 0, len(s.scheduled))
+	for key := range s.scheduled {
+		ops = append(ops, s.items[key].Object.(ScheduledOp))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].At.Before(ops[j].At)
+	})
+	return ops
+}
+
+//CancelScheduled removes a pending operation and reports whether it existed.
+func (s *Storage) CancelScheduled(id string) bool {
+	s.lock("CancelScheduled")
+	defer s.mu.Unlock()
+	if _, ok := s.scheduled[scheduleKey(id)]; !ok {
+		return false
+	}
+	return s.remove(scheduleKey(id))
+}
+
+//ScheduledRun is the outcome of an executed operation.
+type ScheduledRun struct {
+	Op  ScheduledOp
+	Err error
+}
+
+//SetScheduleGuard makes RunScheduled postpone the due operations guard fails
+//for, e.g. writes of locked keys, to its next run. The guard is called with
+//the write lock held.
+func (s *Storage) SetScheduleGuard(guard func(op ScheduledOp) error) {
+	s.lock("SetScheduleGuard")
+	s.scheduleGuard = guard
+	s.mu.Unlock()
+}
+
+//RunScheduled executes the operations which are due in order and removes them,
+//also if they fail, e.g. because the value doesn't match the schema anymore.
+//Operations postponed by the schedule guard are left out.
+func (s *Storage) RunScheduled() []ScheduledRun {
+	s.lock("RunScheduled")
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var due []ScheduledOp
+	for key, at := range s.scheduled {
+		if at > now {
+			continue
+		}
+		op := s.items[key].Object.(ScheduledOp)
+		if s.scheduleGuard == nil || s.scheduleGuard(op) == nil {
+			due = append(due, op)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].At.Before(due[j].At)
+	})
+
+	runs := make([]ScheduledRun, len(due))
+	for i, op := range due {
+		runs[i].Op = op
+		s.remove(scheduleKey(op.ID))
+		switch op.Op {
+		case "set":
+			var value interface{}
+			if value, runs[i].Err = s.checkWrite(op.Key, op.Value, ClassNormal); runs[i].Err == nil {
+				s.set(op.Key, value, op.TTL)
+			}
+		case "delete":
+			if s.remove(op.Key) {
+				s.recordAccess(op.Key, accessWrite)
+			}
+		}
+	}
+	return runs
+}
+
+//StartScheduler calls RunScheduled every interval and passes the runs to onRun
+//if it isn't nil. The returned function stops it.
+func (s *Storage) StartScheduler(interval time.Duration, onRun func(ScheduledRun)) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, run := range s.RunScheduled() {
+					if onRun != nil {
+						onRun(run)
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+//trackScheduled keeps s.scheduled, which maps keys of scheduled operations to
+//their time, up to date; it is called by replace and remove.
+func (s *Storage) trackScheduled(key string, item *Item) {
+	if Namespace(key) != ScheduleNamespace {
+		return
+	}
+	if item == nil {
+		delete(s.scheduled, key)
+		return
+	}
+	if op, ok := item.Object.(ScheduledOp); ok {
+		s.scheduled[key] = op.At.UnixNano()
+	}
+}
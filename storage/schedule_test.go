@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStorage_Schedule(t *testing.T) {
+	clock := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(clock)
+	s.Set("old", "v", NoExpiration)
+
+	s.Schedule(ScheduledOp{At: clock.now.Add(2 * time.Hour), Op: "set", Key: "new", Value: "v", TTL: NoExpiration})
+	s.Schedule(ScheduledOp{At: clock.now.Add(time.Hour), Op: "delete", Key: "old"})
+	cancelled, _ := s.Schedule(ScheduledOp{At: clock.now.Add(time.Hour), Op: "delete", Key: "new"})
+	if _, err := s.Schedule(ScheduledOp{Op: "incr", Key: "k"}); err == nil {
+		t.Error("unknown op was scheduled")
+	}
+	if !s.CancelScheduled(cancelled.ID) || s.CancelScheduled(cancelled.ID) {
+		t.Error("operation was not cancelled once")
+	}
+
+	if ops := s.Scheduled(); len(ops) != 2 || ops[0].Key != "old" {
+		t.Errorf("unexpected operations: %+v", ops)
+	}
+	if runs := s.RunScheduled(); len(runs) != 0 {
+		t.Errorf("operations ran early: %+v", runs)
+	}
+
+	clock.now = clock.now.Add(90 * time.Minute)
+	if runs := s.RunScheduled(); len(runs) != 1 || runs[0].Err != nil {
+		t.Errorf("unexpected runs: %+v", runs)
+	}
+	if _, found := s.Get("old"); found {
+		t.Error("old was not deleted")
+	}
+
+	//pending operations are saved with the items
+	buf := &bytes.Buffer{}
+	if err := s.Save(buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := New(DefaultExpiration, 0, 0)
+	loaded.SetClock(clock)
+	if err := loaded.Load(buf); err != nil {
+		t.Fatal(err)
+	}
+	clock.now = clock.now.Add(time.Hour)
+	if runs := loaded.RunScheduled(); len(runs) != 1 {
+		t.Errorf("loaded operation did not run: %+v", runs)
+	}
+	if v, _ := loaded.Get("new"); v != "v" {
+		t.Error("new was not set")
+	}
+	if len(loaded.Scheduled()) != 0 {
+		t.Error("executed operation was not removed")
+	}
+}
+
+func TestStorage_ScheduleGuard(t *testing.T) {
+	clock := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(clock)
+	locked := true
+	s.SetScheduleGuard(func(op ScheduledOp) error {
+		if locked && op.Key == "locked" {
+			return errors.New("locked")
+		}
+		return nil
+	})
+	s.Schedule(ScheduledOp{At: clock.now, Op: "set", Key: "locked", Value: "v", TTL: NoExpiration})
+	s.Schedule(ScheduledOp{At: clock.now, Op: "set", Key: "free", Value: "v", TTL: NoExpiration})
+
+	if runs := s.RunScheduled(); len(runs) != 1 || runs[0].Op.Key != "free" {
+		t.Errorf("unexpected runs: %+v", runs)
+	}
+	if ops := s.Scheduled(); len(ops) != 1 {
+		t.Errorf("postponed operation was removed: %+v", ops)
+	}
+	locked = false
+	if runs := s.RunScheduled(); len(runs) != 1 || runs[0].Op.Key != "locked" {
+		t.Errorf("postponed operation did not run: %+v", runs)
+	}
+}
@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+//Schema is the subset of JSON Schema supported for namespace validation.
+type Schema struct {
+	Type                 interface{}        `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+type ValidationError struct {
+	Key    string
+	Errors []string
+	//Hook is set if a write hook rejected the value rather than the schema
+	Hook bool
+}
+
+func (e *ValidationError) Error() string {
+	if e.Hook {
+		return fmt.Sprintf("item %s was rejected: %s", e.Key, strings.Join(e.Errors, "; "))
+	}
+	return fmt.Sprintf("item %s doesn't match schema: %s", e.Key, strings.Join(e.Errors, "; "))
+}
+
+//ParseSchema parses a JSON Schema document and compiles its patterns.
+func ParseSchema(raw []byte) (*Schema, error) {
+	sc := &Schema{}
+	if err := json.Unmarshal(raw, sc); err != nil {
+		return nil, err
+	}
+	if err := sc.compile(); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+func (sc *Schema) compile() error {
+	if sc == nil {
+		return nil
+	}
+	for _, t := range sc.types() {
+		switch t {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("unknown type %v", t)
+		}
+	}
+	if sc.Pattern != "" {
+		re, err := regexp.Compile(sc.Pattern)
+		if err != nil {
+			return err
+		}
+		sc.pattern = re
+	}
+	for _, p := range sc.Properties {
+		if err := p.compile(); err != nil {
+			return err
+		}
+	}
+	return sc.Items.compile()
+}
+
+func (sc *Schema) types() []string {
+	switch t := sc.Type.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		res := make([]string, 0, len(t))
+		for _, v := range t {
+			s, _ := v.(string)
+			res = append(res, s)
+		}
+		return res
+	}
+	return nil
+}
+
+func jsonType(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+//Validate checks a decoded JSON value and returns all violations found.
+func (sc *Schema) Validate(v interface{}) []string {
+	var errs []string
+	sc.validate("$", v, &errs)
+	return errs
+}
+
+func (sc *Schema) validate(path string, v interface{}, errs *[]string) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if types := sc.types(); len(types) > 0 {
+		actual := jsonType(v)
+		ok := false
+		for _, t := range types {
+			if t == actual || t == "number" && actual == "integer" {
+				ok = true
+			}
+		}
+		if !ok {
+			fail("expected %s, got %s", strings.Join(types, " or "), actual)
+			return
+		}
+	}
+
+	if len(sc.Enum) > 0 {
+		found := false
+		for _, e := range sc.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) && jsonType(e) == jsonType(v) {
+				found = true
+			}
+		}
+		if !found {
+			fail("value is not one of %v", sc.Enum)
+		}
+	}
+
+	switch val := v.(type) {
+	case float64:
+		if sc.Minimum != nil && val < *sc.Minimum {
+			fail("%v is less than minimum %v", val, *sc.Minimum)
+		}
+		if sc.Maximum != nil && val > *sc.Maximum {
+			fail("%v is greater than maximum %v", val, *sc.Maximum)
+		}
+	case string:
+		n := utf8.RuneCountInString(val)
+		if sc.MinLength != nil && n < *sc.MinLength {
+			fail("length %d is less than %d", n, *sc.MinLength)
+		}
+		if sc.MaxLength != nil && n > *sc.MaxLength {
+			fail("length %d is greater than %d", n, *sc.MaxLength)
+		}
+		if sc.pattern != nil && !sc.pattern.MatchString(val) {
+			fail("doesn't match pattern %s", sc.Pattern)
+		}
+	case []interface{}:
+		if sc.MinItems != nil && len(val) < *sc.MinItems {
+			fail("has %d items, less than %d", len(val), *sc.MinItems)
+		}
+		if sc.MaxItems != nil && len(val) > *sc.MaxItems {
+			fail("has %d items, more than %d", len(val), *sc.MaxItems)
+		}
+		if sc.Items != nil {
+			for i, item := range val {
+				sc.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, r := range sc.Required {
+			if _, ok := val[r]; !ok {
+				fail("missing required property %s", r)
+			}
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if p, ok := sc.Properties[k]; ok {
+				p.validate(path+"."+k, val[k], errs)
+			} else if sc.AdditionalProperties != nil && !*sc.AdditionalProperties {
+				fail("additional property %s is not allowed", k)
+			}
+		}
+	}
+}
+
+//SetSchema attaches a schema to namespace. Writes and JSON updates of its keys
+//are rejected if the value doesn't conform.
+//A nil schema removes it.
+func (s *Storage) SetSchema(namespace string, sc *Schema) {
+	s.lock("SetSchema")
+	defer s.mu.Unlock()
+	if sc == nil {
+		delete(s.schemas, namespace)
+		return
+	}
+	s.schemas[namespace] = sc
+}
+
+func (s *Storage) Schema(namespace string) (*Schema, bool) {
+	s.rlock("Schema")
+	defer s.mu.RUnlock()
+	sc, ok := s.schemas[namespace]
+	return sc, ok
+}
+
+//Validate checks value against the schema of key's namespace and that a new key
+//fits into the namespace's item limit.
+//Values in namespaces without a schema are always valid.
+func (s *Storage) Validate(key string, value interface{}) error {
+	s.rlock("Validate")
+	defer s.mu.RUnlock()
+	return s.validate(key, value)
+}
+
+func (s *Storage) validate(key string, value interface{}) error {
+	if err := s.checkNamespaceLimit(key); err != nil {
+		return err
+	}
+	return s.validateSchema(key, value)
+}
+
+func (s *Storage) validateSchema(key string, value interface{}) error {
+	sc, ok := s.schemas[Namespace(key)]
+	if !ok {
+		return nil
+	}
+	doc, err := decodeJSONObject(key, value)
+	if err != nil {
+		return &ValidationError{Key: key, Errors: []string{err.Error()}}
+	}
+	if errs := sc.Validate(doc); len(errs) > 0 {
+		return &ValidationError{Key: key, Errors: errs}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"testing"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["id", "status"],
+	"additionalProperties": false,
+	"properties": {
+		"id": {"type": "integer", "minimum": 1},
+		"status": {"enum": ["pending", "done"]},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string", "pattern": "^[a-z]+$"}}
+	}
+}`
+
+func TestSchema_Validate(t *testing.T) {
+	sc, err := ParseSchema([]byte(orderSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := sc.Validate(mustJSON(t, `{"id":1,"status":"done","tags":["a"]}`)); len(errs) != 0 {
+		t.Errorf("valid document rejected: %v", errs)
+	}
+
+	errs := sc.Validate(mustJSON(t, `{"id":0.5,"status":"new","tags":["A","b","c"],"x":1}`))
+	want := []string{
+		"$.id: expected integer, got number",
+		"$.status: value is not one of [pending done]",
+		"$.tags: has 3 items, more than 2",
+		"$.tags[0]: doesn't match pattern ^[a-z]+$",
+		"$: additional property x is not allowed",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	for i := range want {
+		if errs[i] != want[i] {
+			t.Errorf("error %d is %q, expected %q", i, errs[i], want[i])
+		}
+	}
+
+	if _, err = ParseSchema([]byte(`{"type":"decimal"}`)); err == nil {
+		t.Error("schema with unknown type was parsed")
+	}
+}
+
+func TestStorage_Validate(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	sc, _ := ParseSchema([]byte(orderSchema))
+	s.SetSchema("orders", sc)
+
+	if err := s.Validate("orders:1", `{"id":1,"status":"done"}`); err != nil {
+		t.Error(err)
+	}
+	if err := s.Validate("orders:1", `not json`); err == nil {
+		t.Error("non-json value passed validation")
+	}
+	if err := s.Validate("users:1", `not json`); err != nil {
+		t.Error("value in namespace without schema was validated")
+	}
+	if err := s.Add("orders:2", `{"id":2}`, DefaultExpiration); err == nil {
+		t.Error("invalid value was added")
+	}
+
+	s.Set("orders:3", `{"id":3,"status":"pending"}`, DefaultExpiration)
+	if err := s.JSONSet("orders:3", "$.status", "unknown"); err == nil {
+		t.Error("json update broke the schema")
+	}
+
+	s.SetSchema("orders", nil)
+	if err := s.Validate("orders:1", `not json`); err != nil {
+		t.Error("schema was not removed")
+	}
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var ErrSearchDisabled = errors.New("full-text search is disabled")
+
+type SearchResult struct {
+	Key   string  `json:"key"`
+	Score float64 `json:"score"`
+}
+
+//searchIndex is an inverted index from terms to the number of their occurrences per key.
+type searchIndex struct {
+	postings map[string]map[string]int
+	lengths  map[string]int
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+//searchTerms returns terms of string values; for JSON documents only string leaves are used.
+func searchTerms(v interface{}) []string {
+	var text string
+	switch val := v.(type) {
+	case string:
+		text = val
+	case []byte:
+		text = string(val)
+	default:
+		return nil
+	}
+
+	doc, err := decodeJSONObject("", v)
+	if err != nil {
+		return tokenize(text)
+	}
+	var terms []string
+	var walk func(interface{})
+	walk = func(v interface{}) {
+		switch val := v.(type) {
+		case string:
+			terms = append(terms, tokenize(val)...)
+		case []interface{}:
+			for _, e := range val {
+				walk(e)
+			}
+		case map[string]interface{}:
+			for _, e := range val {
+				walk(e)
+			}
+		}
+	}
+	walk(doc)
+	return terms
+}
+
+func (idx *searchIndex) add(key string, v interface{}) {
+	terms := searchTerms(v)
+	if len(terms) == 0 {
+		return
+	}
+	for _, t := range terms {
+		keys, ok := idx.postings[t]
+		if !ok {
+			keys = make(map[string]int)
+			idx.postings[t] = keys
+		}
+		keys[key]++
+	}
+	idx.lengths[key] = len(terms)
+}
+
+func (idx *searchIndex) remove(key string, v interface{}) {
+	if _, ok := idx.lengths[key]; !ok {
+		return
+	}
+	for _, t := range searchTerms(v) {
+		delete(idx.postings[t], key)
+		if len(idx.postings[t]) == 0 {
+			delete(idx.postings, t)
+		}
+	}
+	delete(idx.lengths, key)
+}
+
+//EnableSearch builds an inverted index over string and JSON values,
+//which is then maintained on every write.
+func (s *Storage) EnableSearch() {
+	s.lock("EnableSearch")
+	defer s.mu.Unlock()
+	if s.search != nil {
+		return
+	}
+	s.search = &searchIndex{
+		postings: make(map[string]map[string]int),
+		lengths:  make(map[string]int),
+	}
+	for k, v := range s.items {
+		s.search.add(k, plain(v.Object))
+	}
+}
+
+//Search returns keys containing any of the query terms ranked by tf-idf.
+//If limit > 0, at most limit results are returned.
+func (s *Storage) Search(query string, limit int) ([]SearchResult, error) {
+	s.rlock("Search")
+	defer s.mu.RUnlock()
+
+	if s.search == nil {
+		return nil, ErrSearchDisabled
+	}
+
+	scores := make(map[string]float64)
+	n := float64(len(s.search.lengths))
+	for _, t := range tokenize(query) {
+		keys := s.search.postings[t]
+		if len(keys) == 0 {
+			continue
+		}
+		idf := math.Log(1 + n/float64(len(keys)))
+		for k, tf := range keys {
+			scores[k] += float64(tf) / float64(s.search.lengths[k]) * idf
+		}
+	}
+
+	res := make([]SearchResult, 0, len(scores))
+	for k, score := range scores {
+		if item := s.items[k]; !s.expired(&item) {
+			res = append(res, SearchResult{Key: k, Score: score})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].Key < res[j].Key
+	})
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestStorage_Search(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("a", "the quick brown fox", DefaultExpiration)
+	s.Set("b", `{"title":"Brown bear","tags":["bear","forest"]}`, DefaultExpiration)
+
+	if _, err := s.Search("fox", 0); err != ErrSearchDisabled {
+		t.Errorf("search without index returned %v", err)
+	}
+	s.EnableSearch()
+	s.Set("c", "fox, fox and more fox", DefaultExpiration)
+	s.Set("d", 42, DefaultExpiration)
+
+	res, err := s.Search("FOX", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[0].Key != "c" || res[1].Key != "a" {
+		t.Errorf("unexpected results: %v", res)
+	}
+
+	if res, _ = s.Search("bear forest", 0); len(res) != 1 || res[0].Key != "b" {
+		t.Errorf("json values were not indexed: %v", res)
+	}
+	if res, _ = s.Search("brown", 1); len(res) != 1 {
+		t.Errorf("limit was not applied: %v", res)
+	}
+
+	s.Delete("c")
+	s.Set("a", "slow dog", DefaultExpiration)
+	if res, _ = s.Search("fox", 0); len(res) != 0 {
+		t.Errorf("index wasn't updated: %v", res)
+	}
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+//SessionNamespace holds the sessions, so namespace options like max_items apply to them.
+const SessionNamespace = "session"
+
+//DefaultSessionTTL is used for sessions created without a TTL.
+const DefaultSessionTTL = 30 * time.Minute
+
+//sessionTokenBytes is the entropy of session tokens.
+const sessionTokenBytes = 32
+
+//Session is the data stored with a session token.
+type Session struct {
+	Token     string      `json:"token"`
+	Data      interface{} `json:"data"`
+	ExpiresAt time.Time   `json:"expires_at"`
+	Sliding   bool        `json:"sliding"`
+}
+
+func sessionKey(token string) string {
+	return SessionNamespace + NamespaceSeparator + token
+}
+
+//CreateSession stores data under a new random token for ttl (DefaultSessionTTL
+//if it is 0). A sliding session expires ttl after it was last read.
+func (s *Storage) CreateSession(data interface{}, ttl time.Duration, sliding bool) (Session, error) {
+	if ttl == 0 {
+		ttl = DefaultSessionTTL
+	}
+	if ttl < 0 {
+		return Session{}, errors.New("session ttl must be positive")
+	}
+
+	for {
+		b := make([]byte, sessionTokenBytes)
+		if _, err := rand.Read(b); err != nil {
+			return Session{}, err
+		}
+		token := base64.RawURLEncoding.EncodeToString(b)
+
+		//IfAbsent makes sure a colliding token never replaces another session
+		_, err := s.Write(sessionKey(token), data, WriteOptions{TTL: ttl, Sliding: sliding, IfAbsent: true})
+		if errors.Is(err, ErrExists) {
+			continue
+		}
+		if err != nil {
+			return Session{}, err
+		}
+		return Session{
+			Token:     token,
+			Data:      data,
+			ExpiresAt: time.Unix(0, s.now()+int64(ttl)),
+			Sliding:   sliding,
+		}, nil
+	}
+}
+
+//Session returns the live session of token. With slide set, the expiration of
+//a sliding session is pushed forward; otherwise the session is left untouched.
+func (s *Storage) Session(token string, slide bool) (Session, bool) {
+	s.rlock("Session")
+	item, found := s.items[sessionKey(token)]
+	s.mu.RUnlock()
+
+	if !found {
+		return Session{}, false
+	}
+	if slide {
+		if !s.touch(&item) {
+			return Session{}, false
+		}
+	} else if s.expired(&item) {
+		return Session{}, false
+	}
+	return Session{
+		Token:     token,
+		Data:      plain(item.Object),
+		ExpiresAt: time.Unix(0, item.expiresAt()),
+		Sliding:   item.Sliding > 0,
+	}, true
+}
+
+//RevokeSession deletes the session of token and reports whether it existed.
+func (s *Storage) RevokeSession(token string) bool {
+	return s.Delete(sessionKey(token))
+}
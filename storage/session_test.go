@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStorage_Session(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	sess, err := s.CreateSession("alice", time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sess.Token) < 40 {
+		t.Errorf("token is too short: %s", sess.Token)
+	}
+	other, _ := s.CreateSession("bob", time.Hour, false)
+	if other.Token == sess.Token {
+		t.Error("tokens are not random")
+	}
+
+	got, ok := s.Session(sess.Token, true)
+	if !ok || got.Data != "alice" {
+		t.Errorf("session was not found: %+v", got)
+	}
+	if v, _ := s.Get(SessionNamespace + NamespaceSeparator + sess.Token); v != "alice" {
+		t.Error("session is not stored in its namespace")
+	}
+
+	if !s.RevokeSession(sess.Token) {
+		t.Error("session was not revoked")
+	}
+	if _, ok := s.Session(sess.Token, true); ok {
+		t.Error("revoked session is still valid")
+	}
+	if _, ok := s.Session("unknown", true); ok {
+		t.Error("unknown token is valid")
+	}
+}
+
+func TestStorage_SessionSliding(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	clock := &fixedClock{now: time.Now()}
+	s.SetClock(clock)
+
+	sess, _ := s.CreateSession("alice", time.Minute, true)
+	clock.now = clock.now.Add(40 * time.Second)
+	if _, ok := s.Session(sess.Token, false); !ok {
+		t.Fatal("session expired too early")
+	}
+	clock.now = clock.now.Add(40 * time.Second)
+	if _, ok := s.Session(sess.Token, false); ok {
+		t.Fatal("checking the session slid its expiration")
+	}
+
+	sess, _ = s.CreateSession("bob", time.Minute, true)
+	for i := 0; i < 3; i++ {
+		clock.now = clock.now.Add(40 * time.Second)
+		if _, ok := s.Session(sess.Token, true); !ok {
+			t.Fatalf("sliding session expired after %d reads", i)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sort"
+)
+
+//snapshotMagic starts snapshots written as a stream of records. Files without
+//it are legacy snapshots holding a single gob-encoded map of items.
+var snapshotMagic = []byte("KVSTORE\n")
+
+//snapshotHeader precedes the records; Items makes a truncated snapshot detectable.
+//KeyID and DataKey are only set in encrypted snapshots. Generation ties deltas
+//to the snapshot, it is empty in snapshots not written by SaveFile.
+type snapshotHeader struct {
+	Version    int
+	Items      int
+	KeyID      string
+	DataKey    []byte
+	Generation string
+}
+
+//Snapshot format versions:
+//  0 - a single gob-encoded map of items, written before formats were versioned;
+//      items of the oldest dumps have no version
+//  1 - snapshotMagic, snapshotHeader and a gob-encoded Record per item
+//  2 - like 1 with a sealedRecord per item, written when encryption is on
+//Every version ever written must stay readable: add a case to readSnapshot
+//and a migration to migrateSnapshot instead of changing an old one.
+const (
+	snapshotVersion       = 1
+	sealedSnapshotVersion = 2
+)
+
+//writeSnapshot encrypts items with a new data key if kek is not nil.
+func writeSnapshot(w io.Writer, m map[string]Item, kek *KEK, generation string) error {
+	if _, err := w.Write(snapshotMagic); err != nil {
+		return err
+	}
+	enc := gob.NewEncoder(w)
+	if kek != nil {
+		return writeSealedRecords(enc, m, kek, generation)
+	}
+	if err := enc.Encode(&snapshotHeader{Version: snapshotVersion, Items: len(m), Generation: generation}); err != nil {
+		return err
+	}
+	for k, v := range m {
+		gob.Register(v.Object)
+		if err := enc.Encode(&Record{Key: k, Item: v}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSealedRecords(enc *gob.Encoder, m map[string]Item, kek *KEK, generation string) error {
+	aead, dataKey, err := newDataKey(kek)
+	if err != nil {
+		return err
+	}
+	h := snapshotHeader{Version: sealedSnapshotVersion, Items: len(m), KeyID: kek.ID, DataKey: dataKey, Generation: generation}
+	if err = enc.Encode(&h); err != nil {
+		return err
+	}
+	for k, v := range m {
+		sealed, err := sealItem(aead, v, recordData(k, generation, 0))
+		if err != nil {
+			return err
+		}
+		if err = enc.Encode(&sealedRecord{Key: k, Item: sealed}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//readSnapshot decodes a snapshot of any version and returns its header, which
+//is empty for version 0. Encrypted snapshots are decrypted with one of keys.
+//On error the items decoded before it are returned too, which is what partial
+//recovery keeps.
+func readSnapshot(r io.Reader, keys []KEK) (map[string]Item, snapshotHeader, error) {
+	h := snapshotHeader{}
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(snapshotMagic))
+	if err != nil || !bytes.Equal(magic, snapshotMagic) {
+		items := map[string]Item{}
+		err = gob.NewDecoder(br).Decode(&items)
+		return items, h, err
+	}
+	br.Discard(len(snapshotMagic))
+
+	dec := gob.NewDecoder(br)
+	if err = dec.Decode(&h); err != nil {
+		return map[string]Item{}, snapshotHeader{}, err
+	}
+	switch h.Version {
+	case 1:
+		items, err := readRecords(dec, h.Items)
+		return items, h, err
+	case 2:
+		aead, err := openDataKey(keys, h.KeyID, h.DataKey)
+		if err != nil {
+			return map[string]Item{}, h, err
+		}
+		items, err := readSealedRecords(dec, aead, h.Items, h.Generation)
+		return items, h, err
+	}
+	return map[string]Item{}, h, fmt.Errorf("snapshot version %d is newer than supported %d", h.Version, sealedSnapshotVersion)
+}
+
+func readRecords(dec *gob.Decoder, n int) (map[string]Item, error) {
+	items := make(map[string]Item, n)
+	for i := 0; i < n; i++ {
+		rec := Record{}
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return items, fmt.Errorf("snapshot is corrupt after %d of %d items: %w", i, n, err)
+		}
+		items[rec.Key] = rec.Item
+	}
+	return items, nil
+}
+
+func readSealedRecords(dec *gob.Decoder, aead cipher.AEAD, n int, generation string) (map[string]Item, error) {
+	items := make(map[string]Item, n)
+	for i := 0; i < n; i++ {
+		rec := sealedRecord{}
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		item := Item{}
+		if err == nil {
+			item, err = openItem(aead, rec.Item, recordData(rec.Key, generation, 0))
+		}
+		if err != nil {
+			return items, fmt.Errorf("snapshot is corrupt after %d of %d items: %w", i, n, err)
+		}
+		items[rec.Key] = item
+	}
+	return items, nil
+}
+
+//migrateSnapshot brings items read from a snapshot of version up to date.
+//Must be called with the write lock held, since it may assign versions.
+func (s *Storage) migrateSnapshot(version int, items map[string]Item) {
+	if version < 1 {
+		//dumps written before items were versioned have all versions 0,
+		//which would make every item match a CAS for version 0
+		for k, v := range items {
+			if v.Version == 0 {
+				s.version++
+				v.Version = s.version
+				items[k] = v
+			}
+		}
+	}
+}
+
+//DumpInfo describes a snapshot file.
+type DumpInfo struct {
+	Version int      `json:"version"`
+	Items   int      `json:"items"`
+	Samples []string `json:"samples"`
+	Error   string   `json:"error,omitempty"`
+}
+
+//InspectSnapshot reads a snapshot without loading it and returns its format version,
+//the number of items and up to samples of their keys in sorted order.
+//A corrupt snapshot is described up to the corruption.
+func InspectSnapshot(r io.Reader, samples int) DumpInfo {
+	items, h, err := readSnapshot(r, nil)
+	info := DumpInfo{Version: h.Version, Items: len(items)}
+	if err != nil {
+		info.Error = err.Error()
+	}
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) > samples {
+		keys = keys[:samples]
+	}
+	info.Samples = keys
+	return info
+}
+
+//ReadSnapshot decodes a snapshot written by Save without loading it, for offline tools.
+//keys are needed to read an encrypted snapshot.
+func ReadSnapshot(r io.Reader, keys ...KEK) (map[string]Item, error) {
+	items, _, err := readSnapshot(r, keys)
+	return items, err
+}
+
+//WriteSnapshot writes items in the current snapshot format, so that Load can read them.
+func WriteSnapshot(w io.Writer, items map[string]Item) error {
+	return writeSnapshot(w, items, nil, "")
+}
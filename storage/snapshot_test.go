@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strings"
+	"testing"
+)
+
+func TestInspectSnapshot(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	for _, k := range []string{"c", "a", "b"} {
+		s.Set(k, "v", NoExpiration)
+	}
+	buf := &bytes.Buffer{}
+	if err := s.Save(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	info := InspectSnapshot(buf, 2)
+	if info.Version != snapshotVersion || info.Items != 3 || info.Error != "" {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if strings.Join(info.Samples, ",") != "a,b" {
+		t.Errorf("unexpected samples: %v", info.Samples)
+	}
+}
+
+func TestReadSnapshot_NewerVersion(t *testing.T) {
+	buf := bytes.NewBuffer(append([]byte{}, snapshotMagic...))
+	gob.NewEncoder(buf).Encode(&snapshotHeader{Version: sealedSnapshotVersion + 1})
+
+	s := New(DefaultExpiration, 0, 0)
+	err := s.Load(buf)
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("snapshot of a newer version was not rejected: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"container/list"
+	"fmt"
+	"time"
+)
+
+//DefaultStaleSize is how many expired values SetServeStale keeps by default.
+const DefaultStaleSize = 10000
+
+//StaleError is returned by GetOrLoad along with the last known item of a key
+//when the loader failed and the item expired at most the max staleness ago.
+type StaleError struct {
+	Err error
+	//Age is how long ago the item expired
+	Age time.Duration
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("value is stale by %v: %v", e.Age, e.Err)
+}
+
+func (e *StaleError) Unwrap() error {
+	return e.Err
+}
+
+//staleCache is an LRU of expired items, which are dropped once they expired
+//longer than maxStale ago.
+type staleCache struct {
+	maxStale time.Duration
+	size     int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type staleEntry struct {
+	key       string
+	item      Item
+	expiredAt int64
+}
+
+func newStaleCache(maxStale time.Duration, size int) *staleCache {
+	return &staleCache{
+		maxStale: maxStale,
+		size:     size,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *staleCache) remember(key string, item Item, expiredAt int64) {
+	if e, ok := c.entries[key]; ok {
+		e.Value = staleEntry{key, item, expiredAt}
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(staleEntry{key, item, expiredAt})
+	if c.order.Len() > c.size {
+		c.forget(c.order.Back().Value.(staleEntry).key)
+	}
+}
+
+func (c *staleCache) forget(key string) {
+	if e, ok := c.entries[key]; ok {
+		c.order.Remove(e)
+		delete(c.entries, key)
+	}
+}
+
+//get returns the item of key if it expired at most maxStale before now.
+func (c *staleCache) get(key string, now int64) (Item, time.Duration, bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		return Item{}, 0, false
+	}
+	entry := e.Value.(staleEntry)
+	age := time.Duration(now - entry.expiredAt)
+	if age > c.maxStale {
+		c.forget(key)
+		return Item{}, 0, false
+	}
+	c.order.MoveToFront(e)
+	return entry.item, age, true
+}
+
+//SetServeStale makes GetOrLoad return the last known item of a key together
+//with a StaleError when the loader fails, as long as the item expired at most
+//maxStale ago. Up to size expired items are kept for it after the janitor
+//removed them (0 means DefaultStaleSize). A maxStale <= 0 disables it.
+func (s *Storage) SetServeStale(maxStale time.Duration, size int) {
+	if size <= 0 {
+		size = DefaultStaleSize
+	}
+	s.lock("SetServeStale")
+	s.stale = nil
+	if maxStale > 0 {
+		s.stale = newStaleCache(maxStale, size)
+	}
+	s.mu.Unlock()
+}
+
+//staleItem returns the last known item of key if it may be served stale.
+//Must be called with the write lock held.
+func (s *Storage) staleItem(key string) (Item, time.Duration, bool) {
+	if s.stale == nil {
+		return Item{}, 0, false
+	}
+	now := s.now()
+	//the janitor may not have removed the item yet
+	if item, found := s.items[key]; found {
+		if age := time.Duration(now - item.expiresAt()); age <= s.stale.maxStale {
+			item.Object = plain(item.Object)
+			return item, age, true
+		}
+		return Item{}, 0, false
+	}
+	item, age, ok := s.stale.get(key, now)
+	item.Object = plain(item.Object)
+	return item, age, ok
+}
+
+//forgetStale is called by replace and remove, so neither newer values nor
+//deleted keys are served stale.
+func (s *Storage) forgetStale(key string) {
+	if s.stale != nil {
+		s.stale.forget(key)
+	}
+}
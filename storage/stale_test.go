@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStorage_ServeStale(t *testing.T) {
+	c := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(c)
+	//room for every expired key, the janitor remembers them in any order
+	s.SetServeStale(time.Minute, 3)
+	errDown := errors.New("upstream is down")
+	s.SetLoader(func(ctx context.Context, key string) (interface{}, error) {
+		if key == "gone" {
+			return nil, ErrNotFound
+		}
+		return nil, errDown
+	}, 0)
+
+	s.Set("a", "old a", time.Minute)
+	s.Set("b", "old b", time.Minute)
+	s.Set("gone", "old gone", time.Minute)
+	s.Set("deleted", "old deleted", time.Minute)
+	s.Delete("deleted")
+	c.now = c.now.Add(90 * time.Second)
+
+	//not removed by the janitor yet
+	item, err := s.GetOrLoad(context.Background(), "a")
+	var se *StaleError
+	if !errors.As(err, &se) || !errors.Is(err, errDown) || item.Object != "old a" {
+		t.Fatalf("expected a stale item, got %+v, %v", item, err)
+	}
+	if se.Age != 30*time.Second {
+		t.Errorf("expected an age of 30s, got %v", se.Age)
+	}
+
+	s.DeleteExpired()
+	if item, err = s.GetOrLoad(context.Background(), "b"); !errors.As(err, &se) || item.Object != "old b" {
+		t.Errorf("expected a stale item after the janitor ran, got %+v, %v", item, err)
+	}
+	if _, err = s.GetOrLoad(context.Background(), "gone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("key missing upstream returned %v", err)
+	}
+	if _, err = s.GetOrLoad(context.Background(), "deleted"); err != errDown {
+		t.Errorf("deleted key returned %v", err)
+	}
+
+	s.Set("b", "new b", time.Second)
+	c.now = c.now.Add(2 * time.Second)
+	if item, err = s.GetOrLoad(context.Background(), "b"); !errors.As(err, &se) || item.Object != "new b" {
+		t.Errorf("expected the newest value, got %+v, %v", item, err)
+	}
+
+	c.now = c.now.Add(time.Minute)
+	if _, err = s.GetOrLoad(context.Background(), "a"); err != errDown {
+		t.Errorf("item older than the max staleness returned %v", err)
+	}
+}
+
+func TestStaleCache_LRU(t *testing.T) {
+	c := newStaleCache(time.Hour, 2)
+	c.remember("a", Item{Object: "a"}, 0)
+	c.remember("b", Item{Object: "b"}, 0)
+	c.get("a", 0)
+	c.remember("c", Item{Object: "c"}, 0)
+	if _, _, ok := c.get("b", 0); ok {
+		t.Error("least recently used item wasn't evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if item, _, ok := c.get(key, 0); !ok || item.Object != key {
+			t.Errorf("%s: expected the item, got %+v", key, item)
+		}
+	}
+}
@@ -1,29 +1,75 @@
 package storage
 
 import (
-	"encoding/gob"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 //TODO:
 //    * добавить поддержку репликации
+//    * cluster mode: slot-based sharding, online slot migration on membership change
+//      with ASK/MOVED-style redirects and a /cluster/rebalance admin trigger
+//    * gossip membership (memberlist-style) for node discovery and failure detection,
+//      exposed via /cluster/members; depends on cluster mode
+//    * hinted handoff: buffer writes for a down replica (bounded by hint ttl and size)
+//      and replay them when it returns; depends on replication
+//    * anti-entropy repair: exchange Merkle digests of key ranges between replicas
+//      and sync only differing ranges; depends on replication
+//    * multi-master mode: hybrid logical clock timestamps on writes with
+//      last-write-wins conflict resolution; depends on replication
+//    * per-request read preference (leader, local replica, stale-ok with max staleness)
+//      in HTTP headers and the Go client; depends on replication
+//    * read-repair: compare a digest of the local version and expiration with the
+//      leader's on reads, repair diverged items in the background and count them
+//      in a divergence metric; depends on replication
 
 type Item struct {
 	Object     interface{}
 	Expiration int64
+	Version    uint64
+	//Sliding items expire Sliding nanoseconds after the last access.
+	//LastAccess is shared between copies of the item and updated atomically by Get.
+	Sliding    int64
+	LastAccess *int64 `json:"-"`
+	//ContentType is the media type the value was written with, if it was given
+	ContentType string `json:",omitempty"`
+	//Class decides when the item is evicted from a full namespace
+	Class Class `json:",omitempty"`
+	//Checksum of the stored value, 0 if it was written without, see SetItemChecksums
+	Checksum uint32 `json:",omitempty"`
 }
 
-func (item *Item) Expired() bool {
+func (item *Item) expiresAt() int64 {
+	if item.Sliding > 0 && item.LastAccess != nil {
+		return atomic.LoadInt64(item.LastAccess) + item.Sliding
+	}
+	return item.Expiration
+}
+
+func (item *Item) expiredAt(now int64) bool {
 	//Item never expires when its Expiration == 0
-	if item.Expiration == 0 {
-		return false
+	exp := item.expiresAt()
+	return exp > 0 && now > exp
+}
+
+func (item *Item) Expired() bool {
+	return item.expiredAt(time.Now().UnixNano())
+}
+
+//ExpiresAt returns when the item expires, or the zero time if it never does.
+func (item *Item) ExpiresAt() time.Time {
+	if exp := item.expiresAt(); exp > 0 {
+		return time.Unix(0, exp)
 	}
-	return time.Now().UnixNano() > item.Expiration
+	return time.Time{}
 }
 
 const (
@@ -31,57 +77,228 @@ const (
 	DefaultExpiration time.Duration = 0
 )
 
+//ctxCheckInterval is how many items scans go through between checks of their context.
+const ctxCheckInterval = 1024
+
+var (
+	ErrNotFound        = errors.New("no such key")
+	ErrVersionMismatch = errors.New("version mismatch")
+	ErrExists          = errors.New("key already exists")
+)
+
 type Storage struct {
 	filePath          string
 	defaultExpiration time.Duration
 	items             map[string]Item
+	schemas           map[string]*Schema
+	writeHooks        map[string][]WriteHook
+	scheduled         map[string]int64
+	scheduleGuard     func(ScheduledOp) error
+	indexes           map[string]map[string]*fieldIndex
+	search            *searchIndex
+	sliding           map[string]time.Duration
+	expiry            *expiryTracker
+	namespaces        map[string]*namespace
+	canonNamespaces   int
+	watches           map[uint64]*expiryWatch
+	expiredWatches    map[uint64]*expiredWatch
+	changeWatches     map[uint64]*changeWatch
+	keyEvents         map[string]KeyEventFunc
+	history           history
+	growth            growth
+	overflow          *overflow
+	compressThreshold int
+	checksums         bool
+	corruptions       uint64
+	nodeID            uint64
+	janitorStats      JanitorStats
+	onJanitorRun      func(JanitorRun)
+	evictions         map[string]uint64
+	watchID           uint64
+	watching          bool
+	//dirty counts writes since lastSave
+	dirty             uint64
+	lastSave          time.Time
+	capacity          int
+	peak              int
+	shrinkRatio       float64
+	rebuilds          int
+	clock             *coarseClock
+	source            Clock
+	snapshotMode      SnapshotMode
+	restoreMode       RestoreMode
+	loader            Loader
+	loadTTL           time.Duration
+	loads             map[string]*loadCall
+	stale             *staleCache
+	compaction        *compaction
+	keys              []KEK
+	version           uint64
+	changed           map[string]uint64
+	changeSeq         uint64
+	generation        string
+	deltaSeq          int
+	persistMu         sync.Mutex
 	mu                sync.RWMutex
+	lockWaits         lockWaits
+	keyspace          keyspace
+	access            accessLog
 	janitor           *janitor
+	//pausedUntil is accessed atomically, see PauseJanitor
+	pausedUntil int64
 }
 
-//If the duration is 0, default expiration time is used.
-//If it is -1, item never expires.
-func (s *Storage) Set(key string, value interface{}, duration time.Duration) {
-	if duration == DefaultExpiration {
-		duration = s.defaultExpiration
+//put stores item under a new version. Versions are unique across the whole storage,
+//so a key that was deleted and created again never gets its old version back.
+//Must be called with the write lock held.
+func (s *Storage) put(key string, item Item) uint64 {
+	s.version++
+	item.Version = s.version
+	s.replace(key, item)
+	s.recordAccess(key, accessWrite)
+	return item.Version
+}
+
+//replace stores item as is, keeping indexes up to date.
+//Every change of s.items must go through replace or remove.
+func (s *Storage) replace(key string, item Item) {
+	if old, found := s.items[key]; found {
+		s.unindex(key, old)
+	} else {
+		s.countNamespace(key, 1)
 	}
-	var exp int64
-	if duration > 0 {
-		exp = time.Now().Add(duration).UnixNano()
+	//indexes and watches get the value as it was written
+	item.Object = plain(item.Object)
+	s.items[key] = s.sum(s.compress(key, item))
+	if len(s.items) > s.peak {
+		s.peak = len(s.items)
+	}
+	s.index(key, item)
+	s.expiry.track(key, item)
+	s.trackScheduled(key, &item)
+	s.trackChange(key)
+	s.notifyChange(key, &item)
+	s.forgetStale(key)
+	s.forgetOverflow(key)
+	s.trackCompaction(key)
+	s.dirty++
+	s.compactExpiry()
+}
+
+func (s *Storage) remove(key string) bool {
+	old, found := s.items[key]
+	if found {
+		s.unindex(key, old)
+		delete(s.items, key)
+		s.expiry.untrack(key)
+		s.trackScheduled(key, nil)
+		s.trackChange(key)
+		s.notifyChange(key, nil)
+		s.forgetStale(key)
+		s.forgetOverflow(key)
+		s.forgetKeyEvent(key)
+		s.trackCompaction(key)
+		s.countNamespace(key, -1)
+		s.dirty++
 	}
+	return found
+}
+
+//If the duration is 0, default expiration time is used, or the sliding or
+//default expiration of the key's namespace if it has one.
+//If it is -1, item never expires.
+//Like Write, Set runs the write hooks of key's namespace and fails if the value
+//doesn't conform to its schema or the namespace is full.
+func (s *Storage) Set(key string, value interface{}, duration time.Duration) error {
+	s.lock("Set")
+	defer s.mu.Unlock()
+	value, err := s.checkWrite(key, value, ClassNormal)
+	if err != nil {
+		return err
+	}
+	s.set(key, value, duration)
+	return nil
+}
+
+//SetSliding stores value which expires after ttl without any Get.
+func (s *Storage) SetSliding(key string, value interface{}, ttl time.Duration) {
+	s.lock("SetSliding")
+	s.put(key, slidingItem(value, ttl, s.now()))
+	s.mu.Unlock()
+}
 
-	s.mu.Lock()
+//SetNamespaceSliding makes items of namespace written with DefaultExpiration sliding.
+//A ttl <= 0 disables it.
+func (s *Storage) SetNamespaceSliding(namespace string, ttl time.Duration) {
+	s.lock("SetNamespaceSliding")
+	defer s.mu.Unlock()
+	if ttl <= 0 {
+		delete(s.sliding, namespace)
+		return
+	}
+	s.sliding[namespace] = ttl
+}
 
-	s.items[key] = Item{
+func slidingItem(value interface{}, ttl time.Duration, now int64) Item {
+	return Item{
 		Object:     value,
-		Expiration: exp,
+		Expiration: now + int64(ttl),
+		Sliding:    int64(ttl),
+		LastAccess: &now,
+	}
+}
+
+//SetWithExpireAt stores value until the absolute time at. A zero time means no expiration.
+func (s *Storage) SetWithExpireAt(key string, value interface{}, at time.Time) {
+	var exp int64
+	if !at.IsZero() {
+		exp = at.UnixNano()
 	}
 
+	s.lock("SetWithExpireAt")
+	s.put(key, Item{
+		Object:     value,
+		Expiration: exp,
+	})
 	s.mu.Unlock()
 }
 
 func (s *Storage) set(key string, value interface{}, duration time.Duration) {
+	s.put(key, s.newItem(key, value, duration))
+}
+
+//newItem applies the expiration settings of the storage and the namespace of key.
+func (s *Storage) newItem(key string, value interface{}, duration time.Duration) Item {
 	if duration == DefaultExpiration {
+		if ttl, ok := s.sliding[Namespace(key)]; ok {
+			return slidingItem(value, ttl, s.now())
+		}
 		duration = s.defaultExpiration
+		if ns, ok := s.namespaces[Namespace(key)]; ok && ns.opts.DefaultExpiration != 0 {
+			duration = ns.opts.DefaultExpiration
+		}
 	}
 	var exp int64
 	if duration > 0 {
-		exp = time.Now().Add(duration).UnixNano()
+		exp = s.now() + int64(duration)
 	}
-
-	s.items[key] = Item{
+	return Item{
 		Object:     value,
 		Expiration: exp,
 	}
 }
 
 func (s *Storage) Add(key string, value interface{}, duration time.Duration) error {
-	s.mu.Lock()
+	s.lock("Add")
 	_, found := s.items[key]
 	if found {
 		s.mu.Unlock()
 		return fmt.Errorf("item %s already exists", key)
 	}
+	if err := s.validate(key, value); err != nil {
+		s.mu.Unlock()
+		return err
+	}
 
 	s.set(key, value, duration)
 	s.mu.Unlock()
@@ -89,64 +306,190 @@ func (s *Storage) Add(key string, value interface{}, duration time.Duration) err
 }
 
 func (s *Storage) Delete(key string) bool {
-	s.mu.Lock()
+	deleted, _ := s.Guard(nil).Delete(key)
+	return deleted
+}
+
+//Delete is Storage.Delete for the keys the guard accepts.
+func (g Guarded) Delete(key string) (bool, error) {
+	s := g.s
+	call := s.history.call()
+	s.lock("Delete")
 	defer s.mu.Unlock()
+	if err := g.check(key); err != nil {
+		return false, err
+	}
 
-	_, ok := s.items[key]
-	if ok {
-		delete(s.items, key)
+	deleted := s.remove(key)
+	if deleted {
+		s.recordAccess(key, accessWrite)
 	}
-	return ok
+	s.history.record(HistoryOp{Kind: HistoryDelete, Key: key, OK: deleted, Call: call})
+	s.maybeShrink()
+	return deleted, nil
 }
 
+//Get holds the read lock only for the map lookup: the item is a copy and
+//LastAccess of sliding items is updated atomically, so the rest needs no lock.
 func (s *Storage) Get(key string) (interface{}, bool) {
-	s.mu.RLock()
-
+	call := s.history.call()
+	s.rlock("Get")
 	item, found := s.items[key]
+	spilled := !found && s.overflow.has(key)
+	s.mu.RUnlock()
+	if spilled {
+		item, found = s.fetchOverflow(key)
+	}
+
+	found = found && s.touch(&item) && s.intact(&item)
+	s.recordGet(key, found)
+	s.history.read(call, key, &item, found, false)
 	if !found {
-		s.mu.RUnlock()
 		return nil, false
 	}
+	return plain(item.Object), true
+}
 
-	if item.Expiration > 0 && time.Now().UnixNano() > item.Expiration {
-		s.mu.RUnlock()
-		return nil, false
+func (s *Storage) GetWithVersion(key string) (interface{}, uint64, bool) {
+	call := s.history.call()
+	s.rlock("GetWithVersion")
+	item, found := s.items[key]
+	spilled := !found && s.overflow.has(key)
+	s.mu.RUnlock()
+	if spilled {
+		item, found = s.fetchOverflow(key)
 	}
 
+	found = found && s.touch(&item) && s.intact(&item)
+	s.recordGet(key, found)
+	s.history.read(call, key, &item, found, true)
+	if !found {
+		return nil, 0, false
+	}
+	return plain(item.Object), item.Version, true
+}
+
+//GetItem returns a copy of the item of key with its metadata.
+func (s *Storage) GetItem(key string) (Item, bool) {
+	call := s.history.call()
+	s.rlock("GetItem")
+	item, found := s.items[key]
+	spilled := !found && s.overflow.has(key)
 	s.mu.RUnlock()
-	return item.Object, true
+	if spilled {
+		item, found = s.fetchOverflow(key)
+	}
+
+	found = found && s.touch(&item) && s.intact(&item)
+	s.recordGet(key, found)
+	s.history.read(call, key, &item, found, true)
+	if !found {
+		return Item{}, false
+	}
+	item.Object = plain(item.Object)
+	return item, true
+}
+
+//GetMany looks up keys under a single read lock acquisition, so the items
+//found in memory are consistent with each other; items spilled to the overflow
+//are fetched afterwards. found[i] reports whether keys[i] was found.
+func (s *Storage) GetMany(keys []string) (items []Item, found []bool) {
+	items = make([]Item, len(keys))
+	found = make([]bool, len(keys))
+	var spilled []int
+	s.rlock("GetMany")
+	for i, key := range keys {
+		items[i], found[i] = s.items[key]
+		if !found[i] && s.overflow.has(key) {
+			spilled = append(spilled, i)
+		}
+	}
+	s.mu.RUnlock()
+	for _, i := range spilled {
+		items[i], found[i] = s.fetchOverflow(keys[i])
+	}
+
+	for i, key := range keys {
+		found[i] = found[i] && s.touch(&items[i]) && s.intact(&items[i])
+		s.recordGet(key, found[i])
+		if found[i] {
+			items[i].Object = plain(items[i].Object)
+		} else {
+			items[i] = Item{}
+		}
+	}
+	return items, found
+}
+
+//touch reports whether item is alive and refreshes sliding expiration.
+//Items without expiration don't read the clock at all.
+func (s *Storage) touch(item *Item) bool {
+	if item.Expiration == 0 && item.Sliding == 0 {
+		return true
+	}
+	now := s.now()
+	if item.expiredAt(now) {
+		return false
+	}
+	if item.Sliding > 0 && item.LastAccess != nil {
+		atomic.StoreInt64(item.LastAccess, now)
+	}
+	return true
 }
 
 func (s *Storage) Items() map[string]Item {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	s.rlock("Items")
+	m := s.liveItems(nil)
+	s.mu.RUnlock()
+	return plainItems(m)
+}
+
+//ItemsContext is Items which stops copying with the error of ctx once it is done.
+func (s *Storage) ItemsContext(ctx context.Context) (map[string]Item, error) {
+	s.rlock("Items")
+	m, err := s.liveItemsContext(ctx, nil)
+	s.mu.RUnlock()
+	return plainItems(m), err
+}
+
+//liveItems copies items selected by filter which are not expired. Must be called with the lock held.
+func (s *Storage) liveItems(filter KeyFilter) map[string]Item {
+	m, _ := s.liveItemsContext(context.Background(), filter)
+	return m
+}
+
+//liveItemsContext is liveItems which checks ctx every ctxCheckInterval items.
+func (s *Storage) liveItemsContext(ctx context.Context, filter KeyFilter) (map[string]Item, error) {
 	m := make(map[string]Item)
-	now := time.Now().UnixNano()
+	now := s.now()
+	n := 0
 	for k, v := range s.items {
-		if v.Expiration > 0 && now > v.Expiration {
+		n++
+		if n%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if v.expiredAt(now) || !filter.match(k) {
 			continue
 		}
+		if v.LastAccess != nil {
+			access := atomic.LoadInt64(v.LastAccess)
+			v.LastAccess = &access
+		}
 		m[k] = v
 	}
-	return m
+	return m, nil
 }
 
+//ItemCount includes expired items which haven't been deleted yet, see Counts.
 func (s *Storage) ItemCount() int {
-	s.mu.RLock()
+	s.rlock("ItemCount")
 	n := len(s.items)
 	s.mu.RUnlock()
 	return n
 }
 
 func (s *Storage) DeleteExpired() {
-	now := time.Now().UnixNano()
-	s.mu.Lock()
-	for k, v := range s.items {
-		if v.Expiration > 0 && now > v.Expiration {
-			delete(s.items, k)
-		}
-	}
-	s.mu.Unlock()
+	s.deleteExpired("", true, 0)
 }
 
 type janitor struct {
@@ -155,11 +498,18 @@ type janitor struct {
 }
 
 func (j *janitor) Run(s *Storage) {
+	j.run(s, s.DeleteExpired)
+}
+
+//run calls clean every interval unless the janitors of s are paused.
+func (j *janitor) run(s *Storage, clean func()) {
 	ticker := time.NewTicker(j.Interval)
 	for {
 		select {
 		case <-ticker.C:
-			s.DeleteExpired()
+			if !s.JanitorPaused() {
+				clean()
+			}
 		case <-j.stop:
 			ticker.Stop()
 			return
@@ -168,7 +518,17 @@ func (j *janitor) Run(s *Storage) {
 }
 
 func stopJanitor(s *Storage) {
-	s.janitor.stop <- true
+	if s.janitor != nil {
+		s.janitor.stop <- true
+	}
+	if s.clock != nil {
+		close(s.clock.stop)
+	}
+	for _, ns := range s.namespaces {
+		if ns.janitor != nil {
+			ns.janitor.stop <- true
+		}
+	}
 }
 
 func runJanitor(s *Storage, interval time.Duration) {
@@ -189,6 +549,20 @@ func newStorage(de time.Duration, m map[string]Item) *Storage {
 	s := &Storage{
 		defaultExpiration: de,
 		items:             m,
+		schemas:           make(map[string]*Schema),
+		writeHooks:        make(map[string][]WriteHook),
+		scheduled:         make(map[string]int64),
+		indexes:           make(map[string]map[string]*fieldIndex),
+		sliding:           make(map[string]time.Duration),
+		loads:             make(map[string]*loadCall),
+		expiry:            newExpiryTracker(),
+		namespaces:        make(map[string]*namespace),
+		watches:           make(map[uint64]*expiryWatch),
+		expiredWatches:    make(map[uint64]*expiredWatch),
+		changeWatches:     make(map[uint64]*changeWatch),
+		evictions:         make(map[string]uint64),
+		lockWaits:         lockWaits{ops: make(map[string]*LockWaitHistogram)},
+		lastSave:          time.Now(),
 	}
 
 	return s
@@ -205,57 +579,123 @@ func newsWithJanitor(de, ci time.Duration, m map[string]Item) *Storage {
 
 func New(defaultExpiration, cleanupInterval time.Duration, DBSize int) *Storage {
 	items := make(map[string]Item, DBSize)
-	return newsWithJanitor(defaultExpiration, cleanupInterval, items)
+	s := newsWithJanitor(defaultExpiration, cleanupInterval, items)
+	s.capacity = DBSize
+	return s
 }
 
+//Save writes a point-in-time snapshot of items. Whether writes wait for
+//the encoding to finish depends on the snapshot mode, see SetConsistency.
 func (s *Storage) Save(w io.Writer) error {
-	enc := gob.NewEncoder(w)
-	m := s.Items()
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	for _, v := range m {
-		gob.Register(v.Object)
-	}
-	err := enc.Encode(&m)
+	_, _, err := s.save(w, "")
 	return err
 }
 
+//save returns the number of writes and the last change included in the snapshot.
+func (s *Storage) save(w io.Writer, generation string) (uint64, uint64, error) {
+	s.rlock("save")
+	m := s.liveItems(nil)
+	dirty, seq := s.dirty, s.changeSeq
+	kek := s.currentKEK()
+	if s.snapshotMode == SnapshotBlock {
+		defer s.mu.RUnlock()
+	} else {
+		s.mu.RUnlock()
+	}
+	err := writeSnapshot(w, m, kek, generation)
+	return dirty, seq, err
+}
+
+//SaveFile replaces filename atomically, so a save which fails or is interrupted
+//leaves the previous snapshot intact. It starts a new generation of deltas.
 func (s *Storage) SaveFile(filename string) error {
-	f, err := os.Create(filename)
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+	generation, err := newGeneration()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
 	if err != nil {
 		return err
 	}
-	err = s.Save(f)
+	defer os.Remove(f.Name())
+	dirty, seq, err := s.save(f, generation)
 	if err != nil {
 		f.Close()
 		return err
 	}
-	return f.Close()
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), filename); err != nil {
+		return err
+	}
+
+	//writes which happened while encoding are still unsaved;
+	//a concurrent save may have already subtracted some of them
+	s.lock("SaveFile")
+	if s.dirty > dirty {
+		s.dirty -= dirty
+	} else {
+		s.dirty = 0
+	}
+	s.lastSave = time.Now()
+	s.generation, s.deltaSeq = generation, 0
+	s.saved(seq)
+	s.mu.Unlock()
+	//deltas of the previous generation are ignored by loads even if this fails
+	return removeDeltas(filename)
 }
 
+//Load merges items from a snapshot into the storage. All of them become visible
+//at once; whether old items are served while decoding depends on the restore mode,
+//see SetConsistency.
 func (s *Storage) Load(r io.Reader) error {
-	dec := gob.NewDecoder(r)
-	items := map[string]Item{}
-	err := dec.Decode(&items)
-	if err == nil {
-		s.mu.Lock()
+	_, _, err := s.load(r, false, nil)
+	return err
+}
+
+//load merges items selected by filter and returns their number and the generation
+//of the snapshot. With partial set, items decoded before an error are merged as well.
+func (s *Storage) load(r io.Reader, partial bool, filter KeyFilter) (int, string, error) {
+	s.rlock("load")
+	block := s.restoreMode == RestoreBlock
+	keys := s.keys
+	s.mu.RUnlock()
+	if block {
+		s.lock("load")
 		defer s.mu.Unlock()
-		for k, v := range items {
-			s.items[k] = v
+	}
+
+	items, h, err := readSnapshot(r, keys)
+	if err != nil && !partial {
+		return 0, "", err
+	}
+	if !block {
+		s.lock("load")
+		defer s.mu.Unlock()
+	}
+	for _, v := range items {
+		if v.Version > s.version {
+			s.version = v.Version
 		}
 	}
-	return err
+	s.migrateSnapshot(h.Version, items)
+	n := 0
+	for k, v := range items {
+		if filter.match(k) && s.intact(&v) {
+			s.replace(k, v)
+			n++
+		}
+	}
+	return n, h.Generation, err
 }
 
+//LoadFile merges the items of filename and of its deltas, see SaveDelta.
+//Deltas saved afterwards still continue the snapshot last saved or loaded with
+//LoadFileRecover.
 func (s *Storage) LoadFile(filename string) error {
-	f, err := os.Open(filename)
-	if err != nil {
-		return err
-	}
-	err = s.Load(f)
-	if err != nil {
-		f.Close()
-		return err
-	}
-	return f.Close()
+	_, err := s.LoadFileFilter(filename, nil)
+	return err
 }
@@ -35,6 +35,21 @@ func TestStorage_Delete(t *testing.T) {
 	}
 }
 
+func TestStorage_GetMany(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.Set("users:1", "alice", DefaultExpiration)
+	s.Set("orders:1", "book", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	items, found := s.GetMany([]string{"users:1", "orders:1", "users:2"})
+	if !found[0] || items[0].Object != "alice" || items[0].Version == 0 {
+		t.Errorf("users:1 was not found: %+v", items[0])
+	}
+	if found[1] || found[2] || items[1].Object != nil {
+		t.Errorf("expired or missing keys were found: %v %+v", found, items[1])
+	}
+}
+
 func TestStorage_ItemCount(t *testing.T) {
 	s := New(DefaultExpiration, 0, 0)
 
@@ -237,3 +252,112 @@ func BenchmarkStorage_DeleteExpired(b *testing.B) {
 		s.DeleteExpired()
 	}
 }
+
+func TestStorage_SetWithExpireAt(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.SetWithExpireAt("k1", "v1", time.Now().Add(5*time.Millisecond))
+	s.SetWithExpireAt("k2", "v2", time.Now().Add(-time.Second))
+	s.SetWithExpireAt("k3", "v3", time.Time{})
+
+	if _, found := s.Get("k1"); !found {
+		t.Error("k1 not found although not expired")
+	}
+	if _, found := s.Get("k2"); found {
+		t.Error("k2 found although its expiration time has passed")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, found := s.Get("k1"); found {
+		t.Error("k1 found after expiration time")
+	}
+	if _, found := s.Get("k3"); !found {
+		t.Error("k3 not found when it should never expire")
+	}
+}
+
+func TestStorage_SetSliding(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.SetSliding("session", "v", 20*time.Millisecond)
+	s.SetNamespaceSliding("ns", 20*time.Millisecond)
+	s.Set("ns:k", "v", DefaultExpiration)
+	s.Set("ns:fixed", "v", 20*time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if _, found := s.Get("session"); !found {
+			t.Fatal("session expired although it was accessed")
+		}
+		if _, found := s.Get("ns:k"); !found {
+			t.Fatal("ns:k expired although it was accessed")
+		}
+	}
+	if _, found := s.Get("ns:fixed"); found {
+		t.Error("item with explicit ttl became sliding")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, found := s.Get("session"); found {
+		t.Error("session didn't expire without access")
+	}
+	s.DeleteExpired()
+	if n := s.ItemCount(); n != 0 {
+		t.Errorf("expired sliding items were not deleted: %d", n)
+	}
+}
+
+func BenchmarkStorage_GetExpiringCoarseClock(b *testing.B) {
+	b.StopTimer()
+	s := New(5*time.Minute, 0, 0)
+	s.UseCoarseClock(time.Millisecond)
+	s.Set("key", "value", DefaultExpiration)
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		s.Get("key")
+	}
+}
+
+func TestStorage_UseCoarseClock(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.UseCoarseClock(time.Millisecond)
+	s.Set("k", "v", 5*time.Millisecond)
+
+	if _, found := s.Get("k"); !found {
+		t.Error("k not found although not expired")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, found := s.Get("k"); found {
+		t.Error("k found after expiration with coarse clock")
+	}
+}
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time {
+	return c.now
+}
+
+func TestStorage_SetClock(t *testing.T) {
+	c := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(c)
+	s.Set("k", "v", time.Hour)
+	s.SetSliding("sliding", "v", time.Hour)
+
+	c.now = c.now.Add(59 * time.Minute)
+	if _, found := s.Get("sliding"); !found {
+		t.Error("sliding item expired early")
+	}
+	c.now = c.now.Add(2 * time.Minute)
+	if _, found := s.Get("k"); found {
+		t.Error("k found after the clock passed its expiration")
+	}
+	if _, found := s.Get("sliding"); !found {
+		t.Error("sliding item expired although it was accessed")
+	}
+	s.DeleteExpired()
+	if n := s.ItemCount(); n != 1 {
+		t.Errorf("%d items left instead of 1", n)
+	}
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type StreamID struct {
+	Ms  int64
+	Seq int64
+}
+
+func (id StreamID) String() string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+func (id StreamID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || id.Ms == other.Ms && id.Seq < other.Seq
+}
+
+//ParseStreamID accepts "ms-seq" or just "ms" (seq is 0 then).
+func ParseStreamID(s string) (StreamID, error) {
+	var id StreamID
+	parts := strings.SplitN(s, "-", 2)
+	ms, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return id, fmt.Errorf("invalid stream id %s", s)
+	}
+	id.Ms = ms
+	if len(parts) == 2 {
+		if id.Seq, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return id, fmt.Errorf("invalid stream id %s", s)
+		}
+	}
+	return id, nil
+}
+
+type StreamEntry struct {
+	ID     StreamID          `json:"id"`
+	Fields map[string]string `json:"fields"`
+}
+
+//Stream is an append-only log. Entries are only appended past the stored length
+//or resliced on trim, so a Stream returned by Get stays consistent without locking.
+type Stream struct {
+	LastID  StreamID
+	Entries []StreamEntry
+}
+
+//getStream returns the stream of key and its item, which is empty for a missing key.
+func (s *Storage) getStream(key string) (Item, Stream, error) {
+	item, found := s.items[key]
+	if !found || s.expired(&item) {
+		return Item{}, Stream{}, nil
+	}
+	st, ok := item.Object.(Stream)
+	if !ok {
+		return Item{}, Stream{}, fmt.Errorf("item %s is not a stream", key)
+	}
+	return item, st, nil
+}
+
+//XAdd appends an entry to the stream, creating it if needed, and returns the generated ID.
+//An existing stream keeps its expiration and class.
+func (s *Storage) XAdd(key string, fields map[string]string) (StreamID, error) {
+	return s.Guard(nil).XAdd(key, fields)
+}
+
+//XAdd is Storage.XAdd for the keys the guard accepts.
+func (g Guarded) XAdd(key string, fields map[string]string) (StreamID, error) {
+	s := g.s
+	s.lock("XAdd")
+	defer s.mu.Unlock()
+	if err := g.check(key); err != nil {
+		return StreamID{}, err
+	}
+
+	item, st, err := s.getStream(key)
+	if err != nil {
+		return StreamID{}, err
+	}
+	if err := s.makeRoom(key, ClassNormal); err != nil {
+		return StreamID{}, err
+	}
+
+	id := StreamID{Ms: s.now() / int64(time.Millisecond)}
+	if !st.LastID.Less(id) {
+		id = StreamID{Ms: st.LastID.Ms, Seq: st.LastID.Seq + 1}
+	}
+	st.LastID = id
+	st.Entries = append(st.Entries, StreamEntry{ID: id, Fields: fields})
+
+	item.Object = st
+	s.put(key, item)
+	return id, nil
+}
+
+//XRange returns entries with start <= ID <= end. If count > 0, at most count entries are returned.
+func (s *Storage) XRange(key string, start, end StreamID, count int) ([]StreamEntry, error) {
+	s.rlock("XRange")
+	_, st, err := s.getStream(key)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]StreamEntry, 0)
+	for _, e := range st.Entries {
+		if e.ID.Less(start) {
+			continue
+		}
+		if end.Less(e.ID) {
+			break
+		}
+		res = append(res, e)
+		if count > 0 && len(res) == count {
+			break
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) XLen(key string) (int, error) {
+	s.rlock("XLen")
+	defer s.mu.RUnlock()
+	_, st, err := s.getStream(key)
+	return len(st.Entries), err
+}
+
+//XTrim removes the oldest entries so that at most maxLen remain (if maxLen > 0)
+//and none are older than maxAge (if maxAge > 0). It returns the number of removed entries.
+func (s *Storage) XTrim(key string, maxLen int, maxAge time.Duration) (int, error) {
+	return s.Guard(nil).XTrim(key, maxLen, maxAge)
+}
+
+//XTrim is Storage.XTrim for the keys the guard accepts.
+func (g Guarded) XTrim(key string, maxLen int, maxAge time.Duration) (int, error) {
+	s := g.s
+	s.lock("XTrim")
+	defer s.mu.Unlock()
+	if err := g.check(key); err != nil {
+		return 0, err
+	}
+
+	item, st, err := s.getStream(key)
+	if err != nil || len(st.Entries) == 0 {
+		return 0, err
+	}
+
+	n := 0
+	if maxLen > 0 && len(st.Entries) > maxLen {
+		n = len(st.Entries) - maxLen
+	}
+	if maxAge > 0 {
+		minMs := (s.now() - int64(maxAge)) / int64(time.Millisecond)
+		for n < len(st.Entries) && st.Entries[n].ID.Ms < minMs {
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	st.Entries = st.Entries[n:]
+	item.Object = st
+	s.put(key, item)
+	return n, nil
+}
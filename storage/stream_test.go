@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var streamEnd = StreamID{Ms: math.MaxInt64, Seq: math.MaxInt64}
+
+func TestStorage_XAdd(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	var prev StreamID
+	for i := 0; i < 100; i++ {
+		id, err := s.XAdd("log", map[string]string{"n": "v"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !prev.Less(id) {
+			t.Fatalf("id %s is not greater than %s", id, prev)
+		}
+		prev = id
+	}
+	if n, _ := s.XLen("log"); n != 100 {
+		t.Errorf("stream length is not 100: %d", n)
+	}
+
+	s.Set("str", "v", DefaultExpiration)
+	if _, err := s.XAdd("str", nil); err == nil {
+		t.Error("appended to a string item")
+	}
+}
+
+func TestStorage_XRange(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	ids := make([]StreamID, 5)
+	for i := range ids {
+		ids[i], _ = s.XAdd("log", nil)
+	}
+
+	entries, err := s.XRange("log", ids[1], ids[3], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].ID != ids[1] || entries[2].ID != ids[3] {
+		t.Errorf("unexpected range: %v", entries)
+	}
+
+	entries, _ = s.XRange("log", StreamID{}, streamEnd, 2)
+	if len(entries) != 2 || entries[0].ID != ids[0] {
+		t.Errorf("unexpected range with count: %v", entries)
+	}
+}
+
+func TestStorage_XTrim(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	for i := 0; i < 10; i++ {
+		s.XAdd("log", nil)
+	}
+	if n, _ := s.XTrim("log", 4, 0); n != 6 {
+		t.Errorf("trimmed %d entries instead of 6", n)
+	}
+	if n, _ := s.XLen("log"); n != 4 {
+		t.Errorf("stream length is not 4: %d", n)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	s.XAdd("log", nil)
+	if n, _ := s.XTrim("log", 0, 2*time.Millisecond); n != 4 {
+		t.Errorf("trimmed %d entries by age instead of 4", n)
+	}
+}
+
+func TestStorage_StreamKeepsItem(t *testing.T) {
+	c := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(c)
+	if _, err := s.Write("log", Stream{}, WriteOptions{TTL: time.Hour, Class: ClassCritical}); err != nil {
+		t.Fatal(err)
+	}
+	created, _ := s.GetItem("log")
+	check := func(op string) {
+		t.Helper()
+		item, _ := s.GetItem("log")
+		if item.Class != ClassCritical || !item.ExpiresAt().Equal(created.ExpiresAt()) {
+			t.Errorf("%s dropped the expiration or class: %+v", op, item)
+		}
+	}
+	s.XAdd("log", map[string]string{"f": "a"})
+	check("XAdd")
+	s.XAdd("log", map[string]string{"f": "b"})
+	if n, _ := s.XTrim("log", 1, 0); n != 1 {
+		t.Fatalf("trimmed %d entries instead of 1", n)
+	}
+	check("XTrim")
+}
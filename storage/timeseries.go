@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+//TimeSeries keeps samples ordered by time. Like Stream it is only appended to
+//or copied, so a value returned by Get can be read without locking.
+type TimeSeries struct {
+	Retention time.Duration
+	Samples   []Sample
+}
+
+const (
+	AggAvg   = "avg"
+	AggMin   = "min"
+	AggMax   = "max"
+	AggSum   = "sum"
+	AggCount = "count"
+)
+
+//getTimeSeries returns the series of key and its item, which is empty for a
+//missing key, and whether it exists.
+func (s *Storage) getTimeSeries(key string) (Item, TimeSeries, bool, error) {
+	item, found := s.items[key]
+	if !found || s.expired(&item) {
+		return Item{}, TimeSeries{}, false, nil
+	}
+	ts, ok := item.Object.(TimeSeries)
+	if !ok {
+		return Item{}, TimeSeries{}, false, fmt.Errorf("item %s is not a time series", key)
+	}
+	return item, ts, true, nil
+}
+
+//TSAdd adds a sample to the series, creating it if needed.
+//Retention is applied to new series and updated on existing ones when positive;
+//samples older than the newest sample minus retention are dropped. An existing
+//series keeps its expiration and class.
+func (s *Storage) TSAdd(key string, at time.Time, value float64, retention time.Duration) error {
+	return s.Guard(nil).TSAdd(key, at, value, retention)
+}
+
+//TSAdd is Storage.TSAdd for the keys the guard accepts.
+func (g Guarded) TSAdd(key string, at time.Time, value float64, retention time.Duration) error {
+	s := g.s
+	s.lock("TSAdd")
+	defer s.mu.Unlock()
+	if err := g.check(key); err != nil {
+		return err
+	}
+
+	item, ts, _, err := s.getTimeSeries(key)
+	if err != nil {
+		return err
+	}
+	if err := s.makeRoom(key, ClassNormal); err != nil {
+		return err
+	}
+	if retention > 0 {
+		ts.Retention = retention
+	}
+
+	sample := Sample{Time: at, Value: value}
+	n := len(ts.Samples)
+	if n == 0 || !at.Before(ts.Samples[n-1].Time) {
+		ts.Samples = append(ts.Samples, sample)
+	} else {
+		i := sort.Search(n, func(i int) bool { return at.Before(ts.Samples[i].Time) })
+		samples := make([]Sample, 0, n+1)
+		samples = append(samples, ts.Samples[:i]...)
+		samples = append(samples, sample)
+		ts.Samples = append(samples, ts.Samples[i:]...)
+	}
+
+	if ts.Retention > 0 {
+		min := ts.Samples[len(ts.Samples)-1].Time.Add(-ts.Retention)
+		i := sort.Search(len(ts.Samples), func(i int) bool { return !ts.Samples[i].Time.Before(min) })
+		ts.Samples = ts.Samples[i:]
+	}
+
+	item.Object = ts
+	s.put(key, item)
+	return nil
+}
+
+//TSRange returns samples within [from, to].
+func (s *Storage) TSRange(key string, from, to time.Time) ([]Sample, error) {
+	s.rlock("TSRange")
+	_, ts, found, err := s.getTimeSeries(key)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("item %s not found", key)
+	}
+
+	i := sort.Search(len(ts.Samples), func(i int) bool { return !ts.Samples[i].Time.Before(from) })
+	j := sort.Search(len(ts.Samples), func(i int) bool { return ts.Samples[i].Time.After(to) })
+	if i >= j {
+		return []Sample{}, nil
+	}
+	return ts.Samples[i:j], nil
+}
+
+//TSAggregate downsamples samples within [from, to] into buckets of the given interval
+//using one of the Agg* functions. Empty buckets are omitted.
+func (s *Storage) TSAggregate(key string, from, to time.Time, interval time.Duration, agg string) ([]Sample, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive")
+	}
+	switch agg {
+	case AggAvg, AggMin, AggMax, AggSum, AggCount:
+	default:
+		return nil, fmt.Errorf("unknown aggregation %s", agg)
+	}
+
+	samples, err := s.TSRange(key, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Sample, 0)
+	var count int
+	for i, smp := range samples {
+		start := smp.Time.Truncate(interval)
+		if i == 0 || !res[len(res)-1].Time.Equal(start) {
+			if i > 0 && agg == AggAvg {
+				res[len(res)-1].Value /= float64(count)
+			}
+			init := smp.Value
+			if agg == AggCount {
+				init = 0
+			}
+			res = append(res, Sample{Time: start, Value: init})
+			count = 0
+		}
+
+		last := &res[len(res)-1]
+		switch agg {
+		case AggAvg, AggSum:
+			if count > 0 {
+				last.Value += smp.Value
+			}
+		case AggMin:
+			last.Value = math.Min(last.Value, smp.Value)
+		case AggMax:
+			last.Value = math.Max(last.Value, smp.Value)
+		case AggCount:
+			last.Value++
+		}
+		count++
+	}
+	if len(res) > 0 && agg == AggAvg {
+		res[len(res)-1].Value /= float64(count)
+	}
+	return res, nil
+}
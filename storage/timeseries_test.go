@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStorage_TSAddRange(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.TSAdd("temp", base.Add(2*time.Second), 2, 0)
+	s.TSAdd("temp", base, 0, 0)
+	s.TSAdd("temp", base.Add(time.Second), 1, 0)
+
+	samples, err := s.TSRange("temp", base, base.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 || samples[0].Value != 0 || samples[1].Value != 1 {
+		t.Errorf("unexpected samples: %v", samples)
+	}
+
+	s.Set("str", "v", DefaultExpiration)
+	if err = s.TSAdd("str", base, 1, 0); err == nil {
+		t.Error("added a sample to a string item")
+	}
+}
+
+func TestStorage_TSRetention(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		s.TSAdd("temp", base.Add(time.Duration(i)*time.Minute), float64(i), 5*time.Minute)
+	}
+
+	samples, _ := s.TSRange("temp", base, base.Add(time.Hour))
+	if len(samples) != 6 || samples[0].Value != 4 {
+		t.Errorf("retention was not applied: %v", samples)
+	}
+}
+
+func TestStorage_TSAggregate(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		s.TSAdd("temp", base.Add(time.Duration(i)*20*time.Second), float64(i), 0)
+	}
+
+	cases := map[string][2]float64{
+		AggAvg:   {1, 4},
+		AggMin:   {0, 3},
+		AggMax:   {2, 5},
+		AggSum:   {3, 12},
+		AggCount: {3, 3},
+	}
+	for agg, want := range cases {
+		res, err := s.TSAggregate("temp", base, base.Add(time.Hour), time.Minute, agg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 2 || res[0].Value != want[0] || res[1].Value != want[1] {
+			t.Errorf("%s: unexpected result %v", agg, res)
+		}
+	}
+
+	if _, err := s.TSAggregate("temp", base, base, time.Minute, "median"); err == nil {
+		t.Error("unknown aggregation accepted")
+	}
+}
+
+func TestStorage_TSAddKeepsItem(t *testing.T) {
+	c := &fixedClock{now: time.Now()}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(c)
+	if _, err := s.Write("temp", TimeSeries{}, WriteOptions{TTL: time.Hour, Class: ClassCritical}); err != nil {
+		t.Fatal(err)
+	}
+	created, _ := s.GetItem("temp")
+	if err := s.TSAdd("temp", c.now, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	item, _ := s.GetItem("temp")
+	if item.Class != ClassCritical || !item.ExpiresAt().Equal(created.ExpiresAt()) {
+		t.Errorf("TSAdd dropped the expiration or class: %+v", item)
+	}
+}
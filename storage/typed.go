@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"encoding/gob"
+	"time"
+)
+
+//Typed is a view of Storage holding values of type T only.
+//It registers T with gob, so typed values can always be saved and loaded.
+type Typed[T any] struct {
+	s *Storage
+}
+
+func NewTyped[T any](s *Storage) *Typed[T] {
+	var zero T
+	if any(zero) != nil {
+		gob.Register(zero)
+	}
+	return &Typed[T]{s: s}
+}
+
+func (t *Typed[T]) Set(key string, value T, duration time.Duration) error {
+	return t.s.Set(key, value, duration)
+}
+
+func (t *Typed[T]) Add(key string, value T, duration time.Duration) error {
+	return t.s.Add(key, value, duration)
+}
+
+//Get returns the value stored at key. Values of other types are reported as missing.
+func (t *Typed[T]) Get(key string) (T, bool) {
+	v, found := t.s.Get(key)
+	if !found {
+		var zero T
+		return zero, false
+	}
+	val, ok := v.(T)
+	return val, ok
+}
+
+func (t *Typed[T]) Delete(key string) bool {
+	return t.s.Delete(key)
+}
+
+//Storage returns the underlying untyped storage.
+func (t *Typed[T]) Storage() *Storage {
+	return t.s
+}
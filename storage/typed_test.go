@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+type typedUser struct {
+	Name string
+	Age  int
+}
+
+func TestTyped(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	users := NewTyped[typedUser](s)
+	users.Set("u1", typedUser{"bob", 30}, DefaultExpiration)
+	s.Set("str", "v", DefaultExpiration)
+
+	u, found := users.Get("u1")
+	if !found || u.Name != "bob" {
+		t.Errorf("unexpected user: %v", u)
+	}
+	if _, found = users.Get("str"); found {
+		t.Error("value of another type was returned")
+	}
+	if err := users.Add("u1", typedUser{}, DefaultExpiration); err == nil {
+		t.Error("added existing key")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := s.Save(buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewTyped[typedUser](New(DefaultExpiration, 0, 0))
+	if err := loaded.Storage().Load(buf); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ = loaded.Get("u1"); u.Age != 30 {
+		t.Errorf("user was not restored: %v", u)
+	}
+}
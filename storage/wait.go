@@ -0,0 +1,35 @@
+package storage
+
+import "context"
+
+//WaitItem returns the item of key as soon as key exists and, if version isn't
+//0, holds another version than version. found is false if ctx is done first.
+func (s *Storage) WaitItem(ctx context.Context, key string, version uint64) (item Item, found bool) {
+	changed := make(chan struct{}, 1)
+	pattern := key
+	if isPattern(key) {
+		pattern = "*"
+	}
+	//registered before the first read, so no write is missed in between
+	cancel := s.NotifyChanged(pattern, func(k string, item *Item) {
+		if k != key || item == nil {
+			return
+		}
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	defer cancel()
+
+	for {
+		if item, found = s.GetItem(key); found && item.Version != version {
+			return item, true
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return Item{}, false
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStorage_WaitItem(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	//waits for the key to be written
+	done := make(chan Item)
+	go func() {
+		item, _ := s.WaitItem(ctx, "jobs:1?", 0)
+		done <- item
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Set("jobs:12", "other", NoExpiration)
+	s.Set("jobs:1?", "a", NoExpiration)
+	item := <-done
+	if item.Object != "a" {
+		t.Fatalf("unexpected item: %+v", item)
+	}
+
+	//returns the current item unless it has the given version
+	if got, found := s.WaitItem(ctx, "jobs:1?", 0); !found || got.Version != item.Version {
+		t.Errorf("existing item was not returned: %+v", got)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.Set("jobs:1?", "b", NoExpiration)
+	}()
+	if got, found := s.WaitItem(ctx, "jobs:1?", item.Version); !found || got.Object != "b" {
+		t.Errorf("changed item was not returned: %+v", got)
+	}
+
+	short, cancelShort := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancelShort()
+	if _, found := s.WaitItem(short, "missing", 0); found {
+		t.Error("missing key was found")
+	}
+}
@@ -0,0 +1,129 @@
+package storage
+
+import "time"
+
+//WriteOptions describe how Write stores a value.
+type WriteOptions struct {
+	//TTL works like the duration of Set; it is ignored if ExpiresAt is set
+	TTL       time.Duration
+	ExpiresAt time.Time
+	//Sliding makes TTL count from the last read
+	Sliding bool
+	//ContentType is kept with the value and returned by GetItem
+	ContentType string
+	//Class decides which items are evicted first from a full namespace
+	Class Class
+	//IfAbsent fails the write with ErrExists if key holds a live item,
+	//IfExists fails it with ErrNotFound if it doesn't, and a non-zero IfVersion
+	//fails it with ErrVersionMismatch unless key holds an item of that version
+	IfAbsent  bool
+	IfExists  bool
+	IfVersion uint64
+	//Notify is registered with NotifyKey if the write succeeds
+	Notify KeyEventFunc
+	//Guard fails the write with its error if it rejects key, see Guarded
+	Guard KeyGuard
+}
+
+//Write runs the write hooks of key's namespace, validates and stores value as
+//described by opts under a single lock acquisition and returns the new version of key.
+func (s *Storage) Write(key string, value interface{}, opts WriteOptions) (version uint64, err error) {
+	call := s.history.call()
+	s.lock("Write")
+	defer s.mu.Unlock()
+	if call != 0 {
+		//recorded with the value the write hooks returned
+		defer func() { s.history.write(call, key, value, opts, version, err) }()
+	}
+
+	if err := s.Guard(opts.Guard).check(key); err != nil {
+		return 0, err
+	}
+	cur, found := s.items[key]
+	found = found && !s.expired(&cur)
+	switch {
+	case opts.IfAbsent && found:
+		return 0, ErrExists
+	case opts.IfExists && !found:
+		return 0, ErrNotFound
+	case opts.IfVersion != 0 && (!found || cur.Version != opts.IfVersion):
+		return 0, ErrVersionMismatch
+	}
+	value, err = s.checkWrite(key, value, opts.Class)
+	if err != nil {
+		return 0, err
+	}
+	var item Item
+	switch {
+	case opts.Sliding:
+		item = slidingItem(value, opts.TTL, s.now())
+	case !opts.ExpiresAt.IsZero():
+		item = Item{Object: value, Expiration: opts.ExpiresAt.UnixNano()}
+	default:
+		item = s.newItem(key, value, opts.TTL)
+	}
+	item.ContentType = opts.ContentType
+	item.Class = opts.Class
+	if opts.Notify != nil {
+		s.notifyKey(key, opts.Notify)
+	}
+	return s.put(key, item), nil
+}
+
+//checkWrite returns value as transformed by the write hooks of key's namespace,
+//after checking it against the schema and making room for key if it is new.
+//Must be called with the write lock held.
+func (s *Storage) checkWrite(key string, value interface{}, class Class) (interface{}, error) {
+	value, err := s.applyWriteHooks(key, value)
+	if err != nil {
+		return nil, err
+	}
+	//the schema is checked first, so nothing is evicted for an invalid value
+	if err := s.validateSchema(key, value); err != nil {
+		return nil, err
+	}
+	if err := s.makeRoom(key, class); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+//DeleteGuard selects Key for DeleteVersions if it still holds Version.
+type DeleteGuard struct {
+	Key     string
+	Version uint64
+}
+
+//DeleteVersions deletes the keys of guards whose items still have the guarded
+//version, under a single lock acquisition. The error of each guard is nil if
+//its key was deleted, ErrNotFound or ErrVersionMismatch.
+func (s *Storage) DeleteVersions(guards []DeleteGuard) []error {
+	return s.Guard(nil).DeleteVersions(guards)
+}
+
+//DeleteVersions is Storage.DeleteVersions for the keys the guard accepts; the
+//error of a rejected key is the one of the guard.
+func (g Guarded) DeleteVersions(guards []DeleteGuard) []error {
+	s := g.s
+	s.lock("DeleteVersions")
+	defer s.mu.Unlock()
+
+	errs := make([]error, len(guards))
+	for i, dg := range guards {
+		if errs[i] = g.check(dg.Key); errs[i] != nil {
+			continue
+		}
+		cur, found := s.items[dg.Key]
+		switch {
+		case !found || s.expired(&cur):
+			errs[i] = ErrNotFound
+		case cur.Version != dg.Version:
+			errs[i] = ErrVersionMismatch
+		default:
+			s.remove(dg.Key)
+			s.recordAccess(dg.Key, accessWrite)
+		}
+	}
+	s.maybeShrink()
+	return errs
+}
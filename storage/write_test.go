@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStorage_Write(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	version, err := s.Write("a", `{"x":1}`, WriteOptions{TTL: time.Hour, ContentType: "application/json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, found := s.GetItem("a")
+	if !found || item.Version != version || item.ContentType != "application/json" || item.Expiration == 0 {
+		t.Errorf("unexpected item: %+v", item)
+	}
+
+	at := time.Now().Add(time.Minute)
+	s.Write("b", "v", WriteOptions{ExpiresAt: at})
+	if item, _ = s.GetItem("b"); item.Expiration != at.UnixNano() || item.ContentType != "" {
+		t.Errorf("unexpected item: %+v", item)
+	}
+
+	s.Write("c", "v", WriteOptions{TTL: time.Minute, Sliding: true})
+	if item, _ = s.GetItem("c"); item.Sliding != int64(time.Minute) {
+		t.Errorf("item is not sliding: %+v", item)
+	}
+}
+
+func TestStorage_WriteValidates(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	s.SetNamespaceOptions("small", NamespaceOptions{MaxItems: 1})
+	if _, err := s.Write("small:a", "v", WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write("small:b", "v", WriteOptions{}); err != ErrNamespaceFull {
+		t.Errorf("limit was not checked: %v", err)
+	}
+}
+
+func TestStorage_WriteConditions(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	if _, err := s.Write("a", "v", WriteOptions{IfExists: true}); err != ErrNotFound {
+		t.Errorf("missing key was written with IfExists: %v", err)
+	}
+	if _, err := s.Write("a", "v", WriteOptions{IfVersion: 1}); err != ErrVersionMismatch {
+		t.Errorf("missing key was written with IfVersion: %v", err)
+	}
+	version, err := s.Write("a", "v1", WriteOptions{IfAbsent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Write("a", "v2", WriteOptions{IfAbsent: true}); err != ErrExists {
+		t.Errorf("existing key was written with IfAbsent: %v", err)
+	}
+	if _, err = s.Write("a", "v2", WriteOptions{IfVersion: version + 1}); err != ErrVersionMismatch {
+		t.Errorf("wrong version was accepted: %v", err)
+	}
+	if _, err = s.Write("a", "v2", WriteOptions{IfVersion: version}); err != nil {
+		t.Error(err)
+	}
+
+	s.Set("expired", "v", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, err = s.Write("expired", "v", WriteOptions{IfAbsent: true}); err != nil {
+		t.Errorf("expired key blocked IfAbsent: %v", err)
+	}
+}
+
+func TestStorage_DeleteVersions(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	a, _ := s.Write("a", "1", WriteOptions{})
+	b, _ := s.Write("b", "1", WriteOptions{})
+	s.Write("b", "2", WriteOptions{})
+
+	errs := s.DeleteVersions([]DeleteGuard{{"a", a}, {"b", b}, {"c", 1}})
+	if errs[0] != nil || errs[1] != ErrVersionMismatch || errs[2] != ErrNotFound {
+		t.Errorf("unexpected results: %v", errs)
+	}
+	if _, found := s.Get("a"); found {
+		t.Error("a was not deleted")
+	}
+	if v, _ := s.Get("b"); v != "2" {
+		t.Error("b was deleted although it was modified")
+	}
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//WriteHook checks and transforms JSON documents written to a namespace through Write.
+type WriteHook struct {
+	//Reject fails the write with a ValidationError carrying Message if it evaluates to true
+	Reject  *Expr
+	Message string
+	//Set assigns the values of expressions to paths of the document in order,
+	//e.g. to inject timestamps
+	Set []Assignment
+}
+
+//Assignment sets Path of a document to the value of Value.
+type Assignment struct {
+	Path  string
+	Value *Expr
+	segs  []interface{}
+}
+
+//ParseAssignment parses an assignment like `$.updated_at = now()`.
+func ParseAssignment(s string) (Assignment, error) {
+	i := strings.IndexByte(s, '=')
+	if i == -1 {
+		return Assignment{}, fmt.Errorf("assignment %s has no =", s)
+	}
+	a := Assignment{Path: strings.TrimSpace(s[:i])}
+	var err error
+	if a.segs, err = parseJSONPath(a.Path); err != nil {
+		return a, err
+	}
+	if len(a.segs) == 0 {
+		return a, fmt.Errorf("assignment %s replaces the whole document", s)
+	}
+	a.Value, err = ParseExpr(s[i+1:])
+	return a, err
+}
+
+//SetWriteHooks replaces the write hooks of namespace, which run in order before
+//the schema is checked. Values of the namespace must then be JSON documents.
+//No hooks remove them.
+func (s *Storage) SetWriteHooks(namespace string, hooks []WriteHook) {
+	s.lock("SetWriteHooks")
+	defer s.mu.Unlock()
+	if len(hooks) == 0 {
+		delete(s.writeHooks, namespace)
+		return
+	}
+	s.writeHooks[namespace] = hooks
+}
+
+//applyWriteHooks returns value as transformed by the hooks of key's namespace.
+//Must be called with the lock held.
+func (s *Storage) applyWriteHooks(key string, value interface{}) (interface{}, error) {
+	hooks, ok := s.writeHooks[Namespace(key)]
+	if !ok {
+		return value, nil
+	}
+	doc, err := decodeJSONObject(key, value)
+	if err != nil {
+		return nil, &ValidationError{Key: key, Hook: true, Errors: []string{err.Error()}}
+	}
+
+	now := time.Unix(0, s.now())
+	for _, h := range hooks {
+		if h.Reject != nil {
+			v, err := h.Reject.Eval(doc, now)
+			if err != nil {
+				return nil, &ValidationError{Key: key, Hook: true, Errors: []string{fmt.Sprintf("%s: %v", h.Reject, err)}}
+			}
+			if v == true {
+				return nil, &ValidationError{Key: key, Hook: true, Errors: []string{h.Message}}
+			}
+		}
+		for _, a := range h.Set {
+			v, err := a.Value.Eval(doc, now)
+			if err == nil {
+				doc, err = setJSONPath(doc, a.segs, v)
+			}
+			if err != nil {
+				return nil, &ValidationError{Key: key, Hook: true, Errors: []string{fmt.Sprintf("%s: %v", a.Path, err)}}
+			}
+		}
+	}
+	return encodeJSONObject(value, doc)
+}
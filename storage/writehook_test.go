@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStorage_WriteHooks(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	s := New(DefaultExpiration, 0, 0)
+	s.SetClock(clock)
+
+	reject, _ := ParseExpr(`$.qty <= 0`)
+	stamp, err := ParseAssignment(`$.updated_at = now()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetWriteHooks("orders", []WriteHook{{Reject: reject, Message: "qty must be positive", Set: []Assignment{stamp}}})
+
+	if _, err := s.Write("orders:1", `{"qty":1}`, WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("orders:1"); v != `{"qty":1,"updated_at":"2020-01-02T03:04:05Z"}` {
+		t.Errorf("value was not transformed: %v", v)
+	}
+
+	var ve *ValidationError
+	_, err = s.Write("orders:2", `{"qty":0}`, WriteOptions{})
+	if !errors.As(err, &ve) || ve.Errors[0] != "qty must be positive" {
+		t.Errorf("write was not rejected: %v", err)
+	}
+	if _, err = s.Write("orders:3", "plain", WriteOptions{}); !errors.As(err, &ve) {
+		t.Errorf("value which is not a json document was accepted: %v", err)
+	}
+	if _, err = s.Write("other", "plain", WriteOptions{}); err != nil {
+		t.Errorf("hooks ran for another namespace: %v", err)
+	}
+
+	s.SetWriteHooks("orders", nil)
+	if _, err = s.Write("orders:3", "plain", WriteOptions{}); err != nil {
+		t.Errorf("removed hooks still run: %v", err)
+	}
+}
+
+func TestStorage_WriteHooksOtherWrites(t *testing.T) {
+	s := New(DefaultExpiration, 0, 0)
+	reject, _ := ParseExpr(`$.qty <= 0`)
+	stamp, _ := ParseAssignment(`$.checked = true`)
+	s.SetWriteHooks("orders", []WriteHook{{Reject: reject, Message: "qty must be positive", Set: []Assignment{stamp}}})
+	if _, err := s.Write("orders:1", `{"qty":1}`, WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var ve *ValidationError
+	b := s.Batch()
+	b.Set("other", "v", DefaultExpiration)
+	b.Set("orders:2", `{"qty":0}`, DefaultExpiration)
+	if err := b.Commit(); !errors.As(err, &ve) {
+		t.Errorf("batch was not rejected: %v", err)
+	}
+	b = s.Batch()
+	b.Set("orders:2", `{"qty":2}`, DefaultExpiration)
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("orders:2"); v != `{"checked":true,"qty":2}` {
+		t.Errorf("batch value was not transformed: %v", v)
+	}
+
+	_, _, err := s.PatchJSON("orders:1", 0, func(doc interface{}) (interface{}, error) {
+		doc.(map[string]interface{})["qty"] = 0
+		return doc, nil
+	})
+	if !errors.As(err, &ve) {
+		t.Errorf("patch was not rejected: %v", err)
+	}
+	if err := s.JSONSet("orders:1", "$.qty", -1); !errors.As(err, &ve) {
+		t.Errorf("json set was not rejected: %v", err)
+	}
+	if v, _ := s.Get("orders:1"); v != `{"checked":true,"qty":1}` {
+		t.Errorf("rejected writes changed the value: %v", v)
+	}
+	if _, err := s.Incr("orders:3", 1, 0); !errors.As(err, &ve) {
+		t.Errorf("number was accepted as a json document: %v", err)
+	}
+}
+
+func TestParseAssignment(t *testing.T) {
+	for _, s := range []string{`$.a`, `$ = 1`, `a = 1`, `$.a = (`} {
+		if _, err := ParseAssignment(s); err == nil {
+			t.Errorf("%s was parsed", s)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package utils
+
+import "time"
+
+//Throttle returns a function which sleeps as needed so that it returns
+//at most rate times per second. A rate <= 0 disables throttling.
+func Throttle(rate int) func() {
+	if rate <= 0 {
+		return func() {}
+	}
+	start := time.Now()
+	n := 0
+	return func() {
+		n++
+		due := start.Add(time.Duration(n) * time.Second / time.Duration(rate))
+		if d := time.Until(due); d > 0 {
+			time.Sleep(d)
+		}
+	}
+}